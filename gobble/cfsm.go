@@ -0,0 +1,477 @@
+// The deadlock analysis translates the local protocol of each role into
+// a communicating finite-state machine (CFSM), whose transitions send a
+// message to or receive a message from another role, and explores every
+// configuration reachable by the machines of all the roles passed
+// together. A configuration is stuck if no machine can move and not
+// every machine has finished: a stuck configuration in which a message
+// can never be received because its receiver has finished or waits only
+// for other messages is reported as an orphan message or an unspecified
+// reception respectively, and any other as a deadlock. Each problem is
+// reported with the shortest sequence of messages which leads to it.
+// With a bound of zero, messages are exchanged synchronously, as they are
+// over the unbuffered channels of a combined programme; with a bound n
+// greater than zero each pair of roles communicates through a queue of at
+// most n messages, as over a network connection, and a message waiting
+// in a queue which its receiver cannot accept is reported as soon as it
+// arrives. A choice becomes a state with a transition for the first
+// message of each branch, a rec block a state to which each continue
+// returns, and a par block the product of the machines of its branches.
+// The analysis runs entirely within Gobble.
+
+// input: trees of structs, each holding the single local protocol of one
+// role, and the bound on the length of the queue between two roles
+
+// output: Diagnostics describing every deadlock, orphan message and
+// unspecified reception found, each with a trace leading to it
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxCFSMConfigurations limits the number of configurations the analysis
+// explores before giving up.
+const MaxCFSMConfigurations = 1000000
+
+type CFSM struct {
+	Role   string
+	Start  *CFSMState
+	States []*CFSMState
+}
+
+type CFSMState struct {
+	Id          int
+	Transitions []*CFSMTransition
+	Final       bool
+}
+
+type CFSMTransition struct {
+	Send  bool
+	Peer  string
+	Label string
+	To    *CFSMState
+	Pos   Position
+}
+
+type CFSMBuilder struct {
+	Machine *CFSM
+	Recs    map[string]*CFSMState
+	TypeMap map[string]*TypeData
+}
+
+// CFSMConfiguration is a state of every machine together with the
+// messages waiting in the queue between each pair of roles. Parent and
+// Step record how the configuration was first reached.
+type CFSMConfiguration struct {
+	States []*CFSMState
+	Queues map[string][]*CFSMTransition
+	Parent *CFSMConfiguration
+	Step   string
+}
+
+func CheckDeadlockFreedom(trees []*Protocol, bound int) error {
+	machines := make([]*CFSM, 0)
+	for _, tree := range trees {
+		for _, l := range tree.Locals {
+			machines = append(machines, BuildCFSM(l, GetTypeDataMap(tree.Types, &MessagesData{})))
+		}
+	}
+	diags := &DiagnosticCollector{}
+	ExploreCFSMs(machines, bound, diags)
+	return diags.Err()
+}
+
+func BuildCFSM(l *Local, typeMap map[string]*TypeData) *CFSM {
+	b := &CFSMBuilder{Machine: &CFSM{Role: l.Protagonist}, Recs: make(map[string]*CFSMState), TypeMap: typeMap}
+	end := b.NewState()
+	end.Final = true
+	b.Machine.Start = b.BuildNodes(l.Conv.Nodes, end)
+	return b.Machine
+}
+
+func (b *CFSMBuilder) NewState() *CFSMState {
+	s := &CFSMState{Id: len(b.Machine.States)}
+	b.Machine.States = append(b.Machine.States, s)
+	return s
+}
+
+// BuildNodes builds the states for nodes, working backwards from next,
+// the state reached once they are complete, and returns the first.
+func (b *CFSMBuilder) BuildNodes(nodes []*Node, next *CFSMState) *CFSMState {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := nodes[i]
+		if n.Cat == "message" {
+			next = b.BuildMessage(n.Mess, next)
+		} else if n.Cat == "continue" {
+			if rec, ok := b.Recs[n.Cont.Name]; ok {
+				next = rec
+			}
+		} else if n.Cat == "choice" {
+			s := b.NewState()
+			for _, conv := range n.Choice.Convs {
+				MergeCFSMState(s, b.BuildNodes(conv.Nodes, next))
+			}
+			next = s
+		} else if n.Cat == "rec" {
+			s := b.NewState()
+			enclosing, shadowed := b.Recs[n.Rec.Name]
+			b.Recs[n.Rec.Name] = s
+			MergeCFSMState(s, b.BuildNodes(n.Rec.Conv.Nodes, next))
+			if shadowed {
+				b.Recs[n.Rec.Name] = enclosing
+			} else {
+				delete(b.Recs, n.Rec.Name)
+			}
+			next = s
+		} else if n.Cat == "par" {
+			next = b.BuildPar(n.Par, next)
+		}
+	}
+	return next
+}
+
+func (b *CFSMBuilder) BuildMessage(mess *Message, next *CFSMState) *CFSMState {
+	label := mess.Name + "(" + strings.Join(ResolveActionTypes(mess, b.TypeMap), ", ") + ")"
+	t := &CFSMTransition{Send: mess.From == b.Machine.Role, Peer: mess.From, Label: label, To: next, Pos: mess.Pos}
+	if t.Send {
+		t.Peer = mess.To
+	}
+	s := b.NewState()
+	s.Transitions = append(s.Transitions, t)
+	return s
+}
+
+// MergeCFSMState gives s the transitions of entry, so that a machine in
+// state s may begin whatever entry begins.
+func MergeCFSMState(s *CFSMState, entry *CFSMState) {
+	if s == entry {
+		return
+	}
+	s.Transitions = append(s.Transitions, entry.Transitions...)
+	if entry.Final {
+		s.Final = true
+	}
+}
+
+// BuildPar builds a machine for each branch of par and adds their product
+// to the machine being built, in which the branches take turns to move
+// and which reaches next once every branch has ended. A continue within
+// a branch naming a rec block outside the par block ends the branch.
+func (b *CFSMBuilder) BuildPar(par *Parallel, next *CFSMState) *CFSMState {
+	starts := make([]*CFSMState, 0)
+	ends := make([]*CFSMState, 0)
+	for _, conv := range par.Convs {
+		branch := &CFSMBuilder{Machine: &CFSM{Role: b.Machine.Role}, Recs: make(map[string]*CFSMState), TypeMap: b.TypeMap}
+		end := branch.NewState()
+		starts = append(starts, branch.BuildNodes(conv.Nodes, end))
+		ends = append(ends, end)
+	}
+	product := make(map[string]*CFSMState)
+	var GetProductState func(tuple []*CFSMState) *CFSMState
+	GetProductState = func(tuple []*CFSMState) *CFSMState {
+		key := ""
+		ended := true
+		for i, s := range tuple {
+			key += strconv.Itoa(s.Id) + " "
+			if s != ends[i] {
+				ended = false
+			}
+		}
+		if ended {
+			return next
+		}
+		if s, ok := product[key]; ok {
+			return s
+		}
+		s := b.NewState()
+		product[key] = s
+		for i, component := range tuple {
+			for _, t := range component.Transitions {
+				successor := make([]*CFSMState, len(tuple))
+				copy(successor, tuple)
+				successor[i] = t.To
+				s.Transitions = append(s.Transitions, &CFSMTransition{Send: t.Send, Peer: t.Peer, Label: t.Label, Pos: t.Pos, To: GetProductState(successor)})
+			}
+		}
+		return s
+	}
+	return GetProductState(starts)
+}
+
+func ExploreCFSMs(machines []*CFSM, bound int, diags *DiagnosticCollector) {
+	roles := make(map[string]int)
+	for i, machine := range machines {
+		roles[machine.Role] = i
+	}
+	start := &CFSMConfiguration{States: make([]*CFSMState, 0), Queues: make(map[string][]*CFSMTransition)}
+	for _, machine := range machines {
+		start.States = append(start.States, machine.Start)
+	}
+	seen := map[string]bool{start.Key(): true}
+	reported := make(map[string]bool)
+	queue := []*CFSMConfiguration{start}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if !CheckQueueHeads(c, machines, roles, reported, diags) {
+			continue
+		}
+		successors := c.Successors(machines, roles, bound)
+		if len(successors) == 0 {
+			ReportStuckConfiguration(c, machines, roles, reported, diags)
+			continue
+		}
+		for _, next := range successors {
+			key := next.Key()
+			if seen[key] {
+				continue
+			}
+			if len(seen) >= MaxCFSMConfigurations {
+				diags.Add(Position{}, "The roles "+GetCFSMRoles(machines)+" reach more than "+strconv.Itoa(MaxCFSMConfigurations)+" configurations, too many to analyse for deadlocks", "analyse fewer roles together, or use a smaller bound")
+				return
+			}
+			seen[key] = true
+			queue = append(queue, next)
+		}
+	}
+}
+
+func GetCFSMRoles(machines []*CFSM) string {
+	roles := make([]string, 0)
+	for _, machine := range machines {
+		roles = append(roles, machine.Role)
+	}
+	return strings.Join(roles, ", ")
+}
+
+func GetQueueName(from string, to string) string {
+	return from + " " + to
+}
+
+// GetQueueNames returns the names of the non-empty queues of c in sorted
+// order.
+func GetQueueNames(c *CFSMConfiguration) []string {
+	names := make([]string, 0)
+	for name := range c.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *CFSMConfiguration) Key() string {
+	key := ""
+	for _, s := range c.States {
+		key += strconv.Itoa(s.Id) + " "
+	}
+	for _, name := range GetQueueNames(c) {
+		key += "|" + name + ":"
+		for _, t := range c.Queues[name] {
+			key += t.Label + ","
+		}
+	}
+	return key
+}
+
+// Successors returns the configurations reachable from c by sending or
+// receiving one message, or by exchanging one message when bound is zero.
+func (c *CFSMConfiguration) Successors(machines []*CFSM, roles map[string]int, bound int) []*CFSMConfiguration {
+	successors := make([]*CFSMConfiguration, 0)
+	for i, s := range c.States {
+		role := machines[i].Role
+		for _, t := range s.Transitions {
+			j, ok := roles[t.Peer]
+			if !ok {
+				continue
+			}
+			if bound == 0 {
+				if !t.Send {
+					continue
+				}
+				for _, r := range c.States[j].Transitions {
+					if !r.Send && r.Peer == role && r.Label == t.Label {
+						next := c.Move(i, t.To, role+" sends "+t.Label+" to "+t.Peer+" ("+t.Pos.String()+")")
+						next.States[j] = r.To
+						successors = append(successors, next)
+					}
+				}
+			} else if t.Send {
+				name := GetQueueName(role, t.Peer)
+				if len(c.Queues[name]) < bound {
+					next := c.Move(i, t.To, role+" sends "+t.Label+" to "+t.Peer+" ("+t.Pos.String()+")")
+					next.Queues[name] = append(CopyCFSMTransitions(c.Queues[name]), t)
+					successors = append(successors, next)
+				}
+			} else {
+				name := GetQueueName(t.Peer, role)
+				waiting := c.Queues[name]
+				if len(waiting) > 0 && waiting[0].Label == t.Label {
+					next := c.Move(i, t.To, role+" receives "+t.Label+" from "+t.Peer+" ("+t.Pos.String()+")")
+					next.Queues[name] = CopyCFSMTransitions(waiting[1:])
+					if len(next.Queues[name]) == 0 {
+						delete(next.Queues, name)
+					}
+					successors = append(successors, next)
+				}
+			}
+		}
+	}
+	return successors
+}
+
+// Move returns a copy of c in which machine i has moved to state s.
+func (c *CFSMConfiguration) Move(i int, s *CFSMState, step string) *CFSMConfiguration {
+	next := &CFSMConfiguration{States: make([]*CFSMState, len(c.States)), Queues: make(map[string][]*CFSMTransition), Parent: c, Step: step}
+	copy(next.States, c.States)
+	next.States[i] = s
+	for name, waiting := range c.Queues {
+		next.Queues[name] = waiting
+	}
+	return next
+}
+
+func CopyCFSMTransitions(ts []*CFSMTransition) []*CFSMTransition {
+	copied := make([]*CFSMTransition, 0)
+	return append(copied, ts...)
+}
+
+// GetTrace returns the steps which lead from the initial configuration
+// to c.
+func (c *CFSMConfiguration) GetTrace() []string {
+	trace := make([]string, 0)
+	for ; c.Parent != nil; c = c.Parent {
+		trace = append([]string{c.Step}, trace...)
+	}
+	return trace
+}
+
+// CheckQueueHeads reports a message at the head of a queue which its
+// receiver is waiting for messages from the same sender but will never
+// accept. It returns false if such a message was found.
+func CheckQueueHeads(c *CFSMConfiguration, machines []*CFSM, roles map[string]int, reported map[string]bool, diags *DiagnosticCollector) bool {
+	for _, machine := range machines {
+		for _, peer := range machines {
+			waiting := c.Queues[GetQueueName(peer.Role, machine.Role)]
+			if len(waiting) == 0 {
+				continue
+			}
+			s := c.States[roles[machine.Role]]
+			if IsUnspecifiedReception(s, peer.Role, waiting[0].Label) {
+				ReportCFSMProblem(c, waiting[0].Pos, "Unspecified reception: "+peer.Role+" sends "+waiting[0].Label+" to "+machine.Role+", but "+machine.Role+" is waiting for "+DescribeCFSMState(s), reported, diags)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsUnspecifiedReception reports whether a machine in state s, which
+// only receives, expects a message from peer but not the one labelled
+// label.
+func IsUnspecifiedReception(s *CFSMState, peer string, label string) bool {
+	fromPeer := false
+	for _, t := range s.Transitions {
+		if t.Send {
+			return false
+		}
+		if t.Peer == peer {
+			if t.Label == label {
+				return false
+			}
+			fromPeer = true
+		}
+	}
+	return fromPeer
+}
+
+func DescribeCFSMState(s *CFSMState) string {
+	options := make([]string, 0)
+	for _, t := range s.Transitions {
+		if t.Send {
+			options = append(options, "to send "+t.Label+" to "+t.Peer)
+		} else {
+			options = append(options, t.Label+" from "+t.Peer)
+		}
+	}
+	return strings.Join(options, " or ")
+}
+
+// ReportStuckConfiguration reports a configuration in which no machine
+// can move, unless every machine has finished and every queue is empty.
+func ReportStuckConfiguration(c *CFSMConfiguration, machines []*CFSM, roles map[string]int, reported map[string]bool, diags *DiagnosticCollector) {
+	for _, name := range GetQueueNames(c) {
+		waiting := c.Queues[name]
+		pair := strings.SplitN(name, " ", 2)
+		if c.States[roles[pair[1]]].Final {
+			ReportCFSMProblem(c, waiting[0].Pos, "Orphan message: "+pair[0]+" sends "+waiting[0].Label+" to "+pair[1]+", but "+pair[1]+" has finished without receiving it", reported, diags)
+			return
+		}
+	}
+	finished := true
+	for _, s := range c.States {
+		if !s.Final {
+			finished = false
+		}
+	}
+	if finished {
+		return
+	}
+	for i, s := range c.States {
+		role := machines[i].Role
+		for _, t := range s.Transitions {
+			if !t.Send {
+				continue
+			}
+			j, ok := roles[t.Peer]
+			if !ok {
+				continue
+			}
+			receiver := c.States[j]
+			if receiver.Final && len(receiver.Transitions) == 0 {
+				ReportCFSMProblem(c, t.Pos, "Orphan message: "+role+" sends "+t.Label+" to "+t.Peer+", but "+t.Peer+" has finished", reported, diags)
+				return
+			}
+			if IsUnspecifiedReception(receiver, role, t.Label) {
+				ReportCFSMProblem(c, t.Pos, "Unspecified reception: "+role+" sends "+t.Label+" to "+t.Peer+", but "+t.Peer+" is waiting for "+DescribeCFSMState(receiver), reported, diags)
+				return
+			}
+		}
+	}
+	waits := make([]string, 0)
+	var pos Position
+	for i, s := range c.States {
+		if len(s.Transitions) == 0 {
+			continue
+		}
+		if len(waits) == 0 {
+			pos = s.Transitions[0].Pos
+		}
+		waits = append(waits, machines[i].Role+" waits "+DescribeCFSMWait(s))
+	}
+	ReportCFSMProblem(c, pos, "Deadlock: "+strings.Join(waits, "; "), reported, diags)
+}
+
+func DescribeCFSMWait(s *CFSMState) string {
+	if s.Transitions[0].Send {
+		return DescribeCFSMState(s)
+	}
+	return "for " + DescribeCFSMState(s)
+}
+
+// ReportCFSMProblem reports a problem found in configuration c, once for
+// each position and message however many configurations lead to it.
+func ReportCFSMProblem(c *CFSMConfiguration, pos Position, message string, reported map[string]bool, diags *DiagnosticCollector) {
+	key := pos.String() + message
+	if reported[key] {
+		return
+	}
+	reported[key] = true
+	d := &Diagnostic{Pos: pos, Message: message, Trace: c.GetTrace()}
+	if len(d.Trace) == 0 {
+		d.Hint = "the roles are stuck before any message is sent"
+	}
+	diags.AddDiagnostics(Diagnostics{d})
+}
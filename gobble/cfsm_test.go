@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDeadlockFreedom(t *testing.T) {
+	tests := []struct {
+		name   string
+		bodies map[string]string
+		bound  int
+		want   string
+	}{
+		{
+			name:   "deadlock free",
+			bodies: map[string]string{"A": "rec L { choice at A { x() to B; n() from B; continue L; } or { y() to B; } }", "B": "rec L { choice at A { x() from A; n() to A; continue L; } or { y() from A; } }"},
+		},
+		{
+			name:   "par",
+			bodies: map[string]string{"A": "par { m() to B; } and { n() from C; }", "B": "m() from A;", "C": "n() to A;"},
+		},
+		{
+			name:   "each waits for the other",
+			bodies: map[string]string{"A": "m() from B; n() to B;", "B": "n() from A; m() to A;"},
+			want:   "test.scr:6:2: Deadlock: A waits for m() from B; B waits for n() from A\n\thint: the roles are stuck before any message is sent",
+		},
+		{
+			name:   "both send synchronously",
+			bodies: map[string]string{"A": "m() to B; n() from B;", "B": "n() to A; m() from A;"},
+			want:   "Deadlock: A waits to send m() to B; B waits to send n() to A",
+		},
+		{
+			name:   "both send through queues",
+			bodies: map[string]string{"A": "m() to B; n() from B;", "B": "n() to A; m() from A;"},
+			bound:  1,
+		},
+		{
+			name:   "orphan message",
+			bodies: map[string]string{"A": "m() to B; n() to B;", "B": "m() from A;"},
+			want:   "test.scr:6:12: Orphan message: A sends n() to B, but B has finished\n\ttrace:\n\t\t1. A sends m() to B (test.scr:6:2)",
+		},
+		{
+			name:   "orphan message in a queue",
+			bodies: map[string]string{"A": "m() to B; n() to B;", "B": "m() from A;"},
+			bound:  2,
+			want:   "Orphan message: A sends n() to B, but B has finished without receiving it",
+		},
+		{
+			name:   "unspecified reception",
+			bodies: map[string]string{"A": "y() to B;", "B": "choice at A { x() from A; } or { z() from A; }"},
+			want:   "Unspecified reception: A sends y() to B, but B is waiting for x() from A or z() from A",
+		},
+		{
+			name:   "unspecified reception in a queue",
+			bodies: map[string]string{"A": "choice at A { x() to B; } or { y() to B; }", "B": "choice at A { x() from A; } or { z() from A; }"},
+			bound:  1,
+			want:   "Unspecified reception: A sends y() to B, but B is waiting for x() from A or z() from A",
+		},
+		{
+			name:   "loop counts differ",
+			bodies: map[string]string{"A": "rec L { m() to B; continue L; }", "B": "m() from A; m() from A;"},
+			want:   "Orphan message: A sends m() to B, but B has finished\n\ttrace:\n\t\t1. A sends m() to B (test.scr:6:10)\n\t\t2. A sends m() to B (test.scr:6:10)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckDeadlockFreedom(ParseTestLocals(t, test.bodies), test.bound)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckDeadlockFreedomGivesShortestTrace(t *testing.T) {
+	bodies := map[string]string{
+		"A": "rec L { choice at A { x() to B; continue L; } or { y() to B; } }",
+		"B": "rec L { choice at A { x() from A; continue L; } or { y() from A; n() from A; } }",
+	}
+	err := CheckDeadlockFreedom(ParseTestLocals(t, bodies), 0)
+	diags, ok := err.(Diagnostics)
+	if !ok || len(diags) != 1 {
+		t.Fatalf("got %v, want one diagnostic", err)
+	}
+	want := []string{"A sends y() to B (test.scr:6:53)"}
+	if strings.Join(diags[0].Trace, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got trace %q, want %q", diags[0].Trace, want)
+	}
+}
@@ -0,0 +1,292 @@
+// The checker validates the tree produced by the parser before any code
+// is generated from it. Gobble is otherwise happy to translate whatever
+// the parser accepts, so a protocol which is syntactically correct but
+// meaningless as a session (a message to a role which was never
+// declared, a continue outside of the rec block it names, a choice whose
+// branches cannot be told apart) would be turned into Go which fails to
+// compile or deadlocks. Global protocols are checked before they are
+// projected and every local protocol, including those produced by
+// projection, is checked before translation. Every violation found is
+// reported with its position in the source file.
+
+// input: tree of structs descending from a Protocol root node
+
+// output: Diagnostics describing every violation found, or nil
+
+package main
+
+// Parameter names become Go identifiers in the generated code, so they
+// may not be keywords or predeclared identifiers, or clash with the
+// names which the generated methods use themselves.
+var ReservedParameterNames = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true, "map": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true, "type": true,
+	"var": true, "any": true, "bool": true, "byte": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true, "int8": true, "int16": true,
+	"int32": true, "int64": true, "rune": true, "string": true, "uint": true, "uint8": true,
+	"uint16": true, "uint32": true, "uint64": true, "uintptr": true, "true": true, "false": true,
+	"iota": true, "nil": true, "append": true, "cap": true, "close": true, "complex": true,
+	"copy": true, "delete": true, "imag": true, "len": true, "make": true, "new": true,
+	"panic": true, "print": true, "println": true, "real": true, "recover": true,
+	"self": true, "in": true, "retVal": true, "sendVal": true, "err": true, "errors": true,
+}
+
+type CheckScope struct {
+	ProtocolName string
+	Protagonist  string
+	Roles        map[string]bool
+	Recs         []string
+	RecPositions map[string]Position
+}
+
+func CheckGlobals(p *Protocol) error {
+	diags := &DiagnosticCollector{}
+	for _, g := range p.Globals {
+		CheckGlobal(g, diags)
+	}
+	return diags.Err()
+}
+
+func CheckLocals(p *Protocol) error {
+	diags := &DiagnosticCollector{}
+	declared := make(map[string]Position)
+	signatures := &SignatureScope{Messages: make(map[string]*Message), Checked: make(map[Position]bool)}
+	for _, l := range p.Locals {
+		key := l.Name + " at " + l.Protagonist
+		if pos, ok := declared[key]; ok {
+			diags.Add(l.Pos, "Local protocol "+key+" is declared more than once", "previous declaration at "+pos.String())
+			continue
+		}
+		declared[key] = l.Pos
+		CheckLocal(l, diags)
+		CheckSignatures(l.Conv, signatures, diags)
+	}
+	return diags.Err()
+}
+
+func CheckGlobal(g *Global, diags *DiagnosticCollector) {
+	scope := NewCheckScope(g.Name, "", g.Roles, diags)
+	CheckConversation(g.Conv, scope, diags)
+}
+
+func CheckLocal(l *Local, diags *DiagnosticCollector) {
+	scope := NewCheckScope(l.Name, l.Protagonist, l.Roles, diags)
+	if !scope.Roles[l.Protagonist] {
+		diags.Add(l.Pos, "Local protocol "+l.Name+" is located at "+l.Protagonist+", which is not in its role list", "add \"role "+l.Protagonist+"\" to the role list")
+	}
+	CheckConversation(l.Conv, scope, diags)
+}
+
+func NewCheckScope(name string, protagonist string, roles []*Role, diags *DiagnosticCollector) *CheckScope {
+	scope := &CheckScope{ProtocolName: name, Protagonist: protagonist}
+	scope.Roles = make(map[string]bool)
+	scope.Recs = make([]string, 0)
+	scope.RecPositions = make(map[string]Position)
+	for _, role := range roles {
+		if scope.Roles[role.Name] {
+			diags.Add(role.Pos, "Role "+role.Name+" is declared more than once in protocol "+name, "")
+		}
+		scope.Roles[role.Name] = true
+	}
+	return scope
+}
+
+func CheckRole(role string, pos Position, scope *CheckScope, diags *DiagnosticCollector) {
+	if !scope.Roles[role] {
+		diags.Add(pos, "Role "+role+" is not declared in protocol "+scope.ProtocolName, "add \"role "+role+"\" to the role list of "+scope.ProtocolName)
+	}
+}
+
+func CheckConversation(c *Conversation, scope *CheckScope, diags *DiagnosticCollector) {
+	for _, n := range c.Nodes {
+		if n.Cat == "message" {
+			CheckMessage(n.Mess, scope, diags)
+		} else if n.Cat == "choice" {
+			CheckChoice(n.Choice, scope, diags)
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				CheckConversation(conv, scope, diags)
+			}
+		} else if n.Cat == "rec" {
+			CheckRec(n.Rec, scope, diags)
+		} else if n.Cat == "continue" {
+			CheckContinue(n.Cont, scope, diags)
+		} else if n.Cat == "do" {
+			for _, role := range n.Do.Roles {
+				CheckRole(role, n.Do.Pos, scope, diags)
+			}
+		}
+	}
+}
+
+func CheckMessage(mess *Message, scope *CheckScope, diags *DiagnosticCollector) {
+	if scope.Protagonist == "" {
+		CheckRole(mess.From, mess.Pos, scope, diags)
+		CheckRole(mess.To, mess.Pos, scope, diags)
+		CheckParameterNames(mess, diags)
+		if mess.From == mess.To {
+			diags.Add(mess.Pos, "Message "+mess.Name+" is sent from "+mess.From+" to itself", "")
+		}
+		return
+	}
+	other := mess.To
+	if mess.To == scope.Protagonist {
+		other = mess.From
+	}
+	if other == scope.Protagonist {
+		diags.Add(mess.Pos, "Message "+mess.Name+" is sent from "+scope.Protagonist+" to itself", "")
+		return
+	}
+	CheckRole(other, mess.Pos, scope, diags)
+	CheckParameterNames(mess, diags)
+}
+
+func CheckParameterNames(mess *Message, diags *DiagnosticCollector) {
+	fields := make(map[string]bool)
+	for _, name := range mess.Names {
+		if name == "" {
+			continue
+		}
+		if ReservedParameterNames[name] {
+			diags.Add(mess.Pos, "Parameter "+name+" of message "+mess.Name+" has a reserved name", "rename the parameter; "+name+" is a Go keyword or predeclared identifier, or is used by the generated code")
+		}
+		// Names become struct fields once capitalised
+		field := CapitaliseFirstLetter(name)
+		if fields[field] {
+			diags.Add(mess.Pos, "Message "+mess.Name+" has more than one parameter named "+name, "give each parameter a distinct name")
+		}
+		fields[field] = true
+	}
+}
+
+// SignatureScope records the first message seen with each signature.
+// Each projection of a global protocol repeats its messages, so messages
+// from a source position which has already been checked are skipped.
+type SignatureScope struct {
+	Messages map[string]*Message
+	Checked  map[Position]bool
+}
+
+// CheckSignatures requires messages which share a label, sender,
+// receiver and parameter types to name their parameters alike, since
+// they are carried by the same payload struct.
+func CheckSignatures(c *Conversation, signatures *SignatureScope, diags *DiagnosticCollector) {
+	for _, n := range c.Nodes {
+		if n.Cat == "message" {
+			mess := n.Mess
+			if signatures.Checked[mess.Pos] {
+				continue
+			}
+			signatures.Checked[mess.Pos] = true
+			key := mess.Name + " from " + mess.From + " to " + mess.To + "(" + GetCommaSepList(mess.Types) + ")"
+			prev, ok := signatures.Messages[key]
+			if !ok {
+				signatures.Messages[key] = mess
+			} else if GetCommaSepList(GetParameterNames(prev)) != GetCommaSepList(GetParameterNames(mess)) {
+				diags.Add(mess.Pos, "Message "+mess.Name+" from "+mess.From+" to "+mess.To+" names its parameters differently from the message at "+prev.Pos.String(), "use the same parameter names wherever "+mess.Name+" is sent with these types")
+			}
+		} else if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				CheckSignatures(conv, signatures, diags)
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				CheckSignatures(conv, signatures, diags)
+			}
+		} else if n.Cat == "rec" {
+			CheckSignatures(n.Rec.Conv, signatures, diags)
+		}
+	}
+}
+
+func CheckRec(rec *Rec, scope *CheckScope, diags *DiagnosticCollector) {
+	if pos, ok := scope.RecPositions[rec.Name]; ok {
+		diags.Add(rec.Pos, "rec block "+rec.Name+" is declared more than once in protocol "+scope.ProtocolName, "previous declaration at "+pos.String()+"; give each rec block a distinct name")
+	} else {
+		scope.RecPositions[rec.Name] = rec.Pos
+	}
+	scope.Recs = append(scope.Recs, rec.Name)
+	CheckConversation(rec.Conv, scope, diags)
+	scope.Recs = scope.Recs[:len(scope.Recs)-1]
+}
+
+func CheckContinue(cont *Continue, scope *CheckScope, diags *DiagnosticCollector) {
+	if SliceContainsString(scope.Recs, cont.Name) {
+		return
+	}
+	if _, ok := scope.RecPositions[cont.Name]; ok {
+		diags.Add(cont.Pos, "continue "+cont.Name+" is outside of rec block "+cont.Name, "continue may only name a rec block which encloses it")
+	} else {
+		diags.Add(cont.Pos, "continue "+cont.Name+" does not refer to a rec block", "declare \"rec "+cont.Name+" { ... }\" around the continue")
+	}
+}
+
+// CheckChoice requires the first message of every branch to show which
+// branch has been taken. In a global protocol that message must be sent
+// by the chooser. In a local protocol at the chooser every branch must
+// begin by sending a message, and at any other role every branch must
+// begin by receiving one, either from the chooser or from a role which
+// has already learnt of the choice. The first messages of different
+// branches must also have different labels, so that the receiver can
+// tell the branches apart.
+func CheckChoice(choi *Choice, scope *CheckScope, diags *DiagnosticCollector) {
+	CheckRole(choi.Chooser, choi.Pos, scope, diags)
+	labels := make(map[string]int)
+	for i, conv := range choi.Convs {
+		CheckConversation(conv, scope, diags)
+		firsts := FirstMessages(conv.Nodes)
+		// A branch beginning with a do is checked once the do is inlined
+		if len(conv.Nodes) > 0 && len(firsts) == 0 && conv.Nodes[0].Cat != "do" {
+			diags.Add(conv.Pos, "Branch of choice at "+choi.Chooser+" does not begin with a message", "begin the branch with a message so that the other roles learn which branch was chosen")
+		}
+		for _, mess := range firsts {
+			CheckChoiceFirstMessage(mess, choi.Chooser, scope, diags)
+			if j, ok := labels[mess.Name]; ok && j != i {
+				diags.Add(mess.Pos, "Branches of choice at "+choi.Chooser+" both begin with message "+mess.Name, "the first message of each branch must have a distinct label")
+			}
+			labels[mess.Name] = i
+		}
+	}
+}
+
+func CheckChoiceFirstMessage(mess *Message, chooser string, scope *CheckScope, diags *DiagnosticCollector) {
+	if scope.Protagonist == "" {
+		if mess.From != chooser {
+			diags.Add(mess.Pos, "Branch of choice at "+chooser+" begins with message "+mess.Name+" from "+mess.From, "each branch must begin with a message sent by "+chooser)
+		}
+	} else if chooser == scope.Protagonist {
+		if mess.From != scope.Protagonist {
+			diags.Add(mess.Pos, "Branch of choice at "+chooser+" begins by receiving message "+mess.Name, chooser+" makes this choice, so each branch must begin with a message sent by "+chooser)
+		}
+	} else if mess.To != scope.Protagonist {
+		diags.Add(mess.Pos, "Branch of choice at "+chooser+" begins by sending message "+mess.Name, scope.Protagonist+" does not make this choice, so each branch must begin with a message received by "+scope.Protagonist)
+	}
+}
+
+// FirstMessages returns the messages with which a sequence of nodes may
+// begin: the first message itself, or the first messages of every
+// branch of a leading choice or par block, or of the body of a leading
+// rec block.
+func FirstMessages(nodes []*Node) []*Message {
+	firsts := make([]*Message, 0)
+	if len(nodes) == 0 {
+		return firsts
+	}
+	n := nodes[0]
+	if n.Cat == "message" {
+		firsts = append(firsts, n.Mess)
+	} else if n.Cat == "choice" {
+		for _, conv := range n.Choice.Convs {
+			firsts = append(firsts, FirstMessages(conv.Nodes)...)
+		}
+	} else if n.Cat == "par" {
+		for _, conv := range n.Par.Convs {
+			firsts = append(firsts, FirstMessages(conv.Nodes)...)
+		}
+	} else if n.Cat == "rec" {
+		firsts = append(firsts, FirstMessages(n.Rec.Conv.Nodes)...)
+	}
+	return firsts
+}
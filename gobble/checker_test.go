@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func CheckTestLocal(t *testing.T, header string, body string) error {
+	t.Helper()
+	tree, err := ParseTestSource("module M;\n\n" + header + " {\n\t" + body + "\n}\n")
+	if err != nil {
+		t.Fatalf("unexpected error parsing source: %v", err)
+	}
+	return CheckLocals(tree)
+}
+
+func TestCheckLocals(t *testing.T) {
+	header := "local protocol P at A(role A, role B, role C)"
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   string
+	}{
+		{name: "well formed", body: "rec L { choice at A { x() to B; continue L; } or { y() to B; } }"},
+		{name: "received choice", body: "choice at B { x() from B; } or { y() from B; z() to C; }"},
+		{name: "undeclared role", body: "m() to D;", want: "test.scr:4:2: Role D is not declared in protocol P"},
+		{name: "protagonist not a role", header: "local protocol P at D(role A, role B)", body: "m() to B;", want: "Local protocol P is located at D, which is not in its role list"},
+		{name: "repeated role", header: "local protocol P at A(role A, role B, role B)", body: "m() to B;", want: "Role B is declared more than once in protocol P"},
+		{name: "message to itself", body: "m() to A;", want: "Message m is sent from A to itself"},
+		{name: "reserved parameter", body: "m(string: int) to B;", want: "Parameter string of message m has a reserved name"},
+		{name: "repeated parameter", body: "m(x: int, x: int) to B;", want: "Message m has more than one parameter named x"},
+		{name: "parameter names differ", body: "m(x: int) to B; m(y: int) to B;", want: "names its parameters differently from the message at test.scr:4:2"},
+		{name: "repeated rec", body: "rec L { m() to B; } rec L { n() to B; }", want: "rec block L is declared more than once in protocol P"},
+		{name: "continue outside rec", body: "rec L { m() to B; } continue L;", want: "continue L is outside of rec block L"},
+		{name: "continue without rec", body: "m() to B; continue L;", want: "continue L does not refer to a rec block"},
+		{name: "branch without message", body: "rec L { choice at A { x() to B; } or { continue L; } }", want: "Branch of choice at A does not begin with a message"},
+		{name: "branches alike", body: "choice at A { x() to B; } or { x() to C; }", want: "Branches of choice at A both begin with message x"},
+		{name: "chooser receives", body: "choice at A { x() from B; } or { y() to B; }", want: "Branch of choice at A begins by receiving message x"},
+		{name: "other sends", body: "choice at B { x() to B; } or { y() from B; }", want: "Branch of choice at B begins by sending message x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := test.header
+			if h == "" {
+				h = header
+			}
+			err := CheckTestLocal(t, h, test.body)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckLocalsReportsEveryError(t *testing.T) {
+	err := CheckTestLocal(t, "local protocol P at A(role A, role B)", "m() to D; n() to A; continue L;")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("got %T, want Diagnostics", err)
+	}
+	if len(diags) != 3 {
+		t.Errorf("got %d diagnostics, want 3:\n%v", len(diags), err)
+	}
+}
+
+func TestCheckGlobals(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "choice at A { x() from A to B; } or { y() from A to B; }"},
+		{body: "m() from A to D;", want: "Role D is not declared in protocol P"},
+		{body: "m() from A to A;", want: "Message m is sent from A to itself"},
+		{body: "choice at A { x() from B to A; } or { y() from A to B; }", want: "Branch of choice at A begins with message x from B"},
+	}
+	for _, test := range tests {
+		tree, err := ParseTestSource("module M;\n\nglobal protocol P(role A, role B) {\n\t" + test.body + "\n}\n")
+		if err != nil {
+			t.Fatalf("unexpected error parsing source: %v", err)
+		}
+		err = CheckGlobals(tree)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.body, err)
+		} else if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: got error %v, want one containing %q", test.body, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+// When several local protocols are combined into one programme they must
+// agree with one another: whatever one role sends to another, the other
+// must be waiting to receive, with the same label and payload types, and
+// whichever branch of a choice one role selects, the role it tells must
+// offer. Locals written by hand are not checked against each other by
+// any earlier stage, and a disagreement otherwise turns into a programme
+// which fails to compile or deadlocks. For every pair of roles the
+// compatibility check restricts each role's local protocol to the
+// messages it exchanges with the other and walks the two restrictions
+// side by side, reporting mismatched labels, payload types and
+// directions, branches of a choice which the other role does not offer
+// and messages which are never received. Loops are compared a single
+// pass at a time; whether two roles agree on how often a loop repeats is
+// left to the deadlock analysis.
+
+// input: trees of structs, each holding the single local protocol of one
+// role, as passed to the translator for the combined programme
+
+// output: Diagnostics describing every incompatibility found, or nil
+
+package main
+
+import (
+	"strings"
+)
+
+// Action is an element of a local protocol restricted to the messages
+// its protagonist exchanges with one other role. Kind is "send",
+// "receive", "choice", "par", "rec" or "continue".
+type Action struct {
+	Kind     string
+	Mess     *Message
+	Types    []string
+	Branches [][]*Action
+	Body     []*Action
+	Pos      Position
+}
+
+type CompatibilityPair struct {
+	Role     string
+	Other    string
+	Diags    *DiagnosticCollector
+	Reported map[string]bool
+}
+
+func CheckCompatibility(trees []*Protocol) error {
+	diags := &DiagnosticCollector{}
+	locals := make(map[string]*Local)
+	typeMaps := make(map[string]map[string]*TypeData)
+	roles := make([]string, 0)
+	for _, tree := range trees {
+		for _, l := range tree.Locals {
+			if prev, ok := locals[l.Protagonist]; ok {
+				diags.Add(l.Pos, "More than one local protocol is located at "+l.Protagonist, "previous local protocol at "+prev.Pos.String()+"; pass one local protocol for each role")
+				continue
+			}
+			locals[l.Protagonist] = l
+			typeMaps[l.Protagonist] = GetTypeDataMap(tree.Types, &MessagesData{})
+			roles = append(roles, l.Protagonist)
+		}
+	}
+	missing := make(map[string]bool)
+	for _, role := range roles {
+		CheckCounterpartsPresent(locals[role].Conv.Nodes, role, locals, missing, diags)
+	}
+	for i, role := range roles {
+		for _, other := range roles[i+1:] {
+			pair := &CompatibilityPair{Role: role, Other: other, Diags: diags, Reported: make(map[string]bool)}
+			actions := RestrictNodes(locals[role].Conv.Nodes, role, other, typeMaps[role])
+			otherActions := RestrictNodes(locals[other].Conv.Nodes, other, role, typeMaps[other])
+			CompareActions(actions, otherActions, pair)
+		}
+	}
+	return diags.Err()
+}
+
+// CheckCounterpartsPresent reports the first message to or from each role
+// which has no local protocol among those being combined.
+func CheckCounterpartsPresent(nodes []*Node, role string, locals map[string]*Local, missing map[string]bool, diags *DiagnosticCollector) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			other := n.Mess.To
+			verb := " sends " + n.Mess.Name + " to "
+			if other == role {
+				other = n.Mess.From
+				verb = " receives " + n.Mess.Name + " from "
+			}
+			if _, ok := locals[other]; !ok && !missing[role+" "+other] {
+				missing[role+" "+other] = true
+				diags.Add(n.Mess.Pos, role+verb+other+", but no local protocol for "+other+" is being combined with it", "pass the local protocol located at "+other+" as well")
+			}
+		} else if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				CheckCounterpartsPresent(conv.Nodes, role, locals, missing, diags)
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				CheckCounterpartsPresent(conv.Nodes, role, locals, missing, diags)
+			}
+		} else if n.Cat == "rec" {
+			CheckCounterpartsPresent(n.Rec.Conv.Nodes, role, locals, missing, diags)
+		}
+	}
+}
+
+// RestrictNodes keeps only the messages which role exchanges with other.
+// Choices whose branches all restrict to the same actions, par blocks
+// with at most one branch left and rec blocks left without messages are
+// replaced by what remains of them.
+func RestrictNodes(nodes []*Node, role string, other string, typeMap map[string]*TypeData) []*Action {
+	actions := make([]*Action, 0)
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			mess := n.Mess
+			if mess.From == role && mess.To == other {
+				actions = append(actions, &Action{Kind: "send", Mess: mess, Types: ResolveActionTypes(mess, typeMap), Pos: mess.Pos})
+			} else if mess.From == other && mess.To == role {
+				actions = append(actions, &Action{Kind: "receive", Mess: mess, Types: ResolveActionTypes(mess, typeMap), Pos: mess.Pos})
+			}
+		} else if n.Cat == "choice" {
+			branches := RestrictBranches(n.Choice.Convs, role, other, typeMap)
+			if AllBranchesAlike(branches) {
+				actions = append(actions, branches[0]...)
+			} else {
+				actions = append(actions, &Action{Kind: "choice", Branches: branches, Pos: n.Choice.Pos})
+			}
+		} else if n.Cat == "par" {
+			branches := make([][]*Action, 0)
+			for _, branch := range RestrictBranches(n.Par.Convs, role, other, typeMap) {
+				if len(branch) > 0 {
+					branches = append(branches, branch)
+				}
+			}
+			if len(branches) == 1 {
+				actions = append(actions, branches[0]...)
+			} else if len(branches) > 1 {
+				actions = append(actions, &Action{Kind: "par", Branches: branches, Pos: n.Par.Pos})
+			}
+		} else if n.Cat == "rec" {
+			body := RestrictNodes(n.Rec.Conv.Nodes, role, other, typeMap)
+			if ActionsHaveMessages(body) {
+				actions = append(actions, &Action{Kind: "rec", Body: body, Pos: n.Rec.Pos})
+			}
+		} else if n.Cat == "continue" {
+			actions = append(actions, &Action{Kind: "continue", Pos: n.Cont.Pos})
+		}
+	}
+	return actions
+}
+
+func RestrictBranches(convs []*Conversation, role string, other string, typeMap map[string]*TypeData) [][]*Action {
+	branches := make([][]*Action, 0)
+	for _, conv := range convs {
+		branches = append(branches, RestrictNodes(conv.Nodes, role, other, typeMap))
+	}
+	return branches
+}
+
+func ResolveActionTypes(mess *Message, typeMap map[string]*TypeData) []string {
+	types := make([]string, 0)
+	for _, typ := range mess.Types {
+		resolved, _, _, _ := ResolveTypeExpression(typ, typeMap)
+		types = append(types, resolved)
+	}
+	return types
+}
+
+func AllBranchesAlike(branches [][]*Action) bool {
+	for _, branch := range branches {
+		if GetActionsKey(branch) != GetActionsKey(branches[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetActionsKey returns a string which is the same for two sequences of
+// actions exactly when they describe the same exchanges.
+func GetActionsKey(actions []*Action) string {
+	key := ""
+	for _, a := range actions {
+		key += a.Kind
+		if a.Mess != nil {
+			key += " " + a.Mess.Name + "(" + strings.Join(a.Types, ", ") + ")"
+		}
+		for _, branch := range a.Branches {
+			key += "{" + GetActionsKey(branch) + "}"
+		}
+		if a.Kind == "rec" {
+			key += "{" + GetActionsKey(a.Body) + "}"
+		}
+		key += ";"
+	}
+	return key
+}
+
+func ActionsHaveMessages(actions []*Action) bool {
+	return FirstActionMessage(actions) != nil
+}
+
+// FirstActionMessage returns the first send or receive within actions,
+// or nil if there is none.
+func FirstActionMessage(actions []*Action) *Action {
+	for _, a := range actions {
+		if a.Kind == "send" || a.Kind == "receive" {
+			return a
+		}
+		for _, branch := range a.Branches {
+			if first := FirstActionMessage(branch); first != nil {
+				return first
+			}
+		}
+		if first := FirstActionMessage(a.Body); first != nil {
+			return first
+		}
+	}
+	return nil
+}
+
+func JoinActions(first []*Action, rest []*Action) []*Action {
+	joined := make([]*Action, 0)
+	joined = append(joined, first...)
+	return append(joined, rest...)
+}
+
+// CompareActions walks the restrictions of the local protocols of
+// pair.Role and pair.Other side by side. Comparison along a path stops
+// at the first incompatibility found on it.
+func CompareActions(as []*Action, bs []*Action, pair *CompatibilityPair) {
+	for len(as) > 0 && len(bs) > 0 {
+		a := as[0]
+		b := bs[0]
+		if a.Kind == "continue" || b.Kind == "continue" {
+			return
+		}
+		if IsMessageAction(a) && IsMessageAction(b) {
+			if !CompareMessageActions(a, b, pair) {
+				return
+			}
+			as = as[1:]
+			bs = bs[1:]
+		} else if a.Kind == "choice" && b.Kind == "choice" {
+			CompareChoices(a, b, as[1:], bs[1:], pair)
+			return
+		} else if a.Kind == "choice" {
+			for _, branch := range a.Branches {
+				CompareActions(JoinActions(branch, as[1:]), bs, pair)
+			}
+			return
+		} else if b.Kind == "choice" {
+			for _, branch := range b.Branches {
+				CompareActions(as, JoinActions(branch, bs[1:]), pair)
+			}
+			return
+		} else if a.Kind == "rec" || b.Kind == "rec" {
+			as = UnfoldAction(a, as)
+			bs = UnfoldAction(b, bs)
+		} else if a.Kind == "par" && b.Kind == "par" && len(a.Branches) == len(b.Branches) {
+			for i := range a.Branches {
+				CompareActions(a.Branches[i], b.Branches[i], pair)
+			}
+			as = as[1:]
+			bs = bs[1:]
+		} else {
+			// A par block on one side only is compared as if its branches
+			// ran one after the other
+			as = UnfoldAction(a, as)
+			bs = UnfoldAction(b, bs)
+		}
+	}
+	ReportUnmatched(as, pair.Role, pair.Other, pair)
+	ReportUnmatched(bs, pair.Other, pair.Role, pair)
+}
+
+func IsMessageAction(a *Action) bool {
+	return a.Kind == "send" || a.Kind == "receive"
+}
+
+// UnfoldAction replaces a rec or par block at the head of actions by its
+// contents, leaving other actions as they are.
+func UnfoldAction(a *Action, actions []*Action) []*Action {
+	if a.Kind == "rec" {
+		return JoinActions(a.Body, actions[1:])
+	}
+	if a.Kind == "par" {
+		unfolded := make([]*Action, 0)
+		for _, branch := range a.Branches {
+			unfolded = append(unfolded, branch...)
+		}
+		return JoinActions(unfolded, actions[1:])
+	}
+	return actions
+}
+
+func GetActionSignature(a *Action) string {
+	return a.Mess.Name + "(" + strings.Join(a.Types, ", ") + ")"
+}
+
+// CompareMessageActions reports whether a and b are the two ends of the
+// same message, reporting the incompatibility if not.
+func CompareMessageActions(a *Action, b *Action, pair *CompatibilityPair) bool {
+	if a.Kind == b.Kind {
+		if a.Kind == "send" {
+			pair.Report(a.Pos, pair.Role+" and "+pair.Other+" both send at this point: "+pair.Role+" sends "+GetActionSignature(a)+" while "+pair.Other+" sends "+GetActionSignature(b), b.Pos, "one of them must receive the other's message first")
+		} else {
+			pair.Report(a.Pos, pair.Role+" and "+pair.Other+" both wait to receive at this point: "+pair.Role+" expects "+GetActionSignature(a)+" while "+pair.Other+" expects "+GetActionSignature(b), b.Pos, "one of them must send first")
+		}
+		return false
+	}
+	sender, receiver := pair.Role, pair.Other
+	send, receive := a, b
+	if a.Kind == "receive" {
+		sender, receiver = pair.Other, pair.Role
+		send, receive = b, a
+	}
+	if send.Mess.Name != receive.Mess.Name {
+		pair.Report(send.Pos, sender+" sends "+GetActionSignature(send)+" to "+receiver+", but "+receiver+" expects "+GetActionSignature(receive), receive.Pos, "the labels of the two messages must match")
+		return false
+	}
+	if GetActionSignature(send) != GetActionSignature(receive) {
+		pair.Report(send.Pos, sender+" sends "+GetActionSignature(send)+" to "+receiver+", but "+receiver+" expects "+GetActionSignature(receive), receive.Pos, "the payload types of the two messages must match")
+		return false
+	}
+	return true
+}
+
+// CompareChoices matches the branches of two choices by the label of the
+// message each begins with, and compares each matched pair of branches
+// followed by whatever follows the choices.
+func CompareChoices(a *Action, b *Action, as []*Action, bs []*Action, pair *CompatibilityPair) {
+	matched := make(map[int]bool)
+	for _, branch := range a.Branches {
+		j := FindMatchingBranch(branch, b.Branches)
+		if j < 0 {
+			ReportMissingBranch(branch, a, b, pair.Role, pair.Other, pair)
+			continue
+		}
+		matched[j] = true
+		CompareActions(JoinActions(branch, as), JoinActions(b.Branches[j], bs), pair)
+	}
+	for j, branch := range b.Branches {
+		if !matched[j] && FindMatchingBranch(branch, a.Branches) < 0 {
+			ReportMissingBranch(branch, b, a, pair.Other, pair.Role, pair)
+		}
+	}
+}
+
+func GetBranchLabel(branch []*Action) string {
+	first := FirstActionMessage(branch)
+	if first == nil {
+		return ""
+	}
+	return first.Mess.Name
+}
+
+func FindMatchingBranch(branch []*Action, branches [][]*Action) int {
+	label := GetBranchLabel(branch)
+	for j, other := range branches {
+		if GetBranchLabel(other) == label {
+			return j
+		}
+	}
+	return -1
+}
+
+func ReportMissingBranch(branch []*Action, choice *Action, otherChoice *Action, role string, other string, pair *CompatibilityPair) {
+	first := FirstActionMessage(branch)
+	if first == nil {
+		pair.Report(choice.Pos, "A branch of a choice in the local protocol at "+role+" exchanges no messages with "+other+", but every branch of the matching choice at "+other+" does", otherChoice.Pos, "")
+		return
+	}
+	pair.Report(first.Pos, "A branch of a choice in the local protocol at "+role+" begins with "+GetActionSignature(first)+", which the matching choice at "+other+" does not offer", otherChoice.Pos, "add a branch beginning with "+first.Mess.Name+" to the choice at "+other+", or remove this branch")
+}
+
+// ReportUnmatched reports the first message in actions, which remain
+// after the other role has run out of messages to exchange with role.
+func ReportUnmatched(actions []*Action, role string, other string, pair *CompatibilityPair) {
+	first := FirstActionMessage(actions)
+	if first == nil {
+		return
+	}
+	if first.Kind == "send" {
+		pair.Report(first.Pos, role+" sends "+GetActionSignature(first)+" to "+other+", which "+other+" never receives", Position{}, "add the message to the local protocol at "+other)
+	} else {
+		pair.Report(first.Pos, role+" expects "+GetActionSignature(first)+" from "+other+", which "+other+" never sends", Position{}, "add the message to the local protocol at "+other)
+	}
+}
+
+// Report adds a diagnostic at pos, giving the position of the other
+// role's side of the incompatibility, if known, in the hint. Each
+// incompatibility is reported once however many paths lead to it.
+func (pair *CompatibilityPair) Report(pos Position, message string, otherPos Position, hint string) {
+	if otherPos != (Position{}) {
+		if hint != "" {
+			hint = "; " + hint
+		}
+		hint = "see " + otherPos.String() + hint
+	}
+	key := pos.String() + message
+	if pair.Reported[key] {
+		return
+	}
+	pair.Reported[key] = true
+	pair.Diags.Add(pos, message, hint)
+}
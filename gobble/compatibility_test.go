@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ParseTestLocals parses the local protocol at each of the roles A, B
+// and C given a body in bodies.
+func ParseTestLocals(t *testing.T, bodies map[string]string) []*Protocol {
+	t.Helper()
+	trees := make([]*Protocol, 0)
+	for _, role := range []string{"A", "B", "C"} {
+		body, ok := bodies[role]
+		if !ok {
+			continue
+		}
+		source := "module M;\n\ntype <go> \"int\" from \"\" as Count;\n\nlocal protocol P at " + role + "(role A, role B, role C) {\n\t" + body + "\n}\n"
+		tree, err := ParseTestSource(source)
+		if err != nil {
+			t.Fatalf("unexpected error parsing local protocol at %s: %v", role, err)
+		}
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+func CheckTestCompatibility(t *testing.T, bodies map[string]string) error {
+	t.Helper()
+	return CheckCompatibility(ParseTestLocals(t, bodies))
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name   string
+		bodies map[string]string
+		want   string
+	}{
+		{
+			name:   "compatible",
+			bodies: map[string]string{"A": "m(Count) to B; rec L { choice at A { x() to B; continue L; } or { y() to B; } }", "B": "m(int) from A; rec L { choice at A { x() from A; continue L; } or { y() from A; } }"},
+		},
+		{
+			name:   "third role ignored",
+			bodies: map[string]string{"A": "m() to B; n() to C;", "B": "m() from A;", "C": "n() from A;"},
+		},
+		{
+			name:   "labels differ",
+			bodies: map[string]string{"A": "m() to B;", "B": "n() from A;"},
+			want:   "A sends m() to B, but B expects n()\n\thint: see test.scr:6:2; the labels of the two messages must match",
+		},
+		{
+			name:   "types differ",
+			bodies: map[string]string{"A": "m(int) to B;", "B": "m(string) from A;"},
+			want:   "A sends m(int) to B, but B expects m(string)",
+		},
+		{
+			name:   "both send",
+			bodies: map[string]string{"A": "m() to B;", "B": "m() to A;"},
+			want:   "A and B both send at this point",
+		},
+		{
+			name:   "both receive",
+			bodies: map[string]string{"A": "m() from B;", "B": "m() from A;"},
+			want:   "A and B both wait to receive at this point",
+		},
+		{
+			name:   "never received",
+			bodies: map[string]string{"A": "m() to B; n() to B;", "B": "m() from A;"},
+			want:   "A sends n() to B, which B never receives",
+		},
+		{
+			name:   "never sent",
+			bodies: map[string]string{"A": "m() to B;", "B": "m() from A; n() from A;"},
+			want:   "B expects n() from A, which A never sends",
+		},
+		{
+			name:   "branch not offered",
+			bodies: map[string]string{"A": "choice at A { x() to B; } or { y() to B; }", "B": "choice at A { x() from A; } or { z() from A; }"},
+			want:   "begins with y(), which the matching choice at B does not offer",
+		},
+		{
+			name:   "missing counterpart",
+			bodies: map[string]string{"A": "m() to B; n() to C;", "B": "m() from A;"},
+			want:   "no local protocol for C is being combined with it",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckTestCompatibility(t, test.bodies)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckCompatibilityRepeatedRole(t *testing.T) {
+	a, err := ParseTestSource("module M;\n\nlocal protocol P at A(role A, role B) {\n\tm() to B;\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckCompatibility([]*Protocol{a, a})
+	if err == nil || !strings.Contains(err.Error(), "More than one local protocol is located at A") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestCheckCompatibilityCaseStudy(t *testing.T) {
+	trees, err := ParseFiles([]string{"aggregatorLocal.scr", "clientLocal.scr", "brutishAirwaysLocal.scr", "queasyJetLocal.scr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckCompatibility(trees)
+	if err != nil {
+		t.Errorf("case study locals are incompatible: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Diagnostics describe problems found in a Scribble protocol by the
+// lexer, the parser, the projector or the translator. Each diagnostic
+// records the position in the source file at which the problem was
+// found, a message describing it and, where one can be given, a hint
+// suggesting how to fix it and a trace of the steps which lead to it.
+// Diagnostics are returned as errors rather than causing Gobble to
+// panic, so that the user is shown the offending line of the .scr file
+// instead of a Go stack trace.
+
+// input: positions and messages from the lexer, parser, projector and
+// translator
+
+// output: errors of the form ``file:line:col: message''
+
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+type Position struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+func (pos Position) String() string {
+	s := pos.File
+	if pos.Line > 0 {
+		if s != "" {
+			s += ":"
+		}
+		s += strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Col)
+	}
+	return s
+}
+
+type Diagnostic struct {
+	Pos     Position
+	Message string
+	Hint    string
+	Trace   []string
+}
+
+func NewDiagnostic(pos Position, message string, hint string) *Diagnostic {
+	return &Diagnostic{Pos: pos, Message: message, Hint: hint}
+}
+
+func (d *Diagnostic) Error() string {
+	s := ""
+	if pos := d.Pos.String(); pos != "" {
+		s += pos + ": "
+	}
+	s += d.Message
+	if d.Hint != "" {
+		s += "\n\thint: " + d.Hint
+	}
+	if len(d.Trace) > 0 {
+		s += "\n\ttrace:"
+		for i, step := range d.Trace {
+			s += "\n\t\t" + strconv.Itoa(i+1) + ". " + step
+		}
+	}
+	return s
+}
+
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	s := ""
+	for i, d := range ds {
+		if i > 0 {
+			s += "\n"
+		}
+		s += d.Error()
+	}
+	return s
+}
+
+// Err returns the diagnostics as an error, or nil if there are none, so
+// that an empty Diagnostics is never mistaken for a failure.
+func (ds Diagnostics) Err() error {
+	if len(ds) == 0 {
+		return nil
+	}
+	return ds
+}
+
+// AppendDiagnostics adds the diagnostics carried by err, if any, to ds.
+func AppendDiagnostics(ds Diagnostics, err error) Diagnostics {
+	if err == nil {
+		return ds
+	}
+	switch e := err.(type) {
+	case Diagnostics:
+		return append(ds, e...)
+	case *Diagnostic:
+		return append(ds, e)
+	default:
+		return append(ds, NewDiagnostic(Position{}, err.Error(), ""))
+	}
+}
+
+// DiagnosticCollector gathers diagnostics from the goroutines launched
+// by the parser.
+type DiagnosticCollector struct {
+	mutex       sync.Mutex
+	Diagnostics Diagnostics
+}
+
+func (dc *DiagnosticCollector) Add(pos Position, message string, hint string) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	dc.Diagnostics = append(dc.Diagnostics, NewDiagnostic(pos, message, hint))
+}
+
+func (dc *DiagnosticCollector) AddDiagnostics(ds Diagnostics) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	dc.Diagnostics = append(dc.Diagnostics, ds...)
+}
+
+func (dc *DiagnosticCollector) Err() error {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	return dc.Diagnostics.Err()
+}
@@ -0,0 +1,505 @@
+// The diagram writer draws protocols as sequence diagrams, in Mermaid
+// (sequenceDiagram) and PlantUML syntax, so that they can be reviewed
+// without being redrawn by hand. Every message becomes an arrow from its
+// sender to its receiver labelled with its name and payload types, a
+// choice becomes an alt block with one section per branch, a par block a
+// par block and a rec block a loop; a continue is drawn as a note. A
+// local protocol given on its own is drawn as it is, showing only the
+// messages its role sends and receives. Local protocols of the same name
+// given together, whether written by hand or projected from a global
+// protocol, are combined into a single diagram: each message is drawn
+// once, where its sender sends it and its receiver receives it, and each
+// choice, par and rec block is taken from the role which runs it, with
+// the other roles following the branch which begins with the messages
+// they are sent. Local protocols which cannot be combined in this way
+// are reported as Diagnostics.
+
+// input: trees of structs, each holding the single local protocol of one
+// role
+
+// output: a Mermaid .mmd file and a PlantUML .puml file for each protocol,
+// written to the diagrams subdirectory of the output directory
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// DiagramCursor holds the nodes of the local protocol of Role which have
+// not yet been drawn.
+type DiagramCursor struct {
+	Role  string
+	Nodes []*Node
+}
+
+type SequenceDiagram struct {
+	Format       string
+	Participants []string
+	Output       string
+	Indent       int
+}
+
+// DiagramGroup is the local protocols of one protocol to be drawn in one
+// diagram.
+type DiagramGroup struct {
+	Name   string
+	Locals []*Local
+}
+
+func GroupLocalsByName(trees []*Protocol) []*DiagramGroup {
+	groups := make([]*DiagramGroup, 0)
+	for _, tree := range trees {
+		for _, l := range tree.Locals {
+			var group *DiagramGroup
+			for _, g := range groups {
+				if g.Name == l.Name {
+					group = g
+				}
+			}
+			if group == nil {
+				group = &DiagramGroup{Name: l.Name}
+				groups = append(groups, group)
+			}
+			group.Locals = append(group.Locals, l)
+		}
+	}
+	return groups
+}
+
+// GetDiagramNodes returns the nodes to draw for a group: those of its only
+// local protocol, or the combination of all of them.
+func GetDiagramNodes(group *DiagramGroup, diags *DiagnosticCollector) []*Node {
+	if len(group.Locals) == 1 {
+		return group.Locals[0].Conv.Nodes
+	}
+	cursors := make([]*DiagramCursor, 0)
+	for _, l := range group.Locals {
+		cursors = append(cursors, &DiagramCursor{Role: l.Protagonist, Nodes: l.Conv.Nodes})
+	}
+	return CombineLocalNodes(cursors, len(cursors), group.Name, diags)
+}
+
+func GetDiagramParticipants(group *DiagramGroup) []string {
+	participants := make([]string, 0)
+	for _, l := range group.Locals {
+		for _, r := range l.Roles {
+			if !SliceContainsString(participants, r.Name) {
+				participants = append(participants, r.Name)
+			}
+		}
+	}
+	return participants
+}
+
+// CombineLocalNodes draws the local protocols held by cursors together,
+// returning nodes in the form of a global protocol and advancing each
+// cursor past the nodes it has drawn. Drawing stops once the first owners
+// cursors are empty; the rest are only advanced as far as is needed to
+// draw them.
+func CombineLocalNodes(cursors []*DiagramCursor, owners int, name string, diags *DiagnosticCollector) []*Node {
+	combined := make([]*Node, 0)
+	var cont *Node
+	for {
+		// A continue ends the branch for its role, but is drawn only once
+		// every role has exchanged its messages; the roles shared with
+		// other branches keep theirs for whatever follows the block
+		for _, c := range cursors[:owners] {
+			if len(c.Nodes) > 0 && c.Nodes[0].Cat == "continue" {
+				if cont == nil {
+					cont = c.Nodes[0]
+				}
+				c.Nodes = nil
+			}
+		}
+		if AllCursorsEmpty(cursors[:owners]) {
+			if cont != nil {
+				combined = append(combined, cont)
+			}
+			return combined
+		}
+		if mess := CombineNextMessage(cursors); mess != nil {
+			combined = append(combined, &Node{Cat: "message", Mess: mess})
+			continue
+		}
+		driver := FindBlockDriver(cursors)
+		if driver == nil {
+			for _, c := range cursors {
+				if len(c.Nodes) > 0 {
+					diags.Add(GetNodePos(c.Nodes[0]), "The local protocols of "+name+" cannot be combined into one sequence diagram: no role is ready to exchange a message with "+c.Role+" at this point", "check the protocols with gobble check, or draw each local protocol on its own")
+					break
+				}
+			}
+			return combined
+		}
+		combined = append(combined, CombineBlock(driver, cursors, name, diags))
+	}
+}
+
+func AllCursorsEmpty(cursors []*DiagramCursor) bool {
+	for _, c := range cursors {
+		if len(c.Nodes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func FindCursor(cursors []*DiagramCursor, role string) *DiagramCursor {
+	for _, c := range cursors {
+		if c.Role == role {
+			return c
+		}
+	}
+	return nil
+}
+
+// CombineNextMessage finds a role about to send a message which its
+// receiver is about to receive, advances both past it and returns it.
+func CombineNextMessage(cursors []*DiagramCursor) *Message {
+	for _, c := range cursors {
+		if len(c.Nodes) == 0 || c.Nodes[0].Cat != "message" || c.Nodes[0].Mess.From != c.Role {
+			continue
+		}
+		send := c.Nodes[0].Mess
+		receiver := FindCursor(cursors, send.To)
+		if receiver == nil || len(receiver.Nodes) == 0 || receiver.Nodes[0].Cat != "message" {
+			continue
+		}
+		receive := receiver.Nodes[0].Mess
+		if receive.From == c.Role && receive.Name == send.Name {
+			c.Nodes = c.Nodes[1:]
+			receiver.Nodes = receiver.Nodes[1:]
+			return send
+		}
+	}
+	return nil
+}
+
+// FindBlockDriver returns the cursor whose block is to be drawn next: the
+// first role making a choice, or failing that the first role beginning a
+// par block, a rec block or a choice made by another role.
+func FindBlockDriver(cursors []*DiagramCursor) *DiagramCursor {
+	for _, cat := range []string{"choice", "par", "rec"} {
+		for _, c := range cursors {
+			if len(c.Nodes) > 0 && c.Nodes[0].Cat == cat && (cat != "choice" || c.Nodes[0].Choice.Chooser == c.Role) {
+				return c
+			}
+		}
+	}
+	for _, c := range cursors {
+		if len(c.Nodes) > 0 && c.Nodes[0].Cat == "choice" {
+			return c
+		}
+	}
+	return nil
+}
+
+// IsMatchingBlock reports whether n, at the head of another role's
+// cursor, belongs to the same block as the driver's node block.
+func IsMatchingBlock(n *Node, block *Node) bool {
+	if n.Cat != block.Cat {
+		return false
+	}
+	if n.Cat == "choice" {
+		return n.Choice.Chooser == block.Choice.Chooser
+	}
+	return true
+}
+
+func GetBlockBranches(n *Node) []*Conversation {
+	if n.Cat == "choice" {
+		return n.Choice.Convs
+	}
+	if n.Cat == "par" {
+		return n.Par.Convs
+	}
+	return []*Conversation{n.Rec.Conv}
+}
+
+// CombineBlock draws the choice, par or rec block at the head of driver.
+// The other roles beginning a matching block follow, in each branch, the
+// branch of their own block which begins with a message sent or received
+// in the branches followed so far, or the body of their own rec block.
+// The remaining roles are shared by the branches of a par block, which
+// take turns to advance them, and start afresh in each branch of a
+// choice.
+func CombineBlock(driver *DiagramCursor, cursors []*DiagramCursor, name string, diags *DiagnosticCollector) *Node {
+	block := driver.Nodes[0]
+	driver.Nodes = driver.Nodes[1:]
+	followers := make([]*DiagramCursor, 0)
+	blocks := make(map[string]*Node)
+	for _, c := range cursors {
+		if c != driver && len(c.Nodes) > 0 && IsMatchingBlock(c.Nodes[0], block) {
+			blocks[c.Role] = c.Nodes[0]
+			c.Nodes = c.Nodes[1:]
+			followers = append(followers, c)
+		}
+	}
+	var afterFirstBranch []*DiagramCursor
+	convs := make([]*Conversation, 0)
+	for i, conv := range GetBlockBranches(block) {
+		branch := []*DiagramCursor{&DiagramCursor{Role: driver.Role, Nodes: conv.Nodes}}
+		messages := make(map[string]bool)
+		AddMessageKeys(conv.Nodes, messages)
+		// A role may learn which branch was taken from another follower,
+		// so keep looking until no more followers find their branch
+		following := make(map[string]bool)
+		for found := true; found; {
+			found = false
+			for _, f := range followers {
+				if following[f.Role] {
+					continue
+				}
+				var nodes []*Node
+				ok := block.Cat == "rec"
+				if ok {
+					nodes = blocks[f.Role].Rec.Conv.Nodes
+				} else {
+					nodes, ok = FindFollowerBranch(blocks[f.Role], messages)
+				}
+				if ok {
+					branch = append(branch, &DiagramCursor{Role: f.Role, Nodes: nodes})
+					AddMessageKeys(nodes, messages)
+					following[f.Role] = true
+					found = true
+				}
+			}
+		}
+		shared := make([]*DiagramCursor, 0)
+		for _, c := range cursors {
+			if c == driver || blocks[c.Role] != nil {
+				continue
+			}
+			if block.Cat == "choice" {
+				c = &DiagramCursor{Role: c.Role, Nodes: c.Nodes}
+			}
+			shared = append(shared, c)
+		}
+		owners := len(branch)
+		branch = append(branch, shared...)
+		if i == 0 {
+			afterFirstBranch = shared
+		}
+		nodes := CombineLocalNodes(branch, owners, name, diags)
+		convs = append(convs, &Conversation{Nodes: nodes, Pos: conv.Pos})
+	}
+	if block.Cat == "choice" {
+		// Roles outside the choice continue as they were left by the
+		// first branch
+		for _, c := range afterFirstBranch {
+			FindCursor(cursors, c.Role).Nodes = c.Nodes
+		}
+		return &Node{Cat: "choice", Choice: &Choice{Chooser: block.Choice.Chooser, Convs: convs, Pos: block.Choice.Pos}}
+	}
+	if block.Cat == "par" {
+		return &Node{Cat: "par", Par: &Parallel{Convs: convs, Pos: block.Par.Pos}}
+	}
+	return &Node{Cat: "rec", Rec: &Rec{Name: block.Rec.Name, Conv: convs[0], Pos: block.Rec.Pos}}
+}
+
+func GetMessageKey(mess *Message) string {
+	return mess.Name + " " + mess.From + " " + mess.To
+}
+
+// AddMessageKeys adds a key for every message within nodes to keys.
+func AddMessageKeys(nodes []*Node, keys map[string]bool) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			keys[GetMessageKey(n.Mess)] = true
+		}
+		if n.Cat == "choice" || n.Cat == "par" || n.Cat == "rec" {
+			for _, conv := range GetBlockBranches(n) {
+				AddMessageKeys(conv.Nodes, keys)
+			}
+		}
+	}
+}
+
+// FindFollowerBranch returns the nodes of the branch of block which
+// begins with one of the messages whose keys are given.
+func FindFollowerBranch(block *Node, keys map[string]bool) ([]*Node, bool) {
+	for _, conv := range GetBlockBranches(block) {
+		for _, first := range FirstMessages(conv.Nodes) {
+			if keys[GetMessageKey(first)] {
+				return conv.Nodes, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func GetNodePos(n *Node) Position {
+	switch n.Cat {
+	case "message":
+		return n.Mess.Pos
+	case "choice":
+		return n.Choice.Pos
+	case "par":
+		return n.Par.Pos
+	case "rec":
+		return n.Rec.Pos
+	case "continue":
+		return n.Cont.Pos
+	}
+	return Position{}
+}
+
+func GetDiagramMessageLabel(mess *Message) string {
+	params := make([]string, 0)
+	for i, typ := range mess.Types {
+		if i < len(mess.Names) && mess.Names[i] != "" {
+			typ = mess.Names[i] + ": " + typ
+		}
+		params = append(params, typ)
+	}
+	return mess.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// GetDiagramBranchLabel returns the label of a section of an alt block: the
+// name of the first message of the branch.
+func GetDiagramBranchLabel(conv *Conversation) string {
+	firsts := FirstMessages(conv.Nodes)
+	if len(firsts) == 0 {
+		return ""
+	}
+	return firsts[0].Name
+}
+
+func (d *SequenceDiagram) WriteLine(line string) {
+	d.Output += strings.Repeat("    ", d.Indent) + line + "\n"
+}
+
+func (d *SequenceDiagram) WriteHeader() {
+	if d.Format == "mermaid" {
+		d.WriteLine("sequenceDiagram")
+		d.Indent++
+	} else {
+		d.WriteLine("@startuml")
+	}
+	for _, p := range d.Participants {
+		d.WriteLine("participant " + p)
+	}
+}
+
+func (d *SequenceDiagram) WriteFooter() {
+	if d.Format == "plantuml" {
+		d.WriteLine("@enduml")
+	}
+}
+
+func (d *SequenceDiagram) WriteMessage(mess *Message) {
+	if d.Format == "mermaid" {
+		d.WriteLine(mess.From + "->>" + mess.To + ": " + GetDiagramMessageLabel(mess))
+	} else {
+		d.WriteLine(mess.From + " -> " + mess.To + " : " + GetDiagramMessageLabel(mess))
+	}
+}
+
+func (d *SequenceDiagram) WriteNote(text string) {
+	over := d.Participants[0]
+	if len(d.Participants) > 1 {
+		over += "," + d.Participants[len(d.Participants)-1]
+	}
+	if d.Format == "mermaid" {
+		d.WriteLine("Note over " + over + ": " + text)
+	} else {
+		d.WriteLine("note over " + over + " : " + text)
+	}
+}
+
+// WriteBlock writes a block whose sections hold the branches of a choice
+// or par block or the body of a rec block.
+func (d *SequenceDiagram) WriteBlock(keyword string, labels []string, convs []*Conversation) {
+	separator := "else"
+	if keyword == "par" && d.Format == "mermaid" {
+		separator = "and"
+	}
+	for i, conv := range convs {
+		line := keyword
+		if i > 0 {
+			line = separator
+		}
+		if labels[i] != "" {
+			line += " " + labels[i]
+		}
+		d.WriteLine(line)
+		d.Indent++
+		d.WriteNodes(conv.Nodes)
+		d.Indent--
+	}
+	d.WriteLine("end")
+}
+
+func (d *SequenceDiagram) WriteNodes(nodes []*Node) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			d.WriteMessage(n.Mess)
+		} else if n.Cat == "choice" {
+			labels := make([]string, 0)
+			for _, conv := range n.Choice.Convs {
+				labels = append(labels, GetDiagramBranchLabel(conv))
+			}
+			d.WriteBlock("alt", labels, n.Choice.Convs)
+		} else if n.Cat == "par" {
+			d.WriteBlock("par", make([]string, len(n.Par.Convs)), n.Par.Convs)
+		} else if n.Cat == "rec" {
+			d.WriteBlock("loop", []string{n.Rec.Name}, []*Conversation{n.Rec.Conv})
+		} else if n.Cat == "continue" {
+			d.WriteNote("continue " + n.Cont.Name)
+		}
+	}
+}
+
+func WriteSequenceDiagram(format string, participants []string, nodes []*Node) string {
+	d := &SequenceDiagram{Format: format, Participants: participants}
+	d.WriteHeader()
+	d.WriteNodes(nodes)
+	d.WriteFooter()
+	return d.Output
+}
+
+func WriteDiagramToFile(name string, extension string, content string, moduleName string) {
+	sep := string(os.PathSeparator)
+	path := "." + sep + "output" + sep + moduleName + "_Gobble" + sep + "diagrams" + sep
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		log.Fatal("Error creating directory: ", err)
+	}
+	file, err := os.Create(path + name + extension)
+	if err != nil {
+		log.Fatal("Cannot create file: ", err)
+	}
+	fmt.Fprint(file, content)
+	file.Close()
+}
+
+func DiagramFiles(fileNames []string, moduleName string) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to draw.")
+	}
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	diags := &DiagnosticCollector{}
+	for _, group := range GroupLocalsByName(trees) {
+		problems := len(diags.Diagnostics)
+		nodes := GetDiagramNodes(group, diags)
+		if len(diags.Diagnostics) > problems {
+			continue
+		}
+		participants := GetDiagramParticipants(group)
+		name := group.Name
+		if len(group.Locals) == 1 {
+			name += "_" + group.Locals[0].Protagonist
+		}
+		WriteDiagramToFile(name, ".mmd", WriteSequenceDiagram("mermaid", participants, nodes), moduleName)
+		WriteDiagramToFile(name, ".puml", WriteSequenceDiagram("plantuml", participants, nodes), moduleName)
+	}
+	return diags.Err()
+}
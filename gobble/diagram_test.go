@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const DiagramTestSource = "module M;\n\nglobal protocol P(role A, role B, role C) {\n\tm(x: int, string) from A to B;\n\trec L {\n\t\tchoice at B { More() from B to A; par { n() from A to C; } and { o() from B to C; } continue L; } or { Done() from B to A; Stop() from B to C; }\n\t}\n}\n"
+
+func TestWriteSequenceDiagram(t *testing.T) {
+	tree, err := ParseTestSource(DiagramTestSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := tree.Globals[0].Conv.Nodes
+	participants := []string{"A", "B", "C"}
+	mermaid := `sequenceDiagram
+    participant A
+    participant B
+    participant C
+    A->>B: m(x: int, string)
+    loop L
+        alt More
+            B->>A: More()
+            par
+                A->>C: n()
+            and
+                B->>C: o()
+            end
+            Note over A,C: continue L
+        else Done
+            B->>A: Done()
+            B->>C: Stop()
+        end
+    end
+`
+	if got := WriteSequenceDiagram("mermaid", participants, nodes); got != mermaid {
+		t.Errorf("got\n%s\nwant\n%s", got, mermaid)
+	}
+	plantuml := `@startuml
+participant A
+participant B
+participant C
+A -> B : m(x: int, string)
+loop L
+    alt More
+        B -> A : More()
+        par
+            A -> C : n()
+        else
+            B -> C : o()
+        end
+        note over A,C : continue L
+    else Done
+        B -> A : Done()
+        B -> C : Stop()
+    end
+end
+@enduml
+`
+	if got := WriteSequenceDiagram("plantuml", participants, nodes); got != plantuml {
+		t.Errorf("got\n%s\nwant\n%s", got, plantuml)
+	}
+}
+
+func TestGetDiagramNodesCombinesProjections(t *testing.T) {
+	for _, source := range []string{DiagramTestSource, ""} {
+		var tree *Protocol
+		var err error
+		if source == "" {
+			tree, err = ParseAndCheckGlobals("travelAgencyGlobal.scr")
+			if err == nil {
+				err = ProjectAndCheck(tree)
+			}
+		} else {
+			tree, err = ProjectTestSource(t, source)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups := GroupLocalsByName(GetLocalProtocols(tree))
+		if len(groups) != 1 {
+			t.Fatalf("got %d groups, want 1", len(groups))
+		}
+		diags := &DiagnosticCollector{}
+		participants := GetDiagramParticipants(groups[0])
+		got := WriteSequenceDiagram("mermaid", participants, GetDiagramNodes(groups[0], diags))
+		if err := diags.Err(); err != nil {
+			t.Fatal(err)
+		}
+		want := WriteSequenceDiagram("mermaid", participants, tree.Globals[0].Conv.Nodes)
+		if got != want {
+			t.Errorf("%s: combined projections drawn as\n%s\nglobal protocol drawn as\n%s", tree.Globals[0].Name, got, want)
+		}
+	}
+}
+
+func TestGetDiagramNodesReportsIncompatibleLocals(t *testing.T) {
+	trees := ParseTestLocals(t, map[string]string{"A": "m() from B;", "B": "n() from A;"})
+	diags := &DiagnosticCollector{}
+	GetDiagramNodes(GroupLocalsByName(trees)[0], diags)
+	err := diags.Err()
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined into one sequence diagram") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+// The diff command compares two versions of a protocol file and decides
+// whether the new version of each local protocol can safely be deployed
+// against roles still running the old one. Local protocols are paired by
+// name and role, and each pair is compared in two ways. First the two
+// trees are walked side by side, aligning the nodes of each sequence by
+// their longest common subsequence, and every difference is classified:
+// a branch added to or removed from a choice or par block, a message
+// whose payload types have changed, a message which has moved within its
+// sequence, and messages or blocks which have been added or removed.
+// Then the two versions are translated into state machines, as for the
+// deadlock analysis, and checked for session subtyping: the new version
+// may replace the old one if at every point it sends only messages the
+// old version could send and accepts every message the old version
+// accepts, so it may drop branches of a choice it makes but not of a
+// choice made by another role, and the converse for new branches. Each
+// place where the new version fails this check is reported with the
+// messages which lead to it.
+
+// input: the names of two .scr files, holding the old and the new
+// version of one or more protocols
+
+// output: the changes found in each local protocol, written to standard
+// output, and Diagnostics describing every point at which a new version
+// cannot safely replace the old one
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ProtocolChange is a difference between two versions of a local
+// protocol. Kind is "added branch", "removed branch", "payload type
+// change", "reordered messages", "added message", "removed message",
+// "added block" or "removed block".
+type ProtocolChange struct {
+	Kind        string
+	Description string
+	Pos         Position
+}
+
+type ProtocolVersions struct {
+	Old      *Local
+	New      *Local
+	OldTypes map[string]*TypeData
+	NewTypes map[string]*TypeData
+	Changes  []*ProtocolChange
+}
+
+// SubtypingPair is a state of the new version's machine and a state of
+// the old version's machine reached by the same messages. Parent and
+// Step record how the pair was first reached.
+type SubtypingPair struct {
+	New    *CFSMState
+	Old    *CFSMState
+	Parent *SubtypingPair
+	Step   string
+}
+
+func DiffFiles(oldName string, newName string, out io.Writer) error {
+	oldTrees, err := ParseFiles([]string{oldName})
+	if err != nil {
+		return err
+	}
+	newTrees, err := ParseFiles([]string{newName})
+	if err != nil {
+		return err
+	}
+	diags := &DiagnosticCollector{}
+	for _, oldTree := range oldTrees {
+		before := oldTree.Locals[0]
+		newTree := FindLocalVersion(newTrees, before)
+		if newTree == nil {
+			diags.Add(before.Pos, "The new version has no local protocol "+before.Name+" at "+before.Protagonist, "roles running the old version still expect "+before.Protagonist+" to follow it")
+			continue
+		}
+		after := newTree.Locals[0]
+		v := &ProtocolVersions{Old: before, New: after, OldTypes: GetTypeDataMap(oldTree.Types, &MessagesData{}), NewTypes: GetTypeDataMap(newTree.Types, &MessagesData{}), Changes: make([]*ProtocolChange, 0)}
+		v.CompareNodes(before.Conv.Nodes, after.Conv.Nodes)
+		name := before.Name + " at " + before.Protagonist
+		if len(v.Changes) == 0 {
+			fmt.Fprintln(out, name+": no changes")
+		} else {
+			fmt.Fprintln(out, name+":")
+			for _, c := range v.Changes {
+				fmt.Fprintln(out, "\t"+c.Pos.String()+": "+c.Kind+": "+c.Description)
+			}
+		}
+		errs := &DiagnosticCollector{}
+		CheckSubtyping(BuildCFSM(after, v.NewTypes), BuildCFSM(before, v.OldTypes), name, errs)
+		if errs.Err() != nil {
+			fmt.Fprintln(out, "\tthe new version cannot safely replace the old one")
+			diags.AddDiagnostics(errs.Diagnostics)
+		} else if len(v.Changes) > 0 {
+			fmt.Fprintln(out, "\tthe new version can safely replace the old one")
+		}
+	}
+	for _, newTree := range newTrees {
+		after := newTree.Locals[0]
+		if FindLocalVersion(oldTrees, after) == nil {
+			fmt.Fprintln(out, after.Name+" at "+after.Protagonist+": added in the new version")
+		}
+	}
+	return diags.Err()
+}
+
+// FindLocalVersion returns the tree holding the local protocol with the
+// same name and role as l, or nil if there is none.
+func FindLocalVersion(trees []*Protocol, l *Local) *Protocol {
+	for _, tree := range trees {
+		if tree.Locals[0].Name == l.Name && tree.Locals[0].Protagonist == l.Protagonist {
+			return tree
+		}
+	}
+	return nil
+}
+
+func RunDiffCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Please specicfy the old and the new version of a .scr file to compare.")
+	}
+	return DiffFiles(args[0], args[1], os.Stdout)
+}
+
+func (v *ProtocolVersions) AddChange(kind string, description string, pos Position) {
+	v.Changes = append(v.Changes, &ProtocolChange{Kind: kind, Description: description, Pos: pos})
+}
+
+// GetChangeKey returns a string which is the same for a node of the old
+// version and a node of the new version exactly when one may be compared
+// with the other.
+func GetChangeKey(n *Node, protagonist string) string {
+	switch n.Cat {
+	case "message":
+		if n.Mess.From == protagonist {
+			return "send " + n.Mess.Name + " to " + n.Mess.To
+		}
+		return "receive " + n.Mess.Name + " from " + n.Mess.From
+	case "choice":
+		return "choice at " + n.Choice.Chooser
+	case "rec":
+		return "rec " + n.Rec.Name
+	case "continue":
+		return "continue " + n.Cont.Name
+	}
+	return n.Cat
+}
+
+func GetMessageSignature(mess *Message) string {
+	return mess.Name + "(" + strings.Join(mess.Types, ", ") + ")"
+}
+
+func DescribeChangeNode(n *Node, protagonist string) string {
+	switch n.Cat {
+	case "message":
+		if n.Mess.From == protagonist {
+			return protagonist + " sends " + GetMessageSignature(n.Mess) + " to " + n.Mess.To
+		}
+		return protagonist + " receives " + GetMessageSignature(n.Mess) + " from " + n.Mess.From
+	case "choice":
+		return "a choice at " + n.Choice.Chooser
+	case "par":
+		return "a par block"
+	case "rec":
+		return "rec block " + n.Rec.Name
+	case "continue":
+		return "continue " + n.Cont.Name
+	}
+	return n.Cat
+}
+
+// CompareNodes aligns two sequences of nodes by the longest common
+// subsequence of their keys, compares the nodes aligned with one another
+// and classifies the rest.
+func (v *ProtocolVersions) CompareNodes(before []*Node, after []*Node) {
+	protagonist := v.Old.Protagonist
+	// lcs[i][j] is the length of the longest common subsequence of
+	// before[i:] and after[j:]
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if GetChangeKey(before[i], protagonist) == GetChangeKey(after[j], protagonist) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	removed := make([]*Node, 0)
+	added := make([]*Node, 0)
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		if i < len(before) && j < len(after) && GetChangeKey(before[i], protagonist) == GetChangeKey(after[j], protagonist) {
+			v.CompareAlignedNodes(before[i], after[j])
+			i++
+			j++
+		} else if j == len(after) || (i < len(before) && lcs[i+1][j] >= lcs[i][j+1]) {
+			removed = append(removed, before[i])
+			i++
+		} else {
+			added = append(added, after[j])
+			j++
+		}
+	}
+	v.ClassifyUnaligned(removed, added)
+}
+
+func (v *ProtocolVersions) CompareAlignedNodes(before *Node, after *Node) {
+	switch before.Cat {
+	case "message":
+		v.CompareMessageTypes(before.Mess, after.Mess)
+	case "choice":
+		v.CompareBranches(before.Choice.Convs, after.Choice.Convs, "the choice at "+before.Choice.Chooser, after.Choice.Pos)
+	case "par":
+		v.CompareBranches(before.Par.Convs, after.Par.Convs, "the par block", after.Par.Pos)
+	case "rec":
+		v.CompareNodes(before.Rec.Conv.Nodes, after.Rec.Conv.Nodes)
+	}
+}
+
+func (v *ProtocolVersions) CompareMessageTypes(before *Message, after *Message) {
+	oldTypes := ResolveActionTypes(before, v.OldTypes)
+	newTypes := ResolveActionTypes(after, v.NewTypes)
+	if strings.Join(oldTypes, ", ") == strings.Join(newTypes, ", ") {
+		return
+	}
+	direction := " to " + after.To
+	if after.To == v.New.Protagonist {
+		direction = " from " + after.From
+	}
+	v.AddChange("payload type change", after.Name+direction+" carried ("+strings.Join(oldTypes, ", ")+") and now carries ("+strings.Join(newTypes, ", ")+")", after.Pos)
+}
+
+// GetBranchKey returns the key of the first message of a branch, by
+// which branches of the two versions are matched, or "" if the branch
+// has no messages.
+func GetBranchKey(nodes []*Node, protagonist string) (string, *Message) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			return GetChangeKey(n, protagonist), n.Mess
+		}
+		convs := make([]*Conversation, 0)
+		if n.Cat == "choice" {
+			convs = n.Choice.Convs
+		} else if n.Cat == "par" {
+			convs = n.Par.Convs
+		} else if n.Cat == "rec" {
+			convs = append(convs, n.Rec.Conv)
+		}
+		for _, conv := range convs {
+			if key, mess := GetBranchKey(conv.Nodes, protagonist); key != "" {
+				return key, mess
+			}
+		}
+	}
+	return "", nil
+}
+
+// CompareBranches matches the branches of a block in the two versions by
+// the message each begins with, compares each matched pair and reports
+// the branches of either version left unmatched.
+func (v *ProtocolVersions) CompareBranches(before []*Conversation, after []*Conversation, block string, pos Position) {
+	protagonist := v.Old.Protagonist
+	matched := make(map[int]bool)
+	for _, conv := range after {
+		key, mess := GetBranchKey(conv.Nodes, protagonist)
+		found := -1
+		for i, old := range before {
+			if oldKey, _ := GetBranchKey(old.Nodes, protagonist); !matched[i] && oldKey == key {
+				found = i
+				break
+			}
+		}
+		if found >= 0 {
+			matched[found] = true
+			v.CompareNodes(before[found].Nodes, conv.Nodes)
+		} else if mess != nil {
+			v.AddChange("added branch", block+" has a new branch beginning with "+GetMessageSignature(mess), mess.Pos)
+		} else {
+			v.AddChange("added branch", block+" has a new branch which exchanges no messages", pos)
+		}
+	}
+	for i, conv := range before {
+		if matched[i] {
+			continue
+		}
+		if _, mess := GetBranchKey(conv.Nodes, protagonist); mess != nil {
+			v.AddChange("removed branch", block+" no longer has the branch beginning with "+GetMessageSignature(mess)+" ("+mess.Pos.String()+")", pos)
+		} else {
+			v.AddChange("removed branch", block+" no longer has a branch which exchanges no messages", pos)
+		}
+	}
+}
+
+// ClassifyUnaligned reports a message removed from one place in a
+// sequence and added at another as having moved, and every other node
+// left unaligned as having been removed or added.
+func (v *ProtocolVersions) ClassifyUnaligned(removed []*Node, added []*Node) {
+	protagonist := v.Old.Protagonist
+	moved := make(map[*Node]bool)
+	for _, n := range added {
+		if n.Cat != "message" {
+			continue
+		}
+		for _, old := range removed {
+			if !moved[old] && GetChangeKey(old, protagonist) == GetChangeKey(n, protagonist) {
+				moved[old] = true
+				moved[n] = true
+				v.AddChange("reordered messages", DescribeChangeNode(n, protagonist)+" here rather than at "+old.Mess.Pos.String(), n.Mess.Pos)
+				v.CompareMessageTypes(old.Mess, n.Mess)
+				break
+			}
+		}
+	}
+	for _, n := range removed {
+		if moved[n] {
+			continue
+		}
+		kind := "removed block"
+		if n.Cat == "message" {
+			kind = "removed message"
+		}
+		v.AddChange(kind, "the new version no longer has "+DescribeChangeNode(n, protagonist)+" ("+GetNodePos(n).String()+")", GetNodePos(n))
+	}
+	for _, n := range added {
+		if moved[n] {
+			continue
+		}
+		kind := "added block"
+		if n.Cat == "message" {
+			kind = "added message"
+		}
+		v.AddChange(kind, "the new version adds "+DescribeChangeNode(n, protagonist), GetNodePos(n))
+	}
+}
+
+// CheckSubtyping reports every pair of states, reachable by the same
+// messages in the new and the old version, at which the new version may
+// send a message the old version could not or refuse one the old version
+// accepts.
+func CheckSubtyping(after *CFSM, before *CFSM, name string, diags *DiagnosticCollector) {
+	start := &SubtypingPair{New: after.Start, Old: before.Start}
+	seen := map[string]bool{start.Key(): true}
+	reported := make(map[string]bool)
+	queue := []*SubtypingPair{start}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, next := range p.Successors(after.Role, name, reported, diags) {
+			key := next.Key()
+			if !seen[key] {
+				seen[key] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+}
+
+func (p *SubtypingPair) Key() string {
+	return fmt.Sprint(p.New.Id, " ", p.Old.Id)
+}
+
+// Successors checks the transitions of the two states of p against one
+// another and returns the pairs of states which follow the transitions
+// they share.
+func (p *SubtypingPair) Successors(role string, name string, reported map[string]bool, diags *DiagnosticCollector) []*SubtypingPair {
+	successors := make([]*SubtypingPair, 0)
+	oldReceives := false
+	for _, t := range p.Old.Transitions {
+		if !t.Send {
+			oldReceives = true
+		}
+	}
+	for _, t := range p.New.Transitions {
+		if !t.Send {
+			if !oldReceives {
+				p.Report(t.Pos, name, "it waits for "+t.Label+" from "+t.Peer+" where the old version receives nothing", t.Peer+", written against the old version, will not send it", reported, diags)
+			}
+			continue
+		}
+		match, similar := FindCFSMTransition(p.Old, t)
+		if match != nil {
+			successors = append(successors, &SubtypingPair{New: t.To, Old: match.To, Parent: p, Step: role + " sends " + t.Label + " to " + t.Peer + " (" + t.Pos.String() + ")"})
+		} else if similar != nil {
+			p.Report(t.Pos, name, "it sends "+t.Label+" to "+t.Peer+" where the old version sends "+similar.Label, t.Peer+", written against the old version, expects the old payload types", reported, diags)
+		} else {
+			p.Report(t.Pos, name, "it sends "+t.Label+" to "+t.Peer+", which the old version never sends at this point", t.Peer+", written against the old version, does not expect it", reported, diags)
+		}
+	}
+	for _, t := range p.Old.Transitions {
+		if t.Send {
+			continue
+		}
+		match, similar := FindCFSMTransition(p.New, t)
+		if match != nil {
+			successors = append(successors, &SubtypingPair{New: match.To, Old: t.To, Parent: p, Step: role + " receives " + t.Label + " from " + t.Peer + " (" + match.Pos.String() + ")"})
+		} else if similar != nil {
+			p.Report(similar.Pos, name, "it expects "+similar.Label+" from "+t.Peer+" where the old version expects "+t.Label, t.Peer+", written against the old version, still sends the old payload types", reported, diags)
+		} else if len(p.New.Transitions) > 0 {
+			p.Report(p.New.Transitions[0].Pos, name, "it no longer accepts "+t.Label+" from "+t.Peer+" at this point, which the old version accepts ("+t.Pos.String()+")", t.Peer+", written against the old version, may still send it", reported, diags)
+		}
+	}
+	if p.New.Final && !p.Old.Final && len(p.Old.Transitions) > 0 {
+		p.Report(p.Old.Transitions[0].Pos, name, "it may end where the old version goes on "+DescribeCFSMWait(p.Old), "the position is that of the old version", reported, diags)
+	}
+	return successors
+}
+
+// FindCFSMTransition returns the transition of s which matches t, or
+// failing that one which exchanges a message of the same name with the
+// same peer but with other payload types.
+func FindCFSMTransition(s *CFSMState, t *CFSMTransition) (*CFSMTransition, *CFSMTransition) {
+	var similar *CFSMTransition
+	for _, u := range s.Transitions {
+		if u.Send != t.Send || u.Peer != t.Peer {
+			continue
+		}
+		if u.Label == t.Label {
+			return u, nil
+		}
+		if similar == nil && GetCFSMMessageName(u.Label) == GetCFSMMessageName(t.Label) {
+			similar = u
+		}
+	}
+	return nil, similar
+}
+
+func GetCFSMMessageName(label string) string {
+	return label[:strings.Index(label, "(")]
+}
+
+// GetTrace returns the steps which lead from the initial pair of states
+// to p.
+func (p *SubtypingPair) GetTrace() []string {
+	trace := make([]string, 0)
+	for ; p.Parent != nil; p = p.Parent {
+		trace = append([]string{p.Step}, trace...)
+	}
+	return trace
+}
+
+// Report reports a point at which the new version cannot replace the
+// old, once however many pairs of states lead to it.
+func (p *SubtypingPair) Report(pos Position, name string, message string, hint string, reported map[string]bool, diags *DiagnosticCollector) {
+	message = "The new version of " + name + " cannot safely replace the old one: " + message
+	key := pos.String() + message
+	if reported[key] {
+		return
+	}
+	reported[key] = true
+	diags.AddDiagnostics(Diagnostics{&Diagnostic{Pos: pos, Message: message, Hint: hint, Trace: p.GetTrace()}})
+}
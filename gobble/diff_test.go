@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func DiffTestSources(t *testing.T, before string, after string) (string, error) {
+	t.Helper()
+	header := "module M;\n\ntype <go> \"int\" from \"\" as Count;\n\n"
+	oldName := WriteTestFile(t, "old.scr", header+before)
+	newName := WriteTestFile(t, "new.scr", header+after)
+	var out bytes.Buffer
+	err := DiffFiles(oldName, newName, &out)
+	return out.String(), err
+}
+
+func GetTestDiffLocal(body string) string {
+	return "local protocol P at A(role A, role B) {\n\t" + body + "\n}\n"
+}
+
+func TestDiffFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		changes []string
+		wantErr string
+	}{
+		{
+			name:    "unchanged",
+			before:  "m(int) to B; n() from B;",
+			after:   "m(Count) to B; n() from B;",
+			changes: []string{"P at A: no changes"},
+		},
+		{
+			name:    "branch removed from own choice",
+			before:  "choice at A { x() to B; } or { y() to B; }",
+			after:   "choice at A { x() to B; }",
+			changes: []string{"removed branch: the choice at A no longer has the branch beginning with y()", "the new version can safely replace the old one"},
+		},
+		{
+			name:    "branch removed from other's choice",
+			before:  "choice at B { x() from B; } or { y() from B; }",
+			after:   "choice at B { x() from B; }",
+			changes: []string{"removed branch: the choice at B no longer has the branch beginning with y()", "the new version cannot safely replace the old one"},
+			wantErr: "it no longer accepts y() from B at this point",
+		},
+		{
+			name:    "branch added to own choice",
+			before:  "choice at A { x() to B; } or { y() to B; }",
+			after:   "choice at A { x() to B; } or { y() to B; } or { z() to B; }",
+			changes: []string{"added branch: the choice at A has a new branch beginning with z()"},
+			wantErr: "it sends z() to B, which the old version never sends at this point",
+		},
+		{
+			name:    "branch added to other's choice",
+			before:  "choice at B { x() from B; } or { y() from B; }",
+			after:   "choice at B { x() from B; } or { y() from B; } or { z() from B; }",
+			changes: []string{"added branch: the choice at B has a new branch beginning with z()", "the new version can safely replace the old one"},
+		},
+		{
+			name:    "payload type changed",
+			before:  "m(int) to B;",
+			after:   "m(string) to B;",
+			changes: []string{"payload type change: m to B carried (int) and now carries (string)"},
+			wantErr: "it sends m(string) to B where the old version sends m(int)",
+		},
+		{
+			name:    "messages reordered",
+			before:  "m() to B; n() to B;",
+			after:   "n() to B; m() to B;",
+			changes: []string{"reordered messages: A sends m() to B here rather than at"},
+			wantErr: "it sends n() to B, which the old version never sends at this point",
+		},
+		{
+			name:    "message added",
+			before:  "m() to B;",
+			after:   "m() to B; n() from B;",
+			changes: []string{"added message: the new version adds A receives n() from B"},
+			wantErr: "it waits for n() from B where the old version receives nothing",
+		},
+		{
+			name:    "message removed",
+			before:  "m() to B; n() from B;",
+			after:   "m() to B;",
+			changes: []string{"removed message: the new version no longer has A receives n() from B"},
+			wantErr: "it may end where the old version goes on for n() from B",
+		},
+		{
+			name:    "loop body changed",
+			before:  "rec L { m() to B; continue L; }",
+			after:   "rec L { m() to B; n() to B; continue L; }",
+			changes: []string{"added message: the new version adds A sends n() to B"},
+			wantErr: "trace:\n\t\t1. A sends m() to B",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := DiffTestSources(t, GetTestDiffLocal(test.before), GetTestDiffLocal(test.after))
+			for _, change := range test.changes {
+				if !strings.Contains(out, change) {
+					t.Errorf("output does not contain %q:\n%s", change, out)
+				}
+			}
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffFilesPairsLocalsByNameAndRole(t *testing.T) {
+	before := GetTestDiffLocal("m() to B;")
+	after := "local protocol Q at A(role A, role B) {\n\tm() to B;\n}\n"
+	out, err := DiffTestSources(t, before, after)
+	if !strings.Contains(out, "Q at A: added in the new version") {
+		t.Errorf("output does not report Q as added:\n%s", out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "The new version has no local protocol P at A") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,444 @@
+// The dump command writes Gobble's internal representations of a set of
+// Scribble files to standard output as JSON, for debugging, for golden
+// tests and for tools built on Gobble's front end. `gobble dump --ast`
+// writes the tree produced by the parser for each file, before any
+// projection or inlining. `gobble dump --ir` writes the MessagesData
+// produced by the translator for each local protocol that would be
+// generated, after projection, inlining and type resolution.
+//
+// The JSON schema is given by the Dump types below: every field is
+// always present unless its tag says omitempty, lists are never null,
+// and positions are {"file", "line", "col"} objects with 1-based lines
+// and columns. Both documents carry a schemaVersion, which is increased
+// whenever a field is removed or changes meaning; fields may be added
+// without changing it.
+
+// input: Scribble .scr protocol files
+
+// output: a JSON document on standard output
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+)
+
+const DumpSchemaVersion = 1
+
+// DumpAST is the document written by dump --ast.
+type DumpAST struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Files         []*DumpFile `json:"files"`
+}
+
+type DumpFile struct {
+	File    string          `json:"file"`
+	Module  string          `json:"module"`
+	Imports []string        `json:"imports"`
+	Types   []*DumpType     `json:"types"`
+	Globals []*DumpProtocol `json:"globals"`
+	Locals  []*DumpProtocol `json:"locals"`
+}
+
+// DumpType is a type declaration: type <schema> "source" from "file" as
+// alias;
+type DumpType struct {
+	Schema string   `json:"schema"`
+	Source string   `json:"source"`
+	File   string   `json:"file"`
+	Alias  string   `json:"alias"`
+	Pos    Position `json:"pos"`
+}
+
+// DumpProtocol is a global protocol, or a local protocol if at is set.
+type DumpProtocol struct {
+	Name  string      `json:"name"`
+	At    string      `json:"at,omitempty"`
+	Roles []string    `json:"roles"`
+	Body  []*DumpNode `json:"body"`
+	Pos   Position    `json:"pos"`
+}
+
+// DumpNode is an element of a protocol body. Kind is one of "message",
+// "choice", "par", "rec", "continue" or "do", and determines which of
+// the other fields are set; the rest, and lists which are empty, are
+// omitted:
+//
+//	message:  label, from, to, parameters
+//	choice:   at (the chooser), branches
+//	par:      branches
+//	rec:      name, body
+//	continue: name
+//	do:       name (the protocol invoked), roles
+type DumpNode struct {
+	Kind       string            `json:"kind"`
+	Id         string            `json:"id"`
+	Label      string            `json:"label,omitempty"`
+	From       string            `json:"from,omitempty"`
+	To         string            `json:"to,omitempty"`
+	Parameters []*DumpParameter  `json:"parameters,omitempty"`
+	At         string            `json:"at,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Branches   [][]*DumpNode     `json:"branches,omitempty"`
+	Body       []*DumpNode       `json:"body,omitempty"`
+	Roles      []*DumpDoArgument `json:"roles,omitempty"`
+	Pos        Position          `json:"pos"`
+}
+
+// DumpParameter is a message parameter. Name is empty where the
+// parameter is not named; type is the type as written in the source.
+type DumpParameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// DumpDoArgument passes role of the caller to a do. As names the role of
+// the invoked protocol it plays, or is empty if roles are passed by
+// position.
+type DumpDoArgument struct {
+	Role string `json:"role"`
+	As   string `json:"as"`
+}
+
+// DumpIR is the document written by dump --ir, with one entry for each
+// local protocol that would be generated.
+type DumpIR struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	Protocols     []*DumpTranslation `json:"protocols"`
+}
+
+// DumpTranslation is the MessagesData of one local protocol. Each list
+// holds the corresponding MessagesData slice in order; the fields of its
+// entries have the meanings documented in the translator, and suffixes
+// are the lists of strings from which struct names are built.
+type DumpTranslation struct {
+	Name          string                  `json:"name"`
+	At            string                  `json:"at"`
+	Messages      []*DumpMessageData      `json:"messages"`
+	Choices       []*DumpChoiceData       `json:"choices"`
+	Parallels     []*DumpParallelData     `json:"parallels"`
+	Conversations []*DumpConversationData `json:"conversations"`
+	Recs          []*DumpRecData          `json:"recs"`
+	Continues     []*DumpContinueData     `json:"continues"`
+	TypeImports   []*DumpTypeImport       `json:"typeImports"`
+	CustomTypes   []string                `json:"customTypes"`
+}
+
+// DumpWhereTo is a WhereToIfBranchEnds: the element to which control
+// passes when the branch containing an element ends. Id is empty if
+// there is none.
+type DumpWhereTo struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	EndingPar bool   `json:"endingPar"`
+}
+
+type DumpMessageData struct {
+	Id                  string      `json:"id"`
+	Protagonist         string      `json:"protagonist"`
+	Label               string      `json:"label"`
+	From                string      `json:"from"`
+	To                  string      `json:"to"`
+	Suffix              []string    `json:"suffix"`
+	SubsequentSuffix    []string    `json:"subsequentSuffix"`
+	Parameters          []string    `json:"parameters"`
+	ParameterTypes      []string    `json:"parameterTypes"`
+	ParameterNames      []string    `json:"parameterNames"`
+	ChanName            string      `json:"chanName"`
+	SeqCounter          int         `json:"seqCounter"`
+	IsChoiceOption      bool        `json:"isChoiceOption"`
+	ChoiceChanName      string      `json:"choiceChanName"`
+	WhereToIfBranchEnds DumpWhereTo `json:"whereToIfBranchEnds"`
+	RecId               string      `json:"recId"`
+	RecName             string      `json:"recName"`
+	FollowingContinueId string      `json:"followingContinueId"`
+	ContinueToStruct    string      `json:"continueToStruct"`
+	InRecBlock          bool        `json:"inRecBlock"`
+}
+
+type DumpChoiceData struct {
+	Id                  string      `json:"id"`
+	Protagonist         string      `json:"protagonist"`
+	Chooser             string      `json:"chooser"`
+	Suffix              []string    `json:"suffix"`
+	OptionIds           []string    `json:"optionIds"`
+	OptionSuffixes      []string    `json:"optionSuffixes"`
+	NextId              string      `json:"nextId"`
+	NextType            string      `json:"nextType"`
+	WhereToIfBranchEnds DumpWhereTo `json:"whereToIfBranchEnds"`
+	RecId               string      `json:"recId"`
+	RecName             string      `json:"recName"`
+	InRecBlock          bool        `json:"inRecBlock"`
+}
+
+type DumpParallelData struct {
+	Id                  string      `json:"id"`
+	Protagonist         string      `json:"protagonist"`
+	Suffix              []string    `json:"suffix"`
+	OptionIds           []string    `json:"optionIds"`
+	OptionSuffixes      []string    `json:"optionSuffixes"`
+	ChanNameBase        string      `json:"chanNameBase"`
+	SubsequentSuffix    []string    `json:"subsequentSuffix"`
+	WhereToIfBranchEnds DumpWhereTo `json:"whereToIfBranchEnds"`
+	RecId               string      `json:"recId"`
+	RecName             string      `json:"recName"`
+	InRecBlock          bool        `json:"inRecBlock"`
+}
+
+// DumpConversationData lists the elements of a conversation in order,
+// each as its type ("message", "choice", "parallel", "rec" or
+// "continue") and id.
+type DumpConversationData struct {
+	Id               string             `json:"id"`
+	Protagonist      string             `json:"protagonist"`
+	Elements         []*DumpElementData `json:"elements"`
+	Suffix           []string           `json:"suffix"`
+	RecId            string             `json:"recId"`
+	RecName          string             `json:"recName"`
+	InRecBlock       bool               `json:"inRecBlock"`
+	InParBlock       bool               `json:"inParBlock"`
+	ParentIsRecBlock bool               `json:"parentIsRecBlock"`
+}
+
+type DumpElementData struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type DumpRecData struct {
+	Id                  string      `json:"id"`
+	Protagonist         string      `json:"protagonist"`
+	Name                string      `json:"name"`
+	Suffix              []string    `json:"suffix"`
+	AncestralRecId      string      `json:"ancestralRecId"`
+	AncestralRecName    string      `json:"ancestralRecName"`
+	FirstStructId       string      `json:"firstStructId"`
+	FirstStructType     string      `json:"firstStructType"`
+	WhereToIfBranchEnds DumpWhereTo `json:"whereToIfBranchEnds"`
+	InRecBlock          bool        `json:"inRecBlock"`
+}
+
+// DumpContinueData is a continue; recId is the id of the rec block it
+// continues.
+type DumpContinueData struct {
+	Id               string `json:"id"`
+	Name             string `json:"name"`
+	RecId            string `json:"recId"`
+	FirstStructName  string `json:"firstStructName"`
+	FirstStructType  string `json:"firstStructType"`
+	FirstStructId    string `json:"firstStructId"`
+	ContinueToStruct string `json:"continueToStruct"`
+}
+
+type DumpTypeImport struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+func GetDumpFile(fileName string, p *Protocol) *DumpFile {
+	f := &DumpFile{File: fileName}
+	if p.Mod != nil {
+		f.Module = p.Mod.Name
+	}
+	f.Imports = make([]string, 0)
+	for _, imp := range p.Imports {
+		f.Imports = append(f.Imports, imp.Name)
+	}
+	f.Types = make([]*DumpType, 0)
+	for _, typ := range p.Types {
+		f.Types = append(f.Types, &DumpType{Schema: typ.Schema, Source: typ.Source, File: typ.FileName, Alias: typ.Alias, Pos: typ.Pos})
+	}
+	f.Globals = make([]*DumpProtocol, 0)
+	for _, g := range p.Globals {
+		f.Globals = append(f.Globals, &DumpProtocol{Name: g.Name, Roles: GetDumpRoles(g.Roles), Body: GetDumpNodes(g.Conv.Nodes), Pos: g.Pos})
+	}
+	f.Locals = make([]*DumpProtocol, 0)
+	for _, l := range p.Locals {
+		f.Locals = append(f.Locals, &DumpProtocol{Name: l.Name, At: l.Protagonist, Roles: GetDumpRoles(l.Roles), Body: GetDumpNodes(l.Conv.Nodes), Pos: l.Pos})
+	}
+	return f
+}
+
+func GetDumpRoles(roles []*Role) []string {
+	names := make([]string, 0)
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+func GetDumpNodes(nodes []*Node) []*DumpNode {
+	dumped := make([]*DumpNode, 0)
+	for _, n := range nodes {
+		var d *DumpNode
+		if n.Cat == "message" {
+			d = &DumpNode{Kind: "message", Id: n.Mess.Id, Label: n.Mess.Name, From: n.Mess.From, To: n.Mess.To, Pos: n.Mess.Pos}
+			d.Parameters = make([]*DumpParameter, 0)
+			for i, typ := range n.Mess.Types {
+				param := &DumpParameter{Type: typ}
+				if i < len(n.Mess.Names) {
+					param.Name = n.Mess.Names[i]
+				}
+				d.Parameters = append(d.Parameters, param)
+			}
+		} else if n.Cat == "choice" {
+			d = &DumpNode{Kind: "choice", Id: n.Choice.Id, At: n.Choice.Chooser, Branches: GetDumpBranches(n.Choice.Convs), Pos: n.Choice.Pos}
+		} else if n.Cat == "par" {
+			d = &DumpNode{Kind: "par", Id: n.Par.Id, Branches: GetDumpBranches(n.Par.Convs), Pos: n.Par.Pos}
+		} else if n.Cat == "rec" {
+			d = &DumpNode{Kind: "rec", Id: n.Rec.Id, Name: n.Rec.Name, Body: GetDumpNodes(n.Rec.Conv.Nodes), Pos: n.Rec.Pos}
+		} else if n.Cat == "continue" {
+			d = &DumpNode{Kind: "continue", Id: n.Cont.Id, Name: n.Cont.Name, Pos: n.Cont.Pos}
+		} else if n.Cat == "do" {
+			d = &DumpNode{Kind: "do", Id: n.Do.Id, Name: n.Do.Name, Pos: n.Do.Pos}
+			d.Roles = make([]*DumpDoArgument, 0)
+			for i, role := range n.Do.Roles {
+				arg := &DumpDoArgument{Role: role}
+				if i < len(n.Do.Params) {
+					arg.As = n.Do.Params[i]
+				}
+				d.Roles = append(d.Roles, arg)
+			}
+		} else {
+			continue
+		}
+		dumped = append(dumped, d)
+	}
+	return dumped
+}
+
+func GetDumpBranches(convs []*Conversation) [][]*DumpNode {
+	branches := make([][]*DumpNode, 0)
+	for _, conv := range convs {
+		branches = append(branches, GetDumpNodes(conv.Nodes))
+	}
+	return branches
+}
+
+func GetDumpWhereTo(w WhereToIfBranchEnds) DumpWhereTo {
+	return DumpWhereTo{Id: w.Id, Type: w.Type, EndingPar: w.EndingPar}
+}
+
+// CopyDumpSlice returns s, or an empty slice if s is nil, so that lists
+// are never written as null.
+func CopyDumpSlice(s []string) []string {
+	if s == nil {
+		return make([]string, 0)
+	}
+	return s
+}
+
+func GetDumpTranslation(l *Local, m *MessagesData) *DumpTranslation {
+	d := &DumpTranslation{Name: l.Name, At: l.Protagonist}
+	d.Messages = make([]*DumpMessageData, 0)
+	for _, mess := range m.Messages {
+		d.Messages = append(d.Messages, &DumpMessageData{Id: mess.UniqueId, Protagonist: mess.Protagonist, Label: mess.MethodNameBase, From: mess.FromBase, To: mess.ToBase, Suffix: CopyDumpSlice(mess.Suffix), SubsequentSuffix: CopyDumpSlice(mess.SubsequentSuffix), Parameters: CopyDumpSlice(mess.Parameters), ParameterTypes: CopyDumpSlice(mess.ParameterTypes), ParameterNames: CopyDumpSlice(mess.ParameterNames), ChanName: mess.ChanName, SeqCounter: mess.SeqCounter, IsChoiceOption: mess.IsChoiceOption, ChoiceChanName: mess.ChoiceChanName, WhereToIfBranchEnds: GetDumpWhereTo(mess.WhereToIfBranchEnds), RecId: mess.RecId, RecName: mess.RecName, FollowingContinueId: mess.FollowingContinueId, ContinueToStruct: mess.ContinueToStruct, InRecBlock: mess.InRecBlock})
+	}
+	d.Choices = make([]*DumpChoiceData, 0)
+	for _, choi := range m.Choices {
+		d.Choices = append(d.Choices, &DumpChoiceData{Id: choi.UniqueId, Protagonist: choi.Protagonist, Chooser: choi.Chooser, Suffix: CopyDumpSlice(choi.Suffix), OptionIds: CopyDumpSlice(choi.OptionIds), OptionSuffixes: CopyDumpSlice(choi.OptionSuffixes), NextId: choi.NextId, NextType: choi.NextType, WhereToIfBranchEnds: GetDumpWhereTo(choi.WhereToIfBranchEnds), RecId: choi.RecId, RecName: choi.RecName, InRecBlock: choi.InRecBlock})
+	}
+	d.Parallels = make([]*DumpParallelData, 0)
+	for _, par := range m.Parallels {
+		d.Parallels = append(d.Parallels, &DumpParallelData{Id: par.UniqueId, Protagonist: par.Protagonist, Suffix: CopyDumpSlice(par.Suffix), OptionIds: CopyDumpSlice(par.OptionIds), OptionSuffixes: CopyDumpSlice(par.OptionSuffixes), ChanNameBase: par.ChanNameBase, SubsequentSuffix: CopyDumpSlice(par.SubsequentSuffix), WhereToIfBranchEnds: GetDumpWhereTo(par.WhereToIfBranchEnds), RecId: par.RecId, RecName: par.RecName, InRecBlock: par.InRecBlock})
+	}
+	d.Conversations = make([]*DumpConversationData, 0)
+	for _, conv := range m.Conversations {
+		elements := make([]*DumpElementData, 0)
+		for _, e := range conv.ConversationElementsData {
+			elements = append(elements, &DumpElementData{Type: e.Type, Id: e.UniqueId})
+		}
+		d.Conversations = append(d.Conversations, &DumpConversationData{Id: conv.UniqueId, Protagonist: conv.Protagonist, Elements: elements, Suffix: CopyDumpSlice(conv.Suffix), RecId: conv.RecId, RecName: conv.RecName, InRecBlock: conv.InRecBlock, InParBlock: conv.InParBlock, ParentIsRecBlock: conv.ParentIsRecBlock})
+	}
+	d.Recs = make([]*DumpRecData, 0)
+	for _, rec := range m.Recs {
+		d.Recs = append(d.Recs, &DumpRecData{Id: rec.UniqueId, Protagonist: rec.Protagonist, Name: rec.Name, Suffix: CopyDumpSlice(rec.Suffix), AncestralRecId: rec.AncestralRecId, AncestralRecName: rec.AncestralRecName, FirstStructId: rec.FirstStructId, FirstStructType: rec.FirstStructType, WhereToIfBranchEnds: GetDumpWhereTo(rec.WhereToIfBranchEnds), InRecBlock: rec.InRecBlock})
+	}
+	d.Continues = make([]*DumpContinueData, 0)
+	for _, cont := range m.Continues {
+		recId := ""
+		if cont.Rec != nil {
+			recId = cont.Rec.Id
+		}
+		d.Continues = append(d.Continues, &DumpContinueData{Id: cont.UniqueId, Name: cont.Name, RecId: recId, FirstStructName: cont.FirstStructName, FirstStructType: cont.FirstStructType, FirstStructId: cont.FirstStructId, ContinueToStruct: cont.ContinueToStruct})
+	}
+	d.TypeImports = make([]*DumpTypeImport, 0)
+	for _, imp := range m.TypeImports {
+		d.TypeImports = append(d.TypeImports, &DumpTypeImport{Name: imp.Name, Path: imp.Path})
+	}
+	d.CustomTypes = CopyDumpSlice(m.CustomTypes)
+	return d
+}
+
+func DumpASTs(fileNames []string, out io.Writer) error {
+	dump := &DumpAST{SchemaVersion: DumpSchemaVersion, Files: make([]*DumpFile, 0)}
+	diags := make(Diagnostics, 0)
+	for _, name := range fileNames {
+		tokens, err := GetTokens(name)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		tree, err := ProcessTokens(tokens)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		dump.Files = append(dump.Files, GetDumpFile(name, tree))
+	}
+	if err := diags.Err(); err != nil {
+		return err
+	}
+	return WriteDump(dump, out)
+}
+
+func DumpIRs(fileNames []string, out io.Writer) error {
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	dump := &DumpIR{SchemaVersion: DumpSchemaVersion, Protocols: make([]*DumpTranslation, 0)}
+	diags := make(Diagnostics, 0)
+	for _, tree := range trees {
+		m, err := TranslateTree(tree)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		dump.Protocols = append(dump.Protocols, GetDumpTranslation(tree.Locals[0], m))
+	}
+	if err := diags.Err(); err != nil {
+		return err
+	}
+	return WriteDump(dump, out)
+}
+
+func WriteDump(dump interface{}, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+func RunDumpCommand(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	ast := flags.Bool("ast", false, "dump the tree produced by the parser")
+	ir := flags.Bool("ir", false, "dump the MessagesData produced by the translator")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *ast == *ir {
+		return errors.New("Please specify exactly one of --ast and --ir.")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("Please specicfy one or more .scr files to dump.")
+	}
+	if *ast {
+		return DumpASTs(flags.Args(), os.Stdout)
+	}
+	return DumpIRs(flags.Args(), os.Stdout)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const DumpTestSource = "module M;\n\ntype <go> \"travel.Fare\" from \"github.com/acme/travel\" as Fare;\n\nglobal protocol P(role A, role B) {\n\tm(fare: Fare, int) from A to B;\n\tchoice at B { x() from B to A; } or { y() from B to A; }\n}\n"
+
+func TestDumpASTs(t *testing.T) {
+	name := WriteTestFile(t, "test.scr", DumpTestSource)
+	var out bytes.Buffer
+	err := DumpASTs([]string{name}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "null") {
+		t.Errorf("dump contains null:\n%s", out.String())
+	}
+	var dump DumpAST
+	err = json.Unmarshal(out.Bytes(), &dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.SchemaVersion != DumpSchemaVersion || len(dump.Files) != 1 {
+		t.Fatalf("got schema version %d and %d files", dump.SchemaVersion, len(dump.Files))
+	}
+	f := dump.Files[0]
+	if f.Module != "M" || len(f.Types) != 1 || f.Types[0].Alias != "Fare" || len(f.Globals) != 1 || len(f.Locals) != 0 {
+		t.Fatalf("unexpected file %+v", f)
+	}
+	body := f.Globals[0].Body
+	if len(body) != 2 || body[0].Kind != "message" || body[1].Kind != "choice" {
+		t.Fatalf("unexpected body %+v", body)
+	}
+	mess := body[0]
+	if mess.Label != "m" || mess.From != "A" || mess.To != "B" || mess.Pos.Line != 6 || mess.Pos.Col != 2 {
+		t.Errorf("unexpected message %+v", mess)
+	}
+	if len(mess.Parameters) != 2 || *mess.Parameters[0] != (DumpParameter{Name: "fare", Type: "Fare"}) || *mess.Parameters[1] != (DumpParameter{Type: "int"}) {
+		t.Errorf("unexpected parameters %+v %+v", mess.Parameters[0], mess.Parameters[1])
+	}
+	if choice := body[1]; choice.At != "B" || len(choice.Branches) != 2 || choice.Branches[1][0].Label != "y" {
+		t.Errorf("unexpected choice %+v", choice)
+	}
+}
+
+func TestDumpIRs(t *testing.T) {
+	name := WriteTestFile(t, "test.scr", DumpTestSource)
+	var out bytes.Buffer
+	err := DumpIRs([]string{name}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump DumpIR
+	err = json.Unmarshal(out.Bytes(), &dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Protocols) != 2 {
+		t.Fatalf("got %d protocols, want one for each role", len(dump.Protocols))
+	}
+	a := dump.Protocols[0]
+	if a.At != "A" || len(a.Messages) != 3 || len(a.Choices) != 1 {
+		t.Fatalf("unexpected translation %+v", a)
+	}
+	mess := a.Messages[0]
+	if strings.Join(mess.ParameterTypes, ",") != "travel.Fare,int" || strings.Join(mess.ParameterNames, ",") != "fare,param2" {
+		t.Errorf("got parameter types %v and names %v", mess.ParameterTypes, mess.ParameterNames)
+	}
+	if len(a.TypeImports) != 1 || a.TypeImports[0].Path != "github.com/acme/travel" {
+		t.Errorf("got type imports %+v", a.TypeImports)
+	}
+}
+
+func TestRunDumpCommandErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"test.scr"}, want: "exactly one of --ast and --ir"},
+		{args: []string{"--ast", "--ir", "test.scr"}, want: "exactly one of --ast and --ir"},
+		{args: []string{"--ast"}, want: "one or more .scr files"},
+	}
+	for _, test := range tests {
+		err := RunDumpCommand(test.args)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got error %v, want one containing %q", test.args, err, test.want)
+		}
+	}
+}
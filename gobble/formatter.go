@@ -0,0 +1,405 @@
+// The formatter rewrites a Scribble protocol in a canonical layout: one
+// statement per line, blocks indented with one tab per level, a single
+// space between words and after commas and colons, no space inside
+// brackets, and `} or {` and `} and {` kept on one line. Blank lines are
+// kept where the source has them, but runs of blank lines are collapsed
+// to one, and each top-level protocol is followed by a blank line.
+// Comments are kept: a comment which follows code on the same line stays
+// at the end of that line and any other comment is placed on its own
+// line at the indentation of the code around it. The formatter works
+// from the tokens produced by the lexer, so nothing but whitespace is
+// changed, and a file which does not parse, or whose brackets do not
+// match, is reported and not formatted.
+
+// input: Scribble .scr protocol files
+
+// output: the files rewritten in place, a list of the files which are not
+// formatted, or a diff between each file and its formatted version
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Formatter struct {
+	Tokens      []*Token
+	Comments    []*Comment
+	Output      string
+	Indent      int
+	ParenDepth  int
+	InString    bool
+	LineStart   bool
+	LastLine    int
+	NextComment int
+	// AfterComment is set when a block comment has been written in the
+	// middle of a line, so that it is followed by a space
+	AfterComment bool
+	Diags        *DiagnosticCollector
+}
+
+// FormatSource returns the canonical layout of a Scribble source file.
+func FormatSource(source string, fileName string) (string, error) {
+	tokens, comments, err := TokenizeWithComments(source, fileName)
+	if err != nil {
+		return "", err
+	}
+	_, err = ProcessTokens(tokens)
+	if err != nil {
+		return "", err
+	}
+	f := &Formatter{Tokens: tokens, Comments: comments, LineStart: true, Diags: &DiagnosticCollector{}}
+	f.FormatTokens()
+	err = f.Diags.Err()
+	if err != nil {
+		return "", err
+	}
+	return f.Output, nil
+}
+
+func (f *Formatter) FormatTokens() {
+	for i, token := range f.Tokens {
+		f.WriteCommentsBefore(token.Pos)
+		var prev *Token
+		if i > 0 {
+			prev = f.Tokens[i-1]
+		}
+		var next *Token
+		if i < len(f.Tokens)-1 {
+			next = f.Tokens[i+1]
+		}
+		f.WriteToken(token, prev, next)
+	}
+	f.WriteCommentsBefore(Position{Line: int(^uint(0) >> 1)})
+	f.Output = strings.TrimRight(f.Output, "\n") + "\n"
+}
+
+func (f *Formatter) WriteToken(token *Token, prev *Token, next *Token) {
+	value := token.Value
+	if f.InString {
+		// Strings are copied as they were written
+		if prev != nil && prev.Pos.Line == token.Pos.Line {
+			end := prev.Pos.Col + len([]rune(prev.Value))
+			f.Output += strings.Repeat(" ", token.Pos.Col-end)
+		}
+		f.Output += value
+		if value == "\"" {
+			f.InString = false
+		}
+		f.LastLine = token.Pos.Line
+		return
+	}
+	if value == "{" && f.ParenDepth == 0 {
+		f.Write(token, !f.LineStart)
+		f.Indent++
+		f.EndLine(token.Pos.Line, next)
+		return
+	}
+	if value == "}" && f.ParenDepth == 0 {
+		if f.Indent == 0 {
+			f.Diags.Add(token.Pos, "Unmatched \"}\"", "remove it or add the \"{\" it closes")
+		} else {
+			f.Indent--
+		}
+		if !f.LineStart {
+			f.EndLine(f.LastLine, token)
+		}
+		f.Write(token, false)
+		if next != nil && (next.Value == "or" || next.Value == "and") {
+			return
+		}
+		f.EndLine(token.Pos.Line, next)
+		if f.Indent == 0 && next != nil {
+			f.BlankLine()
+		}
+		return
+	}
+	f.Write(token, prev != nil && SpaceBetweenTokens(prev.Value, value))
+	if value == "(" || value == "[" || value == "{" {
+		f.ParenDepth++
+	} else if value == ")" || value == "]" || value == "}" {
+		if f.ParenDepth == 0 {
+			f.Diags.Add(token.Pos, "Unmatched \""+value+"\"", "remove it or add the bracket it closes")
+		} else {
+			f.ParenDepth--
+		}
+	} else if value == "\"" {
+		f.InString = true
+	} else if value == ";" {
+		f.EndLine(token.Pos.Line, next)
+	}
+}
+
+// SpaceBetweenTokens reports whether a space separates two adjacent
+// tokens on the same line.
+func SpaceBetweenTokens(prev string, next string) bool {
+	switch next {
+	case ",", ";", ":", ".", ")", "]", ">":
+		return false
+	case "(", "[", "{":
+		if IsWordToken(prev) || prev == "]" {
+			return false
+		}
+	case "}":
+		if prev == "{" {
+			return false
+		}
+	}
+	switch prev {
+	case "(", "[", "{", ".", "<":
+		return false
+	case "]":
+		return !IsWordToken(next) && next != "("
+	}
+	return true
+}
+
+func IsWordToken(value string) bool {
+	return !isSpecialChar([]rune(value)[0])
+}
+
+// Write writes a token, starting a new line at the current indentation
+// if the previous line has ended.
+func (f *Formatter) Write(token *Token, space bool) {
+	if f.LineStart {
+		f.StartLine(token.Pos.Line)
+	} else if space || f.AfterComment {
+		f.Output += " "
+	}
+	f.Output += token.Value
+	f.LastLine = token.Pos.Line
+	f.AfterComment = false
+}
+
+// StartLine indents a new line, first keeping a single blank line if the
+// source has one or more between this line and the last.
+func (f *Formatter) StartLine(line int) {
+	if f.Output != "" && f.LastLine > 0 && line > f.LastLine+1 {
+		f.BlankLine()
+	}
+	f.Output += strings.Repeat("\t", f.Indent)
+	f.LineStart = false
+}
+
+func (f *Formatter) BlankLine() {
+	if f.Output != "" && !strings.HasSuffix(f.Output, "\n\n") {
+		f.Output += "\n"
+	}
+}
+
+// EndLine ends the current line, first appending any comments which
+// follow code on the same source line and come before the next token.
+func (f *Formatter) EndLine(line int, next *Token) {
+	for f.NextComment < len(f.Comments) && f.Comments[f.NextComment].Pos.Line == line {
+		c := f.Comments[f.NextComment]
+		if next != nil && !PositionBefore(c.Pos, next.Pos) {
+			break
+		}
+		f.Output += " " + c.Text
+		f.LastLine = c.End.Line
+		f.NextComment++
+	}
+	if !f.LineStart {
+		f.Output += "\n"
+	}
+	f.LineStart = true
+	f.AfterComment = false
+}
+
+func PositionBefore(a Position, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+// WriteCommentsBefore writes the comments which come before pos.
+func (f *Formatter) WriteCommentsBefore(pos Position) {
+	for f.NextComment < len(f.Comments) && PositionBefore(f.Comments[f.NextComment].Pos, pos) {
+		c := f.Comments[f.NextComment]
+		f.NextComment++
+		isLineComment := strings.HasPrefix(c.Text, "//")
+		if !f.LineStart && c.Pos.Line == f.LastLine {
+			// A comment following code on the same line
+			f.Output += " " + c.Text
+			f.LastLine = c.End.Line
+			if isLineComment {
+				f.EndLine(c.End.Line, nil)
+			} else {
+				f.AfterComment = true
+			}
+			continue
+		}
+		if !f.LineStart {
+			f.EndLine(f.LastLine, nil)
+		}
+		f.StartLine(c.Pos.Line)
+		f.Output += c.Text
+		f.LastLine = c.End.Line
+		if isLineComment || pos.Line > c.End.Line {
+			f.EndLine(c.End.Line, nil)
+		} else {
+			f.AfterComment = true
+		}
+	}
+}
+
+// FormatFiles formats each file in place, or with list set prints the
+// names of the files which are not formatted, or with diff set prints
+// the changes formatting would make.
+func FormatFiles(fileNames []string, list bool, diff bool, out io.Writer) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to format.")
+	}
+	diags := make(Diagnostics, 0)
+	for _, name := range fileNames {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			diags = append(diags, NewDiagnostic(Position{File: name}, err.Error(), ""))
+			continue
+		}
+		formatted, err := FormatSource(string(source), name)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		if formatted == string(source) {
+			continue
+		}
+		if list {
+			fmt.Fprintln(out, name)
+		}
+		if diff {
+			fmt.Fprint(out, UnifiedDiff(name, string(source), formatted))
+		}
+		if !list && !diff {
+			info, err := os.Stat(name)
+			if err == nil {
+				err = ioutil.WriteFile(name, []byte(formatted), info.Mode())
+			}
+			if err != nil {
+				diags = append(diags, NewDiagnostic(Position{File: name}, err.Error(), ""))
+			}
+		}
+	}
+	return diags.Err()
+}
+
+func RunFormatCommand(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs from gobble fmt's")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	return FormatFiles(flags.Args(), *list, *diff, os.Stdout)
+}
+
+// UnifiedDiff returns the changes between two versions of a file in
+// unified diff format, with three lines of context around each change.
+func UnifiedDiff(name string, before string, after string) string {
+	a := strings.SplitAfter(before, "\n")
+	b := strings.SplitAfter(after, "\n")
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	if b[len(b)-1] == "" {
+		b = b[:len(b)-1]
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	// Each line of the edit script is prefixed with ' ', '-' or '+'
+	lines := make([]string, 0)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		} else if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, "-"+a[i])
+			i++
+		} else {
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	diff := "--- " + name + "\n+++ " + name + " (formatted)\n"
+	context := 3
+	for start := 0; start < len(lines); {
+		if lines[start][0] == ' ' {
+			start++
+			continue
+		}
+		// Extend the hunk until more than twice the context separates
+		// one change from the next
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for k := start; k < len(lines) && k <= end+2*context; k++ {
+			if lines[k][0] != ' ' {
+				end = k
+			}
+		}
+		last := end + context
+		if last >= len(lines) {
+			last = len(lines) - 1
+		}
+		diff += GetHunkHeader(lines, first, last)
+		for k := first; k <= last; k++ {
+			line := lines[k]
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n\\ No newline at end of file\n"
+			}
+			diff += line
+		}
+		start = last + 1
+	}
+	return diff
+}
+
+// GetHunkHeader returns the @@ line for the lines first to last of an
+// edit script.
+func GetHunkHeader(lines []string, first int, last int) string {
+	aStart, bStart := 1, 1
+	for k := 0; k < first; k++ {
+		if lines[k][0] != '+' {
+			aStart++
+		}
+		if lines[k][0] != '-' {
+			bStart++
+		}
+	}
+	aCount, bCount := 0, 0
+	for k := first; k <= last; k++ {
+		if lines[k][0] != '+' {
+			aCount++
+		}
+		if lines[k][0] != '-' {
+			bCount++
+		}
+	}
+	return "@@ -" + strconv.Itoa(aStart) + "," + strconv.Itoa(aCount) + " +" + strconv.Itoa(bStart) + "," + strconv.Itoa(bCount) + " @@\n"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	source := "module M;\nlocal protocol P at A(role A,role B){\n  m( int,string )to B; // send\n\n\n  choice at A{x()to B;}or{ y() to B; }\n}\n"
+	want := "module M;\nlocal protocol P at A(role A, role B) {\n\tm(int, string) to B; // send\n\n\tchoice at A {\n\t\tx() to B;\n\t} or {\n\t\ty() to B;\n\t}\n}\n"
+	got, err := FormatSource(source, "test.scr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSourceIsIdempotent(t *testing.T) {
+	fileNames, err := filepath.Glob("*.scr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range fileNames {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := FormatSource(string(source), name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		twice, err := FormatSource(once, name)
+		if err != nil {
+			t.Errorf("%s: formatted source does not parse: %v", name, err)
+			continue
+		}
+		if once != twice {
+			t.Errorf("%s: formatting is not idempotent:\n%s", name, UnifiedDiff(name, once, twice))
+		}
+	}
+}
+
+func TestFormatTokensUnmatchedBrackets(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "m()) to B;", want: "test.scr:1:4: Unmatched \")\""},
+		{source: "m() to B];", want: "test.scr:1:9: Unmatched \"]\""},
+		{source: "m() to B; }", want: "test.scr:1:11: Unmatched \"}\""},
+	}
+	for _, test := range tests {
+		tokens, comments, err := TokenizeWithComments(test.source, "test.scr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &Formatter{Tokens: tokens, Comments: comments, LineStart: true, Diags: &DiagnosticCollector{}}
+		f.FormatTokens()
+		err = f.Diags.Err()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.source, err, test.want)
+		}
+	}
+}
+
+func TestFormatFilesReportsErrors(t *testing.T) {
+	source := "module M;\n\nlocal protocol P at A(role A, role B) {\n\tm()) to B;\n}\n"
+	name := WriteTestFile(t, "test.scr", source)
+	var out bytes.Buffer
+	err := FormatFiles([]string{name}, false, false, &out)
+	if err == nil {
+		t.Fatal("expected an error formatting a file which does not parse")
+	}
+	after, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != source {
+		t.Errorf("file which does not parse was rewritten:\n%s", after)
+	}
+}
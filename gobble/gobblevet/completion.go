@@ -0,0 +1,207 @@
+// The completion analyzer checks that hand-written code which takes over
+// a session from the API generated by Gobble sees it through to the end
+// of the protocol. Following the same control flow graph as the linear
+// analyzer, it reports each path on which a function returns while a
+// state value it holds has been neither advanced nor passed on, which
+// leaves the other roles waiting for messages which never come. This
+// includes the _end struct returned by StartPar, on which EndPar must be
+// called, and the struct of each branch. A path which returns because a
+// method returned an error is not reported for the state returned
+// alongside the error, nor is one which ends by calling a function which
+// never returns, such as log.Fatal, or which leaves a select statement
+// without a default clause other than through one of its cases. The
+// analyzer also reports each state at which another role chooses between
+// branches for which the package calls the Receive_ methods of some
+// branches but not of others, so that the session is abandoned if the
+// other role chooses one of the others. The Receive_ methods may be
+// called from different functions, as they are by the functions which
+// Gobble generates for each branch.
+
+// input: the syntax trees, type information and control flow graphs of
+// a Go package
+
+// output: analysis diagnostics
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/ctrlflow"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/cfg"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+var CompletionAnalyzer = &analysis.Analyzer{
+	Name:     "gobblecomplete",
+	Doc:      "check that code using the session state structs generated by Gobble completes the protocol on every path",
+	Requires: []*analysis.Analyzer{inspect.Analyzer, ctrlflow.Analyzer},
+	Run:      RunCompletionAnalyzer,
+}
+
+// ChoiceBranches records the Receive_ methods called on the values of
+// one state type anywhere in a package, and the position of the first
+// such call.
+type ChoiceBranches struct {
+	Type     types.Type
+	Received map[string]bool
+	First    token.Pos
+}
+
+func RunCompletionAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	cfgs := pass.ResultOf[ctrlflow.Analyzer].(*ctrlflow.CFGs)
+	choices := make(map[string]*ChoiceBranches)
+	filter := []ast.Node{(*ast.FuncDecl)(nil), (*ast.FuncLit)(nil)}
+	insp.Preorder(filter, func(n ast.Node) {
+		switch fn := n.(type) {
+		case *ast.FuncDecl:
+			if fn.Body == nil || IsStateMethod(pass, fn) {
+				return
+			}
+			CheckCompletion(pass, cfgs, fn.Type, fn.Recv, fn.Body, cfgs.FuncDecl(fn), choices)
+		case *ast.FuncLit:
+			CheckCompletion(pass, cfgs, fn.Type, nil, fn.Body, cfgs.FuncLit(fn), choices)
+		}
+	})
+	CheckChoicesHandled(pass, choices)
+	return nil, nil
+}
+
+func CheckCompletion(pass *analysis.Pass, cfgs *ctrlflow.CFGs, typ *ast.FuncType, recv *ast.FieldList, body *ast.BlockStmt, graph *cfg.CFG, choices map[string]*ChoiceBranches) {
+	if graph == nil {
+		return
+	}
+	c, entry := NewLinearChecker(pass, typ, recv, body)
+	c.IgnoreFailed = true
+	in := c.FollowStateValues(graph, entry)
+	reported := make(map[string]bool)
+	for _, b := range graph.Blocks {
+		if !b.Live || in[b.Index] == nil || len(b.Succs) > 0 || c.NeverLeft(b) || EndsWithoutReturning(pass, cfgs, b) {
+			continue
+		}
+		out := c.CheckBlock(b, in[b.Index])
+		pos := body.Rbrace
+		if len(b.Nodes) > 0 {
+			if ret, ok := b.Nodes[len(b.Nodes)-1].(*ast.ReturnStmt); ok {
+				pos = ret.Pos()
+			}
+		}
+		for _, value := range GetUnusedValues(out) {
+			message := "the session held by state value " + value.Var.Name() + " (line " + fmt.Sprint(pass.Fset.Position(value.Def).Line) + ") is abandoned on a path which returns here, before the protocol is complete"
+			key := fmt.Sprint(pos) + message
+			if !reported[key] {
+				reported[key] = true
+				pass.Reportf(pos, "%s", message)
+			}
+		}
+	}
+	RecordReceivedBranches(c, choices)
+}
+
+// EndsWithoutReturning reports whether b ends with a call to a function
+// which never returns, such as panic or log.Fatal.
+func EndsWithoutReturning(pass *analysis.Pass, cfgs *ctrlflow.CFGs, b *cfg.Block) bool {
+	if len(b.Nodes) == 0 {
+		return false
+	}
+	stmt, ok := b.Nodes[len(b.Nodes)-1].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+	call, ok := ast.Unparen(stmt.X).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	switch fn := typeutil.Callee(pass.TypesInfo, call).(type) {
+	case *types.Builtin:
+		return fn.Name() == "panic"
+	case *types.Func:
+		return cfgs.NoReturn(fn)
+	}
+	return false
+}
+
+// GetUnusedValues returns the values in facts which have not been used,
+// in the order in which they were given.
+func GetUnusedValues(facts StateFacts) []StateValue {
+	values := make([]StateValue, 0)
+	for f := range facts {
+		if f.Used == token.NoPos {
+			values = append(values, f.Value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Def < values[j].Def
+	})
+	return values
+}
+
+// GetReceiveMethods returns the names of the Receive_ methods of a state
+// type, in sorted order.
+func GetReceiveMethods(t types.Type) []string {
+	names := make([]string, 0)
+	methods := types.NewMethodSet(t)
+	for i := 0; i < methods.Len(); i++ {
+		if name := methods.At(i).Obj().Name(); strings.HasPrefix(name, "Receive_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecordReceivedBranches records the Receive_ methods called on values
+// of state types at which another role chooses between branches.
+func RecordReceivedBranches(c *LinearChecker, choices map[string]*ChoiceBranches) {
+	for value, calls := range c.Calls {
+		typ := value.Var.Type()
+		if len(GetReceiveMethods(typ)) < 2 {
+			continue
+		}
+		for method := range calls {
+			if !strings.HasPrefix(method, "Receive_") {
+				continue
+			}
+			key := types.TypeString(typ, nil)
+			if choices[key] == nil {
+				choices[key] = &ChoiceBranches{Type: typ, Received: make(map[string]bool), First: value.Def}
+			}
+			choices[key].Received[method] = true
+			if value.Def < choices[key].First {
+				choices[key].First = value.Def
+			}
+		}
+	}
+}
+
+// CheckChoicesHandled reports each state type at which another role
+// chooses between branches, for which the package receives some branches
+// but not others.
+func CheckChoicesHandled(pass *analysis.Pass, choices map[string]*ChoiceBranches) {
+	keys := make([]string, 0)
+	for key := range choices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		choice := choices[key]
+		missing := make([]string, 0)
+		for _, name := range GetReceiveMethods(choice.Type) {
+			if !choice.Received[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			pass.Reportf(choice.First, "another role chooses between branches at %s, but %s is never called, so the session is abandoned if that branch is chosen", types.TypeString(choice.Type, types.RelativeTo(pass.Pkg)), strings.Join(missing, " or "))
+		}
+	}
+}
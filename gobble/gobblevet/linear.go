@@ -0,0 +1,599 @@
+// The linear analyzer checks that each value of a state struct generated
+// by Gobble is used exactly once. A state struct is recognised by its
+// Channels field, the Used field it has from runtime.Endpoint and its
+// Send_, Receive_, StartPar or EndPar methods. Within each function the
+// analyzer follows the control flow graph, tracking which state value
+// each local variable may hold and whether that value has been used, and
+// reports:
+//
+//   - a state value used again after one of its methods has been called
+//     or it has been passed on, including a value used on every
+//     iteration of a loop without being replaced by the state its method
+//     returns;
+//   - a state value which is never used, and the state returned by a
+//     method which is discarded, either of which leaves the protocol
+//     unfinished;
+//   - a state value stored in a field, a slice, a map, a channel or a
+//     package-level variable, captured by a function literal or whose
+//     address is taken, from where it could be used again.
+//
+// Passing a state value to a function, returning it or assigning it to
+// another local variable counts as using it, and the function or
+// variable which receives it is then responsible for it. The methods of
+// the state structs themselves are not checked.
+
+// input: the syntax trees, type information and control flow graphs of
+// a Go package
+
+// output: analysis diagnostics
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/ctrlflow"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/cfg"
+)
+
+var LinearAnalyzer = &analysis.Analyzer{
+	Name:     "gobblelinear",
+	Doc:      "check that the session state structs generated by Gobble are used exactly once",
+	Requires: []*analysis.Analyzer{inspect.Analyzer, ctrlflow.Analyzer},
+	Run:      RunLinearAnalyzer,
+}
+
+// StateValue is the value a local variable was given at Def.
+type StateValue struct {
+	Var *types.Var
+	Def token.Pos
+}
+
+// StateFact records that a variable may hold Value, which was last used
+// at Used, or at token.NoPos if it has not been used.
+type StateFact struct {
+	Value StateValue
+	Used  token.Pos
+}
+
+type StateFacts map[StateFact]bool
+
+// LinearChecker follows the state values held by the local variables of
+// one function. Calls records the methods called on each value, with ""
+// standing for the value being passed on, and Errors the error returned
+// alongside each value by the call which gave it. With IgnoreFailed set,
+// a value is forgotten on the branch taken when its error is not nil.
+type LinearChecker struct {
+	Pass         *analysis.Pass
+	Locals       map[*types.Var]bool
+	Results      []*types.Var
+	Values       map[StateValue]bool
+	Used         map[StateValue]bool
+	Calls        map[StateValue]map[string]bool
+	Errors       map[StateValue]*types.Var
+	IgnoreFailed bool
+	Reporting    bool
+	Reported     map[string]bool
+	Selects      map[*ast.CommClause]*ast.SelectStmt
+}
+
+func RunLinearAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	cfgs := pass.ResultOf[ctrlflow.Analyzer].(*ctrlflow.CFGs)
+	filter := []ast.Node{(*ast.FuncDecl)(nil), (*ast.FuncLit)(nil)}
+	insp.Preorder(filter, func(n ast.Node) {
+		switch fn := n.(type) {
+		case *ast.FuncDecl:
+			if fn.Body == nil || IsStateMethod(pass, fn) {
+				return
+			}
+			CheckFunction(pass, fn.Type, fn.Recv, fn.Body, cfgs.FuncDecl(fn))
+		case *ast.FuncLit:
+			CheckFunction(pass, fn.Type, nil, fn.Body, cfgs.FuncLit(fn))
+		}
+	})
+	return nil, nil
+}
+
+// IsStateType reports whether t is a state struct generated by Gobble or
+// a pointer to one.
+func IsStateType(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return false
+	}
+	hasChannels := false
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i).Name() == "Channels" {
+			hasChannels = true
+		}
+	}
+	used, _, _ := types.LookupFieldOrMethod(named, true, nil, "Used")
+	field, ok := used.(*types.Var)
+	if !ok || !field.IsField() || !types.Identical(field.Type(), types.Typ[types.Bool]) || !hasChannels {
+		return false
+	}
+	methods := types.NewMethodSet(types.NewPointer(named))
+	for i := 0; i < methods.Len(); i++ {
+		name := methods.At(i).Obj().Name()
+		if strings.HasPrefix(name, "Send_") || strings.HasPrefix(name, "Receive_") || name == "StartPar" || name == "EndPar" {
+			return true
+		}
+	}
+	return false
+}
+
+func IsStateMethod(pass *analysis.Pass, fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return false
+	}
+	return IsStateType(pass.TypesInfo.TypeOf(fn.Recv.List[0].Type))
+}
+
+func CheckFunction(pass *analysis.Pass, typ *ast.FuncType, recv *ast.FieldList, body *ast.BlockStmt, graph *cfg.CFG) {
+	if graph == nil {
+		return
+	}
+	c, entry := NewLinearChecker(pass, typ, recv, body)
+	in := c.FollowStateValues(graph, entry)
+	c.Reporting = true
+	for _, b := range graph.Blocks {
+		if b.Live && in[b.Index] != nil {
+			c.CheckBlock(b, in[b.Index])
+		}
+	}
+	for value := range c.Values {
+		if !c.Used[value] {
+			c.Report(value.Def, "state value "+value.Var.Name()+" is never used, so the protocol cannot be completed; call one of its methods or pass it on")
+		}
+	}
+}
+
+// NewLinearChecker returns a checker for a function and the facts which
+// hold on entry to it.
+func NewLinearChecker(pass *analysis.Pass, typ *ast.FuncType, recv *ast.FieldList, body *ast.BlockStmt) (*LinearChecker, StateFacts) {
+	c := &LinearChecker{Pass: pass, Locals: make(map[*types.Var]bool), Results: make([]*types.Var, 0), Values: make(map[StateValue]bool), Used: make(map[StateValue]bool), Calls: make(map[StateValue]map[string]bool), Errors: make(map[StateValue]*types.Var), Reported: make(map[string]bool), Selects: make(map[*ast.CommClause]*ast.SelectStmt)}
+	entry := make(StateFacts)
+	for _, fields := range []*ast.FieldList{recv, typ.Params, typ.Results} {
+		if fields == nil {
+			continue
+		}
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				if v, ok := pass.TypesInfo.Defs[name].(*types.Var); ok {
+					c.Locals[v] = true
+					if fields == typ.Results {
+						c.Results = append(c.Results, v)
+					} else {
+						c.Define(v, name.Pos(), entry)
+					}
+				}
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok {
+			if v, ok := pass.TypesInfo.Defs[id].(*types.Var); ok {
+				c.Locals[v] = true
+			}
+		}
+		if sel, ok := n.(*ast.SelectStmt); ok {
+			for _, clause := range sel.Body.List {
+				c.Selects[clause.(*ast.CommClause)] = sel
+			}
+		}
+		return true
+	})
+	return c, entry
+}
+
+// FollowStateValues returns the facts which hold on entry to each block
+// of graph, or nil for blocks which cannot be reached.
+func (c *LinearChecker) FollowStateValues(graph *cfg.CFG, entry StateFacts) []StateFacts {
+	in := make([]StateFacts, len(graph.Blocks))
+	in[0] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range graph.Blocks {
+			if !b.Live || in[b.Index] == nil {
+				continue
+			}
+			out := c.CheckBlock(b, in[b.Index])
+			for i, succ := range b.Succs {
+				if in[succ.Index] == nil {
+					in[succ.Index] = make(StateFacts)
+				}
+				for f := range c.GetEdgeFacts(b, i, out) {
+					if !in[succ.Index][f] {
+						in[succ.Index][f] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return in
+}
+
+// NeverLeft reports whether b is the block which the control flow graph
+// places after the last case of a select statement without a default
+// clause. It has no successors, like a block which returns, but is never
+// reached as the select waits for one of its cases instead.
+func (c *LinearChecker) NeverLeft(b *cfg.Block) bool {
+	clause, ok := b.Stmt.(*ast.CommClause)
+	if !ok || b.Kind != cfg.KindSelectAfterCase {
+		return false
+	}
+	sel := c.Selects[clause]
+	if sel == nil || sel.Body.List[len(sel.Body.List)-1] != clause {
+		return false
+	}
+	for _, other := range sel.Body.List {
+		if other.(*ast.CommClause).Comm == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetEdgeFacts returns the facts which hold along the i'th edge leaving
+// b, given those which hold at the end of b.
+func (c *LinearChecker) GetEdgeFacts(b *cfg.Block, i int, out StateFacts) StateFacts {
+	if !c.IgnoreFailed || len(b.Succs) != 2 || len(b.Nodes) == 0 {
+		return out
+	}
+	errVar, failed := c.GetErrorCheck(b.Nodes[len(b.Nodes)-1])
+	if errVar == nil || failed != i {
+		return out
+	}
+	edge := make(StateFacts)
+	for f := range out {
+		if c.Errors[f.Value] != errVar {
+			edge[f] = true
+		}
+	}
+	return edge
+}
+
+// GetErrorCheck returns the error variable compared with nil by cond and
+// the index of the successor taken when it is not nil, or nil if cond is
+// not such a comparison.
+func (c *LinearChecker) GetErrorCheck(cond ast.Node) (*types.Var, int) {
+	expr, ok := cond.(*ast.BinaryExpr)
+	if !ok || (expr.Op != token.NEQ && expr.Op != token.EQL) {
+		return nil, 0
+	}
+	id, ok := ast.Unparen(expr.X).(*ast.Ident)
+	if !ok || !c.Pass.TypesInfo.Types[expr.Y].IsNil() {
+		return nil, 0
+	}
+	v, ok := c.Pass.TypesInfo.Uses[id].(*types.Var)
+	if !ok || !c.Locals[v] {
+		return nil, 0
+	}
+	if expr.Op == token.NEQ {
+		return v, 0
+	}
+	return v, 1
+}
+
+// RecordErrors records the error variable assigned alongside the state
+// values given by a call with several results.
+func (c *LinearChecker) RecordErrors(lhs []ast.Expr, rhs []ast.Expr) {
+	if len(rhs) != 1 || len(lhs) < 2 {
+		return
+	}
+	var errVar *types.Var
+	for _, expr := range lhs {
+		if id, ok := expr.(*ast.Ident); ok {
+			v, ok := c.Pass.TypesInfo.ObjectOf(id).(*types.Var)
+			if ok && c.Locals[v] && types.Identical(v.Type(), types.Universe.Lookup("error").Type()) {
+				errVar = v
+			}
+		}
+	}
+	if errVar == nil {
+		return
+	}
+	for _, expr := range lhs {
+		if id, ok := expr.(*ast.Ident); ok {
+			if v := c.GetLocal(id); v != nil {
+				c.Errors[StateValue{Var: v, Def: id.Pos()}] = errVar
+			}
+		}
+	}
+}
+
+func (c *LinearChecker) CheckBlock(b *cfg.Block, in StateFacts) StateFacts {
+	facts := make(StateFacts)
+	for f := range in {
+		facts[f] = true
+	}
+	for _, n := range b.Nodes {
+		c.CheckNode(n, facts)
+	}
+	return facts
+}
+
+func (c *LinearChecker) CheckNode(n ast.Node, facts StateFacts) {
+	switch n := n.(type) {
+	case *ast.AssignStmt:
+		c.Walk(n, facts)
+		c.CheckDiscardedResults(n.Lhs, n.Rhs)
+		c.RecordErrors(n.Lhs, n.Rhs)
+		for _, lhs := range n.Lhs {
+			if id, ok := lhs.(*ast.Ident); ok {
+				if v := c.GetLocal(id); v != nil {
+					c.Define(v, id.Pos(), facts)
+				}
+			}
+		}
+	case *ast.ValueSpec:
+		c.Walk(n, facts)
+		if len(n.Values) > 0 {
+			lhs := make([]ast.Expr, 0)
+			for _, name := range n.Names {
+				lhs = append(lhs, name)
+			}
+			c.CheckDiscardedResults(lhs, n.Values)
+			for _, name := range n.Names {
+				if v := c.GetLocal(name); v != nil {
+					c.Define(v, name.Pos(), facts)
+				}
+			}
+		}
+	case *ast.ExprStmt:
+		if call, ok := ast.Unparen(n.X).(*ast.CallExpr); ok {
+			for i, typ := range c.GetResultTypes(call) {
+				if IsStateType(typ) {
+					c.Report(call.Pos(), "the state returned by "+types.ExprString(call.Fun)+" is discarded"+GetResultIndex(i)+", so the protocol cannot be completed")
+				}
+			}
+		}
+		c.Walk(n, facts)
+	case *ast.ReturnStmt:
+		c.Walk(n, facts)
+		if len(n.Results) == 0 {
+			for _, v := range c.Results {
+				c.Use(v, n.Pos(), facts)
+			}
+		}
+	case *ast.Ident:
+		// The key or value of a range statement
+		if v := c.GetLocal(n); v != nil && c.Pass.TypesInfo.Defs[n] != nil {
+			c.Define(v, n.Pos(), facts)
+			return
+		}
+		c.Walk(n, facts)
+	default:
+		c.Walk(n, facts)
+	}
+}
+
+// GetLocal returns the local variable of state type named by id, or nil.
+func (c *LinearChecker) GetLocal(id *ast.Ident) *types.Var {
+	if id.Name == "_" {
+		return nil
+	}
+	obj := c.Pass.TypesInfo.Defs[id]
+	if obj == nil {
+		obj = c.Pass.TypesInfo.Uses[id]
+	}
+	v, ok := obj.(*types.Var)
+	if !ok || !c.Locals[v] || !IsStateType(v.Type()) {
+		return nil
+	}
+	return v
+}
+
+func (c *LinearChecker) GetResultTypes(call *ast.CallExpr) []types.Type {
+	results := make([]types.Type, 0)
+	if tv, ok := c.Pass.TypesInfo.Types[call]; ok && !tv.IsType() {
+		if tuple, ok := tv.Type.(*types.Tuple); ok {
+			for i := 0; i < tuple.Len(); i++ {
+				results = append(results, tuple.At(i).Type())
+			}
+		} else if tv.Type != nil {
+			results = append(results, tv.Type)
+		}
+	}
+	return results
+}
+
+func GetResultIndex(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (result %d)", i+1)
+}
+
+// CheckDiscardedResults reports the states returned by calls on the
+// right of an assignment which are assigned to the blank identifier.
+func (c *LinearChecker) CheckDiscardedResults(lhs []ast.Expr, rhs []ast.Expr) {
+	if len(rhs) == 1 && len(lhs) > 1 {
+		if call, ok := ast.Unparen(rhs[0]).(*ast.CallExpr); ok {
+			for i, typ := range c.GetResultTypes(call) {
+				if i < len(lhs) && IsBlank(lhs[i]) && IsStateType(typ) {
+					c.Report(lhs[i].Pos(), "the state returned by "+types.ExprString(call.Fun)+" is discarded"+GetResultIndex(i)+", so the protocol cannot be completed")
+				}
+			}
+		}
+		return
+	}
+	for i, expr := range rhs {
+		if call, ok := ast.Unparen(expr).(*ast.CallExpr); ok && i < len(lhs) && IsBlank(lhs[i]) {
+			if results := c.GetResultTypes(call); len(results) == 1 && IsStateType(results[0]) {
+				c.Report(lhs[i].Pos(), "the state returned by "+types.ExprString(call.Fun)+" is discarded, so the protocol cannot be completed")
+			}
+		}
+	}
+}
+
+func IsBlank(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	return ok && id.Name == "_"
+}
+
+// Walk checks every use of a local state variable within n. The names
+// assigned to by an assignment are left to the caller.
+func (c *LinearChecker) Walk(n ast.Node, facts StateFacts) {
+	stack := make([]ast.Node, 0)
+	ast.Inspect(n, func(node ast.Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if id, ok := node.(*ast.Ident); ok && len(stack) > 0 {
+			c.CheckIdent(id, stack, facts)
+		}
+		stack = append(stack, node)
+		return true
+	})
+}
+
+func (c *LinearChecker) CheckIdent(id *ast.Ident, stack []ast.Node, facts StateFacts) {
+	v, ok := c.Pass.TypesInfo.Uses[id].(*types.Var)
+	if !ok || !c.Locals[v] || !IsStateType(v.Type()) {
+		return
+	}
+	parent := stack[len(stack)-1]
+	if assign, ok := parent.(*ast.AssignStmt); ok {
+		for _, lhs := range assign.Lhs {
+			if lhs == id {
+				return
+			}
+		}
+	}
+	for _, node := range stack {
+		if _, ok := node.(*ast.FuncLit); ok {
+			c.Report(id.Pos(), "state value "+id.Name+" is captured by a function literal, from where it could be used again")
+			c.RecordCall(v, "", facts)
+			c.Use(v, id.Pos(), facts)
+			return
+		}
+	}
+	method := ""
+	switch p := parent.(type) {
+	case *ast.SelectorExpr:
+		if sel := c.Pass.TypesInfo.Selections[p]; sel != nil && sel.Kind() == types.FieldVal {
+			return
+		}
+		method = p.Sel.Name
+	case *ast.BinaryExpr:
+		if p.Op == token.EQL || p.Op == token.NEQ {
+			return
+		}
+	case *ast.AssignStmt:
+		if len(p.Lhs) == len(p.Rhs) {
+			for i, rhs := range p.Rhs {
+				if rhs == id && !c.IsLocalTarget(p.Lhs[i]) {
+					c.Report(id.Pos(), "state value "+id.Name+" is stored in "+types.ExprString(p.Lhs[i])+", from where it could be used again")
+				}
+			}
+		}
+	case *ast.CompositeLit, *ast.KeyValueExpr:
+		c.Report(id.Pos(), "state value "+id.Name+" is stored in a composite literal, from where it could be used again")
+	case *ast.SendStmt:
+		c.Report(id.Pos(), "state value "+id.Name+" is sent on a channel, from where it could be used again")
+	case *ast.UnaryExpr:
+		if p.Op == token.AND {
+			c.Report(id.Pos(), "the address of state value "+id.Name+" is taken, through which it could be used again")
+		}
+	}
+	c.RecordCall(v, method, facts)
+	c.Use(v, id.Pos(), facts)
+}
+
+// RecordCall records that method is called on the values v may hold, or
+// with method "" that they are passed on.
+func (c *LinearChecker) RecordCall(v *types.Var, method string, facts StateFacts) {
+	for f := range facts {
+		if f.Value.Var != v {
+			continue
+		}
+		if c.Calls[f.Value] == nil {
+			c.Calls[f.Value] = make(map[string]bool)
+		}
+		c.Calls[f.Value][method] = true
+	}
+}
+
+// IsLocalTarget reports whether expr, assigned to, is the blank
+// identifier or a local variable.
+func (c *LinearChecker) IsLocalTarget(expr ast.Expr) bool {
+	id, ok := ast.Unparen(expr).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	if id.Name == "_" {
+		return true
+	}
+	obj := c.Pass.TypesInfo.Defs[id]
+	if obj == nil {
+		obj = c.Pass.TypesInfo.Uses[id]
+	}
+	v, ok := obj.(*types.Var)
+	return ok && c.Locals[v]
+}
+
+// Define records that v holds a new value, given to it at pos.
+func (c *LinearChecker) Define(v *types.Var, pos token.Pos, facts StateFacts) {
+	if !IsStateType(v.Type()) {
+		return
+	}
+	for f := range facts {
+		if f.Value.Var == v {
+			delete(facts, f)
+		}
+	}
+	value := StateValue{Var: v, Def: pos}
+	facts[StateFact{Value: value}] = true
+	c.Values[value] = true
+}
+
+// Use records that the value held by v is used at pos, reporting any
+// value it may hold which has been used already.
+func (c *LinearChecker) Use(v *types.Var, pos token.Pos, facts StateFacts) {
+	held := make([]StateFact, 0)
+	for f := range facts {
+		if f.Value.Var == v {
+			held = append(held, f)
+			delete(facts, f)
+		}
+	}
+	for _, f := range held {
+		c.Used[f.Value] = true
+		facts[StateFact{Value: f.Value, Used: pos}] = true
+		if f.Used == pos {
+			c.Report(pos, "state value "+v.Name()+" is used on every iteration of a loop; replace it with the state its method returns before the loop repeats")
+		} else if f.Used != token.NoPos {
+			c.Report(pos, "state value "+v.Name()+" is used again after it was used at line "+fmt.Sprint(c.Pass.Fset.Position(f.Used).Line)+"; each state value may be used only once")
+		}
+	}
+}
+
+func (c *LinearChecker) Report(pos token.Pos, message string) {
+	key := fmt.Sprint(pos) + message
+	if !c.Reporting || c.Reported[key] {
+		return
+	}
+	c.Reported[key] = true
+	c.Pass.Reportf(pos, "%s", message)
+}
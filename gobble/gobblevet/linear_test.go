@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestLinearAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), LinearAnalyzer, "linear")
+}
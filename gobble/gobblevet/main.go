@@ -0,0 +1,26 @@
+// Gobblevet checks Go code which uses an API generated by Gobble for
+// misuse of the session state structs which make up the API, so that a
+// state struct used twice is found before the programme is run rather
+// than by the Used check in each generated method, and for paths which
+// abandon a session before the end of the protocol. It is meant to be
+// run by go vet:
+//
+//	cd gobblevet && go build -o gobblevet .
+//	go vet -vettool=/path/to/gobblevet ./...
+//
+// Gobblevet is a module of its own, since it depends on
+// golang.org/x/tools, which the generated code and the runtime do not.
+
+// input: Go packages, as passed by go vet
+
+// output: diagnostics reported through go vet
+
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() {
+	unitchecker.Main(LinearAnalyzer, CompletionAnalyzer)
+}
@@ -0,0 +1,15 @@
+package branches
+
+import "session"
+
+func OneBranch(s *session.Start) error {
+	c, err := s.Send_Request(1) // want `another role chooses between branches at \*session.Choice, but Receive_Reject is never called`
+	if err != nil {
+		return err
+	}
+	a, err := c.Receive_Accept()
+	if err != nil {
+		return err
+	}
+	return a.Send_Pay(2)
+}
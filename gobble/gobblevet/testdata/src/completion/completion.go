@@ -0,0 +1,32 @@
+package completion
+
+import (
+	"log"
+
+	"session"
+)
+
+func Complete(c *session.Choice, accept bool) error {
+	if !accept {
+		return c.Receive_Reject()
+	}
+	a, err := c.Receive_Accept()
+	if err != nil {
+		return err
+	}
+	return a.Send_Pay(1)
+}
+
+func Abandoned(a *session.Accepted, pay bool) error {
+	if !pay {
+		return nil // want `the session held by state value a \(line 20\) is abandoned on a path which returns here`
+	}
+	return a.Send_Pay(1)
+}
+
+func Fatal(a *session.Accepted, pay bool) error {
+	if !pay {
+		log.Fatal("not paying")
+	}
+	return a.Send_Pay(1)
+}
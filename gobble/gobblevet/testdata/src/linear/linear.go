@@ -0,0 +1,50 @@
+package linear
+
+import "session"
+
+func Complete(s *session.Start) error {
+	c, err := s.Send_Request(1)
+	if err != nil {
+		return err
+	}
+	a, err := c.Receive_Accept()
+	if err != nil {
+		return err
+	}
+	return a.Send_Pay(2)
+}
+
+func UsedTwice(s *session.Start) {
+	s.Send_Request(1) // want `the state returned by s.Send_Request is discarded`
+	s.Send_Request(2) // want `state value s is used again after it was used at line 18` `the state returned by s.Send_Request is discarded`
+}
+
+func NeverUsed(s *session.Start) { // want `state value s is never used`
+}
+
+func Discarded(s *session.Start) error {
+	_, err := s.Send_Request(1) // want `the state returned by s.Send_Request is discarded`
+	return err
+}
+
+func Loop(a *session.Accepted) {
+	for i := 0; i < 3; i++ {
+		a.Send_Pay(i) // want `state value a is used on every iteration of a loop`
+	}
+}
+
+var saved *session.Start
+
+func Stored(s *session.Start) {
+	saved = s // want `state value s is stored in saved`
+}
+
+func Captured(s *session.Start) func() {
+	return func() {
+		s.Send_Request(1) // want `state value s is captured by a function literal` `the state returned by s.Send_Request is discarded`
+	}
+}
+
+func Sent(s *session.Start, ch chan *session.Start) {
+	ch <- s // want `state value s is sent on a channel`
+}
@@ -0,0 +1,51 @@
+// Package session declares state structs of the shape Gobble generates
+// for a role which sends Request, then receives Accept or Reject, for the
+// analyzers to be tested against.
+package session
+
+import "errors"
+
+type Endpoint struct {
+	Used bool
+}
+
+func (e *Endpoint) Use() error {
+	if e.Used {
+		return errors.New("state used twice")
+	}
+	e.Used = true
+	return nil
+}
+
+type Channels struct{}
+
+type Start struct {
+	Endpoint
+	Channels *Channels
+}
+
+type Choice struct {
+	Endpoint
+	Channels *Channels
+}
+
+type Accepted struct {
+	Endpoint
+	Channels *Channels
+}
+
+func (self *Start) Send_Request(n int) (*Choice, error) {
+	return &Choice{Channels: self.Channels}, self.Use()
+}
+
+func (self *Choice) Receive_Accept() (*Accepted, error) {
+	return &Accepted{Channels: self.Channels}, self.Use()
+}
+
+func (self *Choice) Receive_Reject() error {
+	return self.Use()
+}
+
+func (self *Accepted) Send_Pay(amount int) error {
+	return self.Use()
+}
@@ -0,0 +1,208 @@
+// The graph writer draws the state machine which the generated API gives
+// each role. Every state struct (Aggregator_rec1_par1_A2,
+// Aggregator_rec1_choice1_C3, ...) becomes a node and every Send_ and
+// Receive_ method an edge from the struct on which it is defined to each
+// struct it returns, labelled with the payload types it sends or
+// receives. A method which returns no struct ends the protocol and leads
+// to a node named end. StartPar is drawn as bold fork edges to the first
+// struct of each branch of a par block, and as a dotted join edge to the
+// block's _end struct, on which EndPar waits for the branches; the method
+// ending each branch is drawn as a dotted join edge too. The method which
+// completes an iteration of a rec block is drawn as a dashed back edge to
+// the first struct of the block. Struct and method names are produced by
+// the same functions as the writers use, so the graph always matches the
+// generated code.
+
+// input: []MessagesData from the translator
+
+// output: a Graphviz DOT file for each role, written to the graph
+// subdirectory of the output directory
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type Graph struct {
+	Name   string
+	Role   string
+	Start  string
+	Nodes  []string
+	Edges  []*GraphEdge
+	HasEnd bool
+}
+
+// GraphEdge is a method leading from one state struct to another. Kind
+// is "step", "fork", "join" or "continue".
+type GraphEdge struct {
+	From  string
+	To    string
+	Label string
+	Kind  string
+}
+
+func BuildGraph(name string, m *MessagesData) *Graph {
+	g := &Graph{Name: name, Role: GetProtagonist(m), Nodes: make([]string, 0), Edges: make([]*GraphEdge, 0)}
+	start, typ := GetFirstStep(m)
+	if typ == "parallel" {
+		start += "_start"
+	}
+	g.Start = start
+	g.AddNode(start)
+	for _, mess := range m.Messages {
+		AddMessageEdges(g, mess, m)
+	}
+	for _, par := range m.Parallels {
+		AddParEdges(g, par)
+	}
+	return g
+}
+
+func (g *Graph) AddNode(name string) {
+	if !SliceContainsString(g.Nodes, name) {
+		g.Nodes = append(g.Nodes, name)
+	}
+}
+
+func (g *Graph) AddEdge(from string, to string, label string, kind string) {
+	g.AddNode(from)
+	if to == "" {
+		to = "end"
+		g.HasEnd = true
+	} else {
+		g.AddNode(to)
+	}
+	g.Edges = append(g.Edges, &GraphEdge{From: from, To: to, Label: label, Kind: kind})
+}
+
+func GetMessageEdgeLabel(mess *MessageData) string {
+	types := strings.Join(mess.ParameterTypes, ", ")
+	if mess.Protagonist == mess.FromBase {
+		return GetMessageMethodName(mess, "Send") + "(" + types + ")"
+	}
+	label := GetMessageMethodName(mess, "Receive") + "()"
+	if len(mess.ParameterTypes) == 1 {
+		label += " " + types
+	} else if len(mess.ParameterTypes) > 1 {
+		label += " (" + types + ")"
+	}
+	return label
+}
+
+// AddMessageEdges adds an edge for each struct returned by the method for
+// mess, in the order in which the method returns them.
+func AddMessageEdges(g *Graph, mess *MessageData, m *MessagesData) {
+	from := GetMessageStructName(mess)
+	label := GetMessageEdgeLabel(mess)
+	returned := false
+	if next := GetSubsequentStructName(mess); next != "" {
+		g.AddEdge(from, next, label, "step")
+		returned = true
+	}
+	whereTo := mess.WhereToIfBranchEnds
+	if whereTo != (WhereToIfBranchEnds{}) {
+		kind := "step"
+		if whereTo.EndingPar {
+			kind = "join"
+		}
+		g.AddEdge(from, GetWhereToIfBranchEndsStructName(whereTo, m), label, kind)
+		returned = true
+	}
+	if mess.ContinueToStruct != "" {
+		g.AddEdge(from, mess.ContinueToStruct, label, "continue")
+		returned = true
+	}
+	if !returned {
+		g.AddEdge(from, "", label, "step")
+	}
+}
+
+func AddParEdges(g *Graph, par *ParallelData) {
+	start := GetParStructNameBase(par) + "_start"
+	end := GetParStructNameBase(par) + "_end"
+	for _, branch := range GetParBranchStructNames(par) {
+		g.AddEdge(start, branch, "StartPar()", "fork")
+	}
+	g.AddEdge(start, end, "StartPar()", "join")
+	g.AddEdge(end, GetParSubsequentStructName(par), "EndPar()", "step")
+}
+
+// GetDOTString quotes s as a DOT identifier.
+func GetDOTString(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+func GetGraphEdgeAttributes(kind string) string {
+	switch kind {
+	case "fork":
+		return ", style=bold"
+	case "join":
+		return ", style=dotted"
+	case "continue":
+		return ", style=dashed, constraint=false"
+	}
+	return ""
+}
+
+func WriteDOT(g *Graph) string {
+	dot := "digraph " + GetDOTString(g.Name+"_"+g.Role) + " {\n"
+	dot += "\trankdir=LR;\n"
+	dot += "\tnode [shape=box, fontname=\"monospace\"];\n"
+	dot += "\tedge [fontname=\"monospace\"];\n"
+	dot += "\t__start [shape=point];\n"
+	for _, node := range g.Nodes {
+		dot += "\t" + GetDOTString(node) + ";\n"
+	}
+	if g.HasEnd {
+		dot += "\tend [shape=doublecircle, label=\"end\"];\n"
+	}
+	dot += "\t__start -> " + GetDOTString(g.Start) + ";\n"
+	for _, e := range g.Edges {
+		to := "end"
+		if e.To != "end" {
+			to = GetDOTString(e.To)
+		}
+		dot += "\t" + GetDOTString(e.From) + " -> " + to + " [label=" + GetDOTString(e.Label) + GetGraphEdgeAttributes(e.Kind) + "];\n"
+	}
+	dot += "}\n"
+	return dot
+}
+
+func WriteGraphToFile(g *Graph, moduleName string) {
+	sep := string(os.PathSeparator)
+	path := "." + sep + "output" + sep + moduleName + "_Gobble" + sep + "graph" + sep
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		log.Fatal("Error creating directory: ", err)
+	}
+	file, err := os.Create(path + g.Name + "_" + g.Role + ".dot")
+	if err != nil {
+		log.Fatal("Cannot create file: ", err)
+	}
+	fmt.Fprint(file, WriteDOT(g))
+	file.Close()
+}
+
+func GraphFiles(fileNames []string, moduleName string) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to graph.")
+	}
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	translations, err := TranslateTrees(trees)
+	if err != nil {
+		return err
+	}
+	for i, m := range translations {
+		WriteGraphToFile(BuildGraph(trees[i].Locals[0].Name, m), moduleName)
+	}
+	return nil
+}
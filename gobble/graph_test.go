@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "m(int) to B;\n\tn(x: string, bool) from B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := WriteDOT(BuildGraph("P", m))
+	want := `digraph "P_A" {
+	rankdir=LR;
+	node [shape=box, fontname="monospace"];
+	edge [fontname="monospace"];
+	__start [shape=point];
+	"A1";
+	"A2";
+	end [shape=doublecircle, label="end"];
+	__start -> "A1";
+	"A1" -> "A2" [label="Send_m_int(int)"];
+	"A2" -> end [label="Receive_n_string_bool() (string, bool)"];
+}
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBuildGraphMatchesGeneratedMethods(t *testing.T) {
+	fileNames, err := filepath.Glob("test*_*.scr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNames = append(fileNames, "aggregatorLocal.scr")
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]bool)
+	for _, tree := range trees {
+		m, err := TranslateTree(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &Translation{}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		WriteMethods(tr, m, &wg)
+		g := BuildGraph(tree.Locals[0].Name, m)
+		for _, e := range g.Edges {
+			kinds[e.Kind] = true
+			method := e.Label[:strings.Index(e.Label, "(")]
+			header := "func (self *" + e.From + ") " + method + "("
+			if !strings.Contains(tr.Methods, header) {
+				t.Errorf("%s at %s: no method %s for edge %s -> %s", tree.Locals[0].Name, m.Messages[0].Protagonist, header, e.From, e.To)
+			}
+			if e.To != "end" && !strings.Contains(tr.Methods, "*"+e.To+",") && !strings.Contains(tr.Methods, "*"+e.To+" ") {
+				t.Errorf("%s: no method returns %s", tree.Locals[0].Name, e.To)
+			}
+		}
+	}
+	for _, kind := range []string{"step", "fork", "join", "continue"} {
+		if !kinds[kind] {
+			t.Errorf("no %s edges drawn for the fixtures", kind)
+		}
+	}
+}
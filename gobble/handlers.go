@@ -0,0 +1,368 @@
+// In handler mode Gobble generates, for each role, a Go interface with a
+// method for each payload the role must produce, each message it
+// consumes and each choice it makes, and a driver which runs the session
+// by calling the user's implementation of the interface. The API, the
+// network code and the driver are written again each time Gobble runs,
+// while the implementation lives in a file of its own: Gobble writes a
+// skeleton implementation the first time and never overwrites it.
+
+// input: one or more validated Scribble .scr local or global protocol
+// files, passed as arguments after "handlers"
+
+// output: api.go, network.go and driver.go, and handler.go if it does not
+// yet exist, in a directory for each role within the ``output''
+// directory.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GetHandlerName returns the name of the interface which the user
+// implements for the protagonist of m.
+func GetHandlerName(m *MessagesData) string {
+	return GetProtagonist(m) + "Handler"
+}
+
+// GetHandlerParam returns the parameter through which each function of
+// the driver is given the user's implementation, or "" outside handler
+// mode.
+func GetHandlerParam(handler string) string {
+	if handler == "" {
+		return ""
+	}
+	return ", handler " + handler
+}
+
+// GetHandlerArg returns the argument passing the user's implementation
+// on to a function of the driver, or "" outside handler mode.
+func GetHandlerArg(handler string) string {
+	if handler == "" {
+		return ""
+	}
+	return ", handler"
+}
+
+// IsHandlerMethodMessage reports whether the protagonist of m has a
+// handler method for mess: one consuming it if it is received, or one
+// producing its payload if it is sent with one.
+func IsHandlerMethodMessage(mess *MessageData) bool {
+	return mess.Protagonist == mess.ToBase || len(mess.Parameters) > 0
+}
+
+// GetHandlerMethodNameBase returns the name of the handler method for
+// mess without the suffix which tells apart messages with the same
+// label.
+func GetHandlerMethodNameBase(mess *MessageData) string {
+	if mess.Protagonist == mess.ToBase {
+		return "On" + CapitaliseFirstLetter(mess.MethodNameBase)
+	}
+	return "Make" + CapitaliseFirstLetter(mess.MethodNameBase)
+}
+
+// GetHandlerMethodName returns the name of the handler method for mess.
+// Where the protagonist sends or receives messages with the same label
+// at several points of the protocol, the name of the state struct on
+// which each is sent or received is added to tell them apart.
+func GetHandlerMethodName(mess *MessageData, m *MessagesData) string {
+	name := GetHandlerMethodNameBase(mess)
+	count := 0
+	for _, other := range m.Messages {
+		if IsHandlerMethodMessage(other) && GetHandlerMethodNameBase(other) == name {
+			count++
+		}
+	}
+	if count > 1 {
+		name += strings.TrimPrefix(GetMessageStructName(mess), mess.Protagonist)
+	}
+	return name
+}
+
+// GetHandlerMethodSignature returns the signature of the handler method
+// for mess.
+func GetHandlerMethodSignature(mess *MessageData, m *MessagesData) string {
+	signature := GetHandlerMethodName(mess, m) + "("
+	if mess.Protagonist == mess.ToBase {
+		for i, typ := range mess.ParameterTypes {
+			if i > 0 {
+				signature += ", "
+			}
+			signature += mess.ParameterNames[i] + " " + typ
+		}
+		return signature + ")"
+	}
+	signature += ") ("
+	for i, typ := range mess.ParameterTypes {
+		if i > 0 {
+			signature += ", "
+		}
+		signature += typ
+	}
+	return signature + ")"
+}
+
+// GetHandlerChoiceType returns the name of the type whose constants name
+// the branches of a choice made by the protagonist.
+func GetHandlerChoiceType(choiceData *ChoiceData) string {
+	return GetChoiceStructName(choiceData) + "_Choice"
+}
+
+// GetHandlerChoiceConstant returns the name of the constant for the
+// branch of a choice made by the protagonist which begins with mess.
+func GetHandlerChoiceConstant(choiceData *ChoiceData, mess *MessageData) string {
+	return GetChoiceStructName(choiceData) + "_" + strings.TrimPrefix(GetMessageMethodName(mess, "Send"), "Send_")
+}
+
+// GetHandlerChooseName returns the name of the handler method which
+// makes a choice for the protagonist.
+func GetHandlerChooseName(choiceData *ChoiceData) string {
+	return "Choose" + strings.TrimPrefix(GetChoiceStructName(choiceData), choiceData.Protagonist)
+}
+
+func GetHandlerChoiceConstants(choiceData *ChoiceData, m *MessagesData) string {
+	typ := GetHandlerChoiceType(choiceData)
+	consts := "type " + typ + " int\n\n"
+	consts += "const (\n"
+	for i, mess := range GetChoiceBranchMessages(choiceData, m) {
+		consts += "\t" + GetHandlerChoiceConstant(choiceData, mess)
+		if i == 0 {
+			consts += " " + typ + " = iota"
+		}
+		consts += "\n"
+	}
+	consts += ")\n\n"
+	return consts
+}
+
+func GetHandlerInterface(m *MessagesData) string {
+	iface := "type " + GetHandlerName(m) + " interface {\n"
+	for _, mess := range m.Messages {
+		if IsHandlerMethodMessage(mess) {
+			iface += "\t" + GetHandlerMethodSignature(mess, m) + "\n"
+		}
+	}
+	for _, choiceData := range m.Choices {
+		if !IsChosenChoice(choiceData) {
+			iface += "\t" + GetHandlerChooseName(choiceData) + "() " + GetHandlerChoiceType(choiceData) + "\n"
+		}
+	}
+	iface += "}\n\n"
+	return iface
+}
+
+// WriteHandlerInterface writes the interface which the user implements,
+// and the constants naming the branches of each choice the protagonist
+// makes.
+func WriteHandlerInterface(t *Translation, m *MessagesData) {
+	t.Functions += "// Handler\n\n"
+	t.Functions += GetHandlerInterface(m)
+	for _, choiceData := range m.Choices {
+		if !IsChosenChoice(choiceData) {
+			t.Functions += GetHandlerChoiceConstants(choiceData, m)
+		}
+	}
+}
+
+// GetHandlerStepVarDecs returns the lines of a step of a conversation
+// function in handler mode which ask the handler for the payload to send.
+func GetHandlerStepVarDecs(id string, typ string, m *MessagesData, index int) []string {
+	decs := make([]string, 0)
+	if typ != "message" {
+		return decs
+	}
+	mess, err := GetMessageDataWithId(m, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mess.Protagonist == mess.FromBase && len(mess.Parameters) > 0 {
+		dec := "\t"
+		for i := range mess.Parameters {
+			if i > 0 {
+				dec += ", "
+			}
+			dec += GetStepVarName("sending", mess, index, i)
+		}
+		dec += " := handler." + GetHandlerMethodName(mess, m) + "()\n"
+		decs = append(decs, dec)
+	}
+	return decs
+}
+
+// GetHandlerStepVarPrints returns the lines of a step of a conversation
+// function in handler mode which pass a received message to the handler.
+func GetHandlerStepVarPrints(id string, typ string, m *MessagesData, index int) []string {
+	prints := make([]string, 0)
+	if typ != "message" {
+		return prints
+	}
+	mess, err := GetMessageDataWithId(m, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mess.Protagonist == mess.ToBase {
+		line := "\thandler." + GetHandlerMethodName(mess, m) + "("
+		for i := range mess.Parameters {
+			if i > 0 {
+				line += ", "
+			}
+			line += GetStepVarName("received", mess, index, i)
+		}
+		line += ")\n"
+		prints = append(prints, line)
+	}
+	return prints
+}
+
+// WriteHandlerChooserFunction writes the body of the function which makes
+// a choice for the protagonist in handler mode, which runs the branch
+// returned by the handler.
+func WriteHandlerChooserFunction(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	self := GetChoiceStructName(choiceData)
+	fun := "\tvar retVal " + GetNextName(choiceData.Protagonist) + "\n"
+	fun += "\tvar err error\n"
+	fun += "\tswitch choice := handler." + GetHandlerChooseName(choiceData) + "(); choice {\n"
+	for index, mess := range GetChoiceBranchMessages(choiceData, m) {
+		fun += "\tcase " + GetHandlerChoiceConstant(choiceData, mess) + ":\n"
+		fun += "\t\tretVal, err = run" + self + GetNextLetter(index) + "(" + self + ", handler)\n"
+	}
+	fun += "\tdefault:\n"
+	fun += "\t\tlog.Fatalf(\"" + GetHandlerName(m) + "." + GetHandlerChooseName(choiceData) + " returned unknown branch %d\", choice)\n"
+	fun += "\t}\n"
+	fun += "\treturn retVal, err\n"
+	t.Functions += fun
+}
+
+// GetHandlerSkeleton returns the contents of the skeleton implementation
+// of the handler, which produces zero values and ignores what it is
+// given, and the main function which runs the session with it.
+func GetHandlerSkeleton(m *MessagesData) string {
+	impl := GetProtagonist(m) + "Impl"
+	skeleton := "// " + impl + " implements " + GetHandlerName(m) + ". Gobble writes this file only if\n"
+	skeleton += "// it does not exist, so it may be edited freely.\n"
+	skeleton += "type " + impl + " struct {\n"
+	skeleton += "}\n\n"
+	for _, mess := range m.Messages {
+		if !IsHandlerMethodMessage(mess) {
+			continue
+		}
+		skeleton += "func (self *" + impl + ") " + GetHandlerMethodSignature(mess, m) + " {\n"
+		if mess.Protagonist == mess.FromBase {
+			ret := "\treturn "
+			for i, typ := range mess.ParameterTypes {
+				skeleton += "\tvar " + mess.ParameterNames[i] + " " + typ + "\n"
+				if i > 0 {
+					ret += ", "
+				}
+				ret += mess.ParameterNames[i]
+			}
+			skeleton += ret + "\n"
+		}
+		skeleton += "}\n\n"
+	}
+	for _, choiceData := range m.Choices {
+		if IsChosenChoice(choiceData) {
+			continue
+		}
+		first := GetChoiceBranchMessages(choiceData, m)[0]
+		skeleton += "func (self *" + impl + ") " + GetHandlerChooseName(choiceData) + "() " + GetHandlerChoiceType(choiceData) + " {\n"
+		skeleton += "\treturn " + GetHandlerChoiceConstant(choiceData, first) + "\n"
+		skeleton += "}\n\n"
+	}
+	skeleton += "func main() {\n"
+	skeleton += "\tRun" + GetProtagonist(m) + "(&" + impl + "{})\n"
+	skeleton += "}\n"
+	return skeleton
+}
+
+// WriteHandlerTranslation writes the API, network code and driver for
+// the protagonist of m to its own directory, and the skeleton handler
+// if none has been written there before.
+func WriteHandlerTranslation(m *MessagesData, moduleName string, config *NetworkConfig, runtimeDir string) {
+	t := &Translation{TypeImports: m.TypeImports, Handler: GetHandlerName(m)}
+	SetupNetworkConnection(t, config)
+	WritePackage(t, m)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	WriteChannels(t, m, &wg)
+	wg.Add(1)
+	WriteStructs(t, m, &wg)
+	wg.Add(1)
+	WriteMethods(t, m, &wg)
+	WriteMainFunction(t, m)
+	wg.Add(1)
+	t.Network += WriteNetwork(t, m, &wg)
+	WriteHandlerInterface(t, m)
+	vals := AssignConversationsFunctionValues(t, m)
+	t.Functions += GetStringConversationsFuncVals(vals, m)
+	wg.Add(1)
+	WriteFunctions(t, m, &wg)
+	wg.Wait()
+	sep := string(os.PathSeparator)
+	path := "." + sep + "output" + sep + moduleName + "_Gobble" + sep + "handlers" + sep + GetProtagonist(m) + sep
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		log.Fatal("Error creating directory: ", err)
+	}
+	WriteCombinedTranslationToFileInstance(t.Package, t.Channels+t.Structs+t.Methods, t.TypeImports, path, "api.go")
+	WriteCombinedTranslationToFileInstance(t.Package, t.Network, t.TypeImports, path, "network.go")
+	WriteCombinedTranslationToFileInstance(t.Package, t.Functions+t.Main, t.TypeImports, path, "driver.go")
+	if _, err := os.Stat(path + "handler.go"); os.IsNotExist(err) {
+		WriteCombinedTranslationToFileInstance(t.Package, GetHandlerSkeleton(m), t.TypeImports, path, "handler.go")
+	}
+	WriteModuleFile(moduleName, runtimeDir)
+}
+
+// HandlerFiles generates the handler mode implementation of each role
+// of each file, each as a separate programme which communicates with
+// the others over TCP.
+func HandlerFiles(fileNames []string, moduleName string, config *NetworkConfig) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to generate handlers for.")
+	}
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	if len(trees) > 1 {
+		err = CheckCompatibility(trees)
+		if err != nil {
+			return err
+		}
+	}
+	translations, err := TranslateTrees(trees)
+	if err != nil {
+		return err
+	}
+	err = CheckNetworkConfig(config, translations)
+	if err != nil {
+		return err
+	}
+	roles := make(map[string]int)
+	for _, m := range translations {
+		roles[GetProtagonist(m)]++
+	}
+	diags := make(Diagnostics, 0)
+	for _, m := range translations {
+		if roles[GetProtagonist(m)] > 1 {
+			diags = AppendDiagnostics(diags, errors.New("Role "+GetProtagonist(m)+" is given "+strconv.Itoa(roles[GetProtagonist(m)])+" local protocols; handler mode needs one for each role."))
+			roles[GetProtagonist(m)] = 0
+		}
+	}
+	if err = diags.Err(); err != nil {
+		return err
+	}
+	runtimeDir, err := GetModuleRuntimeDir(moduleName)
+	if err != nil {
+		return err
+	}
+	for _, m := range translations {
+		WriteHandlerTranslation(m, moduleName, config, runtimeDir)
+	}
+	return nil
+}
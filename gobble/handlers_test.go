@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteHandlerInterface(t *testing.T) {
+	m, err := TranslateTestSource(t, "type <go> \"int\" from \"\" as Count;", "m(x: int, string) to B;\n\tn(Count) from B;\n\tchoice at A { p() to B; } or { q(int) to B; }\n\tchoice at B { r() from B; } or { s() from B; }\n\tm(x: int, string) to B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Translation{}
+	WriteHandlerInterface(tr, m)
+	want := `// Handler
+
+type AHandler interface {
+	MakeM1() (int, string)
+	OnN(param1 int)
+	MakeM3() (int, string)
+	MakeQ() (int)
+	OnR()
+	OnS()
+	Choose_choice1() A_choice1_Choice
+}
+
+type A_choice1_Choice int
+
+const (
+	A_choice1_p A_choice1_Choice = iota
+	A_choice1_q_int
+)
+
+`
+	if tr.Functions != want {
+		t.Errorf("got\n%s\nwant\n%s", tr.Functions, want)
+	}
+	skeleton := GetHandlerSkeleton(m)
+	for _, want := range []string{
+		"func (self *AImpl) MakeM1() (int, string) {\n\tvar x int\n\tvar param2 string\n\treturn x, param2\n}\n",
+		"func (self *AImpl) OnN(param1 int) {\n}\n",
+		"func (self *AImpl) Choose_choice1() A_choice1_Choice {\n\treturn A_choice1_p\n}\n",
+		"func main() {\n\tRunA(&AImpl{})\n}\n",
+	} {
+		if !strings.Contains(skeleton, want) {
+			t.Errorf("skeleton does not contain %q:\n%s", want, skeleton)
+		}
+	}
+	// A message sent without a payload needs no handler method
+	if strings.Contains(skeleton, "MakeP") {
+		t.Errorf("skeleton has a method for a message without a payload:\n%s", skeleton)
+	}
+}
+
+func TestHandlerFilesKeepsHandler(t *testing.T) {
+	runtimeDir, err := filepath.Abs("runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOBBLE_RUNTIME", runtimeDir)
+	ChdirTestTemp(t)
+	source := "module M;\n\nglobal protocol P(role A, role B) {\n\tm(int) from A to B;\n}\n"
+	name := WriteTestFile(t, "test.scr", source)
+	err = HandlerFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join("output", "Protocol_Gobble", "handlers")
+	for _, role := range []string{"A", "B"} {
+		for _, file := range []string{"api.go", "network.go", "driver.go", "handler.go"} {
+			if _, err := os.Stat(filepath.Join(dir, role, file)); err != nil {
+				t.Errorf("%s was not written for %s: %v", file, role, err)
+			}
+		}
+	}
+	handler := filepath.Join(dir, "A", "handler.go")
+	err = os.WriteFile(handler, []byte("package main\n\n// edited\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = HandlerFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != "package main\n\n// edited\n" {
+		t.Errorf("handler.go was overwritten:\n%s", after)
+	}
+}
+
+func TestHandlerFilesRejectsRepeatedRole(t *testing.T) {
+	source := "module M;\n\nlocal protocol P at A(role A, role B) {\n\tm() to B;\n}\n\nlocal protocol Q at A(role A, role B) {\n\tn() to B;\n}\n"
+	err := HandlerFiles([]string{WriteTestFile(t, "test.scr", source)}, "Protocol", &NetworkConfig{})
+	if err == nil || !strings.Contains(err.Error(), "More than one local protocol is located at A") {
+		t.Errorf("got error %v", err)
+	}
+}
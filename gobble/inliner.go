@@ -0,0 +1,353 @@
+// The inliner resolves ``do'' instructions, which invoke one protocol
+// from within another. A do names a sub-protocol and the roles of the
+// caller which take part in it, e.g. ``do Login(Client as C, Server as
+// S);'' or, passing roles in the order the sub-protocol declares them,
+// ``do Login(Client, Server);''. The sub-protocol may be declared in the
+// same file or in a file brought in with ``import Name;''. Each do is
+// replaced by a copy of the body of the sub-protocol local to the role
+// the caller plays in it, with the roles of the sub-protocol renamed to
+// the roles of the caller and its rec blocks renamed so that they cannot
+// clash with those of the caller. The body of the sub-protocol then
+// simply runs on into whatever follows the do, so the translator and the
+// writers chain the states of the sub-session into the continuation of
+// the caller exactly as they would if it had been written out by hand.
+// Protocols invoked with do from elsewhere in the same file are not
+// generated separately. A sub-protocol may not invoke itself, directly
+// or indirectly; loops are written with rec and continue.
+
+// input: tree of structs descending from a Protocol root node, and the
+// trees of any files it imports
+
+// output: the same tree with every do replaced by the body it invokes
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Inliner struct {
+	Protagonist string
+	Locals      []*Local
+	Globals     []*Global
+	Invoked     map[*Local]bool
+	RecNames    map[string]bool
+	Stack       []string
+	Diags       *DiagnosticCollector
+}
+
+// LoadImports parses, checks and projects the files imported by p, and
+// the files which they import in turn. Each file is loaded only once.
+func LoadImports(p *Protocol, fileName string, loaded map[string]bool) ([]*Protocol, error) {
+	trees := make([]*Protocol, 0)
+	diags := make(Diagnostics, 0)
+	dir := filepath.Dir(fileName)
+	for _, imp := range p.Imports {
+		path := filepath.Join(dir, strings.Replace(imp.Name, ".", string(os.PathSeparator), -1)+".scr")
+		if loaded[path] {
+			continue
+		}
+		loaded[path] = true
+		if _, err := os.Stat(path); err != nil {
+			diags = append(diags, NewDiagnostic(imp.Pos, "Cannot import "+imp.Name+": "+path+" not found", "imported files are looked up relative to the importing file"))
+			continue
+		}
+		tree, err := ParseAndCheckGlobals(path)
+		if err == nil {
+			err = ProjectAndCheck(tree)
+		}
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		trees = append(trees, tree)
+		imported, err := LoadImports(tree, path, loaded)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+		}
+		trees = append(trees, imported...)
+	}
+	return trees, diags.Err()
+}
+
+func InlineDos(p *Protocol, imported []*Protocol) error {
+	diags := &DiagnosticCollector{}
+	locals := make([]*Local, 0)
+	globals := make([]*Global, 0)
+	for _, tree := range append([]*Protocol{p}, imported...) {
+		locals = append(locals, tree.Locals...)
+		globals = append(globals, tree.Globals...)
+	}
+	invoked := make(map[*Local]bool)
+	for _, l := range p.Locals {
+		if !NodesContainDo(l.Conv.Nodes) {
+			continue
+		}
+		inl := &Inliner{Protagonist: l.Protagonist, Locals: locals, Globals: globals, Invoked: invoked, Diags: diags}
+		inl.RecNames = make(map[string]bool)
+		inl.Stack = []string{l.Name + " at " + l.Protagonist}
+		CollectRecNames(l.Conv.Nodes, inl.RecNames)
+		nodes := inl.InlineNodes(l.Conv.Nodes, nil, nil, "")
+		l.Conv = &Conversation{Nodes: nodes, Protagonist: l.Protagonist, Pos: l.Conv.Pos}
+		ReassignLocalIds(l)
+	}
+	entries := make([]*Local, 0)
+	for _, l := range p.Locals {
+		if !invoked[l] {
+			entries = append(entries, l)
+		}
+	}
+	p.Locals = entries
+	return diags.Err()
+}
+
+func NodesContainDo(nodes []*Node) bool {
+	for _, n := range nodes {
+		if n.Cat == "do" {
+			return true
+		} else if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				if NodesContainDo(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				if NodesContainDo(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "rec" {
+			if NodesContainDo(n.Rec.Conv.Nodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func CollectRecNames(nodes []*Node, names map[string]bool) {
+	for _, n := range nodes {
+		if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				CollectRecNames(conv.Nodes, names)
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				CollectRecNames(conv.Nodes, names)
+			}
+		} else if n.Cat == "rec" {
+			names[n.Rec.Name] = true
+			CollectRecNames(n.Rec.Conv.Nodes, names)
+		}
+	}
+}
+
+// MapName returns the name to which a role or rec label is renamed, or
+// the name itself where there is no renaming.
+func MapName(names map[string]string, name string) string {
+	if newName, ok := names[name]; ok {
+		return newName
+	}
+	return name
+}
+
+// InlineNodes copies a sequence of nodes, renaming roles and rec labels
+// and replacing each do by the body it invokes. Rec blocks are renamed
+// only within the body of a sub-protocol, for which recPrefix is the
+// name of the sub-protocol.
+func (inl *Inliner) InlineNodes(nodes []*Node, roles map[string]string, recs map[string]string, recPrefix string) []*Node {
+	inlined := make([]*Node, 0)
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			mess := n.Mess
+			from := MapName(roles, mess.From)
+			to := MapName(roles, mess.To)
+			m := &Message{Id: mess.Id, Name: mess.Name, Types: CopyStringSlice(mess.Types), Names: CopyStringSlice(mess.Names), From: from, RoleFrom: from, To: to, RoleTo: to, Rec: mess.Rec, Protagonist: inl.Protagonist, Pos: mess.Pos}
+			inlined = append(inlined, &Node{Cat: "message", Mess: m})
+		} else if n.Cat == "choice" {
+			convs := inl.InlineConversations(n.Choice.Convs, roles, recs, recPrefix)
+			c := &Choice{Id: n.Choice.Id, Chooser: MapName(roles, n.Choice.Chooser), Convs: convs, Protagonist: inl.Protagonist, Pos: n.Choice.Pos}
+			inlined = append(inlined, &Node{Cat: "choice", Choice: c})
+		} else if n.Cat == "par" {
+			convs := inl.InlineConversations(n.Par.Convs, roles, recs, recPrefix)
+			p := &Parallel{Id: n.Par.Id, Convs: convs, Protagonist: inl.Protagonist, Pos: n.Par.Pos}
+			inlined = append(inlined, &Node{Cat: "par", Par: p})
+		} else if n.Cat == "rec" {
+			name := n.Rec.Name
+			innerRecs := recs
+			if recPrefix != "" {
+				name = inl.NewRecName(recPrefix + name)
+				innerRecs = make(map[string]string)
+				for k, v := range recs {
+					innerRecs[k] = v
+				}
+				innerRecs[n.Rec.Name] = name
+			}
+			nodes := inl.InlineNodes(n.Rec.Conv.Nodes, roles, innerRecs, recPrefix)
+			c := &Conversation{Nodes: nodes, Protagonist: inl.Protagonist, Pos: n.Rec.Conv.Pos}
+			r := &Rec{Id: n.Rec.Id, Name: name, Conv: c, Protagonist: inl.Protagonist, Pos: n.Rec.Pos}
+			inlined = append(inlined, &Node{Cat: "rec", Rec: r})
+		} else if n.Cat == "continue" {
+			name := MapName(recs, n.Cont.Name)
+			c := &Continue{Id: n.Cont.Id, Name: name, Pos: n.Cont.Pos}
+			inlined = append(inlined, &Node{Id: n.Cont.Id, Cat: "continue", Cont: c})
+		} else if n.Cat == "do" {
+			inlined = append(inlined, inl.InlineDo(n.Do, roles)...)
+		}
+	}
+	return inlined
+}
+
+func (inl *Inliner) InlineConversations(convs []*Conversation, roles map[string]string, recs map[string]string, recPrefix string) []*Conversation {
+	inlined := make([]*Conversation, 0)
+	for _, conv := range convs {
+		nodes := inl.InlineNodes(conv.Nodes, roles, recs, recPrefix)
+		inlined = append(inlined, &Conversation{Nodes: nodes, Protagonist: inl.Protagonist, Pos: conv.Pos})
+	}
+	return inlined
+}
+
+// NewRecName returns a rec label based on name which is not yet used
+// anywhere in the local protocol being inlined into.
+func (inl *Inliner) NewRecName(name string) string {
+	newName := name
+	for i := 2; inl.RecNames[newName]; i++ {
+		newName = name + strconv.Itoa(i)
+	}
+	inl.RecNames[newName] = true
+	return newName
+}
+
+// GetDoParams returns the names of the roles of the sub-protocol in the
+// order in which they are declared: those of the global protocol if the
+// sub-protocol is global, otherwise those of any of its locals.
+func (inl *Inliner) GetDoParams(name string) []string {
+	params := make([]string, 0)
+	for _, g := range inl.Globals {
+		if g.Name == name {
+			for _, role := range g.Roles {
+				params = append(params, role.Name)
+			}
+			return params
+		}
+	}
+	for _, l := range inl.Locals {
+		if l.Name == name {
+			for _, role := range l.Roles {
+				params = append(params, role.Name)
+			}
+			return params
+		}
+	}
+	return params
+}
+
+func (inl *Inliner) FindLocal(name string, protagonist string) *Local {
+	for _, l := range inl.Locals {
+		if l.Name == name && l.Protagonist == protagonist {
+			return l
+		}
+	}
+	return nil
+}
+
+func (inl *Inliner) InlineDo(d *Do, roles map[string]string) []*Node {
+	empty := make([]*Node, 0)
+	params := CopyStringSlice(d.Params)
+	named := 0
+	for _, param := range params {
+		if param != "" {
+			named++
+		}
+	}
+	if named == 0 {
+		declared := inl.GetDoParams(d.Name)
+		if len(declared) == 0 {
+			inl.Diags.Add(d.Pos, "do "+d.Name+" does not refer to a protocol", "declare protocol "+d.Name+" in this file or import the file which declares it")
+			return empty
+		}
+		if len(declared) != len(d.Roles) {
+			inl.Diags.Add(d.Pos, "do "+d.Name+" passes "+strconv.Itoa(len(d.Roles))+" roles to a protocol with "+strconv.Itoa(len(declared))+" roles", "pass one role for each of "+GetCommaSepList(declared))
+			return empty
+		}
+		params = declared
+	} else if named < len(params) {
+		inl.Diags.Add(d.Pos, "do "+d.Name+" mixes roles passed by position and roles passed with \"as\"", "pass every role in the form \"Role as SubRole\"")
+		return empty
+	}
+	// Map each role of the sub-protocol to the caller role playing it
+	subRoles := make(map[string]string)
+	protagonist := ""
+	for i, param := range params {
+		role := MapName(roles, d.Roles[i])
+		if _, ok := subRoles[param]; ok {
+			inl.Diags.Add(d.Pos, "do "+d.Name+" assigns role "+param+" more than once", "")
+			return empty
+		}
+		subRoles[param] = role
+		if role == inl.Protagonist {
+			protagonist = param
+		}
+	}
+	if protagonist == "" {
+		inl.Diags.Add(d.Pos, "do "+d.Name+" does not include "+inl.Protagonist, "")
+		return empty
+	}
+	key := d.Name + " at " + protagonist
+	if SliceContainsString(inl.Stack, key) {
+		inl.Diags.Add(d.Pos, "do "+d.Name+" invokes "+d.Name+" recursively", "write the loop with rec and continue instead")
+		return empty
+	}
+	sub := inl.FindLocal(d.Name, protagonist)
+	if sub == nil {
+		inl.Diags.Add(d.Pos, "No local protocol "+d.Name+" at "+protagonist+" for do "+d.Name, "declare protocol "+d.Name+" in this file or import the file which declares it")
+		return empty
+	}
+	for _, role := range sub.Roles {
+		if _, ok := subRoles[role.Name]; !ok {
+			inl.Diags.Add(d.Pos, "do "+d.Name+" does not say which role plays "+role.Name, "pass a role \"as "+role.Name+"\"")
+			return empty
+		}
+	}
+	inl.Invoked[sub] = true
+	inl.Stack = append(inl.Stack, key)
+	nodes := inl.InlineNodes(sub.Conv.Nodes, subRoles, make(map[string]string), d.Name)
+	inl.Stack = inl.Stack[:len(inl.Stack)-1]
+	return nodes
+}
+
+// ReassignLocalIds gives every element of a local protocol a fresh
+// unique identifier once do instructions have been inlined, as the
+// copied elements otherwise keep the identifiers of the sub-protocol.
+func ReassignLocalIds(l *Local) {
+	uniqueIdGen := &LocalIdGenerator{LocalName: l.Name}
+	ReassignNodeIds(l.Conv.Nodes, uniqueIdGen)
+}
+
+func ReassignNodeIds(nodes []*Node, uniqueIdGen *LocalIdGenerator) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			n.Mess.Id = uniqueIdGen.GenerateUniqueId(n.Mess.Name)
+		} else if n.Cat == "choice" {
+			n.Choice.Id = uniqueIdGen.GenerateUniqueId("choice")
+			for _, conv := range n.Choice.Convs {
+				ReassignNodeIds(conv.Nodes, uniqueIdGen)
+			}
+		} else if n.Cat == "par" {
+			n.Par.Id = uniqueIdGen.GenerateUniqueId("par")
+			for _, conv := range n.Par.Convs {
+				ReassignNodeIds(conv.Nodes, uniqueIdGen)
+			}
+		} else if n.Cat == "rec" {
+			ReassignNodeIds(n.Rec.Conv.Nodes, uniqueIdGen)
+			n.Rec.Id = uniqueIdGen.GenerateUniqueId(n.Rec.Name)
+		} else if n.Cat == "continue" {
+			n.Cont.Id = uniqueIdGen.GenerateUniqueId(n.Cont.Name)
+			n.Id = n.Cont.Id
+		}
+	}
+}
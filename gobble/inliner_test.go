@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const LoginProtocol = `global protocol Login(role C, role S) {
+	Hello(string) from C to S;
+	rec Retry {
+		choice at S {
+			Again() from S to C;
+			continue Retry;
+		} or {
+			Welcome() from S to C;
+		}
+	}
+}
+`
+
+func ParseTestFile(t *testing.T, source string) (*Protocol, error) {
+	t.Helper()
+	return ParseFile(WriteTestFile(t, "test.scr", source))
+}
+
+func TestInlineDos(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{
+			name:   "roles passed with as",
+			source: "module M;\n\n" + LoginProtocol + "\nglobal protocol Main(role Client, role Server) {\n\tdo Login(Server as S, Client as C);\n\tBye() from Client to Server;\n}\n",
+		},
+		{
+			name:   "roles passed by position",
+			source: "module M;\n\n" + LoginProtocol + "\nglobal protocol Main(role Client, role Server) {\n\tdo Login(Client, Server);\n\tBye() from Client to Server;\n}\n",
+		},
+	}
+	want := "{\n\tHello(string) to Server;\n\trec LoginRetry {\n\t\tchoice at Server {\n\t\t\tAgain() from Server;\n\t\t\tcontinue LoginRetry;\n\t\t} or {\n\t\t\tWelcome() from Server;\n\t\t}\n\t}\n\tBye() to Server;\n}"
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree, err := ParseTestFile(t, test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range tree.Locals {
+				if l.Name == "Login" {
+					t.Errorf("Login is generated separately at %s although it is only invoked with do", l.Protagonist)
+				}
+			}
+			if got := GetTestLocal(t, tree, "Client"); got != want {
+				t.Errorf("got local\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestInlineDoRenamesRecs(t *testing.T) {
+	source := "module M;\n\n" + LoginProtocol + "\nglobal protocol Main(role Client, role Server) {\n\trec LoginRetry {\n\t\tdo Login(Client, Server);\n\t\tchoice at Client {\n\t\t\tMore() from Client to Server;\n\t\t\tcontinue LoginRetry;\n\t\t} or {\n\t\t\tBye() from Client to Server;\n\t\t}\n\t}\n}\n"
+	tree, err := ParseTestFile(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := GetTestLocal(t, tree, "Server")
+	if !strings.Contains(local, "rec LoginRetry {") || !strings.Contains(local, "rec LoginRetry2 {") || strings.Count(local, "continue LoginRetry2;") != 1 {
+		t.Errorf("rec block of Login clashes with that of Main:\n%s", local)
+	}
+}
+
+func TestInlineImportedDo(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "Auth.scr"), []byte("module Auth;\n\n"+LoginProtocol), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.scr")
+	source := "module M;\n\nimport Auth;\n\nglobal protocol Main(role Client, role Server) {\n\tdo Login(Client as C, Server as S);\n\tBye() from Client to Server;\n}\n"
+	err = os.WriteFile(main, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ParseFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local := GetTestLocal(t, tree, "Server"); !strings.HasPrefix(local, "{\n\tHello(string) from Client;") {
+		t.Errorf("imported protocol not inlined:\n%s", local)
+	}
+}
+
+func TestInlineDoErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "unknown protocol",
+			source: "global protocol Main(role Client, role Server) {\n\tdo Logout(Client, Server);\n}\n",
+			want:   "do Logout does not refer to a protocol",
+		},
+		{
+			name:   "wrong number of roles",
+			source: LoginProtocol + "\nglobal protocol Main(role Client, role Server) {\n\tdo Login(Client);\n\tBye() from Client to Server;\n}\n",
+			want:   "do Login passes 1 roles to a protocol with 2 roles",
+		},
+		{
+			name:   "mixed roles",
+			source: LoginProtocol + "\nglobal protocol Main(role Client, role Server) {\n\tdo Login(Client as C, Server);\n}\n",
+			want:   "do Login mixes roles passed by position and roles passed with \"as\"",
+		},
+		{
+			name:   "recursion",
+			source: "global protocol Main(role Client, role Server) {\n\tHello() from Client to Server;\n\tdo Main(Client, Server);\n}\n",
+			want:   "do Main invokes Main recursively",
+		},
+		{
+			name:   "missing import",
+			source: "import Missing;\n\nglobal protocol Main(role Client, role Server) {\n\tHello() from Client to Server;\n}\n",
+			want:   "Cannot import Missing",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseTestFile(t, "module M;\n\n"+test.source)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
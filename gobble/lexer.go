@@ -1,83 +1,40 @@
-// Lexer divides a given Scribble file into a slice of string tokens
+// Lexer divides a given Scribble file into a slice of tokens
 
-// The lexer reads in a local Scribble protocol from file and processes
+// The lexer reads in a Scribble protocol from file and processes
 // it into an array of lexical tokens. These tokens consist of strings
 // which can be either runs of alphanumeric characters such as "protocol"
 // or punctuation characters with specific meanings in Scribble, such as
-// "{" or ";".
+// "{" or ";". Each token records the file, line and column at which it
+// was found so that later stages can report problems against the
+// source. Line comments and block comments are not tokens; they are
+// returned separately, with their positions, for the formatter and
+// discarded by the rest of Gobble.
 
-// input: validated Scribble .scr local protocol file
+// input: Scribble .scr protocol file
 
-// output: []string
+// output: []*Token and []*Comment
 
 package main
 
 import (
-	"bufio"
-	"log"
-	"os"
-	"strings"
+	"io/ioutil"
 )
 
-func ReadLines(inputFile string) []string {
-	lines := make([]string, 0)
-	file, err := os.Open(inputFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.Trim(line, "	 ")
-		lines = append(lines, line)
-	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return lines
+type Token struct {
+	Value string
+	Pos   Position
 }
 
-func RemoveLineComments(lines []string) string {
-	outputString := ""
-	for _, line := range lines {
-		for pos, char := range line {
-			if pos < len(line)-1 {
-				if char == '/' && line[pos+1] == '/' {
-					break
-				} else {
-					outputString += string(char)
-				}
-			} else {
-				outputString += string(char)
-			}
-		}
-		outputString += string(' ')
-	}
-	return outputString
-}
-
-func RemoveBlockComments(in string) string {
-	out := ""
-	inBlockComment := false
-	var prevChar rune
-	for _, char := range in {
-		if prevChar == '/' && char == '*' {
-			inBlockComment = true
-		}
-		if !inBlockComment && char != '/' {
-			out += string(char)
-		}
-		if prevChar == '*' && char == '/' {
-			inBlockComment = false
-		}
-		prevChar = char
-	}
-	return out
+// Comment holds the text of a line or block comment, including its
+// delimiters, and the positions of its first and last characters.
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
 }
 
 func isSpecialChar(r rune) bool {
-	specialChars := []rune{'<', '>', '/', '"', '.', ',', '\\', ';', '{', '}', '[', ']', '(', ')'}
+	specialChars := []rune{'<', '>', '/', '"', '.', ',', '\\', ';', ':', '{', '}', '[', ']', '(', ')'}
 	isSpecial := false
 	for _, currentRune := range specialChars {
 		if currentRune == r {
@@ -87,41 +44,116 @@ func isSpecialChar(r rune) bool {
 	return isSpecial
 }
 
-func Tokenize(words []string) []string {
-	tokens := make([]string, 0)
+func isWhiteSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
+
+func AppendToken(tokens []*Token, value string, pos Position) []*Token {
+	if value == "" {
+		return tokens
+	}
+	return append(tokens, &Token{Value: value, Pos: pos})
+}
+
+func Tokenize(source string, fileName string) ([]*Token, error) {
+	tokens, _, err := TokenizeWithComments(source, fileName)
+	return tokens, err
+}
+
+func TokenizeWithComments(source string, fileName string) ([]*Token, []*Comment, error) {
+	tokens := make([]*Token, 0)
+	comments := make([]*Comment, 0)
+	var comment *Comment
+	runes := []rune(source)
+	line := 1
+	col := 1
 	currentWord := ""
-	for _, word := range words {
-		for _, char := range word {
-			if isSpecialChar(char) {
-				tokens = append(tokens, currentWord)
-				tokens = append(tokens, string(char))
-				currentWord = ""
+	var currentWordPos Position
+	inLineComment := false
+	inBlockComment := false
+	var blockCommentPos Position
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		pos := Position{File: fileName, Line: line, Col: col}
+		var next rune
+		if i < len(runes)-1 {
+			next = runes[i+1]
+		}
+		// Advance position
+		if char == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+		// Comments
+		if inLineComment {
+			if char == '\n' || char == '\r' {
+				inLineComment = false
 			} else {
-				currentWord += string(char)
+				comment.Text += string(char)
+				comment.End = pos
 			}
+			continue
 		}
-		tokens = append(tokens, currentWord)
-		currentWord = ""
-	}
-	return tokens
-}
-
-func RemoveEmptyStrings(tokensWithEmptyStrings []string) []string {
-	tokensWithoutEmptyStrings := make([]string, 0)
-	for _, token := range tokensWithEmptyStrings {
-		if token != "" {
-			tokensWithoutEmptyStrings = append(tokensWithoutEmptyStrings, token)
+		if inBlockComment {
+			comment.Text += string(char)
+			if char == '*' && next == '/' {
+				inBlockComment = false
+				comment.Text += "/"
+				comment.End = Position{File: fileName, Line: line, Col: col}
+				i++
+				col++
+			}
+			continue
+		}
+		if char == '/' && next == '/' {
+			tokens = AppendToken(tokens, currentWord, currentWordPos)
+			currentWord = ""
+			inLineComment = true
+			comment = &Comment{Text: "/", Pos: pos, End: pos}
+			comments = append(comments, comment)
+			continue
 		}
+		if char == '/' && next == '*' {
+			tokens = AppendToken(tokens, currentWord, currentWordPos)
+			currentWord = ""
+			inBlockComment = true
+			blockCommentPos = pos
+			comment = &Comment{Text: "/*", Pos: pos}
+			comments = append(comments, comment)
+			i++
+			col++
+			continue
+		}
+		// Tokens
+		if isWhiteSpace(char) {
+			tokens = AppendToken(tokens, currentWord, currentWordPos)
+			currentWord = ""
+		} else if isSpecialChar(char) {
+			tokens = AppendToken(tokens, currentWord, currentWordPos)
+			currentWord = ""
+			tokens = AppendToken(tokens, string(char), pos)
+		} else {
+			if currentWord == "" {
+				currentWordPos = pos
+			}
+			currentWord += string(char)
+		}
+	}
+	tokens = AppendToken(tokens, currentWord, currentWordPos)
+	if inBlockComment {
+		d := NewDiagnostic(blockCommentPos, "Unterminated block comment", "close the comment with */")
+		return tokens, comments, Diagnostics{d}
 	}
-	return tokensWithoutEmptyStrings
+	return tokens, comments, nil
 }
 
-func GetTokens(inputFile string) []string {
-	lines := ReadLines(inputFile)
-	linesAsSingleString := RemoveLineComments(lines)
-	linesAsSingleString = RemoveBlockComments(linesAsSingleString)
-	tokensDividedByWhiteSpace := strings.Fields(linesAsSingleString)
-	tokens := Tokenize(tokensDividedByWhiteSpace)
-	tokens = RemoveEmptyStrings(tokens)
-	return tokens
+func GetTokens(inputFile string) ([]*Token, error) {
+	source, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		d := NewDiagnostic(Position{File: inputFile}, err.Error(), "")
+		return nil, Diagnostics{d}
+	}
+	return Tokenize(string(source), inputFile)
 }
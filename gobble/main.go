@@ -3,55 +3,264 @@
 // formal, structured descriptions of a protocol which specify for each message sent a 
 // data type, a sequential position and a direction.
 
-// input: one or more validated Scribble .scr local protocol files, passed as arguments
-// from the command line
+// input: one or more validated Scribble .scr local or global protocol files, passed
+// as arguments from the command line
 
 // output: .go files in an ``output'' directory localed within the current directory.
 
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	//"os"
+	"os"
+	"path/filepath"
 	"strconv"
 )
 
-func ProcessFiles(fileNames []string, moduleName string) {
+func GetLocalProtocols(p *Protocol) []*Protocol {
+	trees := make([]*Protocol, 0)
+	for _, l := range p.Locals {
+		tree := &Protocol{Mod: p.Mod, Globals: []*Global{}, Locals: []*Local{l}, Types: p.Types}
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+func ParseAndCheckGlobals(fileName string) (*Protocol, error) {
+	tokens, err := GetTokens(fileName)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := ProcessTokens(tokens)
+	if err != nil {
+		return tree, err
+	}
+	err = CheckGlobals(tree)
+	return tree, err
+}
+
+func ProjectAndCheck(tree *Protocol) error {
+	err := ProjectGlobals(tree)
+	if err != nil {
+		return err
+	}
+	return CheckLocals(tree)
+}
+
+// ParseFile parses, checks and projects a file, resolves its do
+// instructions against the file and the files it imports, and checks
+// the resulting local protocols once more now that they are complete.
+func ParseFile(fileName string) (*Protocol, error) {
+	tree, err := ParseAndCheckGlobals(fileName)
+	if err != nil {
+		return tree, err
+	}
+	err = ProjectAndCheck(tree)
+	if err != nil {
+		return tree, err
+	}
+	loaded := map[string]bool{filepath.Clean(fileName): true}
+	imported, err := LoadImports(tree, fileName, loaded)
+	if err != nil {
+		return tree, err
+	}
+	err = InlineDos(tree, imported)
+	if err != nil {
+		return tree, err
+	}
+	return tree, CheckLocals(tree)
+}
+
+func ParseFiles(fileNames []string) ([]*Protocol, error) {
+	trees := make([]*Protocol, 0)
+	diags := make(Diagnostics, 0)
+	for _, name := range fileNames {
+		tree, err := ParseFile(name)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		trees = append(trees, GetLocalProtocols(tree)...)
+	}
+	return trees, diags.Err()
+}
+
+func TranslateTrees(trees []*Protocol) ([]*MessagesData, error) {
+	translations := make([]*MessagesData, 0)
+	diags := make(Diagnostics, 0)
+	for _, tree := range trees {
+		translation, err := TranslateTree(tree)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		translations = append(translations, translation)
+	}
+	return translations, diags.Err()
+}
+
+func ProcessFiles(fileNames []string, moduleName string, config *NetworkConfig) error {
 	if len(fileNames) == 0 {
-		log.Fatal("Please specicfy one or more .scr files to process.")
-	} else if len(fileNames) == 1 {
-		fileName := fileNames[0]
-		tokens := GetTokens(fileName)
-		tree := ProcessTokens(tokens)
-		translation := TranslateTree(tree)
-		WriteTranslation(translation, moduleName)
+		return errors.New("Please specicfy one or more .scr files to process.")
+	}
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	if len(trees) > 1 {
+		err = CheckCompatibility(trees)
+		if err != nil {
+			return err
+		}
+	}
+	translations, err := TranslateTrees(trees)
+	if err != nil {
+		return err
+	}
+	runtimeDir, err := GetModuleRuntimeDir(moduleName)
+	if err != nil {
+		return err
+	}
+	if len(translations) == 1 {
+		err = CheckNetworkConfig(config, translations)
+		if err != nil {
+			return err
+		}
+		WriteTranslation(translations[0], moduleName, config, runtimeDir)
 	} else {
-		translations := make([]*MessagesData, 0)
-		for _, name := range fileNames {
-			tokens := GetTokens(name)
-			tree := ProcessTokens(tokens)
-			translation := TranslateTree(tree)
-			translations = append(translations, translation)
+		WriteCombinedTranslation(translations, moduleName, runtimeDir)
+	}
+	return nil
+}
+
+func ProjectFiles(fileNames []string, moduleName string) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to project.")
+	}
+	diags := make(Diagnostics, 0)
+	for _, name := range fileNames {
+		tree, err := ParseAndCheckGlobals(name)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		tree.Locals = make([]*Local, 0)
+		err = ProjectAndCheck(tree)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
 		}
-		WriteCombinedTranslation(translations, moduleName)
+		WriteProjectionsToFile(tree, moduleName)
+	}
+	return diags.Err()
+}
+
+// CheckFiles runs every check that generation would run, from parsing
+// through to translation, without writing any output. When more than one
+// local protocol is given it also runs the deadlock analysis, in which
+// the queue between two roles holds at most bound messages.
+func CheckFiles(fileNames []string, bound int) error {
+	if len(fileNames) == 0 {
+		return errors.New("Please specicfy one or more .scr files to check.")
+	}
+	trees, err := ParseFiles(fileNames)
+	if err != nil {
+		return err
+	}
+	if len(trees) > 1 {
+		err = CheckCompatibility(trees)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = TranslateTrees(trees)
+	if err != nil {
+		return err
+	}
+	if len(trees) > 1 {
+		return CheckDeadlockFreedom(trees, bound)
+	}
+	return nil
+}
+
+func RunCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	bound := flags.Int("bound", 0, "length of the queue between two roles in the deadlock analysis; 0 exchanges messages synchronously")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *bound < 0 {
+		return errors.New("The -bound given to gobble check must not be negative.")
+	}
+	return CheckFiles(flags.Args(), *bound)
+}
+
+// RunGenerateCommand generates code for the files which follow the
+// network flags in args.
+func RunGenerateCommand(args []string) error {
+	flags := flag.NewFlagSet("gobble", flag.ContinueOnError)
+	nf := AddNetworkFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config, err := GetNetworkConfig(nf)
+	if err != nil {
+		return err
 	}
+	return ProcessFiles(flags.Args(), "Protocol", config)
+}
+
+func RunHandlersCommand(args []string) error {
+	flags := flag.NewFlagSet("handlers", flag.ContinueOnError)
+	nf := AddNetworkFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	config, err := GetNetworkConfig(nf)
+	if err != nil {
+		return err
+	}
+	return HandlerFiles(flags.Args(), "Protocol", config)
+}
+
+func ExitWithDiagnostics(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
 
 func runCaseStudy() {
 	fileNames := []string{"aggregatorLocal.scr", "clientLocal.scr", "brutishAirwaysLocal.scr", "queasyJetLocal.scr"}
-	ProcessFiles(fileNames, "Aggregator")
+	err := ProcessFiles(fileNames, "Aggregator", &NetworkConfig{})
+	if err != nil {
+		ExitWithDiagnostics(err)
+	}
 	for _, fileName := range fileNames {
 		slice := []string{fileName}
-		ProcessFiles(slice, "Aggregator"+"_"+fileName[:len(fileName)-4])
+		err = ProcessFiles(slice, "Aggregator"+"_"+fileName[:len(fileName)-4], &NetworkConfig{})
+		if err != nil {
+			ExitWithDiagnostics(err)
+		}
 	}
 }
 
 func RunTest(testRoles []string, index int) {
 	testNameBase := "test" + strconv.Itoa(index+1)
-	ProcessFiles(testRoles, testNameBase)
+	err := ProcessFiles(testRoles, testNameBase, &NetworkConfig{})
+	if err != nil {
+		ExitWithDiagnostics(err)
+	}
 	for _, role := range testRoles {
 		slice := []string{role}
-		ProcessFiles(slice, role[:len(role)-4])
+		err = ProcessFiles(slice, role[:len(role)-4], &NetworkConfig{})
+		if err != nil {
+			ExitWithDiagnostics(err)
+		}
 	}
 }
 
@@ -72,7 +281,10 @@ func RunTests() {
 
 func GenerateSpeedTest() {
 	slice := []string{"speedTestServer.scr", "speedTestClient.scr"}
-	ProcessFiles(slice, "SpeedTest")
+	err := ProcessFiles(slice, "SpeedTest", &NetworkConfig{})
+	if err != nil {
+		ExitWithDiagnostics(err)
+	}
 }
 
 func main() {
@@ -81,5 +293,27 @@ func main() {
 	if len(args) == 0 {
 		log.Fatal("Enter the name of one or more .scr files after \"sumProj\"")
 	}
-	ProcessFiles(args, "Protocol")
+	var err error
+	if args[0] == "project" {
+		err = ProjectFiles(args[1:], "Protocol")
+	} else if args[0] == "check" {
+		err = RunCheckCommand(args[1:])
+	} else if args[0] == "fmt" {
+		err = RunFormatCommand(args[1:])
+	} else if args[0] == "diagram" {
+		err = DiagramFiles(args[1:], "Protocol")
+	} else if args[0] == "graph" {
+		err = GraphFiles(args[1:], "Protocol")
+	} else if args[0] == "diff" {
+		err = RunDiffCommand(args[1:])
+	} else if args[0] == "handlers" {
+		err = RunHandlersCommand(args[1:])
+	} else if args[0] == "dump" {
+		err = RunDumpCommand(args[1:])
+	} else {
+		err = RunGenerateCommand(args)
+	}
+	if err != nil {
+		ExitWithDiagnostics(err)
+	}
 }
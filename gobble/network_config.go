@@ -0,0 +1,278 @@
+// The network configuration gives the choices made on Gobble's command
+// line about how the roles of a network-enabled implementation reach one
+// another. Each role listens at its own endpoint, a "host:port" address,
+// and is dialled there by the roles which connect to it. The endpoints
+// given to Gobble are compiled into the generated programmes as defaults,
+// which the gobble/runtime package lets a configuration file, environment
+// variables or flags override when a programme is started. A topology
+// file says which role of each pair which exchange messages dials the
+// other; without one the role whose name comes first alphabetically dials.
+// The codec, with which messages are put on the wire, is also a default
+// which can be changed when a programme is started.
+
+// input: -endpoint Role=host:port flags, an -endpoints JSON file, a
+// -topology JSON file and a -codec given on the command line before the
+// .scr files
+
+// output: a NetworkConfig used by the writers
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultEndpoint is the endpoint of a role for which none is given.
+const DefaultEndpoint = "localhost:8080"
+
+// DefaultCodec is the codec used if none is given.
+const DefaultCodec = "gob"
+
+// Codecs lists the codecs of the gobble/runtime package.
+var Codecs = []string{"gob", "json", "binary"}
+
+// NetworkConfig holds the endpoint of each role given to Gobble. Named
+// lists the roles named by -endpoint flags, which unlike those in an
+// -endpoints file, which may be shared by several protocols, must be
+// roles of the protocols given.
+type NetworkConfig struct {
+	Endpoints map[string]string
+	Named     []string
+	Topology  *Topology
+	Codec     string
+}
+
+// Topology describes a deployment, such as
+//
+//	{"roles": {
+//		"Client": {"listen": "localhost:9001"},
+//		"Aggregator": {"listen": "localhost:9002", "dial": ["Client"]}
+//	}}
+//
+// in which Aggregator dials Client at localhost:9001. A role need only
+// listen if another dials it.
+type Topology struct {
+	Roles map[string]*TopologyRole `json:"roles"`
+}
+
+type TopologyRole struct {
+	Listen string   `json:"listen"`
+	Dial   []string `json:"dial"`
+}
+
+// EndpointFlags collects the values of the repeatable -endpoint flag.
+type EndpointFlags []string
+
+func (f *EndpointFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *EndpointFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+type NetworkFlags struct {
+	Endpoints     EndpointFlags
+	EndpointsFile *string
+	TopologyFile  *string
+	Codec         *string
+}
+
+// AddNetworkFlags adds the flags which configure the generated network
+// code to flags.
+func AddNetworkFlags(flags *flag.FlagSet) *NetworkFlags {
+	nf := &NetworkFlags{Endpoints: make(EndpointFlags, 0)}
+	flags.Var(&nf.Endpoints, "endpoint", "default address of a role in the generated code, as Role=host:port; may be repeated, and the role must be a role of the protocols given")
+	nf.EndpointsFile = flags.String("endpoints", "", "JSON file mapping roles to their default host:port addresses")
+	nf.Codec = flags.String("codec", DefaultCodec, "default codec with which the generated code sends messages: gob, json or binary")
+	nf.TopologyFile = flags.String("topology", "", "JSON file giving the address at which each role listens and the roles it dials")
+	return nf
+}
+
+func CheckEndpointAddress(address string, where string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return errors.New(where + ": " + err.Error())
+	}
+	return nil
+}
+
+// ReadTopology reads and checks the topology file fileName.
+func ReadTopology(fileName string) (*Topology, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	topology := &Topology{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(topology)
+	if err != nil {
+		return nil, errors.New(fileName + ": " + err.Error())
+	}
+	roles := make([]string, 0)
+	for role := range topology.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	diags := make(Diagnostics, 0)
+	for _, role := range roles {
+		spec := topology.Roles[role]
+		if spec == nil {
+			continue
+		}
+		if spec.Listen != "" {
+			diags = AppendDiagnostics(diags, CheckEndpointAddress(spec.Listen, fileName+": role "+role))
+		}
+		for _, other := range spec.Dial {
+			if other == role {
+				diags = AppendDiagnostics(diags, errors.New(fileName+": role "+role+" dials itself."))
+			} else if TopologyDials(topology, other, role) && role < other {
+				diags = AppendDiagnostics(diags, errors.New(fileName+": roles "+role+" and "+other+" dial each other; only one of them may dial."))
+			}
+		}
+	}
+	return topology, diags.Err()
+}
+
+// TopologyDials reports whether dialer dials listener in topology.
+func TopologyDials(topology *Topology, dialer string, listener string) bool {
+	spec := topology.Roles[dialer]
+	if spec == nil {
+		return false
+	}
+	for _, role := range spec.Dial {
+		if role == listener {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDialer returns whichever of the roles a and b dials the other.
+func GetDialer(config *NetworkConfig, a string, b string) string {
+	if config != nil && config.Topology != nil {
+		if TopologyDials(config.Topology, b, a) {
+			return b
+		}
+		return a
+	}
+	if b < a {
+		return b
+	}
+	return a
+}
+
+// GetNetworkConfig builds the configuration given by the flags. An
+// -endpoint flag takes precedence over the topology file, which takes
+// precedence over the -endpoints file.
+func GetNetworkConfig(nf *NetworkFlags) (*NetworkConfig, error) {
+	config := &NetworkConfig{Endpoints: make(map[string]string), Named: make([]string, 0), Codec: *nf.Codec}
+	diags := make(Diagnostics, 0)
+	if !SliceContainsString(Codecs, *nf.Codec) {
+		diags = AppendDiagnostics(diags, errors.New("-codec "+*nf.Codec+" is not one of "+strings.Join(Codecs, ", ")+"."))
+	}
+	if *nf.EndpointsFile != "" {
+		data, err := os.ReadFile(*nf.EndpointsFile)
+		if err == nil {
+			err = json.Unmarshal(data, &config.Endpoints)
+		}
+		if err != nil {
+			diags = AppendDiagnostics(diags, errors.New("-endpoints "+*nf.EndpointsFile+": "+err.Error()))
+		}
+		for role, address := range config.Endpoints {
+			diags = AppendDiagnostics(diags, CheckEndpointAddress(address, *nf.EndpointsFile+": role "+role))
+		}
+	}
+	if *nf.TopologyFile != "" {
+		topology, err := ReadTopology(*nf.TopologyFile)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+		} else {
+			config.Topology = topology
+			for role, spec := range topology.Roles {
+				if spec != nil && spec.Listen != "" {
+					config.Endpoints[role] = spec.Listen
+				}
+			}
+		}
+	}
+	for _, assignment := range nf.Endpoints {
+		eq := strings.Index(assignment, "=")
+		if eq < 1 {
+			diags = AppendDiagnostics(diags, errors.New("-endpoint "+assignment+" is not of the form Role=host:port"))
+			continue
+		}
+		err := CheckEndpointAddress(assignment[eq+1:], "-endpoint "+assignment)
+		if err != nil {
+			diags = AppendDiagnostics(diags, err)
+			continue
+		}
+		config.Endpoints[assignment[:eq]] = assignment[eq+1:]
+		config.Named = append(config.Named, assignment[:eq])
+	}
+	return config, diags.Err()
+}
+
+// GetEndpoint returns the endpoint of role given by config, or
+// DefaultEndpoint if none is given.
+func GetEndpoint(config *NetworkConfig, role string) string {
+	if config != nil {
+		if address, ok := config.Endpoints[role]; ok {
+			return address
+		}
+	}
+	return DefaultEndpoint
+}
+
+// GetCodecName returns the name of the codec given by config, or
+// DefaultCodec if none is given.
+func GetCodecName(config *NetworkConfig) string {
+	if config != nil && config.Codec != "" {
+		return config.Codec
+	}
+	return DefaultCodec
+}
+
+// GetNetworkRoles returns, in alphabetical order, the protagonist of m and
+// the roles it exchanges messages with.
+func GetNetworkRoles(m *MessagesData) []string {
+	roles := append([]string{GetProtagonist(m)}, GetDialoguePartners(m)...)
+	sort.Strings(roles)
+	return roles
+}
+
+// CheckNetworkConfig reports each role named by an -endpoint flag which is
+// not a role of any of the local protocols, which is most likely misspelt,
+// and each pair of roles which exchange messages but neither of which
+// dials the other in the topology.
+func CheckNetworkConfig(config *NetworkConfig, mds []*MessagesData) error {
+	known := make(map[string]bool)
+	diags := make(Diagnostics, 0)
+	for _, m := range mds {
+		for _, role := range GetNetworkRoles(m) {
+			known[role] = true
+		}
+		if config.Topology == nil {
+			continue
+		}
+		for _, partner := range GetDialoguePartners(m) {
+			if !TopologyDials(config.Topology, GetProtagonist(m), partner) && !TopologyDials(config.Topology, partner, GetProtagonist(m)) {
+				diags = AppendDiagnostics(diags, errors.New("The topology does not say whether "+GetProtagonist(m)+" dials "+partner+" or "+partner+" dials "+GetProtagonist(m)+"."))
+			}
+		}
+	}
+	for _, role := range config.Named {
+		if !known[role] {
+			diags = AppendDiagnostics(diags, errors.New("-endpoint names role "+role+", which is not a role of any of the protocols given."))
+		}
+	}
+	return diags.Err()
+}
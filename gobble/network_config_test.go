@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func GetTestNetworkConfig(t *testing.T, args ...string) (*NetworkConfig, error) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	nf := AddNetworkFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return GetNetworkConfig(nf)
+}
+
+func TestGetNetworkConfig(t *testing.T) {
+	endpoints := WriteTestFile(t, "endpoints.json", `{"Client": "localhost:9001", "Server": "10.0.0.2:9000"}`)
+	config, err := GetTestNetworkConfig(t, "-endpoints", endpoints, "-endpoint", "Server=:9002", "-codec", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := GetEndpoint(config, "Client"); got != "localhost:9001" {
+		t.Errorf("got Client endpoint %q, want localhost:9001 from the file", got)
+	}
+	if got := GetEndpoint(config, "Server"); got != ":9002" {
+		t.Errorf("got Server endpoint %q, want :9002 from the flag", got)
+	}
+	if got := GetEndpoint(config, "Other"); got != DefaultEndpoint {
+		t.Errorf("got Other endpoint %q, want %s", got, DefaultEndpoint)
+	}
+	if got := GetCodecName(config); got != "json" {
+		t.Errorf("got codec %q, want json", got)
+	}
+}
+
+func TestGetNetworkConfigReportsEveryError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	malformed := WriteTestFile(t, "endpoints.json", `{"Client": `)
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "missing file",
+			args: []string{"-endpoints", missing, "-endpoint", "Server", "-codec", "xml"},
+			want: []string{"-endpoints " + missing, "-endpoint Server is not of the form Role=host:port", "-codec xml is not one of"},
+		},
+		{
+			name: "malformed file",
+			args: []string{"-endpoints", malformed, "-endpoint", "Server=nohost"},
+			want: []string{"-endpoints " + malformed + ": unexpected end of JSON input", "-endpoint Server=nohost"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GetTestNetworkConfig(t, test.args...)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+const TopologyTestSource = `{"roles": {
+	"Client": {"listen": "localhost:9001"},
+	"Aggregator": {"listen": "localhost:9002", "dial": ["Client", "Airline"]},
+	"Airline": {"listen": "localhost:9003"}
+}}`
+
+func TestReadTopology(t *testing.T) {
+	topology, err := ReadTopology(WriteTestFile(t, "topology.json", TopologyTestSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &NetworkConfig{Topology: topology}
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{a: "Aggregator", b: "Client", want: "Aggregator"},
+		{a: "Client", b: "Aggregator", want: "Aggregator"},
+		{a: "Airline", b: "Aggregator", want: "Aggregator"},
+	}
+	for _, test := range tests {
+		if got := GetDialer(config, test.a, test.b); got != test.want {
+			t.Errorf("GetDialer(%s, %s) = %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+	// Without a topology the role whose name comes first dials
+	if got := GetDialer(&NetworkConfig{}, "Client", "Aggregator"); got != "Aggregator" {
+		t.Errorf("got %s dialling without a topology, want Aggregator", got)
+	}
+	if got := GetDialer(nil, "Client", "Server"); got != "Client" {
+		t.Errorf("got %s dialling without a configuration, want Client", got)
+	}
+}
+
+func TestReadTopologyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		topology string
+		want     []string
+	}{
+		{
+			name:     "unknown field",
+			topology: `{"roles": {"A": {"listen": "localhost:9001", "dials": ["B"]}}}`,
+			want:     []string{`unknown field "dials"`},
+		},
+		{
+			name:     "malformed",
+			topology: `{"roles": `,
+			want:     []string{"unexpected EOF"},
+		},
+		{
+			name:     "every problem",
+			topology: `{"roles": {"A": {"listen": "nohost", "dial": ["A", "B"]}, "B": {"dial": ["A"]}}}`,
+			want:     []string{"role A: address nohost: missing port in address", "role A dials itself.", "roles A and B dial each other; only one of them may dial."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := WriteTestFile(t, "topology.json", test.topology)
+			_, err := ReadTopology(name)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), name+": ") || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNetworkConfigTopology(t *testing.T) {
+	endpoints := WriteTestFile(t, "endpoints.json", `{"Client": "10.0.0.1:9000", "Airline": "10.0.0.3:9000"}`)
+	topology := WriteTestFile(t, "topology.json", TopologyTestSource)
+	config, err := GetTestNetworkConfig(t, "-endpoints", endpoints, "-topology", topology, "-endpoint", "Airline=:9004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for role, want := range map[string]string{"Client": "localhost:9001", "Aggregator": "localhost:9002", "Airline": ":9004"} {
+		if got := GetEndpoint(config, role); got != want {
+			t.Errorf("got %s endpoint %q, want %q", role, got, want)
+		}
+	}
+}
+
+func TestCheckNetworkConfig(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "m() to B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := GetTestNetworkConfig(t, "-endpoint", "A=:9001", "-endpoint", "C=:9003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckNetworkConfig(config, []*MessagesData{m})
+	if err == nil || !strings.Contains(err.Error(), "-endpoint names role C, which is not a role of any of the protocols given.") {
+		t.Errorf("got error %v for an unknown role", err)
+	}
+	topology, err := ReadTopology(WriteTestFile(t, "topology.json", `{"roles": {"A": {"listen": ":9001"}, "B": {"listen": ":9002"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckNetworkConfig(&NetworkConfig{Topology: topology}, []*MessagesData{m})
+	if err == nil || !strings.Contains(err.Error(), "The topology does not say whether A dials B or B dials A.") {
+		t.Errorf("got error %v for a pair neither of which dials", err)
+	}
+	topology.Roles["B"].Dial = []string{"A"}
+	err = CheckNetworkConfig(&NetworkConfig{Topology: topology}, []*MessagesData{m})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetDialogueUsesTopology(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "m() to B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology := &Topology{Roles: map[string]*TopologyRole{"B": {Dial: []string{"A"}}}}
+	for _, test := range []struct {
+		config *NetworkConfig
+		client string
+	}{
+		{config: &NetworkConfig{}, client: "A"},
+		{config: &NetworkConfig{Topology: topology}, client: "B"},
+	} {
+		dialogues := GetDialogues(m, test.config)
+		if len(dialogues) != 1 || dialogues[0].Client != test.client {
+			t.Errorf("got %+v, want %s to dial", dialogues[0], test.client)
+		}
+	}
+}
@@ -8,17 +8,22 @@
 // conversation's participants) and rec blocks (for which looping
 // behaviour is prescribed).
 
-// The parser reads through this tree recursively, launching a new
-// goroutine to read through each sub-conversation as it goes and saving
-// the information in a tree data structure composed of conversations
-// composed of nodes, where each node represents a message, a
-// par block, a choice block or a rec block. Each element of this tree
+// The parser first divides the file into its top-level declarations and
+// parses each type declaration and local or global protocol in its own
+// goroutine. The results are gathered in the order in which the
+// declarations appear in the source, so that the same file always
+// produces the same tree. Each protocol is read through recursively,
+// saving the information in a tree data structure composed of
+// conversations composed of nodes, where each node represents a message,
+// a par block, a choice block or a rec block. Each element of this tree
 // is represented by a struct, which consists of collection of fields
 // which may include other structs. During this part stage each element
 // of the tree is given a unique identifier for use in subsequent
-// computation.
+// computation, numbered in source order within its protocol, and records
+// the position in the source file at which it begins. Malformed input is
+// reported as Diagnostics rather than causing the parser to panic.
 
-// input: []string
+// input: []*Token
 
 // output: tree of structs descending from a Protocol root node
 
@@ -26,27 +31,47 @@ package main
 
 import (
 	"strconv"
+	"sync"
 )
 
 type Module struct {
 	Name string
+	Pos  Position
 }
 
 type Do struct {
-	Id    string
-	Name  string
-	Roles []string
+	Id     string
+	Name   string
+	Roles  []string
+	Params []string
+	Pos    Position
+}
+
+type Import struct {
+	Name string
+	Pos  Position
 }
 
 type Continue struct {
 	Id   string
 	Name string
+	Pos  Position
 }
 
 type Protocol struct {
-	Mod    *Module
-	Locals []*Local
-	Types  []*Type
+	Mod     *Module
+	Imports []*Import
+	Globals []*Global
+	Locals  []*Local
+	Types   []*Type
+}
+
+type Global struct {
+	Id    string
+	Name  string
+	Roles []*Role
+	Conv  *Conversation
+	Pos   Position
 }
 
 type Local struct {
@@ -55,6 +80,7 @@ type Local struct {
 	Roles       []*Role
 	Conv        *Conversation
 	Protagonist string
+	Pos         Position
 }
 
 type Conversation struct {
@@ -62,6 +88,7 @@ type Conversation struct {
 	Name        string
 	Nodes       []*Node
 	Protagonist string
+	Pos         Position
 }
 
 type Choice struct {
@@ -69,6 +96,7 @@ type Choice struct {
 	Chooser     string
 	Protagonist string
 	Convs       []*Conversation
+	Pos         Position
 }
 
 type Node struct {
@@ -91,12 +119,14 @@ type Rec struct {
 	Name        string
 	Conv        *Conversation
 	Protagonist string
+	Pos         Position
 }
 
 type Parallel struct {
 	Id          string
 	Protagonist string
 	Convs       []*Conversation
+	Pos         Position
 }
 
 type Message struct {
@@ -107,12 +137,15 @@ type Message struct {
 	To          string
 	RoleTo      string
 	Types       []string
+	Names       []string
 	Rec         string
 	Protagonist string
+	Pos         Position
 }
 
 type Role struct {
 	Name string
+	Pos  Position
 }
 
 type Type struct {
@@ -121,111 +154,213 @@ type Type struct {
 	Source   string
 	FileName string
 	Alias    string
+	Pos      Position
+}
+
+func TokenValues(tokens []*Token) []string {
+	values := make([]string, 0)
+	for _, token := range tokens {
+		values = append(values, token.Value)
+	}
+	return values
+}
+
+func IsIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !(isDigit && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Section holds the tokens of a top-level type declaration or protocol,
+// and the results of parsing them.
+type Section struct {
+	Kind   string
+	Tokens []*Token
+	Type   *Type
+	Local  *Local
+	Global *Global
+	Diags  *DiagnosticCollector
 }
 
-func ProcessTokens(tokens []string) *Protocol {
-	typeCounter := 0
-	typeChan := make(chan *Type)
-	globCounter := 0
-	globChan := make(chan *Local)
+func ProcessTokens(tokens []*Token) (*Protocol, error) {
+	diags := &DiagnosticCollector{}
+	sections := make([]*Section, 0)
 	p := &Protocol{}
+	p.Imports = make([]*Import, 0)
+	p.Globals = make([]*Global, 0)
 	p.Locals = make([]*Local, 0)
 	p.Types = make([]*Type, 0)
 	writing := false
+	skipping := false
 	inModule := false
+	inImport := false
 	inType := false
 	inLocal := false
-	currentSection := make([]string, 0)
+	inGlobal := false
+	currentSection := make([]*Token, 0)
 	openBracketCounter := 0
 	closeBracketCounter := 0
 	for _, token := range tokens {
 		if !writing {
-			if token == "module" {
+			if token.Value == "module" {
 				writing = true
 				inModule = true
-			} else if token == "type" {
+			} else if token.Value == "import" {
+				writing = true
+				inImport = true
+			} else if token.Value == "type" {
 				writing = true
 				inType = true
-			} else if token == "local" {
+			} else if token.Value == "local" {
 				writing = true
 				inLocal = true
 				openBracketCounter = 0
 				closeBracketCounter = 0
+			} else if token.Value == "global" {
+				writing = true
+				inGlobal = true
+				openBracketCounter = 0
+				closeBracketCounter = 0
 			} else {
-				panic("Unexpected token: " + token)
+				// Report only the first of a run of unexpected tokens
+				if !skipping {
+					diags.Add(token.Pos, "Unexpected token \""+token.Value+"\"", "expected a module, import, type, local protocol or global protocol declaration")
+				}
+				skipping = true
 			}
-		} else {
+			if writing {
+				skipping = false
+			}
+		}
+		if writing {
+			currentSection = append(currentSection, token)
 			if inModule {
-				currentSection = append(currentSection, token)
-				if token == ";" {
+				if token.Value == ";" {
 					writing = false
 					inModule = false
-					p.Mod = ProcessModule(currentSection)
-					currentSection = make([]string, 0)
+					p.Mod = ProcessModule(currentSection, diags)
+					currentSection = make([]*Token, 0)
+				}
+			} else if inImport {
+				if token.Value == ";" {
+					writing = false
+					inImport = false
+					p.Imports = append(p.Imports, ProcessImport(currentSection, diags))
+					currentSection = make([]*Token, 0)
 				}
 			} else if inType {
-				currentSection = append(currentSection, token)
-				if token == ";" {
+				if token.Value == ";" {
 					writing = false
 					inType = false
-					typeCounter++
-					go ProcessConcurrentType(currentSection, typeChan)
-					currentSection = make([]string, 0)
+					sections = append(sections, &Section{Kind: "type", Tokens: currentSection})
+					currentSection = make([]*Token, 0)
 				}
-			} else if inLocal {
-				currentSection = append(currentSection, token)
-				if token == "{" {
+			} else if inLocal || inGlobal {
+				if token.Value == "{" {
 					openBracketCounter++
-				} else if token == "}" {
+				} else if token.Value == "}" {
 					closeBracketCounter++
 					if openBracketCounter == closeBracketCounter {
+						if inLocal {
+							sections = append(sections, &Section{Kind: "local", Tokens: currentSection})
+						} else {
+							sections = append(sections, &Section{Kind: "global", Tokens: currentSection})
+						}
 						writing = false
 						inLocal = false
-						globCounter++
-						go ProcessConcurrentLocal(currentSection, globChan)
-						currentSection = make([]string, 0)
+						inGlobal = false
+						currentSection = make([]*Token, 0)
 					}
 				}
-			} else {
-				panic("Should be 'in' a module, type or Local, but not. Current token: " + token)
 			}
 		}
 	}
-	for i := 0; i < typeCounter; i++ {
-		t := <-typeChan
-		p.Types = append(p.Types, t)
+	if writing {
+		start := currentSection[0]
+		if inModule || inImport || inType {
+			diags.Add(start.Pos, "Unterminated "+start.Value+" declaration", "expected \";\" at the end of the declaration")
+		} else {
+			diags.Add(start.Pos, "Unterminated "+start.Value+" protocol", "expected \"}\" to close the protocol body; "+strconv.Itoa(openBracketCounter-closeBracketCounter)+" \"{\" left unclosed")
+		}
 	}
-	for i := 0; i < globCounter; i++ {
-		g := <-globChan
-		p.Locals = append(p.Locals, g)
+	ProcessSections(sections)
+	for _, section := range sections {
+		diags.AddDiagnostics(section.Diags.Diagnostics)
+		if section.Kind == "type" {
+			p.Types = append(p.Types, section.Type)
+		} else if section.Kind == "local" {
+			p.Locals = append(p.Locals, section.Local)
+		} else {
+			p.Globals = append(p.Globals, section.Global)
+		}
 	}
-	return p
+	return p, diags.Err()
 }
 
-func ProcessConcurrentLocal(tokens []string, ch chan *Local) {
-	g := ProcessLocal(tokens)
-	ch <- g
+// ProcessSections parses each section in its own goroutine. Every
+// section records its own results and diagnostics, so that they can be
+// gathered in source order however the goroutines are scheduled.
+func ProcessSections(sections []*Section) {
+	var wg sync.WaitGroup
+	for _, section := range sections {
+		section.Diags = &DiagnosticCollector{}
+		wg.Add(1)
+		go ProcessSection(section, &wg)
+	}
+	wg.Wait()
 }
 
-func ProcessConcurrentType(tokens []string, ch chan *Type) {
-	t := ProcessType(tokens)
-	ch <- t
+func ProcessSection(section *Section, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if section.Kind == "type" {
+		section.Type = ProcessType(section.Tokens, section.Diags)
+	} else if section.Kind == "local" {
+		section.Local = ProcessLocal(section.Tokens, section.Diags)
+	} else {
+		section.Global = ProcessGlobal(section.Tokens, section.Diags)
+	}
 }
 
-func ProcessModule(tokens []string) *Module {
+func ProcessModule(tokens []*Token, diags *DiagnosticCollector) *Module {
 	name := ""
-	for i := 0; i < len(tokens); i++ {
-		if tokens[i] == ";" {
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].Value == ";" {
 			break
 		} else {
-			name += tokens[i]
+			name += tokens[i].Value
 		}
 	}
-	m := &Module{Name: name}
+	if name == "" {
+		diags.Add(tokens[0].Pos, "Module declaration has no name", "expected \"module <Name>;\"")
+	}
+	m := &Module{Name: name, Pos: tokens[0].Pos}
 	return m
 }
 
-func ProcessType(tokens []string) *Type {
+// ProcessImport reads ``import a.b.Name;'', which makes the protocols
+// declared in the file a/b/Name.scr available to do instructions.
+func ProcessImport(tokens []*Token, diags *DiagnosticCollector) *Import {
+	name := ""
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].Value != ";" {
+			name += tokens[i].Value
+		}
+	}
+	if name == "" || name[0] == '.' || name[len(name)-1] == '.' {
+		diags.Add(tokens[0].Pos, "Malformed import declaration", "expected \"import <Name>;\" or \"import <a.b.Name>;\"")
+	}
+	return &Import{Name: name, Pos: tokens[0].Pos}
+}
+
+func ProcessType(tokens []*Token, diags *DiagnosticCollector) *Type {
 	schema := ""
 	source := ""
 	fileName := ""
@@ -239,8 +374,8 @@ func ProcessType(tokens []string) *Type {
 	prevToken := ""
 	prevPrevToken := ""
 	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
-		prevToken = tokens[i-1]
+		token = tokens[i].Value
+		prevToken = tokens[i-1].Value
 		// Setting where to write
 		// Schema
 		if prevToken == "<" && !writtenSchema {
@@ -284,28 +419,89 @@ func ProcessType(tokens []string) *Type {
 		// Setting second before last token
 		prevPrevToken = prevToken
 	}
-	t := &Type{Schema: schema, Source: source, FileName: fileName, Alias: alias}
+	if schema == "" || source == "" || alias == "" {
+		diags.Add(tokens[0].Pos, "Malformed type declaration", "expected type <schema> \"Source\" from \"File\" as Alias;")
+	}
+	t := &Type{Schema: schema, Source: source, FileName: fileName, Alias: alias, Pos: tokens[0].Pos}
 	return t
 }
 
-func ProcessRoles(tokens []string) []*Role {
+func ProcessRoles(tokens []*Token, diags *DiagnosticCollector) []*Role {
 	r := make([]*Role, 0)
-	token := ""
-	prevToken := ""
-	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
-		prevToken = tokens[i-1]
-		if prevToken == "role" {
-			newRole := &Role{Name: token}
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Value == "(" || token.Value == ")" || token.Value == "," {
+			continue
+		}
+		if token.Value == "role" && i < len(tokens)-1 && IsIdentifier(tokens[i+1].Value) {
+			newRole := &Role{Name: tokens[i+1].Value, Pos: tokens[i+1].Pos}
 			r = append(r, newRole)
+			i++
+		} else {
+			diags.Add(token.Pos, "Unexpected token \""+token.Value+"\" in role list", "expected \"role <Name>\"")
 		}
 	}
 	return r
 }
 
-func ProcessConversation(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Conversation {
+// ProcessProtocolHeader reads the header of a local or global protocol,
+// accepting both ``protocol Name at Role(...)'' and ``protocol at Role
+// Name(...)'' for locals, and returns the index of the opening bracket of
+// the protocol body.
+func ProcessProtocolHeader(tokens []*Token, isLocal bool, diags *DiagnosticCollector) (string, string, []*Role, int) {
+	name := ""
+	protagonist := ""
+	roles := make([]*Role, 0)
+	start := tokens[0]
+	if len(tokens) < 2 || tokens[1].Value != "protocol" {
+		diags.Add(start.Pos, "Expected \"protocol\" after \""+start.Value+"\"", "")
+		return name, protagonist, roles, -1
+	}
+	i := 2
+	for ; i < len(tokens) && tokens[i].Value != "(" && tokens[i].Value != "{"; i++ {
+		if tokens[i].Value == "at" && i < len(tokens)-1 {
+			protagonist = tokens[i+1].Value
+			i++
+		} else {
+			name += tokens[i].Value
+		}
+	}
+	if name == "" {
+		diags.Add(start.Pos, "Protocol has no name", "expected \""+start.Value+" protocol <Name>(...)\"")
+	}
+	if isLocal && protagonist == "" {
+		diags.Add(start.Pos, "Local protocol "+name+" does not name the role it is local to", "expected \"local protocol "+name+" at <Role>(...)\"")
+	}
+	if !isLocal && protagonist != "" {
+		diags.Add(start.Pos, "Global protocol "+name+" cannot be located at a role", "remove \"at "+protagonist+"\" or declare the protocol as local")
+	}
+	if i == len(tokens) || tokens[i].Value != "(" {
+		diags.Add(start.Pos, "Protocol "+name+" has no role list", "expected \"("+"role A, role B, ...)\" after the protocol name")
+	} else {
+		rolesStart := i
+		for ; i < len(tokens) && tokens[i].Value != ")"; i++ {
+		}
+		if i == len(tokens) {
+			diags.Add(tokens[rolesStart].Pos, "Unterminated role list", "expected \")\"")
+			return name, protagonist, roles, -1
+		}
+		roles = ProcessRoles(tokens[rolesStart:i+1], diags)
+		i++
+	}
+	if i == len(tokens) || tokens[i].Value != "{" {
+		pos := start.Pos
+		if i < len(tokens) {
+			pos = tokens[i].Pos
+		}
+		diags.Add(pos, "Expected \"{\" to open the body of protocol "+name, "")
+		return name, protagonist, roles, -1
+	}
+	return name, protagonist, roles, i
+}
+
+func ProcessConversation(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Conversation {
 	Nodes := make([]*Node, 0)
-	tokenSubsequence := make([]string, 0)
+	tokenSubsequence := make([]*Token, 0)
 	openingBracketCounter := 0
 	closingBracketCounter := 0
 	writingRec := false
@@ -316,7 +512,7 @@ func ProcessConversation(tokens []string, uniqueIdGen *LocalIdGenerator, protago
 	writingMessage := false
 	token := ""
 	for i := 1; i < len(tokens)-1; i++ { // Ignore opening and closing brackets
-		token = tokens[i]
+		token = tokens[i].Value
 		// Incrementing bracket counters
 		if token == "{" {
 			openingBracketCounter++
@@ -338,81 +534,168 @@ func ProcessConversation(tokens []string, uniqueIdGen *LocalIdGenerator, protago
 				writingDo = true
 			} else if token == "continue" {
 				writingContinue = true
+			} else if token == "{" || token == "}" || token == "or" || token == "and" {
+				diags.Add(tokens[i].Pos, "Unexpected token \""+token+"\"", "expected a message, choice, par, rec, do or continue")
+				continue
 			} else {
 				writingMessage = true
 			}
 		}
+		// Messages, do and continue instructions may not contain blocks
+		if (writingMessage || writingDo || writingContinue) && (token == "{" || token == "}") {
+			diags.Add(tokenSubsequence[0].Pos, "Expected \";\" at the end of \""+tokenSubsequence[0].Value+"\"", "")
+			tokenSubsequence = make([]*Token, 0)
+			writingMessage = false
+			writingDo = false
+			writingContinue = false
+			continue
+		}
 		// Rec
 		if writingRec && openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter {
-			tokenSubsequence = append(tokenSubsequence, token)
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
 			writingRec = false
-			n := ProcessRec(tokenSubsequence, uniqueIdGen, protagonist)
+			n := ProcessRec(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingRec = false
 		}
 		// Choice
-		if writingChoice && openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter && token != "or" && tokens[i+1] != "or" {
-			tokenSubsequence = append(tokenSubsequence, token)
-			n := ProcessChoice(tokenSubsequence, uniqueIdGen, protagonist)
+		if writingChoice && openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter && token != "or" && tokens[i+1].Value != "or" {
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
+			n := ProcessChoice(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingChoice = false
 		}
 		// Par
-		if writingPar && openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter && token != "and" && tokens[i+1] != "and" {
-			tokenSubsequence = append(tokenSubsequence, token)
-			n := ProcessPar(tokenSubsequence, uniqueIdGen, protagonist)
+		if writingPar && openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter && token != "and" && tokens[i+1].Value != "and" {
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
+			n := ProcessPar(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingPar = false
 		}
 		// Message
 		if writingMessage && token == ";" {
-			tokenSubsequence = append(tokenSubsequence, token)
-			n := ProcessMessage(tokenSubsequence, uniqueIdGen, protagonist)
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
+			n := ProcessMessage(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingMessage = false
 		}
 		// Do
 		if writingDo && token == ";" {
-			tokenSubsequence = append(tokenSubsequence, token)
-			n := ProcessDo(tokenSubsequence, uniqueIdGen, protagonist)
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
+			n := ProcessDo(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingDo = false
 		}
 		// Continue
 		if writingContinue && token == ";" {
-			tokenSubsequence = append(tokenSubsequence, token)
-			n := ProcessContinue(tokenSubsequence, uniqueIdGen, protagonist)
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
+			n := ProcessContinue(tokenSubsequence, uniqueIdGen, protagonist, diags)
 			Nodes = append(Nodes, n)
-			tokenSubsequence = make([]string, 0)
+			tokenSubsequence = make([]*Token, 0)
 			writingContinue = false
 		}
 		// Writing
 		if writingChoice || writingRec || writingMessage || writingDo || writingContinue || writingPar {
-			tokenSubsequence = append(tokenSubsequence, token)
+			tokenSubsequence = append(tokenSubsequence, tokens[i])
 		}
 	}
-	c := &Conversation{Protagonist: protagonist}
+	if len(tokenSubsequence) > 0 {
+		first := tokenSubsequence[0]
+		if writingMessage || writingDo || writingContinue {
+			diags.Add(first.Pos, "Expected \";\" at the end of \""+first.Value+"\"", "")
+		} else {
+			diags.Add(first.Pos, "Incomplete "+first.Value+" block", "check that every \"{\" is matched by a \"}\"")
+		}
+	}
+	c := &Conversation{Protagonist: protagonist, Pos: tokens[0].Pos}
 	c.Nodes = Nodes
 	return c
 }
 
-func ProcessMessage(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+// JoinTypeTokens rebuilds a Go type expression such as map[string]int
+// or []time.Time from the tokens into which the lexer has split it.
+func JoinTypeTokens(tokens []string) string {
+	typ := ""
+	for i, token := range tokens {
+		if i > 0 && !isSpecialChar([]rune(token)[0]) && !isSpecialChar([]rune(tokens[i-1])[0]) {
+			typ += " "
+		}
+		typ += token
+	}
+	return typ
+}
+
+// SplitParameterTokens separates the tokens of a message parameter
+// written as ``name: Type'' into its name and type. A parameter written
+// as a bare type has an empty name. The boolean result is false if the
+// parameter is malformed, as in ``name Type'' or ``: Type''.
+func SplitParameterTokens(tokens []string) (string, string, bool) {
+	for i, token := range tokens {
+		if token == ":" {
+			name := JoinTypeTokens(tokens[:i])
+			typ := JoinTypeTokens(tokens[i+1:])
+			return name, typ, IsIdentifier(name) && typ != ""
+		}
+	}
+	typ := JoinTypeTokens(tokens)
+	if len(tokens) > 1 && IsIdentifier(tokens[0]) && IsIdentifier(tokens[1]) {
+		switch tokens[0] {
+		case "chan", "func", "interface", "map", "struct":
+		default:
+			return "", typ, false
+		}
+	}
+	return "", typ, true
+}
+
+// CheckMessageRoles reports the first of the tokens following the
+// parameters of message name, up to its ";", which is not part of
+// ``from Role'' or ``to Role'', each of which may be given once.
+func CheckMessageRoles(tokens []*Token, name string, diags *DiagnosticCollector) bool {
+	if len(tokens) > 0 && tokens[len(tokens)-1].Value == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(tokens); i += 2 {
+		keyword := tokens[i]
+		if (keyword.Value != "from" && keyword.Value != "to") || seen[keyword.Value] {
+			diags.Add(keyword.Pos, "Unexpected \""+keyword.Value+"\" after the parameters of message "+name, "expected \"from Role\" or \"to Role\" before \";\"")
+			return false
+		}
+		seen[keyword.Value] = true
+		if i+1 == len(tokens) || !IsIdentifier(tokens[i+1].Value) || tokens[i+1].Value == "from" || tokens[i+1].Value == "to" {
+			diags.Add(keyword.Pos, "Expected a role after \""+keyword.Value+"\" in message "+name, "")
+			return false
+		}
+	}
+	return true
+}
+
+func ProcessMessage(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	name := ""
 	types := make([]string, 0)
+	names := make([]string, 0)
+	typeTokens := make([]string, 0)
+	emptyType := false
+	badName := ""
+	depth := 0
 	from := ""
 	to := ""
 	rec := ""
 	writingName := true
 	writingTypes := false
+	openedTypes := false
+	closedTypes := false
+	rolesStart := len(tokens)
 	token := ""
 	prevToken := ""
 	for i := 0; i < len(tokens); i++ {
-		token = tokens[i]
+		token = tokens[i].Value
 		// Name
 		if writingName && token == "(" {
 			writingName = false
@@ -420,43 +703,100 @@ func ProcessMessage(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist
 		if writingName {
 			name += token
 		}
-		// Types
-		if token == ")" {
-			writingTypes = false
-		}
-		if writingTypes && token != "," {
-			types = append(types, token)
-		}
-		if token == "(" {
+		// Types, which are split only at commas outside of brackets
+		if writingTypes {
+			if (token == ")" && depth == 0) || (token == "," && depth == 0) {
+				if len(typeTokens) > 0 {
+					paramName, typ, ok := SplitParameterTokens(typeTokens)
+					if !ok {
+						badName = JoinTypeTokens(typeTokens)
+					}
+					names = append(names, paramName)
+					types = append(types, typ)
+				} else if token == "," || len(types) > 0 {
+					emptyType = true
+				}
+				typeTokens = make([]string, 0)
+				if token == ")" {
+					writingTypes = false
+					closedTypes = true
+					rolesStart = i + 1
+				}
+			} else {
+				if token == "(" || token == "[" || token == "{" {
+					depth++
+				} else if token == ")" || token == "]" || token == "}" {
+					depth--
+				}
+				typeTokens = append(typeTokens, token)
+			}
+		} else if token == "(" && !openedTypes {
 			writingTypes = true
+			openedTypes = true
 		}
 		// From
-		if prevToken == "from" {
-			to = protagonist
+		if prevToken == "from" && !writingTypes {
 			from = token
 		}
 		// To
-		if prevToken == "to" {
-			from = protagonist
+		if prevToken == "to" && !writingTypes {
 			to = token
 		}
 		prevToken = token
 	}
+	pos := tokens[0].Pos
+	if !openedTypes || !closedTypes {
+		diags.Add(pos, "Malformed message \""+name+"\"", "expected \"Label(Type, ...) from Role;\" or \"Label(Type, ...) to Role;\"")
+	} else if !IsIdentifier(name) {
+		diags.Add(pos, "Invalid message label \""+name+"\"", "message labels must be identifiers")
+	} else if emptyType {
+		diags.Add(pos, "Message "+name+" has an empty parameter", "separate the types of the parameters with single commas")
+	} else if badName != "" {
+		diags.Add(pos, "Message "+name+" has a malformed parameter \""+badName+"\"", "expected \"Type\" or \"name: Type\"")
+	}
+	if !CheckMessageRoles(tokens[rolesStart:], name, diags) {
+		// Already reported
+	} else if protagonist == "" {
+		if from == "" || to == "" {
+			diags.Add(pos, "Message "+name+" in a global protocol must name both its sender and its receiver", "expected \""+name+"(...) from A to B;\"")
+		}
+	} else if from == "" && to == "" {
+		diags.Add(pos, "Message "+name+" names neither a sender nor a receiver", "expected \""+name+"(...) from Role;\" or \""+name+"(...) to Role;\"")
+	} else if from != "" && to != "" {
+		diags.Add(pos, "Message "+name+" in a local protocol names both a sender and a receiver", "the role the protocol is local to is implicit; keep only one of \"from "+from+"\" and \"to "+to+"\"")
+	}
+	// A local message names only the other party, the protagonist being
+	// the implicit sender or receiver; a global message names both.
+	if from == "" {
+		from = protagonist
+	}
+	if to == "" {
+		to = protagonist
+	}
 	id := uniqueIdGen.GenerateUniqueId(name)
-	m := &Message{Id: id, Name: name, Types: types, From: from, RoleFrom: from, To: to, RoleTo: to, Rec: rec, Protagonist: protagonist}
+	m := &Message{Id: id, Name: name, Types: types, Names: names, From: from, RoleFrom: from, To: to, RoleTo: to, Rec: rec, Protagonist: protagonist, Pos: pos}
 	n := &Node{Cat: "message", Mess: m}
 	return n
 }
 
-func ProcessDo(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+// ProcessDo reads ``do Sub(A, B);'', in which the roles of the caller
+// are passed to Sub in the order in which Sub declares its roles, or
+// ``do Sub(A as X, B as Y);'', in which caller role A plays role X of Sub
+// and B plays Y. Params holds the names of the roles of Sub, or empty
+// strings where the roles are passed by position.
+func ProcessDo(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	roles := make([]string, 0)
+	params := make([]string, 0)
 	writingName := false
 	writtenName := false
 	writingRoles := false
+	closedRoles := false
+	wellFormed := true
 	name := ""
 	token := ""
+	arg := make([]string, 0)
 	for i := 0; i < len(tokens); i++ {
-		token = tokens[i]
+		token = tokens[i].Value
 		// Name
 		if writingName && token == "(" {
 			writingName = false
@@ -469,51 +809,73 @@ func ProcessDo(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist strin
 			writingName = true
 		}
 		// Roles
+		if writingRoles && (token == "," || token == ")") {
+			if len(arg) == 1 && IsIdentifier(arg[0]) {
+				roles = append(roles, arg[0])
+				params = append(params, "")
+			} else if len(arg) == 3 && arg[1] == "as" && IsIdentifier(arg[0]) && IsIdentifier(arg[2]) {
+				roles = append(roles, arg[0])
+				params = append(params, arg[2])
+			} else if len(arg) > 0 || token == "," {
+				wellFormed = false
+			}
+			arg = make([]string, 0)
+		} else if writingRoles {
+			arg = append(arg, token)
+		}
 		if token == ")" {
 			writingRoles = false
-		}
-		if writingRoles && token != "," {
-			roles = append(roles, token)
+			closedRoles = true
 		}
 		if token == "(" {
 			writingRoles = true
 		}
 	}
-	d := &Do{Name: name, Roles: roles}
+	if !writtenName || !IsIdentifier(name) || !closedRoles || !wellFormed {
+		diags.Add(tokens[0].Pos, "Malformed do instruction", "expected \"do Protocol(Role, ...);\" or \"do Protocol(Role as SubRole, ...);\"")
+	}
+	id := uniqueIdGen.GenerateUniqueId("do")
+	d := &Do{Id: id, Name: name, Roles: roles, Params: params, Pos: tokens[0].Pos}
 	n := &Node{Cat: "do", Do: d}
 	return n
 }
 
-func ProcessContinue(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+func ProcessContinue(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	name := ""
 	token := ""
 	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
+		token = tokens[i].Value
 		if token != ";" {
 			name += token
 		}
 	}
+	if !IsIdentifier(name) {
+		diags.Add(tokens[0].Pos, "Malformed continue instruction", "expected \"continue <RecName>;\"")
+	}
 	id := uniqueIdGen.GenerateUniqueId(name)
-	c := &Continue{Name: name, Id: id}
+	c := &Continue{Name: name, Id: id, Pos: tokens[0].Pos}
 	s := &Node{Id: id, Cat: "continue", Cont: c}
 	return s
 }
 
-func ProcessRec(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+func ProcessRec(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	s := &Node{}
 	name := ""
-	currentTokenSubsection := make([]string, 0)
+	currentTokenSubsection := make([]*Token, 0)
 	named := false
 	openingBracketCounter := 0
 	closingBracketCounter := 0
 	token := ""
 	prevToken := ""
 	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
-		prevToken = tokens[i-1]
+		token = tokens[i].Value
+		prevToken = tokens[i-1].Value
 		if prevToken == "rec" && named == false {
 			name = token
 			named = true
+			if !IsIdentifier(name) {
+				diags.Add(tokens[0].Pos, "Rec block has no name", "expected \"rec <Name> { ... }\"")
+			}
 		}
 		if token == "{" {
 			openingBracketCounter++
@@ -522,12 +884,12 @@ func ProcessRec(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist stri
 			closingBracketCounter++
 		}
 		if openingBracketCounter > 0 {
-			currentTokenSubsection = append(currentTokenSubsection, token)
+			currentTokenSubsection = append(currentTokenSubsection, tokens[i])
 		}
 		if openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter {
-			c := ProcessConversation(currentTokenSubsection, uniqueIdGen, protagonist)
+			c := ProcessConversation(currentTokenSubsection, uniqueIdGen, protagonist, diags)
 			id := uniqueIdGen.GenerateUniqueId(name)
-			r := &Rec{Id: id, Name: name, Conv: c, Protagonist: protagonist}
+			r := &Rec{Id: id, Name: name, Conv: c, Protagonist: protagonist, Pos: tokens[0].Pos}
 			s = &Node{Cat: "rec", Rec: r}
 			return s
 		}
@@ -535,10 +897,8 @@ func ProcessRec(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist stri
 	return s
 }
 
-func ProcessChoice(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+func ProcessChoice(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	id := uniqueIdGen.GenerateUniqueId("choice")
-	choiceCounter := 0
-	convChan := make(chan *Conversation)
 	conversations := make([]*Conversation, 0)
 	writingConversation := false
 	writingChooser := false
@@ -546,12 +906,12 @@ func ProcessChoice(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist s
 	chooserNamed := false
 	openingBracketCounter := 0
 	closingBracketCounter := 0
-	currentTokenSubsection := make([]string, 0)
+	currentTokenSubsection := make([]*Token, 0)
 	token := ""
 	prevToken := ""
 	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
-		prevToken = tokens[i-1]
+		token = tokens[i].Value
+		prevToken = tokens[i-1].Value
 		// Bracket counters
 		if token == "{" {
 			openingBracketCounter++
@@ -575,42 +935,34 @@ func ProcessChoice(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist s
 			writingConversation = true
 		}
 		if writingConversation {
-			currentTokenSubsection = append(currentTokenSubsection, token)
+			currentTokenSubsection = append(currentTokenSubsection, tokens[i])
 		}
 		if writingConversation && openingBracketCounter == closingBracketCounter {
-			choiceCounter++
-			go ProcessConcurrentConversation(currentTokenSubsection, convChan, uniqueIdGen, protagonist)
+			c := ProcessConversation(currentTokenSubsection, uniqueIdGen, protagonist, diags)
+			conversations = append(conversations, c)
 			openingBracketCounter = 0
 			closingBracketCounter = 0
-			currentTokenSubsection = make([]string, 0)
+			currentTokenSubsection = make([]*Token, 0)
 			writingConversation = false
 		}
 	}
-	for i := 0; i < choiceCounter; i++ {
-		c := <-convChan
-		conversations = append(conversations, c)
+	if chooser == "" {
+		diags.Add(tokens[0].Pos, "Choice block does not name the role making the choice", "expected \"choice at <Role> { ... } or { ... }\"")
 	}
-	c := &Choice{Id: id, Chooser: chooser, Convs: conversations, Protagonist: protagonist}
+	c := &Choice{Id: id, Chooser: chooser, Convs: conversations, Protagonist: protagonist, Pos: tokens[0].Pos}
 	n := &Node{Cat: "choice", Choice: c}
 	return n
 }
 
-func ProcessConcurrentConversation(tokens []string, ch chan *Conversation, uniqueIdGen *LocalIdGenerator, protagonist string) {
-	c := ProcessConversation(tokens, uniqueIdGen, protagonist)
-	ch <- c
-}
-
-func ProcessPar(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist string) *Node {
+func ProcessPar(tokens []*Token, uniqueIdGen *LocalIdGenerator, protagonist string, diags *DiagnosticCollector) *Node {
 	id := uniqueIdGen.GenerateUniqueId("par")
-	parCounter := 0
-	convChan := make(chan *Conversation)
 	conversations := make([]*Conversation, 0)
 	openingBracketCounter := 0
 	closingBracketCounter := 0
-	currentTokenSubsection := make([]string, 0)
+	currentTokenSubsection := make([]*Token, 0)
 	token := ""
 	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
+		token = tokens[i].Value
 		if token == "{" {
 			openingBracketCounter++
 		}
@@ -620,124 +972,62 @@ func ProcessPar(tokens []string, uniqueIdGen *LocalIdGenerator, protagonist stri
 		if token == "and" && openingBracketCounter == 0 {
 			continue
 		} else {
-			currentTokenSubsection = append(currentTokenSubsection, token)
+			currentTokenSubsection = append(currentTokenSubsection, tokens[i])
 		}
 		if openingBracketCounter > 0 && openingBracketCounter == closingBracketCounter {
-			parCounter++
-			go ProcessConcurrentConversation(currentTokenSubsection, convChan, uniqueIdGen, protagonist)
+			c := ProcessConversation(currentTokenSubsection, uniqueIdGen, protagonist, diags)
+			conversations = append(conversations, c)
 			openingBracketCounter = 0
 			closingBracketCounter = 0
-			currentTokenSubsection = make([]string, 0)
+			currentTokenSubsection = make([]*Token, 0)
 		}
 	}
-	for i := 0; i < parCounter; i++ {
-		c := <-convChan
-		conversations = append(conversations, c)
-	}
-	p := &Parallel{Id: id, Convs: conversations, Protagonist: protagonist}
+	p := &Parallel{Id: id, Convs: conversations, Protagonist: protagonist, Pos: tokens[0].Pos}
 	s := &Node{Cat: "par", Par: p}
 	return s
 }
 
+// LocalIdGenerator numbers the elements of a single protocol. A protocol
+// is parsed by one goroutine, so identifiers are handed out in the order
+// in which the elements are reached.
 type LocalIdGenerator struct {
-	LocalName      string
-	RequestIntChan chan bool
-	ReceiveIntChan chan int
-	StopCounter    chan bool
+	LocalName string
+	Counter   int
 }
 
 func (idg *LocalIdGenerator) GenerateUniqueId(MessageName string) string {
-	idg.RequestIntChan <- true
-	counter := <-idg.ReceiveIntChan
-	uniqueId := idg.LocalName + MessageName + strconv.Itoa(counter)
+	uniqueId := idg.LocalName + MessageName + strconv.Itoa(idg.Counter)
+	idg.Counter++
 	return uniqueId
 }
 
-func GenerateCounterValues(idg *LocalIdGenerator) {
-	counter := 0
-	for {
-		select {
-		case <-idg.RequestIntChan:
-			idg.ReceiveIntChan <- counter
-			counter++
-		case <-idg.StopCounter:
-			return
-		default:
-			// Keep looping
-		}
+func ProcessLocal(tokens []*Token, diags *DiagnosticCollector) *Local {
+	g := &Local{Pos: tokens[0].Pos}
+	uniqueIdGen := &LocalIdGenerator{}
+	name, protagonist, roles, bodyStart := ProcessProtocolHeader(tokens, true, diags)
+	uniqueIdGen.LocalName = name
+	g.Name = name
+	g.Protagonist = protagonist
+	g.Roles = roles
+	if bodyStart < 0 {
+		g.Conv = &Conversation{Nodes: make([]*Node, 0), Protagonist: protagonist, Pos: tokens[0].Pos}
+	} else {
+		g.Conv = ProcessConversation(tokens[bodyStart:], uniqueIdGen, protagonist, diags)
 	}
+	return g
 }
 
-func ProcessLocal(tokens []string) *Local {
-	g := &Local{}
+func ProcessGlobal(tokens []*Token, diags *DiagnosticCollector) *Global {
+	g := &Global{Pos: tokens[0].Pos}
 	uniqueIdGen := &LocalIdGenerator{}
-	uniqueIdGen.ReceiveIntChan = make(chan int)
-	uniqueIdGen.RequestIntChan = make(chan bool)
-	uniqueIdGen.StopCounter = make(chan bool)
-	go GenerateCounterValues(uniqueIdGen)
-	name := ""
-	protagonist := ""
-	rolesTokens := make([]string, 0)
-	conversationTokens := make([]string, 0)
-	writingName := false
-	writingRoles := false
-	writingConversation := false
-	writingProtagonist := false
-	nameWritten := false
-	protagonistWritten := false
-	rolesWritten := false
-	token := ""
-	prevToken := ""
-	for i := 1; i < len(tokens); i++ {
-		token = tokens[i]
-		prevToken = tokens[i-1]
-		// Name
-		if prevToken == "protocol" && !nameWritten {
-			writingName = true
-			writingRoles = true
-		}
-		if writingName && token == "at" {
-			writingName = false
-			nameWritten = true
-			uniqueIdGen.LocalName = name
-		}
-		// Protagonist
-		if prevToken == "at" && !protagonistWritten {
-			writingProtagonist = true
-		}
-		// Roles
-		if token == "(" && !rolesWritten {
-			writingRoles = true
-		}
-		if writingRoles && token == ")" {
-			rolesTokens = append(rolesTokens, token)
-			writingRoles = false
-			rolesWritten = true
-		}
-		// Conversation
-		if nameWritten && rolesWritten && !writingConversation && token == "{" {
-			writingConversation = true
-		}
-		// Writing
-		if writingName {
-			name += token
-		}
-		if writingProtagonist {
-			protagonist += token
-			writingProtagonist = false
-			protagonistWritten = true
-		}
-		if writingRoles {
-			rolesTokens = append(rolesTokens, token)
-		}
-		if writingConversation {
-			conversationTokens = append(conversationTokens, token)
-		}
-	}
-	g.Roles = ProcessRoles(rolesTokens)
-	g.Conv = ProcessConversation(conversationTokens, uniqueIdGen, protagonist)
+	name, _, roles, bodyStart := ProcessProtocolHeader(tokens, false, diags)
+	uniqueIdGen.LocalName = name
 	g.Name = name
-	g.Protagonist = protagonist
-	uniqueIdGen.StopCounter <- true
+	g.Roles = roles
+	if bodyStart < 0 {
+		g.Conv = &Conversation{Nodes: make([]*Node, 0), Pos: tokens[0].Pos}
+	} else {
+		g.Conv = ProcessConversation(tokens[bodyStart:], uniqueIdGen, "", diags)
+	}
 	return g
 }
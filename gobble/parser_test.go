@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func ParseTestSource(source string) (*Protocol, error) {
+	tokens, err := Tokenize(source, "test.scr")
+	if err != nil {
+		return nil, err
+	}
+	return ProcessTokens(tokens)
+}
+
+func TestProcessMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "send", message: "m(int) to B;"},
+		{name: "receive", message: "m(x: int, y: string) from B;"},
+		{name: "extra bracket", message: "m()) to B;", want: "test.scr:4:5: Unexpected \")\" after the parameters of message m"},
+		{name: "extra role", message: "m() to B A;", want: "Unexpected \"A\" after the parameters of message m"},
+		{name: "repeated to", message: "m() to B to A;", want: "Unexpected \"to\" after the parameters of message m"},
+		{name: "missing role", message: "m() from;", want: "Expected a role after \"from\" in message m"},
+		{name: "keyword as role", message: "m() from to;", want: "Expected a role after \"from\" in message m"},
+		{name: "no role", message: "m();", want: "Message m names neither a sender nor a receiver"},
+		{name: "both roles", message: "m() from B to A;", want: "names both a sender and a receiver"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "module M;\n\nlocal protocol P at A(role A, role B) {\n\t" + test.message + "\n}\n"
+			_, err := ParseTestSource(source)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestProcessGlobalMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "m(int) from A to B;"},
+		{message: "m(int) to B from A;"},
+		{message: "m(int) from A to B C;", want: "Unexpected \"C\" after the parameters of message m"},
+		{message: "m(int) from A;", want: "must name both its sender and its receiver"},
+	}
+	for _, test := range tests {
+		source := "module M;\n\nglobal protocol P(role A, role B) {\n\t" + test.message + "\n}\n"
+		_, err := ParseTestSource(source)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.message, err)
+		} else if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: got error %v, want one containing %q", test.message, err, test.want)
+		}
+	}
+}
+
+func GetTestIds(nodes []*Node) []string {
+	ids := make([]string, 0)
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			ids = append(ids, n.Mess.Id)
+		} else if n.Cat == "choice" {
+			ids = append(ids, n.Choice.Id)
+			for _, conv := range n.Choice.Convs {
+				ids = append(ids, GetTestIds(conv.Nodes)...)
+			}
+		} else if n.Cat == "rec" {
+			ids = append(ids, n.Rec.Id)
+			ids = append(ids, GetTestIds(n.Rec.Conv.Nodes)...)
+		}
+	}
+	return ids
+}
+
+func TestProcessTokensIsDeterministic(t *testing.T) {
+	source := "module M;\n\n"
+	for _, name := range []string{"P1", "P2", "P3", "P4"} {
+		source += "type <go> \"int\" from \"\" as " + name + "Count;\n"
+		source += "local protocol " + name + " at A(role A, role B) {\n\tm() to B;\n\trec L {\n\t\tchoice at A { x() to B; continue L; } or { y() to B; }\n\t}\n\tn() from B;\n}\n\n"
+	}
+	var first []string
+	for i := 0; i < 20; i++ {
+		tree, err := ParseTestSource(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0)
+		for _, typ := range tree.Types {
+			got = append(got, typ.Alias)
+		}
+		for _, l := range tree.Locals {
+			got = append(got, l.Name)
+			got = append(got, GetTestIds(l.Conv.Nodes)...)
+		}
+		if i == 0 {
+			first = got
+			if first[0] != "P1Count" || first[3] != "P4Count" || first[4] != "P1" || first[5] != "P1m0" {
+				t.Fatalf("declarations are not in source order: %v", first)
+			}
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(first, " ") {
+			t.Fatalf("run %d parsed\n%v\nrun 0 parsed\n%v", i, got, first)
+		}
+	}
+}
+
+func TestProcessTokensReportsErrorsInSourceOrder(t *testing.T) {
+	source := "module M;\n\nlocal protocol P at A(role A, role B) {\n\tm();\n}\n\nlocal protocol Q at A(role A, role B) {\n\tn();\n}\n"
+	for i := 0; i < 20; i++ {
+		_, err := ParseTestSource(source)
+		if err == nil {
+			t.Fatal("expected errors")
+		}
+		msg := err.Error()
+		m, n := strings.Index(msg, "test.scr:4:2"), strings.Index(msg, "test.scr:8:2")
+		if m < 0 || n < 0 || m > n {
+			t.Fatalf("errors are not in source order:\n%s", msg)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// The projector takes the global protocols found by the parser and
+// projects each of them onto every role that they declare, producing
+// one local protocol per role. A global message ``M(T) from A to B''
+// becomes ``M(T) to B'' at A and ``M(T) from A'' at B and disappears
+// from the locals of every other role. Choice, par and rec blocks are
+// kept for a role only if the role takes part in them; par branches and
+// rec blocks in which a role takes no part are dropped. Branches of a
+// choice which project to the same local protocol for a role other than
+// the chooser are merged, and a choice whose remaining branches involve a
+// role in some but not all cases cannot be projected, the first branch
+// with no interaction involving the role being reported; a continue
+// counts as taking part, since the role must know whether to loop. The
+// resulting Local trees have exactly the shape the parser produces for
+// hand-written local protocols, so they can be passed on to the
+// translator unchanged. Globals which cannot be projected are reported
+// as Diagnostics.
+
+// input: tree of structs descending from a Protocol root node
+
+// output: the same tree with one Local appended to Protocol.Locals for
+// each role of each Global
+
+package main
+
+import (
+	"strconv"
+)
+
+func ProjectGlobals(p *Protocol) error {
+	diags := &DiagnosticCollector{}
+	for _, g := range p.Globals {
+		locals := ProjectGlobal(g, diags)
+		p.Locals = append(p.Locals, locals...)
+	}
+	return diags.Err()
+}
+
+func ProjectGlobal(g *Global, diags *DiagnosticCollector) []*Local {
+	locals := make([]*Local, 0)
+	for _, role := range g.Roles {
+		l := ProjectGlobalOntoRole(g, role, diags)
+		if l != nil {
+			locals = append(locals, l)
+		}
+	}
+	return locals
+}
+
+func ProjectGlobalOntoRole(g *Global, r *Role, diags *DiagnosticCollector) *Local {
+	role := r.Name
+	uniqueIdGen := &LocalIdGenerator{LocalName: g.Name}
+	reported := len(diags.Diagnostics)
+	nodes := ProjectNodes(g.Conv.Nodes, g, role, uniqueIdGen, diags)
+	if len(diags.Diagnostics) > reported {
+		// A role whose projection failed is not also reported as taking
+		// no part
+		return nil
+	}
+	if !NodesHaveInteractions(nodes) {
+		diags.Add(r.Pos, "Role "+role+" takes no part in global protocol "+g.Name, "remove "+role+" from the role list or add messages to or from it")
+		return nil
+	}
+	c := &Conversation{Nodes: nodes, Protagonist: role, Pos: g.Conv.Pos}
+	roles := GetProjectedRoles(g, c, role)
+	l := &Local{Name: g.Name, Roles: roles, Conv: c, Protagonist: role, Pos: g.Pos}
+	return l
+}
+
+func GetProjectedRoles(g *Global, c *Conversation, role string) []*Role {
+	involved := make(map[string]bool)
+	involved[role] = true
+	AddInvolvedRoles(c.Nodes, involved)
+	roles := make([]*Role, 0)
+	for _, r := range g.Roles {
+		if involved[r.Name] {
+			roles = append(roles, &Role{Name: r.Name, Pos: r.Pos})
+		}
+	}
+	return roles
+}
+
+func AddInvolvedRoles(nodes []*Node, involved map[string]bool) {
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			involved[n.Mess.From] = true
+			involved[n.Mess.To] = true
+		} else if n.Cat == "choice" {
+			involved[n.Choice.Chooser] = true
+			for _, conv := range n.Choice.Convs {
+				AddInvolvedRoles(conv.Nodes, involved)
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				AddInvolvedRoles(conv.Nodes, involved)
+			}
+		} else if n.Cat == "rec" {
+			AddInvolvedRoles(n.Rec.Conv.Nodes, involved)
+		} else if n.Cat == "do" {
+			for _, r := range n.Do.Roles {
+				involved[r] = true
+			}
+		}
+	}
+}
+
+func NodesHaveInteractions(nodes []*Node) bool {
+	for _, n := range nodes {
+		if n.Cat == "message" || n.Cat == "do" {
+			return true
+		} else if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				if NodesHaveInteractions(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				if NodesHaveInteractions(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "rec" {
+			if NodesHaveInteractions(n.Rec.Conv.Nodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NodesInvolveRole reports whether nodes projected onto a role have any
+// effect on it: a message or do instruction, or a continue, which makes
+// the role repeat an enclosing rec block.
+func NodesInvolveRole(nodes []*Node) bool {
+	for _, n := range nodes {
+		if n.Cat == "message" || n.Cat == "do" || n.Cat == "continue" {
+			return true
+		} else if n.Cat == "choice" {
+			for _, conv := range n.Choice.Convs {
+				if NodesInvolveRole(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "par" {
+			for _, conv := range n.Par.Convs {
+				if NodesInvolveRole(conv.Nodes) {
+					return true
+				}
+			}
+		} else if n.Cat == "rec" {
+			if NodesInvolveRole(n.Rec.Conv.Nodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MergeBranches drops each branch which projects to the same local
+// protocol as an earlier one: a role which does not make the choice
+// cannot tell such branches apart, and need not.
+func MergeBranches(convs []*Conversation) []*Conversation {
+	merged := make([]*Conversation, 0)
+	seen := make(map[string]bool)
+	for _, conv := range convs {
+		key := WriteScribbleConversation(conv, "")
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, conv)
+		}
+	}
+	return merged
+}
+
+func ProjectNodes(nodes []*Node, g *Global, role string, uniqueIdGen *LocalIdGenerator, diags *DiagnosticCollector) []*Node {
+	projected := make([]*Node, 0)
+	for _, n := range nodes {
+		if n.Cat == "message" {
+			if n.Mess.From == role || n.Mess.To == role {
+				projected = append(projected, ProjectMessage(n.Mess, role, uniqueIdGen))
+			}
+		} else if n.Cat == "choice" {
+			projected = append(projected, ProjectChoice(n.Choice, g, role, uniqueIdGen, diags)...)
+		} else if n.Cat == "par" {
+			projected = append(projected, ProjectPar(n.Par, g, role, uniqueIdGen, diags)...)
+		} else if n.Cat == "rec" {
+			projected = append(projected, ProjectRec(n.Rec, g, role, uniqueIdGen, diags)...)
+		} else if n.Cat == "continue" {
+			id := uniqueIdGen.GenerateUniqueId(n.Cont.Name)
+			c := &Continue{Id: id, Name: n.Cont.Name, Pos: n.Cont.Pos}
+			projected = append(projected, &Node{Id: id, Cat: "continue", Cont: c})
+		} else if n.Cat == "do" {
+			if SliceContainsString(n.Do.Roles, role) {
+				id := uniqueIdGen.GenerateUniqueId("do")
+				d := &Do{Id: id, Name: n.Do.Name, Roles: CopyStringSlice(n.Do.Roles), Params: CopyStringSlice(n.Do.Params), Pos: n.Do.Pos}
+				projected = append(projected, &Node{Cat: "do", Do: d})
+			}
+		} else {
+			diags.Add(g.Pos, "Unknown Cat encountered in ProjectNodes(): "+n.Cat, "")
+		}
+	}
+	return projected
+}
+
+func ProjectMessage(mess *Message, role string, uniqueIdGen *LocalIdGenerator) *Node {
+	id := uniqueIdGen.GenerateUniqueId(mess.Name)
+	m := &Message{Id: id, Name: mess.Name, Types: CopyStringSlice(mess.Types), Names: CopyStringSlice(mess.Names), From: mess.From, RoleFrom: mess.RoleFrom, To: mess.To, RoleTo: mess.RoleTo, Rec: mess.Rec, Protagonist: role, Pos: mess.Pos}
+	n := &Node{Cat: "message", Mess: m}
+	return n
+}
+
+func ProjectChoice(choi *Choice, g *Global, role string, uniqueIdGen *LocalIdGenerator, diags *DiagnosticCollector) []*Node {
+	id := uniqueIdGen.GenerateUniqueId("choice")
+	convs := make([]*Conversation, 0)
+	uninvolved := 0
+	for i, conv := range choi.Convs {
+		nodes := ProjectNodes(conv.Nodes, g, role, uniqueIdGen, diags)
+		convs = append(convs, &Conversation{Nodes: nodes, Protagonist: role, Pos: conv.Pos})
+		if uninvolved == 0 && !NodesInvolveRole(nodes) {
+			uninvolved = i + 1
+		}
+	}
+	if role != choi.Chooser {
+		convs = MergeBranches(convs)
+	}
+	involvedCounter := 0
+	for _, conv := range convs {
+		if NodesInvolveRole(conv.Nodes) {
+			involvedCounter++
+		}
+	}
+	if involvedCounter == 0 {
+		return []*Node{}
+	}
+	if len(convs) == 1 {
+		return convs[0].Nodes
+	}
+	if involvedCounter < len(convs) {
+		hint := "add a message to or from " + role + " to the branch, so that " + role + " learns which branch was chosen and whether to continue"
+		if role == choi.Chooser {
+			hint = "begin the branch with a message from " + role + ", which makes the choice"
+		}
+		diags.Add(choi.Pos, "Global protocol "+g.Name+" cannot be projected onto role "+role+": branch "+strconv.Itoa(uninvolved)+" of choice at "+Position{Line: choi.Pos.Line, Col: choi.Pos.Col}.String()+" has no interaction involving "+role, hint)
+		return []*Node{}
+	}
+	c := &Choice{Id: id, Chooser: choi.Chooser, Convs: convs, Protagonist: role, Pos: choi.Pos}
+	return []*Node{&Node{Cat: "choice", Choice: c}}
+}
+
+func ProjectPar(par *Parallel, g *Global, role string, uniqueIdGen *LocalIdGenerator, diags *DiagnosticCollector) []*Node {
+	id := uniqueIdGen.GenerateUniqueId("par")
+	convs := make([]*Conversation, 0)
+	for _, conv := range par.Convs {
+		nodes := ProjectNodes(conv.Nodes, g, role, uniqueIdGen, diags)
+		if NodesInvolveRole(nodes) {
+			convs = append(convs, &Conversation{Nodes: nodes, Protagonist: role, Pos: conv.Pos})
+		}
+	}
+	if len(convs) == 0 {
+		return []*Node{}
+	}
+	// A par block with a single remaining branch is just a sequence
+	if len(convs) == 1 {
+		return convs[0].Nodes
+	}
+	p := &Parallel{Id: id, Convs: convs, Protagonist: role, Pos: par.Pos}
+	return []*Node{&Node{Cat: "par", Par: p}}
+}
+
+func ProjectRec(rec *Rec, g *Global, role string, uniqueIdGen *LocalIdGenerator, diags *DiagnosticCollector) []*Node {
+	nodes := ProjectNodes(rec.Conv.Nodes, g, role, uniqueIdGen, diags)
+	if !NodesHaveInteractions(nodes) {
+		return []*Node{}
+	}
+	id := uniqueIdGen.GenerateUniqueId(rec.Name)
+	c := &Conversation{Nodes: nodes, Protagonist: role, Pos: rec.Conv.Pos}
+	r := &Rec{Id: id, Name: rec.Name, Conv: c, Protagonist: role, Pos: rec.Pos}
+	return []*Node{&Node{Cat: "rec", Rec: r}}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// WriteTestFile writes source to a file called name in a temporary
+// directory and returns its path.
+func WriteTestFile(t *testing.T, name string, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func ProjectTestSource(t *testing.T, source string) (*Protocol, error) {
+	t.Helper()
+	tree, err := ParseAndCheckGlobals(WriteTestFile(t, "test.scr", source))
+	if err != nil {
+		t.Fatalf("unexpected error parsing source: %v", err)
+	}
+	return tree, ProjectAndCheck(tree)
+}
+
+func GetTestLocal(t *testing.T, tree *Protocol, role string) string {
+	t.Helper()
+	for _, l := range tree.Locals {
+		if l.Protagonist == role {
+			return WriteScribbleConversation(l.Conv, "")
+		}
+	}
+	t.Fatalf("no local protocol projected onto %s", role)
+	return ""
+}
+
+func TestProjectTravelAgencyFixture(t *testing.T) {
+	tree, err := ParseAndCheckGlobals("travelAgencyGlobal.scr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ProjectAndCheck(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{"Client", "Aggregator", "BrutishAirways", "QueasyJet"} {
+		if local := GetTestLocal(t, tree, role); !strings.Contains(local, "continue MakeBooking;") {
+			t.Errorf("local protocol of %s does not loop:\n%s", role, local)
+		}
+	}
+	err = CheckDeadlockFreedom(GetLocalProtocols(tree), 0)
+	if err != nil {
+		t.Errorf("projections are not deadlock free: %v", err)
+	}
+}
+
+func TestProjectChoice(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		role    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "role in no branch drops the choice",
+			body: "choice at A { X() from A to B; } or { Y() from A to B; }",
+			role: "C",
+			want: "{\n}",
+		},
+		{
+			name: "identical branches are merged",
+			body: "choice at A { X() from A to B; N() from B to C; } or { Y() from A to B; N() from B to C; }",
+			role: "C",
+			want: "{\n\tN() from B;\n}",
+		},
+		{
+			name:    "continue in some branches only",
+			body:    "rec L { N() from B to C; choice at A { X() from A to B; } or { Y() from A to B; continue L; } }",
+			role:    "C",
+			wantErr: "cannot be projected onto role C",
+		},
+		{
+			name: "continue in every branch keeps the loop",
+			body: "rec L { N() from B to C; choice at A { X() from A to B; continue L; } or { Y() from A to B; continue L; } }",
+			role: "C",
+			want: "{\n\trec L {\n\t\tN() from B;\n\t\tcontinue L;\n\t}\n}",
+		},
+		{
+			name:    "message in some branches only",
+			body:    "choice at A { X() from A to B; } or { Y() from A to B; Z() from B to C; }",
+			role:    "C",
+			wantErr: "cannot be projected onto role C: branch 1 of choice at 5:2 has no interaction involving C",
+		},
+		{
+			name:    "branch empty for the chooser",
+			body:    "choice at A { X() from A to B; } or { }",
+			role:    "A",
+			wantErr: "cannot be projected onto role A: branch 2 of choice at 5:2 has no interaction involving A\n\thint: begin the branch with a message from A, which makes the choice",
+		},
+		{
+			name:    "message in the first branch only",
+			body:    "choice at A { X() from A to B; Z() from B to C; } or { Y() from A to B; } or { W() from A to B; }",
+			role:    "C",
+			wantErr: "cannot be projected onto role C: branch 2 of choice at 5:2 has no interaction involving C",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "module M;\n\nglobal protocol P(role A, role B, role C) {\n\tM() from A to C;\n\t" + test.body + "\n}\n"
+			tree, err := ProjectTestSource(t, source)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				if strings.Contains(err.Error(), "takes no part") {
+					t.Errorf("role whose projection failed is also reported as taking no part:\n%v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Replace(test.want, "{\n", "{\n\tM() from A;\n", 1)
+			if got := GetTestLocal(t, tree, test.role); got != want {
+				t.Errorf("got local\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+// The compact binary encoding used by BinaryCodec. Values are written
+// without any description of their types, so both ends of a connection
+// must agree on the payload type of each message, which the protocol
+// fixes.
+
+// input: Go values
+
+// output: their binary encoding, and values decoded from it
+
+package runtime
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+var gobEncoderType = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+var gobDecoderType = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+var errShortBinary = errors.New("binary codec: payload ends unexpectedly")
+
+// MarshalBinary appends the encoding of v to buf:
+//
+//   - a bool as one byte, 0 or 1;
+//   - a signed integer as a zig-zag varint and an unsigned integer as a
+//     varint, as in encoding/binary;
+//   - a float as its IEEE 754 bits, big-endian, in four or eight bytes,
+//     and a complex number as its real and then its imaginary part;
+//   - a string as its length as a varint followed by its bytes;
+//   - a value of a type which implements encoding.BinaryMarshaler, such
+//     as time.Time, or failing that gob.GobEncoder, such as big.Int, or
+//     failing that encoding.TextMarshaler, as the bytes the method
+//     returns, written as a string;
+//   - a slice or map as its length as a varint followed by its elements,
+//     or for a map each key followed by its value, and an array as its
+//     elements;
+//   - a pointer as one byte, 0 if it is nil or 1 followed by the value it
+//     points to;
+//   - a struct as its exported fields in order.
+//
+// Interfaces, channels and functions cannot be encoded, and nor can
+// structs with unexported fields which implement none of the interfaces
+// above, since their state would be lost.
+func MarshalBinary(buf []byte, v interface{}) ([]byte, error) {
+	return appendBinaryValue(buf, reflect.ValueOf(v))
+}
+
+// UnmarshalBinary decodes data into the value v points to. A slice or
+// map longer than MaxFrameSize is refused, as is a map with more than
+// one entry whose keys encode as nothing, so that a corrupt or hostile
+// payload cannot make the decoder allocate or loop without bound.
+func UnmarshalBinary(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("binary codec: UnmarshalBinary needs a non-nil pointer")
+	}
+	rest, err := readBinaryValue(data, rv.Elem())
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("binary codec: payload has bytes left over")
+	}
+	return nil
+}
+
+func AppendBinaryString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func ReadBinaryString(data []byte) (string, []byte, error) {
+	n, rest, err := readUvarint(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < n {
+		return "", nil, errShortBinary
+	}
+	return string(rest[:n]), rest[n:], nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func appendVarint(buf []byte, x int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+func appendUint32(buf []byte, x uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], x)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, x uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], x)
+	return append(buf, b[:]...)
+}
+
+// marshalerKind returns which of the interfaces a value of type t is
+// encoded with, "binary", "gob" or "text", or "" if it is encoded by its
+// kind. An interface is used only if t or *t implements its encoding
+// method and *t its decoding method, so that both ends choose the same.
+func marshalerKind(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return ""
+	}
+	pt := reflect.PtrTo(t)
+	if pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType) {
+		return "binary"
+	}
+	if pt.Implements(gobEncoderType) && pt.Implements(gobDecoderType) {
+		return "gob"
+	}
+	if pt.Implements(textMarshalerType) && pt.Implements(textUnmarshalerType) {
+		return "text"
+	}
+	return ""
+}
+
+// marshalValue calls the encoding method chosen by marshalerKind on v.
+func marshalValue(v reflect.Value, kind string) ([]byte, error) {
+	if !v.CanAddr() {
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		v = copied
+	}
+	switch kind {
+	case "binary":
+		return v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	case "gob":
+		return v.Addr().Interface().(gob.GobEncoder).GobEncode()
+	}
+	return v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+}
+
+// unmarshalValue calls the decoding method chosen by marshalerKind on
+// v, which must be addressable.
+func unmarshalValue(v reflect.Value, kind string, data []byte) error {
+	switch kind {
+	case "binary":
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	case "gob":
+		return v.Addr().Interface().(gob.GobDecoder).GobDecode(data)
+	}
+	return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+}
+
+// hasUnexportedFields reports whether a struct type has fields which the
+// binary codec cannot reach.
+func hasUnexportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func unexportedFieldsError(verb string, t reflect.Type) error {
+	return errors.New("binary codec: cannot " + verb + " a value of type " + t.String() + ", which has unexported fields and implements none of encoding.BinaryMarshaler, gob.GobEncoder and encoding.TextMarshaler")
+}
+
+// encodesEmpty reports whether values of type t are encoded as no bytes
+// at all, as empty structs are.
+func encodesEmpty(t reflect.Type) bool {
+	if marshalerKind(t) != "" {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !encodesEmpty(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		return t.Len() == 0 || encodesEmpty(t.Elem())
+	}
+	return false
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 {
+		return 0, nil, errShortBinary
+	}
+	return n, data[size:], nil
+}
+
+// readBinaryLength reads the length of a slice or map of type t, which
+// may not be longer than MaxFrameSize.
+func readBinaryLength(data []byte, t reflect.Type) (int, []byte, error) {
+	n, rest, err := readUvarint(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > MaxFrameSize {
+		return 0, nil, errors.New("binary codec: length " + strconv.FormatUint(n, 10) + " of a value of type " + t.String() + " is longer than MaxFrameSize")
+	}
+	return int(n), rest, nil
+}
+
+func appendBinaryValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if kind := marshalerKind(v.Type()); kind != "" {
+		data, err := marshalValue(v, kind)
+		if err != nil {
+			return nil, err
+		}
+		return AppendBinaryString(buf, string(data)), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return appendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.Complex64:
+		buf = appendUint32(buf, math.Float32bits(float32(real(v.Complex()))))
+		return appendUint32(buf, math.Float32bits(float32(imag(v.Complex())))), nil
+	case reflect.Complex128:
+		buf = appendUint64(buf, math.Float64bits(real(v.Complex())))
+		return appendUint64(buf, math.Float64bits(imag(v.Complex()))), nil
+	case reflect.String:
+		return AppendBinaryString(buf, v.String()), nil
+	case reflect.Slice:
+		buf = appendUvarint(buf, uint64(v.Len()))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buf, v.Bytes()...), nil
+		}
+		return appendBinaryElements(buf, v)
+	case reflect.Array:
+		return appendBinaryElements(buf, v)
+	case reflect.Map:
+		buf = appendUvarint(buf, uint64(v.Len()))
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			buf, err = appendBinaryValue(buf, iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			buf, err = appendBinaryValue(buf, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendBinaryValue(append(buf, 1), v.Elem())
+	case reflect.Struct:
+		if hasUnexportedFields(v.Type()) {
+			return nil, unexportedFieldsError("encode", v.Type())
+		}
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			buf, err = appendBinaryValue(buf, v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, errors.New("binary codec: cannot encode a value of type " + v.Type().String())
+}
+
+func appendBinaryElements(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		buf, err = appendBinaryValue(buf, v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func readBinaryValue(data []byte, v reflect.Value) ([]byte, error) {
+	if kind := marshalerKind(v.Type()); kind != "" {
+		s, rest, err := ReadBinaryString(data)
+		if err != nil {
+			return nil, err
+		}
+		err = unmarshalValue(v, kind, []byte(s))
+		return rest, err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(data) < 1 {
+			return nil, errShortBinary
+		}
+		v.SetBool(data[0] != 0)
+		return data[1:], nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, size := binary.Varint(data)
+		if size <= 0 {
+			return nil, errShortBinary
+		}
+		v.SetInt(n)
+		return data[size:], nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, rest, err := readUvarint(data)
+		if err != nil {
+			return nil, err
+		}
+		v.SetUint(n)
+		return rest, nil
+	case reflect.Float32:
+		if len(data) < 4 {
+			return nil, errShortBinary
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))))
+		return data[4:], nil
+	case reflect.Float64:
+		if len(data) < 8 {
+			return nil, errShortBinary
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(data)))
+		return data[8:], nil
+	case reflect.Complex64:
+		if len(data) < 8 {
+			return nil, errShortBinary
+		}
+		re := math.Float32frombits(binary.BigEndian.Uint32(data))
+		im := math.Float32frombits(binary.BigEndian.Uint32(data[4:]))
+		v.SetComplex(complex(float64(re), float64(im)))
+		return data[8:], nil
+	case reflect.Complex128:
+		if len(data) < 16 {
+			return nil, errShortBinary
+		}
+		re := math.Float64frombits(binary.BigEndian.Uint64(data))
+		im := math.Float64frombits(binary.BigEndian.Uint64(data[8:]))
+		v.SetComplex(complex(re, im))
+		return data[16:], nil
+	case reflect.String:
+		s, rest, err := ReadBinaryString(data)
+		if err != nil {
+			return nil, err
+		}
+		v.SetString(s)
+		return rest, nil
+	case reflect.Slice:
+		n, rest, err := readBinaryLength(data, v.Type())
+		if err != nil {
+			return nil, err
+		}
+		if n > len(rest) && !encodesEmpty(v.Type().Elem()) {
+			return nil, errShortBinary
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte{}, rest[:n]...))
+			return rest[n:], nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		if n > 0 && encodesEmpty(v.Type().Elem()) {
+			// Elements which encode as nothing are all alike, so only the
+			// first need be read
+			return readBinaryValue(rest, v.Index(0))
+		}
+		return readBinaryElements(rest, v)
+	case reflect.Array:
+		return readBinaryElements(data, v)
+	case reflect.Map:
+		n, rest, err := readBinaryLength(data, v.Type())
+		if err != nil {
+			return nil, err
+		}
+		// Keys which encode as nothing all have the same value
+		if n > 1 && encodesEmpty(v.Type().Key()) {
+			return nil, errors.New("binary codec: a value of type " + v.Type().String() + " cannot have " + strconv.Itoa(n) + " entries, as its keys are all equal")
+		}
+		if n > len(rest) && !(encodesEmpty(v.Type().Key()) && encodesEmpty(v.Type().Elem())) {
+			return nil, errShortBinary
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			rest, err = readBinaryValue(rest, key)
+			if err != nil {
+				return nil, err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			rest, err = readBinaryValue(rest, value)
+			if err != nil {
+				return nil, err
+			}
+			v.SetMapIndex(key, value)
+		}
+		return rest, nil
+	case reflect.Ptr:
+		if len(data) < 1 {
+			return nil, errShortBinary
+		}
+		if data[0] == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return data[1:], nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		return readBinaryValue(data[1:], v.Elem())
+	case reflect.Struct:
+		if hasUnexportedFields(v.Type()) {
+			return nil, unexportedFieldsError("decode", v.Type())
+		}
+		var err error
+		for i := 0; i < v.NumField(); i++ {
+			data, err = readBinaryValue(data, v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	return nil, errors.New("binary codec: cannot decode a value of type " + v.Type().String())
+}
+
+func readBinaryElements(data []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		data, err = readBinaryValue(data, v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
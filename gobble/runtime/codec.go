@@ -0,0 +1,248 @@
+// The codecs which put the messages of a session on the wire. Each
+// message is sent as its name, which says which message of the protocol
+// it is, followed by its payload. The gob codec can only be read by Go
+// programmes built from the same generated types; the JSON and binary
+// codecs are documented here so that peers written in other languages
+// can speak them.
+
+// input: the name and payload of each message to send, and the bytes
+// received across a connection
+
+// output: the bytes sent across a connection, and the name and payload of
+// each message received
+
+package runtime
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder writes messages to a connection.
+type Encoder interface {
+	Encode(name string, payload interface{}) error
+}
+
+// Decoder reads messages from a connection. DecodeName reads the name of
+// the next message, after which DecodePayload must be called to read its
+// payload into the value v points to.
+type Decoder interface {
+	DecodeName() (string, error)
+	DecodePayload(v interface{}) error
+}
+
+type Codec interface {
+	Name() string
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Codecs holds each codec by the name by which it is selected.
+var Codecs = map[string]Codec{
+	"gob":    GobCodec{},
+	"json":   JSONCodec{},
+	"binary": BinaryCodec{},
+}
+
+// GetCodec returns the codec called name.
+func GetCodec(name string) (Codec, error) {
+	codec, ok := Codecs[name]
+	if !ok {
+		names := make([]string, 0)
+		for n := range Codecs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, errors.New("unknown codec " + name + "; the codecs are " + strings.Join(names, ", "))
+	}
+	return codec, nil
+}
+
+// LoadCodec returns the codec named by the -codec flag or, failing that,
+// by the GOBBLE_CODEC environment variable, or else the codec called
+// def. The flags are added to the programme's command line, which is
+// parsed if it has not been already.
+func LoadCodec(def string) (Codec, error) {
+	ParseFlags()
+	name := flag.Lookup("codec").Value.String()
+	if name == "" {
+		name = os.Getenv("GOBBLE_CODEC")
+	}
+	if name == "" {
+		name = def
+	}
+	return GetCodec(name)
+}
+
+// GobCodec sends the name and then the payload of each message as
+// consecutive gob values.
+type GobCodec struct{}
+
+type gobEncoder struct {
+	enc *gob.Encoder
+}
+
+type gobDecoder struct {
+	dec *gob.Decoder
+}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) NewEncoder(w io.Writer) Encoder {
+	return &gobEncoder{enc: gob.NewEncoder(w)}
+}
+
+func (GobCodec) NewDecoder(r io.Reader) Decoder {
+	return &gobDecoder{dec: gob.NewDecoder(r)}
+}
+
+func (e *gobEncoder) Encode(name string, payload interface{}) error {
+	err := e.enc.Encode(name)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(payload)
+}
+
+func (d *gobDecoder) DecodeName() (string, error) {
+	var name string
+	err := d.dec.Decode(&name)
+	return name, err
+}
+
+func (d *gobDecoder) DecodePayload(v interface{}) error {
+	return d.dec.Decode(v)
+}
+
+// JSONCodec sends each message as a JSON object on a line of its own,
+// such as {"name":"Quote_from_Agency_to_Client_int","payload":{"Param1":42}}.
+// The payload is encoded by encoding/json, so it holds a field for each
+// exported field of the message's struct.
+type JSONCodec struct{}
+
+type jsonMessage struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+type jsonDecoder struct {
+	dec     *json.Decoder
+	payload json.RawMessage
+}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) NewEncoder(w io.Writer) Encoder {
+	return &jsonEncoder{enc: json.NewEncoder(w)}
+}
+
+func (JSONCodec) NewDecoder(r io.Reader) Decoder {
+	return &jsonDecoder{dec: json.NewDecoder(r)}
+}
+
+func (e *jsonEncoder) Encode(name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(jsonMessage{Name: name, Payload: data})
+}
+
+func (d *jsonDecoder) DecodeName() (string, error) {
+	var msg jsonMessage
+	err := d.dec.Decode(&msg)
+	d.payload = msg.Payload
+	return msg.Name, err
+}
+
+func (d *jsonDecoder) DecodePayload(v interface{}) error {
+	return json.Unmarshal(d.payload, v)
+}
+
+// BinaryCodec sends each message as a frame: a four byte big-endian
+// length, followed by that many bytes holding the name of the message as
+// a string and then its payload, encoded as described by MarshalBinary.
+// Frames longer than MaxFrameSize are refused at either end.
+type BinaryCodec struct{}
+
+// MaxFrameSize is the length of the longest frame BinaryCodec sends or
+// receives, so that a corrupt or hostile length cannot make a role
+// allocate more memory than that.
+const MaxFrameSize = 16 << 20
+
+type binaryEncoder struct {
+	w io.Writer
+}
+
+type binaryDecoder struct {
+	r       *bufio.Reader
+	payload []byte
+}
+
+func (BinaryCodec) Name() string {
+	return "binary"
+}
+
+func (BinaryCodec) NewEncoder(w io.Writer) Encoder {
+	return &binaryEncoder{w: w}
+}
+
+func (BinaryCodec) NewDecoder(r io.Reader) Decoder {
+	return &binaryDecoder{r: bufio.NewReader(r)}
+}
+
+func (e *binaryEncoder) Encode(name string, payload interface{}) error {
+	frame := make([]byte, 4)
+	frame = AppendBinaryString(frame, name)
+	frame, err := MarshalBinary(frame, payload)
+	if err != nil {
+		return err
+	}
+	if len(frame)-4 > MaxFrameSize {
+		return errors.New("binary codec: message " + name + " of " + strconv.Itoa(len(frame)-4) + " bytes is longer than MaxFrameSize")
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	_, err = e.w.Write(frame)
+	return err
+}
+
+func (d *binaryDecoder) DecodeName() (string, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(d.r, header)
+	if err != nil {
+		return "", err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxFrameSize {
+		return "", errors.New("binary codec: frame of " + strconv.FormatUint(uint64(size), 10) + " bytes is longer than MaxFrameSize")
+	}
+	frame := make([]byte, size)
+	_, err = io.ReadFull(d.r, frame)
+	if err != nil {
+		return "", err
+	}
+	name, rest, err := ReadBinaryString(frame)
+	d.payload = rest
+	return name, err
+}
+
+func (d *binaryDecoder) DecodePayload(v interface{}) error {
+	return UnmarshalBinary(d.payload, v)
+}
@@ -0,0 +1,177 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Fare struct {
+	Airline string
+	Price   int
+	Seats   []uint8
+	Legs    map[string]float64
+	Via     *string
+	When    time.Time
+}
+
+// Level implements only encoding.TextMarshaler and TextUnmarshaler.
+type Level struct {
+	name string
+}
+
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.name), nil
+}
+
+func (l *Level) UnmarshalText(data []byte) error {
+	l.name = string(data)
+	return nil
+}
+
+type Hidden struct {
+	Visible int
+	secret  int
+}
+
+func RoundTrip[T any](t *testing.T, codec Codec, v T) T {
+	t.Helper()
+	var buf bytes.Buffer
+	err := codec.NewEncoder(&buf).Encode("m", v)
+	if err != nil {
+		t.Fatalf("%s: %v", codec.Name(), err)
+	}
+	dec := codec.NewDecoder(&buf)
+	name, err := dec.DecodeName()
+	if err != nil || name != "m" {
+		t.Fatalf("%s: got name %q and error %v", codec.Name(), name, err)
+	}
+	var got T
+	err = dec.DecodePayload(&got)
+	if err != nil {
+		t.Fatalf("%s: %v", codec.Name(), err)
+	}
+	return got
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	via := "Paris"
+	fare := Fare{Airline: "QueasyJet", Price: 12345, Seats: []uint8{1, 2}, Legs: map[string]float64{"LHR": 1.5}, Via: &via, When: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	for _, name := range []string{"gob", "json", "binary"} {
+		codec, err := GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := RoundTrip(t, codec, fare)
+		if !reflect.DeepEqual(got, fare) {
+			t.Errorf("%s: got %+v, want %+v", name, got, fare)
+		}
+		n := big.NewInt(12345)
+		if got := RoundTrip(t, codec, n); got.Cmp(n) != 0 {
+			t.Errorf("%s: got *big.Int %v, want %v", name, got, n)
+		}
+	}
+}
+
+func TestBinaryMarshalers(t *testing.T) {
+	n := big.NewInt(-12345)
+	if got := RoundTrip(t, BinaryCodec{}, *n); got.Cmp(n) != 0 {
+		t.Errorf("got big.Int %v, want %v", &got, n)
+	}
+	level := Level{name: "gold"}
+	if got := RoundTrip(t, BinaryCodec{}, level); got != level {
+		t.Errorf("got %+v, want %+v", got, level)
+	}
+}
+
+func TestBinaryRejectsUnexportedFields(t *testing.T) {
+	_, err := MarshalBinary(nil, Hidden{Visible: 1, secret: 2})
+	if err == nil || !strings.Contains(err.Error(), "runtime.Hidden, which has unexported fields") {
+		t.Errorf("got error %v encoding a struct with unexported fields", err)
+	}
+	var h Hidden
+	err = UnmarshalBinary([]byte{2}, &h)
+	if err == nil || !strings.Contains(err.Error(), "cannot decode a value of type runtime.Hidden") {
+		t.Errorf("got error %v decoding a struct with unexported fields", err)
+	}
+}
+
+func TestBinaryMaxFrameSize(t *testing.T) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, MaxFrameSize+1)
+	_, err := BinaryCodec{}.NewDecoder(bytes.NewReader(header)).DecodeName()
+	if err == nil || !strings.Contains(err.Error(), "longer than MaxFrameSize") {
+		t.Errorf("got error %v decoding an oversized frame", err)
+	}
+	err = BinaryCodec{}.NewEncoder(&bytes.Buffer{}).Encode("m", make([]byte, MaxFrameSize))
+	if err == nil || !strings.Contains(err.Error(), "longer than MaxFrameSize") {
+		t.Errorf("got error %v encoding an oversized frame", err)
+	}
+}
+
+func TestBinaryTruncatedPayload(t *testing.T) {
+	data, err := MarshalBinary(nil, Fare{Airline: "BrutishAirways", Seats: []uint8{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		var fare Fare
+		if UnmarshalBinary(data[:i], &fare) == nil {
+			t.Errorf("decoding %d of %d bytes succeeded", i, len(data))
+		}
+	}
+}
+
+func TestBinaryHostileLengths(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		v    interface{}
+		want string
+	}{
+		{"slice of empty structs", appendUvarint(nil, 1<<63+5), &[]struct{}{}, "longer than MaxFrameSize"},
+		{"slice of bytes", appendUvarint(nil, 1<<63+5), &[]byte{}, "longer than MaxFrameSize"},
+		{"map of empty structs", appendUvarint(nil, 1<<62), &map[struct{}]struct{}{}, "longer than MaxFrameSize"},
+		{"map with equal keys", appendUvarint(nil, 2), &map[struct{}]struct{}{}, "cannot have 2 entries"},
+		{"map of ints", appendUvarint(nil, MaxFrameSize), &map[int]int{}, "payload ends unexpectedly"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := UnmarshalBinary(test.data, test.v)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+	// Lengths up to MaxFrameSize are still accepted where the elements
+	// take no space
+	var empty []struct{}
+	if err := UnmarshalBinary(appendUvarint(nil, 5), &empty); err != nil || len(empty) != 5 {
+		t.Errorf("got %d elements and error %v", len(empty), err)
+	}
+	var set map[struct{}]bool
+	if err := UnmarshalBinary(appendUvarint(nil, 1), &set); err == nil {
+		t.Errorf("decoded a map entry whose value is missing")
+	}
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	data, err := MarshalBinary(nil, Fare{Airline: "QueasyJet", Seats: []uint8{1}, Legs: map[string]float64{"LHR": 1}})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Add(appendUvarint(nil, 1<<63+5))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var fare Fare
+		UnmarshalBinary(data, &fare)
+		var empties []struct{}
+		UnmarshalBinary(data, &empties)
+		var sets []map[struct{}][0]int
+		UnmarshalBinary(data, &sets)
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package runtime
+
+import (
+	"time"
+)
+
+// ProcessCPUTime cannot measure the CPU time used on this platform.
+func ProcessCPUTime() (time.Duration, bool) {
+	return 0, false
+}
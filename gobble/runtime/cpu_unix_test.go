@@ -0,0 +1,33 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package runtime
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// ProcessCPUTime returns the user and system CPU time used by the
+// process so far.
+func ProcessCPUTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &usage) != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
+
+// TestIdleSessionUsesNoCPU checks that the send and receive loops block
+// rather than poll while there are no messages.
+func TestIdleSessionUsesNoCPU(t *testing.T) {
+	_, closeSession := StartTestSession(t, Codecs["gob"], false)
+	defer closeSession()
+	start, _ := ProcessCPUTime()
+	idle := 500 * time.Millisecond
+	time.Sleep(idle)
+	end, _ := ProcessCPUTime()
+	if used := end - start; used > idle/10 {
+		t.Errorf("an idle session used %v of CPU in %v", used, idle)
+	}
+}
@@ -0,0 +1,60 @@
+// Package runtime holds the code shared by every protocol implementation
+// Gobble generates: the check that each state struct is used only once,
+// the typed sends and receives across the channels between the state
+// structs of a role and its network connections, and the connections
+// themselves. Generated code is a thin typed wrapper around it, so fixes
+// made here take effect without generating the code again.
+
+// input: state structs, channels and connections from generated code
+
+// output: values received, and errors reporting misuse of a state struct
+
+package runtime
+
+import (
+	"errors"
+)
+
+// Endpoint is embedded in every state struct. It records whether one of
+// the struct's methods has been called, since each state of a session
+// may be left only once.
+type Endpoint struct {
+	Used bool
+}
+
+// Use marks the state struct named state as used by its method named
+// method, or returns an error if it has been used already.
+func (e *Endpoint) Use(state string, method string) error {
+	if e.Used {
+		return errors.New("Dynamic session type checking error: attempted repeat method call to one or more methods of a given session type struct. Struct: " + state + "; method: " + method)
+	}
+	e.Used = true
+	return nil
+}
+
+// Send sends v on ch.
+func Send[T any](ch chan<- T, v T) {
+	ch <- v
+}
+
+// Recv waits for a value on ch and returns it.
+func Recv[T any](ch <-chan T) T {
+	return <-ch
+}
+
+// Done reports on ch that a branch of a par block has ended.
+func Done(ch chan<- bool) {
+	ch <- true
+}
+
+// CheckDone returns an error if the branch of a par block named branch
+// has not reported on ch that it has ended by the time end, the struct
+// for the end of the block, is used.
+func CheckDone(ch <-chan bool, end string, branch string) error {
+	select {
+	case <-ch:
+		return nil
+	default:
+	}
+	return errors.New("Dynamic session type checking error: attempted call to " + end + ".EndPar() prior to completion of parallel process " + branch)
+}
@@ -0,0 +1,162 @@
+// The addresses at which the roles of a protocol can be reached. Each role
+// listens at its own endpoint and is dialled there by the roles which
+// connect to it. The endpoints compiled into a generated programme can be
+// overridden when it is started by a configuration file, by environment
+// variables or by flags.
+
+// input: the default endpoints of the roles known to a programme, and
+// the command line and environment of the programme
+
+// output: the endpoint of each role
+
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"strings"
+)
+
+// Endpoints maps the name of each role to the "host:port" address at which
+// it listens.
+type Endpoints map[string]string
+
+// endpointFlags collects the values of the repeatable -endpoint flag.
+type endpointFlags []string
+
+func (f *endpointFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *endpointFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// ParseEndpoint splits a "Role=host:port" assignment.
+func ParseEndpoint(assignment string) (string, string, error) {
+	eq := strings.Index(assignment, "=")
+	if eq < 1 {
+		return "", "", errors.New("endpoint " + assignment + " is not of the form Role=host:port")
+	}
+	role := assignment[:eq]
+	address := assignment[eq+1:]
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", errors.New("endpoint " + assignment + ": " + err.Error())
+	}
+	return role, address, nil
+}
+
+// ReadEndpointsFile reads a JSON object mapping role names to addresses,
+// such as {"Client": "localhost:8080", "QueasyJet": "10.0.0.2:9000"}.
+func ReadEndpointsFile(fileName string) (Endpoints, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(Endpoints)
+	err = json.Unmarshal(data, &endpoints)
+	if err != nil {
+		return nil, errors.New("endpoints file " + fileName + ": " + err.Error())
+	}
+	for role, address := range endpoints {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, errors.New("endpoints file " + fileName + ": role " + role + ": " + err.Error())
+		}
+	}
+	return endpoints, nil
+}
+
+// GetEndpointEnvName returns the environment variable which sets the
+// endpoint of role, e.g. GOBBLE_ENDPOINT_QUEASYJET.
+func GetEndpointEnvName(role string) string {
+	return "GOBBLE_ENDPOINT_" + strings.ToUpper(role)
+}
+
+// LoadEndpoints returns the endpoint of each role in defaults. Each is
+// taken from the first of these which sets it:
+//
+//   - a -endpoint Role=host:port flag, which may be repeated;
+//   - the GOBBLE_ENDPOINT_<ROLE> environment variable;
+//   - the JSON file named by the -endpoints flag or, failing that, by the
+//     GOBBLE_ENDPOINTS environment variable;
+//   - defaults.
+//
+// The flags are added to the programme's command line, which is parsed if
+// it has not been already.
+func LoadEndpoints(defaults Endpoints) (Endpoints, error) {
+	ParseFlags()
+	endpoints := make(Endpoints)
+	for role, address := range defaults {
+		endpoints[role] = address
+	}
+	fileName := flag.Lookup("endpoints").Value.String()
+	if fileName == "" {
+		fileName = os.Getenv("GOBBLE_ENDPOINTS")
+	}
+	if fileName != "" {
+		fromFile, err := ReadEndpointsFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		for role, address := range fromFile {
+			if _, ok := endpoints[role]; ok {
+				endpoints[role] = address
+			}
+		}
+	}
+	for role := range endpoints {
+		if address := os.Getenv(GetEndpointEnvName(role)); address != "" {
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, errors.New(GetEndpointEnvName(role) + ": " + err.Error())
+			}
+			endpoints[role] = address
+		}
+	}
+	if assignments, ok := flag.Lookup("endpoint").Value.(*endpointFlags); ok {
+		for _, assignment := range *assignments {
+			role, address, err := ParseEndpoint(assignment)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := endpoints[role]; !ok {
+				return nil, errors.New("-endpoint names role " + role + ", which this programme does not talk to")
+			}
+			endpoints[role] = address
+		}
+	}
+	return endpoints, nil
+}
+
+// ParseFlags adds the flags which configure the runtime to the
+// programme's command line, unless it already has flags of the same
+// names, and parses the command line if it has not been already.
+func ParseFlags() {
+	if flag.Lookup("endpoint") == nil {
+		flag.Var(new(endpointFlags), "endpoint", "address of a role, as Role=host:port; may be repeated")
+	}
+	if flag.Lookup("endpoints") == nil {
+		flag.String("endpoints", "", "JSON file mapping roles to host:port addresses")
+	}
+	if flag.Lookup("codec") == nil {
+		flag.String("codec", "", "codec with which messages are sent: gob, json or binary")
+	}
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+}
+
+// Listen returns the address at which role listens: its endpoint, host
+// and port. An endpoint with an empty host, such as ":9000", listens on
+// every interface.
+func (e Endpoints) Listen(role string) string {
+	return e[role]
+}
+
+// Dial returns the address at which role is dialled.
+func (e Endpoints) Dial(role string) string {
+	return e[role]
+}
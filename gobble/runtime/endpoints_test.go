@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointsListen(t *testing.T) {
+	endpoints := Endpoints{"Client": "localhost:9001", "Server": ":9002", "Remote": "10.0.0.2:9000"}
+	for role, want := range map[string]string{"Client": "localhost:9001", "Server": ":9002", "Remote": "10.0.0.2:9000"} {
+		if got := endpoints.Listen(role); got != want {
+			t.Errorf("Listen(%q) = %q, want %q", role, got, want)
+		}
+		if got := endpoints.Dial(role); got != want {
+			t.Errorf("Dial(%q) = %q, want %q", role, got, want)
+		}
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		assignment string
+		role       string
+		address    string
+		want       string
+	}{
+		{assignment: "Client=localhost:9001", role: "Client", address: "localhost:9001"},
+		{assignment: "Client=:9001", role: "Client", address: ":9001"},
+		{assignment: "=localhost:9001", want: "is not of the form Role=host:port"},
+		{assignment: "Client", want: "is not of the form Role=host:port"},
+		{assignment: "Client=localhost", want: "missing port in address"},
+	}
+	for _, test := range tests {
+		role, address, err := ParseEndpoint(test.assignment)
+		if test.want != "" {
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("%s: got error %v, want one containing %q", test.assignment, err, test.want)
+			}
+			continue
+		}
+		if err != nil || role != test.role || address != test.address {
+			t.Errorf("%s: got %q, %q, %v", test.assignment, role, address, err)
+		}
+	}
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "endpoints.json")
+	err := os.WriteFile(fileName, []byte(`{"Client": "10.0.0.1:9001", "Server": "10.0.0.2:9002", "Other": "10.0.0.3:9003"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOBBLE_ENDPOINTS", fileName)
+	t.Setenv(GetEndpointEnvName("Server"), "10.0.0.4:9004")
+	endpoints, err := LoadEndpoints(Endpoints{"Client": "localhost:8080", "Server": "localhost:8080", "Broker": "localhost:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Endpoints{"Client": "10.0.0.1:9001", "Server": "10.0.0.4:9004", "Broker": "localhost:8080"}
+	if len(endpoints) != len(want) {
+		t.Errorf("got endpoints %v, want %v", endpoints, want)
+	}
+	for role, address := range want {
+		if endpoints[role] != address {
+			t.Errorf("got endpoint %q for %s, want %q", endpoints[role], role, address)
+		}
+	}
+	t.Setenv(GetEndpointEnvName("Server"), "nohost")
+	_, err = LoadEndpoints(Endpoints{"Server": "localhost:8080"})
+	if err == nil || !strings.Contains(err.Error(), "GOBBLE_ENDPOINT_SERVER") {
+		t.Errorf("got error %v for a malformed environment variable", err)
+	}
+}
@@ -0,0 +1,3 @@
+module gobble/runtime
+
+go 1.18
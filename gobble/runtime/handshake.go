@@ -0,0 +1,169 @@
+// The handshake two roles exchange when a connection is made between
+// them, before any message of the protocol is sent. Each side states the
+// version of the handshake, the module and protocol it implements, its
+// role and a hash of its local protocol restricted to the messages it
+// expects to exchange with the other; a connection whose two sides do
+// not agree is closed.
+
+// input: the Session a role implements and the Handshake received from
+// the other end of a connection
+
+// output: the role at the other end of the connection, or an error
+// describing why it was rejected
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// HandshakeVersion is the version of the handshake described by
+// Handshake. Roles generated with a different version are rejected.
+const HandshakeVersion = 1
+
+// HandshakeTimeout is how long either side of a connection waits for
+// the other to complete the handshake.
+const HandshakeTimeout = 10 * time.Second
+
+type Handshake struct {
+	Version  int
+	Module   string
+	Protocol string
+	Role     string
+	Hash     string
+	Reject   string
+}
+
+// Session is what a role knows of the protocol it implements: Hashes
+// holds, for each role it communicates with, the hash of its local
+// protocol restricted to the messages the two exchange.
+type Session struct {
+	Module   string
+	Protocol string
+	Role     string
+	Hashes   map[string]string
+}
+
+// Handshake returns the handshake the role sends to peer.
+func (s Session) Handshake(peer string) Handshake {
+	return Handshake{Version: HandshakeVersion, Module: s.Module, Protocol: s.Protocol, Role: s.Role, Hash: s.Hashes[peer]}
+}
+
+// Peers returns the roles the role communicates with, sorted.
+func (s Session) Peers() []string {
+	peers := make([]string, 0)
+	for peer := range s.Hashes {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Check returns an error if h was not sent by a role with which the
+// role may communicate. If peer is not empty, h must have been sent by
+// that role.
+func (s Session) Check(h Handshake, peer string) error {
+	if h.Version != HandshakeVersion {
+		return fmt.Errorf("handshake version %d is not supported; expected version %d", h.Version, HandshakeVersion)
+	}
+	if h.Module != s.Module || h.Protocol != s.Protocol {
+		return fmt.Errorf("role %s implements protocol %s of module %s; expected protocol %s of module %s", h.Role, h.Protocol, h.Module, s.Protocol, s.Module)
+	}
+	if peer != "" && h.Role != peer {
+		return fmt.Errorf("expected role %s but role %s answered", peer, h.Role)
+	}
+	hash, ok := s.Hashes[h.Role]
+	if !ok {
+		return fmt.Errorf("role %s is not one of the roles %s communicates with: %v", h.Role, s.Role, s.Peers())
+	}
+	if h.Hash != hash {
+		return fmt.Errorf("role %s was generated from a version of protocol %s which differs from that of %s in the messages the two exchange", h.Role, s.Protocol, s.Role)
+	}
+	return nil
+}
+
+// readHandshake receives the handshake from the other end of the
+// connection, which must be the first message sent across it.
+func readHandshake(trans *Transmitter) (Handshake, error) {
+	var h Handshake
+	name, err := trans.Decoder.DecodeName()
+	if err != nil {
+		return h, errors.New("could not receive a handshake: " + err.Error())
+	}
+	if name != "Handshake" {
+		return h, errors.New("expected a handshake but received " + name)
+	}
+	err = trans.Decoder.DecodePayload(&h)
+	if err != nil {
+		return h, errors.New("could not receive a handshake: " + err.Error())
+	}
+	return h, nil
+}
+
+// DialSession connects to the role peer at serverAddress and exchanges
+// handshakes with it, stopping the programme if the role which answers
+// is not peer or does not implement the same protocol.
+func DialSession(conType string, serverAddress string, codec Codec, session Session, peer string) *Transmitter {
+	trans := Dial(conType, serverAddress, codec)
+	trans.Connection.SetDeadline(time.Now().Add(HandshakeTimeout))
+	Encode(trans, "Handshake", session.Handshake(peer))
+	h, err := readHandshake(trans)
+	if err == nil && h.Reject != "" {
+		err = errors.New("rejected by " + h.Role + ": " + h.Reject)
+	} else if err == nil {
+		err = session.Check(h, peer)
+	}
+	if err != nil {
+		trans.Connection.Close()
+		log.Fatal("Handshake with " + peer + " at " + serverAddress + " failed: " + err.Error())
+	}
+	trans.Connection.SetDeadline(time.Time{})
+	return trans
+}
+
+// AcceptConnections listens at endpoint, a "host:port" address, and
+// exchanges handshakes with each role which connects to it, calling
+// handle with the connection and the role which made it. Connections from
+// roles which do not implement the same protocol are told why and closed.
+func AcceptConnections(conType string, endpoint string, codec Codec, session Session, handle func(trans *Transmitter, role string)) {
+	ln, err := net.Listen(conType, endpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go acceptSession(NewTransmitter(conn, codec), session, handle)
+	}
+}
+
+func acceptSession(trans *Transmitter, session Session, handle func(trans *Transmitter, role string)) {
+	trans.Connection.SetDeadline(time.Now().Add(HandshakeTimeout))
+	h, err := readHandshake(trans)
+	if err == nil {
+		err = session.Check(h, "")
+	}
+	if err != nil {
+		reply := session.Handshake("")
+		reply.Reject = err.Error()
+		trans.Encoder.Encode("Handshake", reply)
+		trans.Connection.Close()
+		log.Println("Rejected connection from " + trans.Connection.RemoteAddr().String() + ": " + err.Error())
+		return
+	}
+	err = trans.Encoder.Encode("Handshake", session.Handshake(h.Role))
+	if err != nil {
+		trans.Connection.Close()
+		log.Println("Handshake with " + h.Role + " failed: " + err.Error())
+		return
+	}
+	trans.Connection.SetDeadline(time.Time{})
+	handle(trans, h.Role)
+}
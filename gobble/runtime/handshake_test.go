@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func GetTestSessions() (Session, Session) {
+	client := Session{Module: "M", Protocol: "P", Role: "Client", Hashes: map[string]string{"Server": "abc"}}
+	server := Session{Module: "M", Protocol: "P", Role: "Server", Hashes: map[string]string{"Client": "abc"}}
+	return client, server
+}
+
+func TestSessionCheck(t *testing.T) {
+	client, server := GetTestSessions()
+	tests := []struct {
+		name   string
+		change func(h *Handshake)
+		peer   string
+		want   string
+	}{
+		{name: "matching", change: func(h *Handshake) {}},
+		{name: "matching expected peer", change: func(h *Handshake) {}, peer: "Client"},
+		{name: "version", change: func(h *Handshake) { h.Version++ }, want: "handshake version 2 is not supported"},
+		{name: "module", change: func(h *Handshake) { h.Module = "N" }, want: "role Client implements protocol P of module N"},
+		{name: "protocol", change: func(h *Handshake) { h.Protocol = "Q" }, want: "role Client implements protocol Q of module M"},
+		{name: "unexpected peer", change: func(h *Handshake) {}, peer: "Other", want: "expected role Other but role Client answered"},
+		{name: "unknown role", change: func(h *Handshake) { h.Role = "Other" }, want: "role Other is not one of the roles Server communicates with: [Client]"},
+		{name: "hash", change: func(h *Handshake) { h.Hash = "def" }, want: "differs from that of Server"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := client.Handshake("Server")
+			test.change(&h)
+			err := server.Check(h, test.peer)
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+// ExchangeTestHandshake sends h across a pipe to a role accepting
+// connections for server and returns the handshake it replies with and
+// the role passed to its handler, if any.
+func ExchangeTestHandshake(t *testing.T, server Session, h Handshake) (Handshake, string) {
+	t.Helper()
+	codec, err := GetCodec("gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	handled := make(chan string, 1)
+	go func() {
+		acceptSession(NewTransmitter(b, codec), server, func(trans *Transmitter, role string) {
+			handled <- role
+			trans.Connection.Close()
+		})
+		close(handled)
+	}()
+	trans := NewTransmitter(a, codec)
+	err = trans.Encoder.Encode("Handshake", h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := readHandshake(trans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	return reply, <-handled
+}
+
+func TestAcceptSession(t *testing.T) {
+	client, server := GetTestSessions()
+	reply, role := ExchangeTestHandshake(t, server, client.Handshake("Server"))
+	if reply.Reject != "" {
+		t.Fatalf("handshake rejected: %s", reply.Reject)
+	}
+	if err := client.Check(reply, "Server"); err != nil {
+		t.Errorf("reply does not match: %v", err)
+	}
+	if role != "Client" {
+		t.Errorf("handler called with role %q, want Client", role)
+	}
+}
+
+func TestAcceptSessionRejects(t *testing.T) {
+	client, server := GetTestSessions()
+	client.Hashes["Server"] = "def"
+	reply, role := ExchangeTestHandshake(t, server, client.Handshake("Server"))
+	if !strings.Contains(reply.Reject, "differs from that of Server") {
+		t.Errorf("got rejection %q", reply.Reject)
+	}
+	if role != "" {
+		t.Errorf("handler called with role %q for a rejected connection", role)
+	}
+}
@@ -0,0 +1,82 @@
+// The connections between roles running on different systems. A
+// Transmitter wraps a connection with an encoder and a decoder of the
+// codec chosen for it; the two roles exchange handshakes (see
+// handshake.go) before sending any messages.
+
+// input: a connection type, an address and a codec, and messages to send
+
+// output: the messages received across each connection
+
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+type Transmitter struct {
+	Encoder    Encoder
+	Decoder    Decoder
+	Connection net.Conn
+}
+
+// NewTransmitter wraps conn with an encoder and a decoder of codec.
+func NewTransmitter(conn net.Conn, codec Codec) *Transmitter {
+	return &Transmitter{Encoder: codec.NewEncoder(conn), Decoder: codec.NewDecoder(conn), Connection: conn}
+}
+
+// Dial connects to serverAddress, retrying every three seconds until the
+// server accepts the connection.
+func Dial(conType string, serverAddress string, codec Codec) *Transmitter {
+	for {
+		conn, err := net.Dial(conType, serverAddress)
+		if err == nil {
+			fmt.Println("Successfully established " + conType + " connection with " + serverAddress)
+			return NewTransmitter(conn, codec)
+		}
+		fmt.Println("Connection error: " + err.Error())
+		fmt.Println("Retrying...")
+		time.Sleep(time.Second * 3)
+	}
+}
+
+// Encode sends the message called name with payload v across the
+// connection, stopping the programme if it cannot be sent.
+func Encode(trans *Transmitter, name string, v interface{}) {
+	err := trans.Encoder.Encode(name, v)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// DecodeName receives the name of the next message from the connection.
+// It returns false once the connection has been closed between messages,
+// by the other role when it has sent all its messages or by this role at
+// the end of the session, and stops the programme if no name can be
+// received for any other reason. The payload must then be received with
+// Decode.
+func DecodeName(trans *Transmitter) (string, bool) {
+	name, err := trans.Decoder.DecodeName()
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return "", false
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return name, true
+}
+
+// Decode receives the payload of the message whose name has just been
+// received, stopping the programme if it cannot be received.
+func Decode[T any](trans *Transmitter) T {
+	var v T
+	err := trans.Decoder.DecodePayload(&v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,197 @@
+package runtime
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// NewTestConnection returns the two ends of a TCP connection across the
+// loopback interface, each wrapped with codec.
+func NewTestConnection(tb testing.TB, codec Codec) (*Transmitter, *Transmitter) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server, ok := <-accepted
+	if !ok {
+		tb.Fatal("could not accept the test connection")
+	}
+	return NewTransmitter(conn, codec), NewTransmitter(server, codec)
+}
+
+// TestPeer is one end of a connection served as the generated SendTo
+// and ReceiveFrom functions serve it: out carries the messages its
+// states send, in the messages they receive.
+type TestPeer struct {
+	out  chan int
+	in   chan int
+	done chan bool
+}
+
+// StartTestPeer serves trans with SendLoop, or with PollingSendLoop if
+// polling is set.
+func StartTestPeer(trans *Transmitter, polling bool) *TestPeer {
+	p := &TestPeer{out: make(chan int), in: make(chan int), done: make(chan bool)}
+	if polling {
+		go p.PollingSendLoop(trans)
+	} else {
+		go p.SendLoop(trans)
+	}
+	go p.ReceiveLoop(trans)
+	return p
+}
+
+// SendLoop has the shape of a generated SendTo function.
+func (p *TestPeer) SendLoop(trans *Transmitter) {
+	for {
+		select {
+		case v := <-p.out:
+			Encode(trans, "Ping", v)
+		case <-p.done:
+			trans.Connection.Close()
+			p.done <- true
+			return
+		}
+	}
+}
+
+// PollingSendLoop has the shape the generated SendTo functions had
+// before they blocked: the default case makes it spin while there is
+// nothing to send. It is kept for comparison in the benchmarks.
+func (p *TestPeer) PollingSendLoop(trans *Transmitter) {
+	for {
+		select {
+		case v := <-p.out:
+			Encode(trans, "Ping", v)
+		case <-p.done:
+			trans.Connection.Close()
+			p.done <- true
+			return
+		default:
+			// Keep looping
+		}
+	}
+}
+
+// ReceiveLoop has the shape of a generated ReceiveFrom function.
+func (p *TestPeer) ReceiveLoop(trans *Transmitter) {
+	for {
+		name, ok := DecodeName(trans)
+		if !ok {
+			return
+		}
+		switch name {
+		case "Ping":
+			Send(p.in, Decode[int](trans))
+		}
+	}
+}
+
+// Close ends the session of the peer as the generated
+// CloseConnectAsClientWith functions do.
+func (p *TestPeer) Close() {
+	p.done <- true
+	<-p.done
+}
+
+// StartTestSession connects a client to a server which echoes each
+// message it receives, both sending with PollingSendLoop if polling is
+// set.
+func StartTestSession(tb testing.TB, codec Codec, polling bool) (*TestPeer, func()) {
+	clientTrans, serverTrans := NewTestConnection(tb, codec)
+	client := StartTestPeer(clientTrans, polling)
+	server := StartTestPeer(serverTrans, polling)
+	go func() {
+		for v := range server.in {
+			Send(server.out, v)
+		}
+	}()
+	return client, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	for name, codec := range Codecs {
+		client, closeSession := StartTestSession(t, codec, false)
+		for i := 0; i < 100; i++ {
+			Send(client.out, i)
+			if got := Recv(client.in); got != i {
+				t.Errorf("%s: got %d back, want %d", name, got, i)
+			}
+		}
+		closeSession()
+	}
+}
+
+// SendLoops names the send loops the benchmarks compare.
+var SendLoops = []struct {
+	name    string
+	polling bool
+}{
+	{"blocking", false},
+	{"polling", true},
+}
+
+func BenchmarkSendRecv(b *testing.B) {
+	for _, loop := range SendLoops {
+		for _, name := range []string{"gob", "json", "binary"} {
+			b.Run(loop.name+"/"+name, func(b *testing.B) {
+				client, closeSession := StartTestSession(b, Codecs[name], loop.polling)
+				defer closeSession()
+				cpu, measured := ProcessCPUTime()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					Send(client.out, i)
+					Recv(client.in)
+				}
+				b.StopTimer()
+				ReportCPUTime(b, cpu, measured, b.N, "cpu-ns/op")
+			})
+		}
+	}
+}
+
+// BenchmarkIdleSession measures the CPU used by a session whose roles
+// have no messages to send, for 10ms each iteration.
+func BenchmarkIdleSession(b *testing.B) {
+	for _, loop := range SendLoops {
+		b.Run(loop.name, func(b *testing.B) {
+			_, closeSession := StartTestSession(b, Codecs["gob"], loop.polling)
+			defer closeSession()
+			cpu, measured := ProcessCPUTime()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			b.StopTimer()
+			ReportCPUTime(b, cpu, measured, b.N, "cpu-ns/10ms")
+		})
+	}
+}
+
+// ReportCPUTime reports the CPU time used by the process since start,
+// divided by n, where the platform can measure it.
+func ReportCPUTime(b *testing.B, start time.Duration, measured bool, n int, unit string) {
+	end, ok := ProcessCPUTime()
+	if measured && ok {
+		b.ReportMetric(float64(end-start)/float64(n), unit)
+	}
+}
@@ -0,0 +1,190 @@
+// The standard library packages may be referred to by their package
+// names in message parameters, as in Quote(time.Time) or
+// Total(*big.Int), without a type declaration giving their import
+// paths. StandardPackages maps each package name to the import paths of
+// the packages of the standard library which have it, as listed by go
+// list std less the internal, vendored and command packages. A name
+// shared by several packages, such as rand, is ambiguous, and its
+// package must still be imported by a type declaration.
+
+// input: the package name qualifying a name in a message parameter
+
+// output: the import paths of the standard library packages of that name
+
+package main
+
+var StandardPackages = map[string][]string{
+	"adler32":         {"hash/adler32"},
+	"aes":             {"crypto/aes"},
+	"ascii85":         {"encoding/ascii85"},
+	"asn1":            {"encoding/asn1"},
+	"ast":             {"go/ast"},
+	"atomic":          {"sync/atomic"},
+	"base32":          {"encoding/base32"},
+	"base64":          {"encoding/base64"},
+	"big":             {"math/big"},
+	"binary":          {"encoding/binary"},
+	"bits":            {"math/bits"},
+	"bufio":           {"bufio"},
+	"build":           {"go/build"},
+	"buildinfo":       {"debug/buildinfo"},
+	"bytes":           {"bytes"},
+	"bzip2":           {"compress/bzip2"},
+	"cgi":             {"net/http/cgi"},
+	"cgo":             {"runtime/cgo"},
+	"cipher":          {"crypto/cipher"},
+	"cmp":             {"cmp"},
+	"cmplx":           {"math/cmplx"},
+	"color":           {"image/color"},
+	"comment":         {"go/doc/comment"},
+	"constant":        {"go/constant"},
+	"constraint":      {"go/build/constraint"},
+	"context":         {"context"},
+	"cookiejar":       {"net/http/cookiejar"},
+	"coverage":        {"runtime/coverage"},
+	"crc32":           {"hash/crc32"},
+	"crc64":           {"hash/crc64"},
+	"crypto":          {"crypto"},
+	"cryptotest":      {"testing/cryptotest"},
+	"csv":             {"encoding/csv"},
+	"debug":           {"runtime/debug"},
+	"des":             {"crypto/des"},
+	"doc":             {"go/doc"},
+	"draw":            {"image/draw"},
+	"driver":          {"database/sql/driver"},
+	"dsa":             {"crypto/dsa"},
+	"dwarf":           {"debug/dwarf"},
+	"ecdh":            {"crypto/ecdh"},
+	"ecdsa":           {"crypto/ecdsa"},
+	"ed25519":         {"crypto/ed25519"},
+	"elf":             {"debug/elf"},
+	"elliptic":        {"crypto/elliptic"},
+	"embed":           {"embed"},
+	"encoding":        {"encoding"},
+	"errors":          {"errors"},
+	"exec":            {"os/exec"},
+	"expvar":          {"expvar"},
+	"fcgi":            {"net/http/fcgi"},
+	"filepath":        {"path/filepath"},
+	"fips140":         {"crypto/fips140"},
+	"flag":            {"flag"},
+	"flate":           {"compress/flate"},
+	"fmt":             {"fmt"},
+	"fnv":             {"hash/fnv"},
+	"format":          {"go/format"},
+	"fs":              {"io/fs"},
+	"fstest":          {"testing/fstest"},
+	"gif":             {"image/gif"},
+	"gob":             {"encoding/gob"},
+	"gosym":           {"debug/gosym"},
+	"gzip":            {"compress/gzip"},
+	"hash":            {"hash"},
+	"heap":            {"container/heap"},
+	"hex":             {"encoding/hex"},
+	"hkdf":            {"crypto/hkdf"},
+	"hmac":            {"crypto/hmac"},
+	"hpke":            {"crypto/hpke"},
+	"html":            {"html"},
+	"http":            {"net/http"},
+	"httptest":        {"net/http/httptest"},
+	"httptrace":       {"net/http/httptrace"},
+	"httputil":        {"net/http/httputil"},
+	"image":           {"image"},
+	"importer":        {"go/importer"},
+	"io":              {"io"},
+	"iotest":          {"testing/iotest"},
+	"ioutil":          {"io/ioutil"},
+	"iter":            {"iter"},
+	"jpeg":            {"image/jpeg"},
+	"json":            {"encoding/json", "encoding/json/v2"},
+	"jsonrpc":         {"net/rpc/jsonrpc"},
+	"jsontext":        {"encoding/json/jsontext"},
+	"list":            {"container/list"},
+	"log":             {"log"},
+	"lzw":             {"compress/lzw"},
+	"macho":           {"debug/macho"},
+	"mail":            {"net/mail"},
+	"maphash":         {"hash/maphash"},
+	"maps":            {"maps"},
+	"math":            {"math"},
+	"md5":             {"crypto/md5"},
+	"metrics":         {"runtime/metrics"},
+	"mime":            {"mime"},
+	"mldsa":           {"crypto/mldsa"},
+	"mlkem":           {"crypto/mlkem"},
+	"mlkemtest":       {"crypto/mlkem/mlkemtest"},
+	"multipart":       {"mime/multipart"},
+	"net":             {"net"},
+	"netip":           {"net/netip"},
+	"os":              {"os"},
+	"palette":         {"image/color/palette"},
+	"parse":           {"text/template/parse"},
+	"parser":          {"go/parser"},
+	"path":            {"path"},
+	"pbkdf2":          {"crypto/pbkdf2"},
+	"pe":              {"debug/pe"},
+	"pem":             {"encoding/pem"},
+	"pkix":            {"crypto/x509/pkix"},
+	"plan9obj":        {"debug/plan9obj"},
+	"plugin":          {"plugin"},
+	"png":             {"image/png"},
+	"pprof":           {"net/http/pprof", "runtime/pprof"},
+	"printer":         {"go/printer"},
+	"quick":           {"testing/quick"},
+	"quotedprintable": {"mime/quotedprintable"},
+	"race":            {"runtime/race"},
+	"rand":            {"crypto/rand", "math/rand", "math/rand/v2"},
+	"rc4":             {"crypto/rc4"},
+	"reflect":         {"reflect"},
+	"regexp":          {"regexp"},
+	"ring":            {"container/ring"},
+	"rpc":             {"net/rpc"},
+	"rsa":             {"crypto/rsa"},
+	"runtime":         {"runtime"},
+	"scanner":         {"go/scanner", "text/scanner"},
+	"sha1":            {"crypto/sha1"},
+	"sha256":          {"crypto/sha256"},
+	"sha3":            {"crypto/sha3"},
+	"sha512":          {"crypto/sha512"},
+	"signal":          {"os/signal"},
+	"slices":          {"slices"},
+	"slog":            {"log/slog"},
+	"slogtest":        {"testing/slogtest"},
+	"smtp":            {"net/smtp"},
+	"sort":            {"sort"},
+	"sql":             {"database/sql"},
+	"strconv":         {"strconv"},
+	"strings":         {"strings"},
+	"structs":         {"structs"},
+	"subtle":          {"crypto/subtle"},
+	"suffixarray":     {"index/suffixarray"},
+	"sync":            {"sync"},
+	"synctest":        {"testing/synctest"},
+	"syntax":          {"regexp/syntax"},
+	"syscall":         {"syscall"},
+	"syslog":          {"log/syslog"},
+	"tabwriter":       {"text/tabwriter"},
+	"tar":             {"archive/tar"},
+	"template":        {"html/template", "text/template"},
+	"testing":         {"testing"},
+	"textproto":       {"net/textproto"},
+	"time":            {"time"},
+	"tls":             {"crypto/tls"},
+	"token":           {"go/token"},
+	"trace":           {"runtime/trace"},
+	"types":           {"go/types"},
+	"tzdata":          {"time/tzdata"},
+	"unicode":         {"unicode"},
+	"unique":          {"unique"},
+	"url":             {"net/url"},
+	"user":            {"os/user"},
+	"utf16":           {"unicode/utf16"},
+	"utf8":            {"unicode/utf8"},
+	"uuid":            {"uuid"},
+	"version":         {"go/version"},
+	"weak":            {"weak"},
+	"x509":            {"crypto/x509"},
+	"xml":             {"encoding/xml"},
+	"zip":             {"archive/zip"},
+	"zlib":            {"compress/zlib"},
+}
@@ -10,7 +10,8 @@
 // each of the element types that make up the protocol. In order to
 // improve the efficiency of subsequent calculations, the translator then
 // generates a hashmap linking to allow constant time access to these
-// structures using their unique identifier strings.
+// structures using their unique identifier strings. Protocols which the
+// translator cannot handle are reported as Diagnostics.
 
 // input: struct tree descending from Protocol root node
 
@@ -21,7 +22,6 @@ package main
 
 import (
 	"errors"
-	"log"
 	"strconv"
 )
 
@@ -49,6 +49,8 @@ type MessageData struct {
 	MethodNameBase      string
 	Suffix              []string
 	Parameters          []string
+	ParameterTypes      []string
+	ParameterNames      []string
 	FromBase            string
 	ToBase              string
 	ChanName            string
@@ -62,6 +64,7 @@ type MessageData struct {
 	FollowingContinueId string
 	ContinueToStruct    string
 	InRecBlock          bool
+	Pos                 Position
 }
 
 type ChoiceData struct {
@@ -208,12 +211,13 @@ func SetMessagesData(c *Conversation, s *SequenceCountingData, m *MessagesData,
 				suffixCopy = append(suffixCopy, s)
 			}
 			mess := node.Mess
+			params := MangleTypeNames(mess.Types)
 			chanType := ""
-			if len(mess.Types) == 0 {
+			if len(params) == 0 {
 				chanType = "_Empty"
 				//chanType = "_bool"
 			} else {
-				for _, t := range mess.Types {
+				for _, t := range params {
 					chanType += "_" + t
 				}
 			}
@@ -228,7 +232,7 @@ func SetMessagesData(c *Conversation, s *SequenceCountingData, m *MessagesData,
 			if i == len(c.Nodes)-2 && c.Nodes[len(c.Nodes)-1].Cat == "continue" {
 				followingContinueId = c.Nodes[len(c.Nodes)-1].Cont.Id
 			}
-			newMessageData := &MessageData{UniqueId: mess.Id, Protagonist: mess.Protagonist, MethodNameBase: mess.Name, Suffix: suffixCopy, Parameters: mess.Types, FromBase: mess.From, ToBase: mess.To, ChanName: chanName, SeqCounter: messageCounter, IsChoiceOption: isChoiceOption, ChoiceChanName: choiceChanName, RecId: a.RecId, RecName: a.RecName, FollowingContinueId: followingContinueId, InRecBlock: a.InRecBlock}
+			newMessageData := &MessageData{UniqueId: mess.Id, Protagonist: mess.Protagonist, MethodNameBase: mess.Name, Suffix: suffixCopy, Parameters: params, ParameterTypes: CopyStringSlice(mess.Types), ParameterNames: GetParameterNames(mess), FromBase: mess.From, ToBase: mess.To, ChanName: chanName, SeqCounter: messageCounter, IsChoiceOption: isChoiceOption, ChoiceChanName: choiceChanName, RecId: a.RecId, RecName: a.RecName, FollowingContinueId: followingContinueId, InRecBlock: a.InRecBlock, Pos: mess.Pos}
 			m.Messages = append(m.Messages, newMessageData)
 		}
 	}
@@ -303,7 +307,8 @@ func GenerateContinueData(c *Conversation, s *SequenceCountingData, m *MessagesD
 		cont := potentialContinue.Cont
 		rec, err := GetRecDataWithName(cont.Name, m)
 		if err != nil {
-			log.Fatal(err)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(cont.Pos, "continue "+cont.Name+" does not refer to a rec block", "continue must name an enclosing rec block"))
+			return
 		}
 		recInParserTree := rec.RecInParserTree
 		continueData := &ContinueData{UniqueId: cont.Id, Name: cont.Name, Rec: recInParserTree}
@@ -317,23 +322,26 @@ func GetFirstStructNameFromRecData(rec *Rec, m *MessagesData) string {
 	if firstNode.Cat == "message" {
 		mess, err := GetMessageDataWithId(m, firstNode.Mess.Id)
 		if err != nil {
-			log.Fatal(err)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(rec.Pos, err.Error(), ""))
+			return name
 		}
 		name = mess.Protagonist + GetStringSliceAsString(AddUnderscoreSeparatorBetweenIntStrings(mess.Suffix))
 	} else if firstNode.Cat == "choice" {
 		choi, err := GetChoiceDataWithId(m, firstNode.Choice.Id)
 		if err != nil {
-			log.Fatal(err)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(rec.Pos, err.Error(), ""))
+			return name
 		}
 		name = choi.Protagonist + CutStringAfterLetter(choi.OptionSuffixes[0], "_")
 	} else if firstNode.Cat == "par" {
 		par, err := GetParallelDataWithId(m, firstNode.Par.Id)
 		if err != nil {
-			log.Fatal(err)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(rec.Pos, err.Error(), ""))
+			return name
 		}
 		name = par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_") + "ADDINGPARSUFFIX"
 	} else {
-		log.Fatal("Unknown cat " + firstNode.Cat + " encountered by GetFirstStructNameFromRecData")
+		m.Diagnostics = append(m.Diagnostics, NewDiagnostic(rec.Pos, "Unknown cat "+firstNode.Cat+" encountered by GetFirstStructNameFromRecData", ""))
 	}
 	return name
 }
@@ -341,9 +349,10 @@ func GetFirstStructNameFromRecData(rec *Rec, m *MessagesData) string {
 func TransContinues(c *Conversation, s *SequenceCountingData, m *MessagesData, a AffixData) {
 	potentialConv := c.Nodes[len(c.Nodes)-1]
 	if potentialConv.Cat == "continue" {
-		rec, err := GetRecDataWithName(potentialConv.Name, m)
+		rec, err := GetRecDataWithName(potentialConv.Cont.Name, m)
 		if err != nil {
-			log.Fatal(err)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(potentialConv.Cont.Pos, err.Error(), ""))
+			return
 		}
 		firstId := rec.FirstStructId
 		firstType := rec.FirstStructType
@@ -353,7 +362,8 @@ func TransContinues(c *Conversation, s *SequenceCountingData, m *MessagesData, a
 		if firstType == "message" {
 			mess, err := GetMessageDataWithId(m, firstId)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(potentialConv.Cont.Pos, err.Error(), ""))
+				return
 			}
 			firstStructName = mess.Protagonist + GetStringSliceAsString(mess.Suffix)
 			firstStructType = "message"
@@ -361,7 +371,8 @@ func TransContinues(c *Conversation, s *SequenceCountingData, m *MessagesData, a
 		} else if firstType == "choice" {
 			choi, err := GetChoiceDataWithId(m, firstId)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(potentialConv.Cont.Pos, err.Error(), ""))
+				return
 			}
 			firstStructName = choi.Protagonist + CutStringAfterLetter(choi.OptionSuffixes[0], "_")
 			firstStructType = "choice"
@@ -369,15 +380,17 @@ func TransContinues(c *Conversation, s *SequenceCountingData, m *MessagesData, a
 		} else if firstType == "parallel" {
 			par, err := GetParallelDataWithId(m, firstId)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(potentialConv.Cont.Pos, err.Error(), ""))
+				return
 			}
 			firstStructName = par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_")
 			firstStructType = "parallel"
 			firstStructId = par.UniqueId
 		} else {
-			log.Fatal("Unknown type " + firstType + " encountered in TransContinues()")
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(potentialConv.Cont.Pos, "Unknown type "+firstType+" encountered in TransContinues()", ""))
+			return
 		}
-		continueData := &ContinueData{UniqueId: potentialConv.Id, Name: potentialConv.Name, FirstStructName: firstStructName, FirstStructType: firstStructType, FirstStructId: firstStructId}
+		continueData := &ContinueData{UniqueId: potentialConv.Id, Name: potentialConv.Cont.Name, FirstStructName: firstStructName, FirstStructType: firstStructType, FirstStructId: firstStructId}
 		m.Continues = append(m.Continues, continueData)
 	}
 }
@@ -431,7 +444,7 @@ func TransRec(c *Conversation, s *SequenceCountingData, m *MessagesData, a Affix
 			newSuffix := []string{"_rec", strconv.Itoa(recCounter)}
 			recInParserTree := n.Rec
 			a.Suffixes = append(a.Suffixes, newSuffix...)
-			firstStructId, firstStructType := GetFirstRecStructId(n.Rec)
+			firstStructId, firstStructType := GetFirstRecStructId(n.Rec, m)
 			newRecData := &RecData{UniqueId: n.Rec.Id, Name: n.Rec.Name, RecInParserTree: recInParserTree, Protagonist: n.Rec.Protagonist, Suffix: newSuffix, AncestralRecId: a.RecId, AncestralRecName: a.RecName, FirstStructId: firstStructId, FirstStructType: firstStructType, InRecBlock: a.InRecBlock}
 			m.Recs = append(m.Recs, newRecData)
 			a.RecId = n.Rec.Id
@@ -445,7 +458,7 @@ func TransRec(c *Conversation, s *SequenceCountingData, m *MessagesData, a Affix
 	}
 }
 
-func GetFirstRecStructId(rec *Rec) (string, string) {
+func GetFirstRecStructId(rec *Rec, m *MessagesData) (string, string) {
 	firstNode := rec.Conv.Nodes[0]
 	if firstNode.Cat == "message" {
 		return firstNode.Mess.Id, "message"
@@ -456,7 +469,7 @@ func GetFirstRecStructId(rec *Rec) (string, string) {
 	} else if firstNode.Cat == "rec" {
 		return firstNode.Rec.Id, "rec"
 	} else {
-		log.Fatal("Unknown Cat encountered in GetFirstRecStruct(): ", firstNode.Cat)
+		m.Diagnostics = append(m.Diagnostics, NewDiagnostic(rec.Pos, "Unknown Cat encountered in GetFirstRecStruct(): "+firstNode.Cat, ""))
 	}
 	return "", ""
 }
@@ -506,7 +519,8 @@ func SetSequence(c *Conversation, s *SequenceCountingData, m *MessagesData, a Af
 		if n.Cat == "message" {
 			messageData, err := GetMessageDataWithId(m, n.Mess.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Mess.Pos, err.Error(), ""))
+				return
 			}
 			if IsFinalElementInParallel(messageData, subsequentSuffix) {
 				endParSuffix := []string{messageData.Protagonist}
@@ -521,7 +535,8 @@ func SetSequence(c *Conversation, s *SequenceCountingData, m *MessagesData, a Af
 		if n.Cat == "par" {
 			parallelData, err := GetParallelDataWithId(m, n.Par.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Par.Pos, err.Error(), ""))
+				return
 			}
 			parallelData.SubsequentSuffix = CopyStringSlice(subsequentSuffix)
 			new := []string{"_par", strconv.Itoa(parCounter), "_start"}
@@ -541,7 +556,8 @@ func SetSequence(c *Conversation, s *SequenceCountingData, m *MessagesData, a Af
 			} else if typeFollowing == "choice" {
 				additionalSuffix = "_choice1"
 			} else {
-				log.Fatal("Unknown type encountered in SetSequence(): ", typeFollowing)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Rec.Pos, "Unknown type encountered in SetSequence(): "+typeFollowing, ""))
+				return
 			}
 			new := []string{"_rec", strconv.Itoa(recCounter) + additionalSuffix}
 			currentSuffix = append(a.Suffixes, new...)
@@ -688,7 +704,8 @@ func SetConversationData(c *Conversation, s *SequenceCountingData, m *MessagesDa
 		} else if node.Cat == "continue" {
 			element = &ConversationElementData{Type: "continue", UniqueId: node.Cont.Id}
 		} else {
-			log.Fatal("Unknown Cat encountered in SetConversationData(): " + node.Cat)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(c.Pos, "Unknown Cat encountered in SetConversationData(): "+node.Cat, ""))
+			return
 		}
 		convElements = append(convElements, element)
 	}
@@ -738,13 +755,74 @@ func TransTree(p *Protocol) *MessagesData {
 	m.Conversations = make([]*ConversationData, 0)
 	m.Recs = make([]*RecData, 0)
 	m.Continues = make([]*ContinueData, 0)
+	if p.Mod != nil {
+		m.Module = p.Mod.Name
+	}
+	if len(p.Locals) > 0 {
+		m.Protocol = p.Locals[0].Name
+		m.Local = p.Locals[0]
+	}
 	for _, loc := range p.Locals {
 		l := loc
-		TransLocal(l, m)
+		diagnosticsCounter := len(m.Diagnostics)
+		CheckTranslatableLocal(l, m)
+		if len(m.Diagnostics) == diagnosticsCounter {
+			TransLocal(l, m)
+		}
 	}
 	return m
 }
 
+// CheckTranslatableLocal reports the shapes of protocol for which the
+// translator is unable to generate code, before translation begins.
+func CheckTranslatableLocal(l *Local, m *MessagesData) {
+	if !NodesHaveInteractions(l.Conv.Nodes) {
+		m.Diagnostics = append(m.Diagnostics, NewDiagnostic(l.Pos, "Local protocol "+l.Name+" at "+l.Protagonist+" contains no messages", ""))
+		return
+	}
+	CheckTranslatableConversation(l.Conv, m)
+}
+
+func CheckTranslatableBranches(convs []*Conversation, block string, m *MessagesData) {
+	for _, conv := range convs {
+		CheckTranslatableConversation(conv, m)
+		if len(conv.Nodes) > 0 {
+			firstCat := conv.Nodes[0].Cat
+			if firstCat != "message" && firstCat != "choice" && firstCat != "par" {
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(conv.Pos, "Branch of "+block+" block begins with "+firstCat, "each branch must begin with a message, choice or par"))
+			}
+		}
+	}
+}
+
+func CheckTranslatableConversation(c *Conversation, m *MessagesData) {
+	if len(c.Nodes) == 0 {
+		m.Diagnostics = append(m.Diagnostics, NewDiagnostic(c.Pos, "Empty block", "every block must contain at least one message"))
+		return
+	}
+	for i, n := range c.Nodes {
+		if n.Cat == "continue" {
+			if i != len(c.Nodes)-1 {
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Cont.Pos, "continue "+n.Cont.Name+" is followed by further instructions", "continue must be the last instruction in its block"))
+			}
+		} else if n.Cat == "do" {
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Do.Pos, "Sub-protocol invocation \"do "+n.Do.Name+"\" was not resolved", ""))
+		} else if n.Cat == "rec" {
+			CheckTranslatableConversation(n.Rec.Conv, m)
+			if len(n.Rec.Conv.Nodes) > 0 {
+				firstCat := n.Rec.Conv.Nodes[0].Cat
+				if firstCat != "message" && firstCat != "choice" {
+					m.Diagnostics = append(m.Diagnostics, NewDiagnostic(n.Rec.Pos, "rec block "+n.Rec.Name+" begins with "+firstCat, "a rec block must begin with a message or a choice"))
+				}
+			}
+		} else if n.Cat == "choice" {
+			CheckTranslatableBranches(n.Choice.Convs, "choice", m)
+		} else if n.Cat == "par" {
+			CheckTranslatableBranches(n.Par.Convs, "par", m)
+		}
+	}
+}
+
 func SetParallelGoToIfBranchEnds(parallel *Parallel, m *MessagesData, w WhereToIfBranchEnds) {
 	for _, conv := range parallel.Convs {
 		SetConvGoToIfBranchEnds(conv, m, w)
@@ -773,7 +851,8 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 			if isBranchEnd {
 				messageData, err := GetMessageDataWithId(m, node.Mess.Id)
 				if err != nil {
-					log.Fatal(err)
+					m.Diagnostics = append(m.Diagnostics, NewDiagnostic(node.Mess.Pos, err.Error(), ""))
+					return
 				}
 				messageData.WhereToIfBranchEnds = w
 			}
@@ -783,7 +862,8 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 		} else if node.Cat == "choice" {
 			choiceData, err := GetChoiceDataWithId(m, node.Choice.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(node.Choice.Pos, err.Error(), ""))
+				return
 			}
 			choiceData.WhereToIfBranchEnds = next
 			id = choiceData.UniqueId
@@ -792,7 +872,8 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 		} else if node.Cat == "par" {
 			par, err := GetParallelDataWithId(m, node.Par.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(node.Par.Pos, err.Error(), ""))
+				return
 			}
 			if isBranchEnd {
 				par.WhereToIfBranchEnds = w
@@ -804,7 +885,8 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 		} else if node.Cat == "rec" {
 			rec, err := GetRecDataWithId(m, node.Rec.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(node.Rec.Pos, err.Error(), ""))
+				return
 			}
 			if isBranchEnd {
 				rec.WhereToIfBranchEnds = next
@@ -815,12 +897,14 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 		} else if node.Cat == "continue" {
 			cont, err := GetContinueDataWithId(m, node.Cont.Id)
 			if err != nil {
-				log.Fatal(err)
+				m.Diagnostics = append(m.Diagnostics, NewDiagnostic(node.Cont.Pos, err.Error(), ""))
+				return
 			}
 			id = cont.UniqueId
 			typ = "continue"
 		} else {
-			log.Fatal("Error, unknown Node.Cat encountered in SetConvGoToIfBranchEnds(): " + node.Cat)
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(conv.Pos, "Error, unknown Node.Cat encountered in SetConvGoToIfBranchEnds(): "+node.Cat, ""))
+			return
 		}
 		next.Id = id
 		next.Type = typ
@@ -828,6 +912,9 @@ func SetConvGoToIfBranchEnds(conv *Conversation, m *MessagesData, w WhereToIfBra
 }
 
 func SetGoToIfBranchEnds(p *Protocol, m *MessagesData) {
+	if len(p.Locals) == 0 {
+		return
+	}
 	w := WhereToIfBranchEnds{}
 	firstConv := p.Locals[0].Conv
 	SetConvGoToIfBranchEnds(firstConv, m, w)
@@ -922,13 +1009,27 @@ type MessagesData struct {
 	RecNameMap      map[string]*RecData
 	ContinueMap     map[string]*ContinueData
 	MapsGenerated   bool
+	TypeImports     []*TypeImport
+	CustomTypes     []string
+	Module          string
+	Protocol        string
+	Local           *Local
+	TypeMap         map[string]*TypeData
+	Diagnostics     Diagnostics
 }
 
-func TranslateTree(p *Protocol) *MessagesData {
+func TranslateTree(p *Protocol) (*MessagesData, error) {
 	m := TransTree(p)
+	ResolveParameterTypes(p, m)
+	if len(m.Diagnostics) > 0 {
+		return m, m.Diagnostics
+	}
 	SetGoToIfBranchEnds(p, m)
 	SetContinueTos(p, m)
+	if len(m.Diagnostics) > 0 {
+		return m, m.Diagnostics
+	}
 	AddSeparatorUnderscoresToMessageSuffixes(m)
 	GenerateHashMaps(m)
-	return m
+	return m, nil
 }
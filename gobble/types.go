@@ -0,0 +1,331 @@
+// Message parameters in a Scribble protocol name the types of the values
+// that are sent. A parameter may be a Go built-in type such as int or
+// string, or an alias introduced by a type declaration of the form
+//
+//	type <go> "travel.Itinerary" from "github.com/acme/travel" as Itinerary;
+//
+// which makes Itinerary stand for the Go type travel.Itinerary in
+// package github.com/acme/travel. The source may also be given in full,
+// as "github.com/acme/travel.Itinerary", or unqualified, in which case
+// it is qualified with the last element of the import path. Only the
+// <go> schema is supported. A parameter may also be any Go type
+// expression built from these, such as []Fare, map[string]int or *Fare;
+// aliases are resolved wherever they appear within it. A qualified name
+// such as time.Duration or big.Int refers to the standard library
+// package of that name; a name in any other package may be used only if
+// a type declaration imports its package, since the package name alone
+// does not give the path. As type expressions are not legal in Go
+// identifiers, each parameter is mangled into a name for use in the
+// names of generated methods, structs and channels ([]time.Time becomes
+// SliceOfTimeTime, map[string]int becomes MapOfStringToInt); names which
+// are already identifiers are left as they are. The Go types the parameters stand for are recorded alongside
+// these names, together with the packages the generated code must import.
+// A parameter may be given a name, as in RequestItinerary(destination:
+// string, passengers: int); the name is then used for the parameter of
+// the send method, the field of the payload struct and the variable the
+// receive method returns. Unnamed parameters are called param1, param2
+// and so on by their position.
+
+// input: tree of structs descending from a Protocol root node and the
+// MessagesData produced from it by the translator
+
+// output: MessagesData with the Go type of every message parameter and
+// the imports they require
+
+package main
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type TypeImport struct {
+	Name string
+	Path string
+}
+
+type TypeData struct {
+	Alias  string
+	GoType string
+	Import *TypeImport
+}
+
+// GetTypeData works out the Go type expression for a type declaration
+// and the import, if any, which it requires.
+func GetTypeData(typ *Type) *TypeData {
+	source := typ.Source
+	importPath := typ.FileName
+	lastSlash := strings.LastIndex(source, "/")
+	if lastSlash >= 0 {
+		// A fully qualified source names its own package
+		lastDot := strings.LastIndex(source, ".")
+		if importPath == "" && lastDot > lastSlash {
+			importPath = source[:lastDot]
+		}
+		source = source[lastSlash+1:]
+	}
+	if importPath == "" {
+		return &TypeData{Alias: typ.Alias, GoType: source}
+	}
+	name := path.Base(importPath)
+	if dot := strings.Index(source, "."); dot >= 0 {
+		name = source[:dot]
+	} else {
+		source = name + "." + source
+	}
+	imp := &TypeImport{Name: name, Path: importPath}
+	return &TypeData{Alias: typ.Alias, GoType: source, Import: imp}
+}
+
+func GetTypeDataMap(types []*Type, m *MessagesData) map[string]*TypeData {
+	typeMap := make(map[string]*TypeData)
+	declared := make(map[string]Position)
+	for _, typ := range types {
+		if typ.Schema != "go" {
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(typ.Pos, "Type "+typ.Alias+" is declared with schema <"+typ.Schema+">", "Gobble generates Go; declare the type as type <go> \"pkg.Type\" from \"import/path\" as "+typ.Alias+";"))
+			continue
+		}
+		if pos, ok := declared[typ.Alias]; ok {
+			m.Diagnostics = append(m.Diagnostics, NewDiagnostic(typ.Pos, "Type "+typ.Alias+" is declared more than once", "previous declaration at "+pos.String()))
+			continue
+		}
+		declared[typ.Alias] = typ.Pos
+		typeMap[typ.Alias] = GetTypeData(typ)
+	}
+	return typeMap
+}
+
+// CapitaliseFirstLetter upper-cases the first letter of s.
+func CapitaliseFirstLetter(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// GetClosingBracketIndex returns the index of the bracket closing the
+// one which opens typ, or -1 if it is not closed.
+func GetClosingBracketIndex(typ string) int {
+	depth := 0
+	for i, r := range typ {
+		if r == '[' || r == '(' || r == '{' {
+			depth++
+		} else if r == ']' || r == ')' || r == '}' {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// MangleTypeName turns a Go type expression into a string which may be
+// used within a Go identifier.
+func MangleTypeName(typ string) string {
+	typ = strings.TrimSpace(typ)
+	if IsIdentifier(typ) {
+		return typ
+	}
+	if strings.HasPrefix(typ, "[]") {
+		return "SliceOf" + CapitaliseFirstLetter(MangleTypeName(typ[2:]))
+	}
+	if strings.HasPrefix(typ, "[") {
+		end := GetClosingBracketIndex(typ)
+		if end > 0 {
+			return "ArrayOf" + MangleTypeName(typ[1:end]) + CapitaliseFirstLetter(MangleTypeName(typ[end+1:]))
+		}
+	}
+	if strings.HasPrefix(typ, "map[") {
+		end := GetClosingBracketIndex(typ[3:]) + 3
+		if end > 3 {
+			return "MapOf" + CapitaliseFirstLetter(MangleTypeName(typ[4:end])) + "To" + CapitaliseFirstLetter(MangleTypeName(typ[end+1:]))
+		}
+	}
+	if strings.HasPrefix(typ, "*") {
+		return "PtrTo" + CapitaliseFirstLetter(MangleTypeName(typ[1:]))
+	}
+	if strings.HasPrefix(typ, "chan ") {
+		return "ChanOf" + CapitaliseFirstLetter(MangleTypeName(typ[5:]))
+	}
+	// Qualified names and anything else: drop punctuation, starting each
+	// word with a capital letter, so that time.Time becomes TimeTime
+	mangled := ""
+	capitaliseNext := true
+	for _, r := range typ {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if capitaliseNext {
+				r = unicode.ToUpper(r)
+			}
+			mangled += string(r)
+			capitaliseNext = false
+		} else {
+			capitaliseNext = true
+		}
+	}
+	return mangled
+}
+
+func MangleTypeNames(types []string) []string {
+	mangled := make([]string, 0)
+	for _, typ := range types {
+		mangled = append(mangled, MangleTypeName(typ))
+	}
+	return mangled
+}
+
+// GetParameterNames returns the name of each parameter of a message, or
+// paramN for the Nth parameter where it has not been named.
+func GetParameterNames(mess *Message) []string {
+	names := make([]string, 0)
+	for i := range mess.Types {
+		if i < len(mess.Names) && mess.Names[i] != "" {
+			names = append(names, mess.Names[i])
+		} else {
+			names = append(names, "param"+strconv.Itoa(i+1))
+		}
+	}
+	return names
+}
+
+// GetDeclaredImport returns the import of the package called name made by
+// a type declaration, or nil if there is none.
+func GetDeclaredImport(name string, typeMap map[string]*TypeData) *TypeImport {
+	aliases := make([]string, 0)
+	for alias := range typeMap {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if imp := typeMap[alias].Import; imp != nil && imp.Name == name {
+			return imp
+		}
+	}
+	return nil
+}
+
+// GetStandardImport returns the import of the standard library package
+// called name, or nil if there is none or the name is shared by several.
+func GetStandardImport(name string) *TypeImport {
+	paths := StandardPackages[name]
+	if len(paths) != 1 {
+		return nil
+	}
+	return &TypeImport{Name: name, Path: paths[0]}
+}
+
+// ResolveTypeExpression replaces the aliases within a type expression
+// by the Go types they stand for, and returns the packages which the
+// expression refers to, the Go types of the aliases it uses and the
+// qualifiers of names in packages which neither a type declaration nor
+// the standard library provides.
+func ResolveTypeExpression(typ string, typeMap map[string]*TypeData) (string, []*TypeImport, []string, []string) {
+	resolved := ""
+	imports := make([]*TypeImport, 0)
+	aliasTypes := make([]string, 0)
+	undeclared := make([]string, 0)
+	runes := []rune(typ)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && runes[i] != '_' {
+			resolved += string(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+			j++
+		}
+		word := string(runes[i:j])
+		isQualifier := j < len(runes) && runes[j] == '.'
+		isQualified := i > 0 && runes[i-1] == '.'
+		if typeData, ok := typeMap[word]; ok && !isQualifier && !isQualified {
+			resolved += typeData.GoType
+			if typeData.Import != nil {
+				imports = append(imports, typeData.Import)
+			}
+			aliasTypes = append(aliasTypes, typeData.GoType)
+		} else {
+			resolved += word
+			if isQualifier && !isQualified {
+				if imp := GetDeclaredImport(word, typeMap); imp != nil {
+					imports = append(imports, imp)
+				} else if imp := GetStandardImport(word); imp != nil {
+					imports = append(imports, imp)
+				} else {
+					undeclared = append(undeclared, word)
+				}
+			}
+		}
+		i = j
+	}
+	return resolved, imports, aliasTypes, undeclared
+}
+
+// ResolveParameterTypes records the Go type of each parameter of each
+// message, and the packages which must be imported for them, in m.
+func ResolveParameterTypes(p *Protocol, m *MessagesData) {
+	typeMap := GetTypeDataMap(p.Types, m)
+	m.TypeMap = typeMap
+	importMap := make(map[string]bool)
+	customMap := make(map[string]bool)
+	m.TypeImports = make([]*TypeImport, 0)
+	m.CustomTypes = make([]string, 0)
+	for _, mess := range m.Messages {
+		goTypes := make([]string, 0)
+		for _, typ := range mess.ParameterTypes {
+			goType, imports, aliasTypes, undeclared := ResolveTypeExpression(typ, typeMap)
+			goTypes = append(goTypes, goType)
+			for _, pkg := range undeclared {
+				m.Diagnostics = append(m.Diagnostics, GetUndeclaredPackageDiagnostic(mess, typ, pkg))
+			}
+			for _, aliasType := range aliasTypes {
+				if !customMap[aliasType] {
+					customMap[aliasType] = true
+					m.CustomTypes = append(m.CustomTypes, aliasType)
+				}
+			}
+			for _, imp := range imports {
+				if !importMap[imp.Path] {
+					importMap[imp.Path] = true
+					m.TypeImports = append(m.TypeImports, imp)
+				}
+			}
+		}
+		mess.ParameterTypes = goTypes
+	}
+}
+
+// GetUndeclaredPackageDiagnostic reports that parameter type typ of mess
+// refers to package pkg, whose import path is not known.
+func GetUndeclaredPackageDiagnostic(mess *MessageData, typ string, pkg string) *Diagnostic {
+	message := "Parameter type " + typ + " of message " + mess.MethodNameBase + " refers to package " + pkg
+	if paths := StandardPackages[pkg]; len(paths) > 1 {
+		message += ", which names more than one standard library package (" + strings.Join(paths, ", ") + ")"
+		return NewDiagnostic(mess.Pos, message, "say which is meant with a type declaration, e.g. type <go> \""+pkg+".Name\" from \""+paths[0]+"\" as Name;")
+	}
+	message += ", which is not in the standard library and which no type declaration imports"
+	return NewDiagnostic(mess.Pos, message, "declare the type with the import path of "+pkg+", e.g. type <go> \""+pkg+".Name\" from \"<import path of "+pkg+">\" as Name;")
+}
+
+// GetTypeImportsForString returns the import specs for those of the
+// imported packages that are referred to in s and have not already been
+// imported.
+func GetTypeImportsForString(s string, typeImports []*TypeImport, imported map[string]bool) string {
+	imports := ""
+	for _, imp := range typeImports {
+		if UsesPackage(s, imp.Name) && !imported[imp.Path] {
+			imported[imp.Path] = true
+			imports += "\t"
+			if imp.Name != path.Base(imp.Path) {
+				imports += imp.Name + " "
+			}
+			imports += "\"" + imp.Path + "\"\n"
+		}
+	}
+	return imports
+}
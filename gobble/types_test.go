@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMangleTypeName(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"int", "int"},
+		{"Fare", "Fare"},
+		{"[]Fare", "SliceOfFare"},
+		{"[]time.Time", "SliceOfTimeTime"},
+		{"[4]int", "ArrayOf4Int"},
+		{"map[string]int", "MapOfStringToInt"},
+		{"map[string][]int", "MapOfStringToSliceOfInt"},
+		{"*Fare", "PtrToFare"},
+		{"chan int", "ChanOfInt"},
+		{"time.Time", "TimeTime"},
+		{"*big.Int", "PtrToBigInt"},
+		{"map[string]time.Time", "MapOfStringToTimeTime"},
+	}
+	for _, test := range tests {
+		if got := MangleTypeName(test.typ); got != test.want {
+			t.Errorf("MangleTypeName(%q) = %q, want %q", test.typ, got, test.want)
+		}
+	}
+}
+
+func TranslateTestSource(t *testing.T, types string, message string) (*MessagesData, error) {
+	t.Helper()
+	source := "module M;\n\n" + types + "\nlocal protocol P at A(role A, role B) {\n\t" + message + "\n}\n"
+	tree, err := ParseTestSource(source)
+	if err != nil {
+		t.Fatalf("unexpected error parsing source: %v", err)
+	}
+	return TranslateTree(GetLocalProtocols(tree)[0])
+}
+
+func TestResolveParameterTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   string
+		message string
+		want    string
+		imports []string
+		wantErr string
+	}{
+		{
+			name:    "built-in",
+			message: "m(map[string][]int) to B;",
+			want:    "map[string][]int",
+			imports: []string{},
+		},
+		{
+			name:    "alias",
+			types:   "type <go> \"travel.Fare\" from \"github.com/acme/travel\" as Fare;",
+			message: "m([]Fare) to B;",
+			want:    "[]travel.Fare",
+			imports: []string{"github.com/acme/travel"},
+		},
+		{
+			name:    "package of a declared type",
+			types:   "type <go> \"time.Time\" from \"time\" as Time;",
+			message: "m(time.Duration) to B;",
+			want:    "time.Duration",
+			imports: []string{"time"},
+		},
+		{
+			name:    "package named differently from its path",
+			types:   "type <go> \"json.RawMessage\" from \"encoding/json\" as Raw;",
+			message: "m([]json.RawMessage) to B;",
+			want:    "[]json.RawMessage",
+			imports: []string{"encoding/json"},
+		},
+		{
+			name:    "standard library package",
+			message: "m(map[string]time.Time) to B;",
+			want:    "map[string]time.Time",
+			imports: []string{"time"},
+		},
+		{
+			name:    "standard library package named differently from its path",
+			message: "m(*big.Int, url.URL) to B;",
+			want:    "*big.Int",
+			imports: []string{"math/big", "net/url"},
+		},
+		{
+			name:    "undeclared package",
+			message: "m(acme.Fare) to B;",
+			wantErr: "test.scr:5:2: Parameter type acme.Fare of message m refers to package acme, which is not in the standard library and which no type declaration imports\n\thint: declare the type with the import path of acme, e.g. type <go> \"acme.Name\" from \"<import path of acme>\" as Name;",
+		},
+		{
+			name:    "ambiguous standard library package",
+			message: "m(*rand.Rand) to B;",
+			wantErr: "test.scr:5:2: Parameter type *rand.Rand of message m refers to package rand, which names more than one standard library package (crypto/rand, math/rand, math/rand/v2)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := TranslateTestSource(t, test.types, test.message)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Messages[0].ParameterTypes[0]; got != test.want {
+				t.Errorf("got type %q, want %q", got, test.want)
+			}
+			paths := make([]string, 0)
+			for _, imp := range m.TypeImports {
+				paths = append(paths, imp.Path)
+			}
+			if strings.Join(paths, " ") != strings.Join(test.imports, " ") {
+				t.Errorf("got imports %v, want %v", paths, test.imports)
+			}
+		})
+	}
+}
+
+func TestGetTypeData(t *testing.T) {
+	tests := []struct {
+		source   string
+		fileName string
+		goType   string
+		name     string
+		path     string
+	}{
+		{source: "int", goType: "int"},
+		{source: "Fare", fileName: "github.com/acme/travel", goType: "travel.Fare", name: "travel", path: "github.com/acme/travel"},
+		{source: "travel.Fare", fileName: "github.com/acme/travel", goType: "travel.Fare", name: "travel", path: "github.com/acme/travel"},
+		{source: "github.com/acme/travel.Fare", goType: "travel.Fare", name: "travel", path: "github.com/acme/travel"},
+		{source: "json.RawMessage", fileName: "encoding/json", goType: "json.RawMessage", name: "json", path: "encoding/json"},
+		{source: "yaml.Node", fileName: "gopkg.in/yaml.v3", goType: "yaml.Node", name: "yaml", path: "gopkg.in/yaml.v3"},
+	}
+	for _, test := range tests {
+		data := GetTypeData(&Type{Schema: "go", Source: test.source, FileName: test.fileName, Alias: "T"})
+		if data.GoType != test.goType {
+			t.Errorf("%s from %q: got Go type %q, want %q", test.source, test.fileName, data.GoType, test.goType)
+		}
+		if test.path == "" {
+			if data.Import != nil {
+				t.Errorf("%s: got import %+v, want none", test.source, data.Import)
+			}
+		} else if data.Import == nil || data.Import.Name != test.name || data.Import.Path != test.path {
+			t.Errorf("%s from %q: got import %+v, want %s %q", test.source, test.fileName, data.Import, test.name, test.path)
+		}
+	}
+}
+
+func TestGetTypeDataMapErrors(t *testing.T) {
+	m := &MessagesData{}
+	types := []*Type{
+		{Schema: "go", Source: "int", Alias: "Count"},
+		{Schema: "java", Source: "java.lang.Integer", Alias: "Integer"},
+		{Schema: "go", Source: "int64", Alias: "Count"},
+	}
+	typeMap := GetTypeDataMap(types, m)
+	if typeMap["Count"] == nil || typeMap["Count"].GoType != "int" {
+		t.Errorf("got %+v for Count, want the first declaration", typeMap["Count"])
+	}
+	err := m.Diagnostics.Err()
+	for _, want := range []string{"Type Integer is declared with schema <java>", "Type Count is declared more than once"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want one containing %q", err, want)
+		}
+	}
+}
+
+func TestGetTypeImportsForString(t *testing.T) {
+	imports := []*TypeImport{{Name: "travel", Path: "github.com/acme/travel"}, {Name: "yaml", Path: "gopkg.in/yaml.v3"}, {Name: "time", Path: "time"}}
+	imported := map[string]bool{"time": true}
+	got := GetTypeImportsForString("x travel.Fare\ny yaml.Node\nz time.Time\nw mytravel.Fare\n", imports, imported)
+	want := "\t\"github.com/acme/travel\"\n\tyaml \"gopkg.in/yaml.v3\"\n"
+	if got != want {
+		t.Errorf("got imports\n%s\nwant\n%s", got, want)
+	}
+	if got := GetTypeImportsForString("mytravel.Fare", imports[:1], map[string]bool{}); got != "" {
+		t.Errorf("got imports %q for a package whose name only ends in travel", got)
+	}
+}
+
+func TestTranslateDeclaredTypes(t *testing.T) {
+	m, err := TranslateTestSource(t, "type <go> \"Fare\" from \"github.com/acme/travel\" as Fare;\ntype <go> \"int\" from \"\" as Count;", "m(fare: Fare, n: Count) to B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(m.Messages[0].ParameterTypes, ", "); got != "travel.Fare, int" {
+		t.Errorf("got parameter types %q", got)
+	}
+	if got := strings.Join(m.Messages[0].ParameterNames, ", "); got != "fare, n" {
+		t.Errorf("got parameter names %q", got)
+	}
+}
+
+func TestGenerateStandardLibraryTypes(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is needed to build the generated code")
+	}
+	runtimeDir, err := filepath.Abs("runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOBBLE_RUNTIME", runtimeDir)
+	dir := ChdirTestTemp(t)
+	source := "module M;\n\ntype <go> \"float64\" from \"\" as Fare;\n\nlocal protocol P at A(role A, role B) {\n\tQuote([]Fare, map[string]int, time.Time) to B;\n}\n"
+	name := WriteTestFile(t, "test.scr", source)
+	err = ProcessFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(goTool, "build", "-o", filepath.Join(dir, "bin"), ".")
+	cmd.Dir = filepath.Join(dir, "output", "Protocol_Gobble")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated code does not build: %v\n%s", err, out)
+	}
+}
@@ -27,6 +27,9 @@ import (
 
 func WriteMainFunctionHeader(t *Translation, m *MessagesData) {
 	header := "func main() {\n"
+	if t.Handler != "" {
+		header = "func Run" + GetProtagonist(m) + "(handler " + t.Handler + ") {\n"
+	}
 	t.Main += header
 }
 
@@ -44,7 +47,7 @@ func WriteMainFunctionLaunchParty(t *Translation, m *MessagesData) {
 	launch := "\tnewWg.Add(1)\n"
 	launch += "\tgo run"
 	launch += m.Conversations[0].Protagonist
-	launch += "(&newWg, startStruct)\n"
+	launch += "(&newWg, startStruct" + GetHandlerArg(t.Handler) + ")\n"
 	t.Main += launch
 }
 
@@ -61,16 +64,32 @@ func WriteMainFunctionGetStartStruct(t *Translation, m *MessagesData) {
 	t.Main += line
 }
 
-func WriteMainFunctionSetNetworkVariables(t *Translation) {
+// WriteMainFunctionSetNetworkVariables writes the loading of the endpoint
+// of each role the protagonist talks to and of the codec, defaulting to
+// those given to Gobble.
+func WriteMainFunctionSetNetworkVariables(t *Translation, m *MessagesData) {
 	variables := ""
 	variables += "\tconnType := \"" + t.NetConnType + "\"\n"
-	variables += "\taddress := \"" + t.NetConnAddress + "\"\n"
-	variables += "\tport := \"" + t.NetConnPort + "\"\n"
+	variables += "\tendpoints, err := runtime.LoadEndpoints(runtime.Endpoints{"
+	for i, role := range GetNetworkRoles(m) {
+		if i > 0 {
+			variables += ", "
+		}
+		variables += "\"" + role + "\": \"" + GetEndpoint(t.NetConfig, role) + "\""
+	}
+	variables += "})\n"
+	variables += "\tif err != nil {\n"
+	variables += "\t\tlog.Fatal(err)\n"
+	variables += "\t}\n"
+	variables += "\tcodec, err := runtime.LoadCodec(\"" + GetCodecName(t.NetConfig) + "\")\n"
+	variables += "\tif err != nil {\n"
+	variables += "\t\tlog.Fatal(err)\n"
+	variables += "\t}\n"
 	t.Main += variables
 }
 
 func WriteMainFunctionSetupNetworkConnection(t *Translation) {
-	line := "\tSetupNetworkConnections(chans, connType, address, port)\n"
+	line := "\tSetupNetworkConnections(chans, connType, endpoints, codec)\n"
 	t.Main += line
 }
 
@@ -83,7 +102,7 @@ func WriteMainFunction(t *Translation, m *MessagesData) {
 	WriteMainFunctionHeader(t, m)
 	WriteMainFunctionChannelDef(t)
 	if t.HasNetConn {
-		WriteMainFunctionSetNetworkVariables(t)
+		WriteMainFunctionSetNetworkVariables(t, m)
 		WriteMainFunctionSetupNetworkConnection(t)
 	}
 	WriteMainFunctionGetStartStruct(t, m)
@@ -236,8 +255,13 @@ func WriteParallelFunctionHeader(t *Translation, parallelData *ParallelData, m *
 	header += "("
 	self := parallelData.Protagonist + CutStringAfterLetter(parallelData.OptionSuffixes[0], "_") + "_start"
 	header += self + " *" + self
+	header += GetHandlerParam(t.Handler)
 	header += ") ("
-	header += "interface{}, "
+	if next := GetParSubsequentStructName(parallelData); next != "" {
+		header += "*" + next + ", "
+	} else {
+		header += GetNextName(parallelData.Protagonist) + ", "
+	}
 	header += "error"
 	header += ") {\n"
 	t.Functions += header
@@ -263,7 +287,7 @@ func WriteParGoRoutine(t *Translation, nameBase string, index int, paramVal stri
 	routine := "\tnewWg.Add(1)\n"
 	routine += "\tgo run" + nameBase + GetNextLetter(index)
 	routine += "(" + paramVal
-	routine += ", &newWg)\n"
+	routine += ", &newWg" + GetHandlerArg(t.Handler) + ")\n"
 	t.Functions += routine
 }
 
@@ -294,7 +318,7 @@ func WriteParallelFunctionReturn(t *Translation, parallelData *ParallelData, m *
 		ret += parallelData.Protagonist + GetStringSliceAsString(parallelData.SubsequentSuffix) + ", "
 	}
 	if ret == originalReturn {
-		ret += "\"\", "
+		ret += "&" + GetEndName(parallelData.Protagonist) + "{}, "
 	}
 	ret += "nil"
 	ret += "\n"
@@ -334,8 +358,9 @@ func WriteParallelFunctions(t *Translation, m *MessagesData) {
 func WriteChoiceFunctionHeader(t *Translation, choiceData *ChoiceData, m *MessagesData) {
 	header := "func Make_" + choiceData.Protagonist + CutStringAfterLetter(choiceData.OptionSuffixes[0], "_") + "_Choices("
 	header += choiceData.Protagonist + CutStringAfterLetter(choiceData.OptionSuffixes[0], "_") + " *" + choiceData.Protagonist + CutStringAfterLetter(choiceData.OptionSuffixes[0], "_")
+	header += GetHandlerParam(t.Handler)
 	header += ") ("
-	header += "interface{}, "
+	header += GetNextName(choiceData.Protagonist) + ", "
 	header += "error) {\n"
 	t.Functions += header
 }
@@ -387,61 +412,61 @@ func WriteChooserFunctionReturnLine(t *Translation) {
 
 func WriteChooserFunction(t *Translation, choiceData *ChoiceData, m *MessagesData) {
 	WriteChoiceFunctionHeader(t, choiceData, m)
+	if t.Handler != "" {
+		WriteHandlerChooserFunction(t, choiceData, m)
+		return
+	}
 	WriteChooserFunctionBranches(t, choiceData, m)
 	WriteChooserFunctionReturnLine(t)
 }
 
-func WriteChosenFunctionBranch(t *Translation, choiceData *ChoiceData, m *MessagesData, branch string, index int) {
-	messageData, err := GetMessageDataWithId(m, choiceData.OptionIds[index])
-	if err != nil {
-		log.Fatal(err)
+func WriteChosenFunctionBranch(t *Translation, choiceData *ChoiceData, mess *MessageData, index int) {
+	self := GetChoiceStructName(choiceData)
+	branch := GetChoiceBranchStructName(choiceData, mess)
+	bran := "func (self *" + self + "_Runner) Case_" + strings.TrimPrefix(branch, self+"_")
+	bran += "(branch *" + branch + ") error {\n"
+	bran += "\tvar err error\n"
+	bran += "\tself.RetVal, err = run" + self + GetNextLetter(index) + "(branch"
+	if t.Handler != "" {
+		bran += ", self.Handler"
 	}
-	chanName := messageData.ChanName
-	self := choiceData.Protagonist + CutStringAfterLetter(choiceData.OptionSuffixes[0], "_")
-	bran := "\tcase received" + GetNextLetter(index) + " := <- "
-	bran += self + ".Channels." + chanName + ":\n"
-	if messageData.ChoiceChanName != "" {
-		chanName = messageData.ChoiceChanName
-	}
-	bran += "\t\t" + self + ".Channels." + chanName + " <- received" + GetNextLetter(index) + "\n"
-	bran += "\t\t"
-	bran += "retVal, "
-	bran += "err = "
-	bran += "run" + self + GetNextLetter(index) + "("
-	bran += self
 	bran += ")\n"
-	bran += "\t\tif err != nil {\n"
-	bran += "\t\t\tlog.Fatal(err)\n"
-	bran += "\t\t}\n"
+	bran += "\treturn err\n"
+	bran += "}\n\n"
 	t.Functions += bran
 }
 
-func WriteChoiceFunctionReturn(t *Translation, choiceData *ChoiceData, m *MessagesData) {
-	t.Functions += "\treturn "
-	t.Functions += "retVal, "
-	t.Functions += "nil\n"
-}
-
-func WriteChoiceFunctionRetValDef(t *Translation, choiceData *ChoiceData, m *MessagesData) {
-	defs := ""
-	defs += "\tvar retVal interface{}\n"
-	errDeclaration := "\tvar err error\n"
-	t.Functions += defs + errDeclaration
+func WriteChosenFunctionRunner(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	runner := "type " + GetChoiceStructName(choiceData) + "_Runner struct {\n"
+	runner += "\tRetVal " + GetNextName(choiceData.Protagonist) + "\n"
+	if t.Handler != "" {
+		runner += "\tHandler " + t.Handler + "\n"
+	}
+	runner += "}\n\n"
+	t.Functions += runner
+	for index, mess := range GetChoiceBranchMessages(choiceData, m) {
+		WriteChosenFunctionBranch(t, choiceData, mess, index)
+	}
 }
 
-func WriteChosenFunctionBranches(t *Translation, choiceData *ChoiceData, m *MessagesData) {
-	WriteChoiceFunctionRetValDef(t, choiceData, m)
-	t.Functions += "\tselect {\n"
-	for index, branch := range choiceData.OptionSuffixes {
-		WriteChosenFunctionBranch(t, choiceData, m, branch, index)
+func WriteChosenFunctionSwitch(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	self := GetChoiceStructName(choiceData)
+	sw := "\tbranch, err := " + self + ".Branch()\n"
+	sw += GetFunctionErrCheck()
+	if t.Handler != "" {
+		sw += "\trunner := &" + self + "_Runner{Handler: handler}\n"
+	} else {
+		sw += "\trunner := &" + self + "_Runner{}\n"
 	}
-	t.Functions += "\t}\n"
-	WriteChoiceFunctionReturn(t, choiceData, m)
+	sw += "\terr = branch.Switch(runner)\n"
+	sw += GetFunctionErrCheck()
+	sw += "\treturn runner.RetVal, nil\n"
+	t.Functions += sw
 }
 
 func WriteChosenFunction(t *Translation, choiceData *ChoiceData, m *MessagesData) {
 	WriteChoiceFunctionHeader(t, choiceData, m)
-	WriteChosenFunctionBranches(t, choiceData, m)
+	WriteChosenFunctionSwitch(t, choiceData, m)
 }
 
 func WriteChoiceFunction(t *Translation, choiceData *ChoiceData, m *MessagesData) {
@@ -451,6 +476,9 @@ func WriteChoiceFunction(t *Translation, choiceData *ChoiceData, m *MessagesData
 		WriteChosenFunction(t, choiceData, m)
 	}
 	t.Functions += "}\n\n"
+	if IsChosenChoice(choiceData) {
+		WriteChosenFunctionRunner(t, choiceData, m)
+	}
 }
 
 func WriteChoiceFunctions(t *Translation, m *MessagesData) {
@@ -465,77 +493,182 @@ func WriteRecFunctionHeader(t *Translation, r *RecData, m *MessagesData, firstSt
 	header += funcName
 	header += "("
 	header += firstStructType + "_old *" + firstStructType
+	header += GetHandlerParam(t.Handler)
 	header += ") ("
-	header += "interface{}, "
+	header += GetNextName(r.Protagonist) + ", "
 	header += "error) {\n"
 	t.Functions += header
 }
 
-func WriteRecFunctionLoopOpening(t *Translation, r *RecData, m *MessagesData) {
-	opening := "\tlooping := true\n"
-	opening += "\tfor looping {\n"
-	t.Functions += opening
+// WriteRecFunctionLoop writes the loop of a rec block, which runs the
+// block once and then again for as long as it ends in a continue to its
+// start, returning the state it ends in otherwise.
+func WriteRecFunctionLoop(t *Translation, r *RecData, m *MessagesData, firstStructType string) {
+	loop := GetRecLoopVisitorName(r)
+	fun := "\tretVal, err := run" + CutStringAfterLetter(firstStructType, "_") + "("
+	fun += firstStructType + "_old" + GetHandlerArg(t.Handler) + ")\n"
+	fun += GetFunctionErrCheck()
+	fun += "\tfor {\n"
+	if t.Handler != "" {
+		fun += "\t\tloop := &" + loop + "{Handler: handler}\n"
+	} else {
+		fun += "\t\tloop := &" + loop + "{}\n"
+	}
+	fun += "\t\terr = retVal.Switch(loop)\n"
+	fun += "\t\tif err != nil {\n"
+	fun += "\t\t\tlog.Fatal(err)\n"
+	fun += "\t\t}\n"
+	fun += "\t\tif !loop.Taken {\n"
+	fun += "\t\t\treturn retVal, nil\n"
+	fun += "\t\t}\n"
+	fun += "\t\tretVal = loop.Next\n"
+	fun += "\t}\n"
+	t.Functions += fun
 }
 
-func WriteRecFunctionRetValDec(t *Translation, r *RecData, m *MessagesData) {
-	dec := "\tvar retVal interface{}\n"
-	dec += "\tvar err error\n"
-	t.Functions += dec
+func GetRecLoopVisitorName(r *RecData) string {
+	return r.Protagonist + GetStringSliceAsString(r.Suffix) + "_Loop"
 }
 
-func WriteRecFunctionRunCall(t *Translation, r *RecData, m *MessagesData, firstStructType string) {
-	call := "\t\tretVal, err "
-	call += "= run" + CutStringAfterLetter(firstStructType, "_") + "("
-	call += firstStructType + "_old)\n"
-	t.Functions += call
+func WriteRecFunction(t *Translation, r *RecData, m *MessagesData) {
+	firstStructType := GetDataStructNameWithIdAndType(r.FirstStructId, r.FirstStructType, m)
+	WriteRecFunctionHeader(t, r, m, firstStructType)
+	WriteRecFunctionLoop(t, r, m, firstStructType)
+	t.Functions += "}\n\n"
+	t.Functions += GetNextVisitor(r.Protagonist, GetRecLoopVisitorName(r), firstStructType, "run"+CutStringAfterLetter(firstStructType, "_"), t.Handler)
 }
 
-func WriteRecFunctionErrCheck(t *Translation) {
-	check := "\t\tif err != nil {\n"
-	check += "\t\t\tlog.Fatal(err)\n"
-	check += "\t\t}\n"
-	t.Functions += check
+func WriteRecFunctions(t *Translation, m *MessagesData) {
+	for _, recData := range m.Recs {
+		WriteRecFunction(t, recData, m)
+	}
 }
 
-func WriteRecFunctionSwitch(t *Translation, r *RecData, m *MessagesData, firstStructType string) {
-	sw := "\t\tswitch t := retVal.(type) {\n"
-	sw += "\t\tcase *" + firstStructType + ":\n"
-	sw += "\t\t\t" + firstStructType + "_old = t\n"
-	sw += "\t\tdefault:\n"
-	sw += "\t\t\tlooping = false\n"
-	sw += "\t\t}\n"
-	sw += "\t}\n"
-	t.Functions += sw
+// GetNextName returns the name of the interface for the state which the
+// functions running the steps of protagonist return: the state that
+// follows a branch, rec or par block, or the end of the session.
+func GetNextName(protagonist string) string {
+	return protagonist + "_Next"
 }
 
-func WriteRecFunctionReturn(t *Translation, r *RecData, m *MessagesData) {
-	line := "\treturn "
-	line += "retVal, "
-	line += "nil\n"
-	t.Functions += line
+func GetEndName(protagonist string) string {
+	return protagonist + "_End"
 }
 
-func WriteRecFunction(t *Translation, r *RecData, m *MessagesData) {
-	firstStructType := GetDataStructNameWithIdAndType(r.FirstStructId, r.FirstStructType, m)
-	WriteRecFunctionHeader(t, r, m, firstStructType)
-	WriteRecFunctionRetValDec(t, r, m)
-	WriteRecFunctionLoopOpening(t, r, m)
-	WriteRecFunctionRunCall(t, r, m, firstStructType)
-	WriteRecFunctionErrCheck(t)
-	WriteRecFunctionSwitch(t, r, m, firstStructType)
-	WriteRecFunctionReturn(t, r, m)
-	t.Functions += "}\n\n"
+func GetNextCaseName(protagonist string, structName string) string {
+	return "Case_" + strings.TrimPrefix(structName, protagonist+"_")
 }
 
-func WriteRecFunctions(t *Translation, m *MessagesData) {
-	for _, recData := range m.Recs {
-		WriteRecFunction(t, recData, m)
+// GetNextVisitor returns a visitor called name which, if a Next holds
+// the state structName, records that it has taken the state and runs fun
+// from it, keeping the Next that fun returns. It passes over any other
+// state, which is left with the holder of the Next.
+func GetNextVisitor(protagonist string, name string, structName string, fun string, handler string) string {
+	visitor := "type " + name + " struct {\n"
+	visitor += "\t" + GetNextName(protagonist) + "_Pass\n"
+	visitor += "\tTaken bool\n"
+	visitor += "\tNext " + GetNextName(protagonist) + "\n"
+	if handler != "" {
+		visitor += "\tHandler " + handler + "\n"
+	}
+	visitor += "}\n\n"
+	visitor += "func (self *" + name + ") " + GetNextCaseName(protagonist, structName) + "(next *" + structName + ") error {\n"
+	visitor += "\tvar err error\n"
+	visitor += "\tself.Taken = true\n"
+	visitor += "\tself.Next, err = " + fun + "(next"
+	if handler != "" {
+		visitor += ", self.Handler"
+	}
+	visitor += ")\n"
+	visitor += "\treturn err\n"
+	visitor += "}\n\n"
+	return visitor
+}
+
+// GetNextStructNames returns the names of the states which a function
+// running the steps of m may return or take from a Next: those which
+// follow the end of a conversation, a continue or a par block, those at
+// the start of rec blocks and those which follow a choice, rec or par
+// block within a conversation.
+func GetNextStructNames(t *Translation, m *MessagesData) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, convVals := range AssignConversationsFunctionValues(t, m) {
+		add(convVals.FinalStruct)
+		for _, step := range convVals.Steps {
+			if step.StepType == "continue" {
+				cont, err := GetContinueDataWithId(m, step.StepId)
+				if err != nil {
+					log.Fatal(err)
+				}
+				add(cont.ContinueToStruct)
+			} else if GetNeedTypeCheck(convVals, step, m) {
+				add(convVals.Steps[step.Index+1].StructName)
+			}
+		}
+	}
+	for _, par := range m.Parallels {
+		add(GetParSubsequentStructName(par))
 	}
+	for _, r := range m.Recs {
+		add(GetDataStructNameWithIdAndType(r.FirstStructId, r.FirstStructType, m))
+	}
+	return names
+}
+
+// WriteNextTypes writes the Next interface of the protagonist of m, which
+// is implemented by the end of the session and by each state that a
+// function may return, together with a visitor with one method for each
+// of them. The Pass visitor passes over every state, so that a visitor
+// which embeds it need handle only the state it looks for.
+func WriteNextTypes(t *Translation, m *MessagesData) {
+	protagonist := GetProtagonist(m)
+	next := GetNextName(protagonist)
+	end := GetEndName(protagonist)
+	names := GetNextStructNames(t, m)
+	def := "type " + next + " interface {\n"
+	def += "\tSwitch(visitor " + next + "_Visitor) error\n"
+	def += "\tis" + next + "()\n"
+	def += "}\n\n"
+	def += "type " + next + "_Visitor interface {\n"
+	def += "\tCase_End() error\n"
+	for _, name := range names {
+		def += "\t" + GetNextCaseName(protagonist, name) + "(next *" + name + ") error\n"
+	}
+	def += "}\n\n"
+	def += "type " + end + " struct{}\n\n"
+	def += "func (self *" + end + ") Switch(visitor " + next + "_Visitor) error {\n"
+	def += "\treturn visitor.Case_End()\n"
+	def += "}\n\n"
+	def += "func (self *" + end + ") is" + next + "() {}\n\n"
+	for _, name := range names {
+		def += "func (self *" + name + ") Switch(visitor " + next + "_Visitor) error {\n"
+		def += "\treturn visitor." + GetNextCaseName(protagonist, name) + "(self)\n"
+		def += "}\n\n"
+		def += "func (self *" + name + ") is" + next + "() {}\n\n"
+	}
+	def += "type " + next + "_Pass struct{}\n\n"
+	def += "func (self *" + next + "_Pass) Case_End() error {\n"
+	def += "\treturn nil\n"
+	def += "}\n\n"
+	for _, name := range names {
+		def += "func (self *" + next + "_Pass) " + GetNextCaseName(protagonist, name) + "(*" + name + ") error {\n"
+		def += "\treturn nil\n"
+		def += "}\n\n"
+	}
+	t.Functions += def
 }
 
 func WriteFunctions(t *Translation, m *MessagesData, wg *sync.WaitGroup) {
 	defer wg.Done()
 	t.Functions += "// Functions\n\n"
+	WriteNextTypes(t, m)
 	WriteParallelFunctions(t, m)
 	WriteChoiceFunctions(t, m)
 	WriteStartFunction(t, m)
@@ -544,7 +677,7 @@ func WriteFunctions(t *Translation, m *MessagesData, wg *sync.WaitGroup) {
 
 func GetConvFuncHeaderRetVals(conv *ConversationData) []string {
 	headerRetVals := make([]string, 0)
-	headerRetVals = append(headerRetVals, "interface{}")
+	headerRetVals = append(headerRetVals, GetNextName(conv.Protagonist))
 	headerRetVals = append(headerRetVals, "error")
 	return headerRetVals
 }
@@ -561,7 +694,7 @@ func GetConvFuncBottomRetVals(conv *ConversationData, m *MessagesData) []string
 		contStructName += "_new"
 		bottomRetVals = append(bottomRetVals, contStructName)
 	} else {
-		bottomRetVals = append(bottomRetVals, "\"\"")
+		bottomRetVals = append(bottomRetVals, "&"+GetEndName(conv.Protagonist)+"{}")
 	}
 	bottomRetVals = append(bottomRetVals, "nil")
 	return bottomRetVals
@@ -626,10 +759,14 @@ func AssignConversationFunctionValues(conv *ConversationData, t *Translation, m
 	finalStruct := GetConvFuncFinalStruct(conv, m)
 	bottomRetVals := GetConvFuncBottomRetVals(conv, m)
 	IsFirstConv := IsFirstConv(conv)
-	convFuncVals := &ConvFuncVals{ConvId: id, ParamName: paramName, HeaderRetVals: headerRetVals, BottomRetVals: bottomRetVals, IsFirstConv: IsFirstConv, FinalStruct: finalStruct, Protagonist: conv.Protagonist, InParBlock: conv.InParBlock, ParentIsRecBlock: conv.ParentIsRecBlock}
+	convFuncVals := &ConvFuncVals{ConvId: id, ParamName: paramName, HeaderRetVals: headerRetVals, BottomRetVals: bottomRetVals, IsFirstConv: IsFirstConv, FinalStruct: finalStruct, Protagonist: conv.Protagonist, InParBlock: conv.InParBlock, ParentIsRecBlock: conv.ParentIsRecBlock, Handler: t.Handler}
 	convFuncStepVals := make([]*ConvFuncStepVals, 0)
 	for index, elem := range conv.ConversationElementsData {
 		stepVals := AssignConversationStepValues(elem, conv, m, index)
+		if t.Handler != "" {
+			stepVals.VarDecs = GetHandlerStepVarDecs(elem.UniqueId, elem.Type, m, index)
+			stepVals.VarPrints = GetHandlerStepVarPrints(elem.UniqueId, elem.Type, m, index)
+		}
 		convFuncStepVals = append(convFuncStepVals, stepVals)
 	}
 	convFuncVals.Steps = convFuncStepVals
@@ -690,9 +827,8 @@ func GetRetValsForDataStructureWithIdAndType(id string, typ string, m *MessagesD
 			log.Fatal(err)
 		}
 		if mess.Protagonist == mess.ToBase {
-			for i, param := range mess.Parameters {
-				val := "received_" + strconv.Itoa(index+1) + "_" + strconv.Itoa(i+1) + "_" + param
-				retVals = append(retVals, val)
+			for i := range mess.Parameters {
+				retVals = append(retVals, GetStepVarName("received", mess, index, i))
 			}
 		}
 	} else if typ == "choice" {
@@ -710,6 +846,15 @@ func GetRetValsForDataStructureWithIdAndType(id string, typ string, m *MessagesD
 	return retVals
 }
 
+// GetStepVarName returns the name of the variable holding the ith value
+// sent or received by the step at index in a conversation function.
+func GetStepVarName(prefix string, mess *MessageData, index int, i int) string {
+	if IsNamedParameter(mess, i) {
+		return prefix + "_" + strconv.Itoa(index+1) + "_" + mess.ParameterNames[i]
+	}
+	return prefix + "_" + strconv.Itoa(index+1) + "_" + strconv.Itoa(i+1) + "_" + mess.Parameters[i]
+}
+
 func GetParamsForDataStructureWithIdAndType(id string, typ string, m *MessagesData, index int) []string {
 	params := make([]string, 0)
 	if typ == "message" {
@@ -718,9 +863,8 @@ func GetParamsForDataStructureWithIdAndType(id string, typ string, m *MessagesDa
 			log.Fatal(err)
 		}
 		if mess.Protagonist == mess.FromBase {
-			for i, p := range mess.Parameters {
-				val := "sending_" + strconv.Itoa(index+1) + "_" + strconv.Itoa(i+1) + "_" + p
-				params = append(params, val)
+			for i := range mess.Parameters {
+				params = append(params, GetStepVarName("sending", mess, index, i))
 			}
 		}
 	} else if typ == "choice" {
@@ -752,8 +896,8 @@ func GetConversationStepVarDecs(id string, typ string, m *MessagesData, index in
 			log.Fatal(err)
 		}
 		if mess.Protagonist == mess.FromBase {
-			for i, p := range mess.Parameters {
-				dec := "\tvar sending_" + strconv.Itoa(index+1) + "_" + strconv.Itoa(i+1) + "_" + p + " " + p + "\n"
+			for i := range mess.Parameters {
+				dec := "\tvar " + GetStepVarName("sending", mess, index, i) + " " + mess.ParameterTypes[i] + "\n"
 				decs = append(decs, dec)
 			}
 		}
@@ -780,7 +924,11 @@ func GetConversationStepVarPrints(id string, typ string, m *MessagesData, index
 		}
 		if mess.Protagonist == mess.ToBase {
 			for i, p := range mess.Parameters {
-				dec := "\tfmt.Println(\"" + mess.Protagonist + GetStringSliceAsString(mess.Suffix) + " received value type: " + p + ": \"" + ", received_" + strconv.Itoa(index+1) + "_" + strconv.Itoa(i+1) + "_" + p + ")\n"
+				label := "value type: " + p
+				if IsNamedParameter(mess, i) {
+					label = mess.ParameterNames[i]
+				}
+				dec := "\tfmt.Println(\"" + mess.Protagonist + GetStringSliceAsString(mess.Suffix) + " received " + label + ": \"" + ", " + GetStepVarName("received", mess, index, i) + ")\n"
 				decs = append(decs, dec)
 			}
 		}
@@ -830,18 +978,17 @@ func AssignConversationsFunctionValues(t *Translation, m *MessagesData) []*ConvF
 	return convFuncVals
 }
 
-func GetConvFuncHeader(convVals *ConvFuncVals, m *MessagesData) string {
-	header := "func "
+// GetConvFuncName returns the name of the function running a
+// conversation, without its run prefix.
+func GetConvFuncName(convVals *ConvFuncVals, m *MessagesData) string {
 	firstStep := convVals.Steps[0]
-	firstStruct := firstStep.StructName
-	funcName := firstStruct
+	funcName := firstStep.StructName
 	cutFuncNameAfterLastUnderscore := false
 	if convVals.IsFirstConv {
 		funcName = convVals.Protagonist
 	} else if firstStep.StepType == "message" {
 		if GetIsChoiceOption(firstStep.StepId, m) {
 			funcName = RemoveLastRuneFromString(funcName)
-			firstStruct = CutStringAfterLetter(firstStruct, "_")
 		}
 	} else if firstStep.StepType == "choice" {
 		cutFuncNameAfterLastUnderscore = true
@@ -855,16 +1002,31 @@ func GetConvFuncHeader(convVals *ConvFuncVals, m *MessagesData) string {
 	if cutFuncNameAfterLastUnderscore {
 		funcName = CutStringAfterLetter(funcName, "_")
 	}
-	header += "run" + funcName + "("
+	return funcName
+}
+
+func GetConvFuncHeader(convVals *ConvFuncVals, m *MessagesData) string {
+	header := "func "
+	firstStep := convVals.Steps[0]
+	firstStruct := firstStep.StructName
+	firstType := firstStruct
+	if !convVals.IsFirstConv && firstStep.StepType == "message" {
+		if GetIsChoiceOption(firstStep.StepId, m) {
+			firstStruct = CutStringAfterLetter(firstStruct, "_")
+			firstType = GetChoiceOptionParamType(firstStep.StepId, firstStruct, m)
+		}
+	}
+	header += "run" + GetConvFuncName(convVals, m) + "("
 	if convVals.IsFirstConv {
 		header += "wg *sync.WaitGroup, "
 	}
-	header += firstStruct + " *" + firstStruct
+	header += firstStruct + " *" + firstType
 	if !convVals.IsFirstConv && convVals.InParBlock {
 		header += ", wg *sync.WaitGroup"
 	}
+	header += GetHandlerParam(convVals.Handler)
 	header += ") ("
-	header += "interface{}, "
+	header += GetNextName(convVals.Protagonist) + ", "
 	header += "error) {\n"
 	if convVals.IsFirstConv || convVals.InParBlock {
 		header += "\tdefer wg.Done()\n"
@@ -872,6 +1034,21 @@ func GetConvFuncHeader(convVals *ConvFuncVals, m *MessagesData) string {
 	return header
 }
 
+// GetChoiceOptionParamType returns the type of the parameter of the
+// function for the branch of a choice which begins with the message with
+// id messId: the struct for the branch if another role makes the choice,
+// or the struct for the choice itself otherwise.
+func GetChoiceOptionParamType(messId string, choiceStruct string, m *MessagesData) string {
+	mess, err := GetMessageDataWithId(m, messId)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if mess.Protagonist == mess.ToBase {
+		return choiceStruct + "_" + strings.TrimPrefix(GetMessageMethodName(mess, "Receive"), "Receive_")
+	}
+	return choiceStruct
+}
+
 func GetConvFuncStepFinalStruct(step *ConvFuncStepVals, convVals *ConvFuncVals) string {
 	structName := ""
 	if convVals.FinalStruct != "" {
@@ -923,7 +1100,7 @@ func GetConvFuncStep(convVals *ConvFuncVals, step *ConvFuncStepVals, m *Messages
 				if retValAdded {
 					s = s[:len(s)-len(retValText)]
 				}
-				if GetNeedTypeCheck(convVals, step) {
+				if GetNeedTypeCheck(convVals, step, m) {
 					s += nextStep.StructName + "_candidate, "
 				} else {
 					s += nextStep.StructName + ", "
@@ -942,7 +1119,7 @@ func GetConvFuncStep(convVals *ConvFuncVals, step *ConvFuncStepVals, m *Messages
 			}
 		}
 		if step.Index != 0 {
-			if GetNeedTypeCheck(convVals, convVals.Steps[step.Index-1]) {
+			if GetNeedTypeCheck(convVals, convVals.Steps[step.Index-1], m) {
 				structName += "_step" + strconv.Itoa(step.Index)
 			}
 		}
@@ -953,6 +1130,7 @@ func GetConvFuncStep(convVals *ConvFuncVals, step *ConvFuncStepVals, m *Messages
 		} else {
 			s += step.MethodName + "("
 			s += structName
+			s += GetHandlerArg(convVals.Handler)
 		}
 		s += ")\n"
 		s += step.ErrCheck
@@ -963,33 +1141,52 @@ func GetConvFuncStep(convVals *ConvFuncVals, step *ConvFuncStepVals, m *Messages
 	return s
 }
 
-func GetNeedTypeCheck(convVals *ConvFuncVals, step *ConvFuncStepVals) bool {
+func GetNeedTypeCheck(convVals *ConvFuncVals, step *ConvFuncStepVals, m *MessagesData) bool {
 	if step.Index < len(convVals.Steps)-1 {
-		if step.StepType == "choice" || step.StepType == "parallel" || step.StepType == "par" || step.StepType == "rec" {
+		if step.StepType == "parallel" || step.StepType == "par" {
+			par, err := GetParallelDataWithId(m, step.StepId)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return GetParSubsequentStructName(par) == ""
+		}
+		if step.StepType == "choice" || step.StepType == "rec" {
 			return true
 		}
 	}
 	return false
 }
 
-func GetConvFuncRetValTypeCheck(convVals *ConvFuncVals, step *ConvFuncStepVals, m *MessagesData) string {
-	check := ""
-	if step.Index < len(convVals.Steps)-1 {
-		nextStep := convVals.Steps[step.Index+1]
-		if GetNeedTypeCheck(convVals, step) {
-			check += "\tvar " + nextStep.StructName + "_step" + strconv.Itoa(step.Index+1) + " *" + nextStep.StructName + "\n"
-			check += "\tswitch v := "
-			check += nextStep.StructName + "_candidate"
-			check += ".(type) {\n"
-			check += "\tcase *" + nextStep.StructName
-			check += ":\n"
-			check += "\t\t" + nextStep.StructName + "_step" + strconv.Itoa(step.Index+1) + " = v\n"
-			check += "\tdefault:\n"
-			check += "\t\tlog.Fatalf(\"Expected type " + nextStep.StructName + ", received type %T\", v)\n"
-			check += "\t}\n"
-		}
+// GetConvFuncThen returns the end of a conversation function at a
+// choice, rec or par block which is followed by another step. The
+// remaining steps are run by a function of their own, from the state for
+// the next step, by a visitor of the Next in which the block ends; a
+// block which ends otherwise, in a continue to an enclosing rec block,
+// returns its Next to the caller, which repeats that block.
+func GetConvFuncThen(convVals *ConvFuncVals, step *ConvFuncStepVals, m *MessagesData) string {
+	nextStep := convVals.Steps[step.Index+1]
+	candidate := nextStep.StructName + "_candidate"
+	name := GetConvFuncName(convVals, m) + "_Then" + strconv.Itoa(step.Index+1)
+	then := "\tthen := &" + name
+	if convVals.Handler != "" {
+		then += "{Handler: handler}\n"
+	} else {
+		then += "{}\n"
 	}
-	return check
+	then += "\tif err := " + candidate + ".Switch(then); err != nil {\n"
+	then += "\t\tlog.Fatal(err)\n"
+	then += "\t}\n"
+	then += "\tif !then.Taken {\n"
+	then += "\t\treturn " + candidate + ", nil\n"
+	then += "\t}\n"
+	then += "\treturn then.Next, nil\n"
+	then += "}\n\n"
+	then += GetNextVisitor(convVals.Protagonist, name, nextStep.StructName, "run"+name, convVals.Handler)
+	then += "func run" + name + "("
+	then += nextStep.StructName + "_step" + strconv.Itoa(step.Index+1) + " *" + nextStep.StructName
+	then += GetHandlerParam(convVals.Handler)
+	then += ") (" + GetNextName(convVals.Protagonist) + ", error) {\n"
+	return then
 }
 
 func GetConvFuncReturnLine(convVals *ConvFuncVals, m *MessagesData) string {
@@ -1005,7 +1202,7 @@ func GetConvFuncReturnLine(convVals *ConvFuncVals, m *MessagesData) string {
 	} else if convVals.Steps[len(convVals.Steps)-1].StepType == "rec" || convVals.Steps[len(convVals.Steps)-1].StepType == "choice" || convVals.Steps[len(convVals.Steps)-1].StepType == "par" || convVals.Steps[len(convVals.Steps)-1].StepType == "parallel" {
 		line += "retVal"
 	} else {
-		line += "\"\""
+		line += "&" + GetEndName(convVals.Protagonist) + "{}"
 	}
 	line += ", nil\n"
 	return line
@@ -1017,7 +1214,9 @@ func GetStringConversationFuncVals(convVals *ConvFuncVals, m *MessagesData) stri
 	for i := 0; i < len(convVals.Steps); i++ {
 		step := convVals.Steps[i]
 		fun += GetConvFuncStep(convVals, step, m)
-		fun += GetConvFuncRetValTypeCheck(convVals, step, m)
+		if GetNeedTypeCheck(convVals, step, m) {
+			fun += GetConvFuncThen(convVals, step, m)
+		}
 	}
 	fun += GetConvFuncReturnLine(convVals, m)
 	fun += "}\n\n"
@@ -1056,4 +1255,5 @@ type ConvFuncVals struct {
 	FinalStruct      string
 	InParBlock       bool
 	ParentIsRecBlock bool
+	Handler          string
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFunctionsDispatchesOnNext(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "rec X {\n\t\tchoice at A { one() to B; continue X; } or { two() to B; }\n\t\tthree() to B;\n\t}\n\tfour() to B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Translation{}
+	tr.Functions += GetStringConversationsFuncVals(AssignConversationsFunctionValues(tr, m), m)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	WriteFunctions(tr, m, &wg)
+	for _, want := range []string{
+		"type A_Next_Visitor interface {\n\tCase_End() error\n",
+		"\tCase_rec1_choice1(next *A_rec1_choice1) error\n",
+		"func (self *A_rec1_choice1) Switch(visitor A_Next_Visitor) error {\n\treturn visitor.Case_rec1_choice1(self)\n}\n",
+		"func (self *A_Next_Pass) Case_rec1_1(*A_rec1_1) error {\n\treturn nil\n}\n",
+		// The step after the choice runs only if the choice ends in its
+		// state; a continue is returned to the loop, which repeats
+		"\tthen := &A_rec1_Then1{}\n\tif err := A_rec1_1_candidate.Switch(then); err != nil {\n\t\tlog.Fatal(err)\n\t}\n\tif !then.Taken {\n\t\treturn A_rec1_1_candidate, nil\n\t}\n\treturn then.Next, nil\n}\n",
+		"func (self *A_rec1_Then1) Case_rec1_1(next *A_rec1_1) error {\n\tvar err error\n\tself.Taken = true\n\tself.Next, err = runA_rec1_Then1(next)\n\treturn err\n}\n",
+		"func runA_rec1_Then1(A_rec1_1_step1 *A_rec1_1) (A_Next, error) {\n\tA1, err2 := A_rec1_1_step1.Send_three()\n",
+		"func LoopA_rec1(A_rec1_choice1_old *A_rec1_choice1) (A_Next, error) {\n",
+		"\t\tloop := &A_rec1_Loop{}\n\t\terr = retVal.Switch(loop)\n",
+		"\t\tif !loop.Taken {\n\t\t\treturn retVal, nil\n\t\t}\n\t\tretVal = loop.Next\n",
+		"func Make_A_rec1_choice1_Choices(A_rec1_choice1 *A_rec1_choice1) (A_Next, error) {\n",
+		"\treturn &A_End{}, nil\n",
+	} {
+		if !strings.Contains(tr.Functions, want) {
+			t.Errorf("functions do not contain %q:\n%s", want, tr.Functions)
+		}
+	}
+	for _, unwanted := range []string{"interface{}", ".(type)", "Expected type"} {
+		if strings.Contains(tr.Functions, unwanted) {
+			t.Errorf("functions contain %q:\n%s", unwanted, tr.Functions)
+		}
+	}
+}
+
+func TestWriteFunctionsPassesHandlerToVisitors(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "rec X {\n\t\tchoice at B { one() from B; continue X; } or { two() from B; }\n\t\tthree() to B;\n\t}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Translation{Handler: GetHandlerName(m)}
+	tr.Functions += GetStringConversationsFuncVals(AssignConversationsFunctionValues(tr, m), m)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	WriteFunctions(tr, m, &wg)
+	for _, want := range []string{
+		"\tthen := &A_rec1_Then1{Handler: handler}\n",
+		"\tself.Next, err = runA_rec1_Then1(next, self.Handler)\n",
+		"\t\tloop := &A_rec1_Loop{Handler: handler}\n",
+		"type A_rec1_Loop struct {\n\tA_Next_Pass\n\tTaken bool\n\tNext A_Next\n\tHandler " + GetHandlerName(m) + "\n}\n",
+	} {
+		if !strings.Contains(tr.Functions, want) {
+			t.Errorf("functions do not contain %q:\n%s", want, tr.Functions)
+		}
+	}
+}
@@ -18,9 +18,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,11 +40,12 @@ type Translation struct {
 	Network                 string
 	HasNetConn              bool
 	NetConnType             string
-	NetConnAddress          string
-	NetConnPort             string
+	NetConfig               *NetworkConfig
+	TypeImports             []*TypeImport
 	StructSlice             []string
 	ChannelDefSlice         []string
 	ChannelConstructorSlice []string
+	Handler                 string
 }
 
 func GetSuffixWithRecUnderscoresAddedAsString(slice []string) string {
@@ -85,8 +88,8 @@ func WriteMessageStruct(t *Translation, mess *MessageData, choiceName string) {
 		strct += choiceName
 	}
 	strct += " struct {\n"
-	strct += "\tChannels *Channels\n"
-	strct += "\tUsed bool\n}\n\n"
+	strct += "\truntime.Endpoint\n"
+	strct += "\tChannels *Channels\n}\n\n"
 	t.StructSlice = append(t.StructSlice, strct)
 	t.Structs += strct
 }
@@ -140,8 +143,8 @@ func WriteParStartStruct(t *Translation, par *ParallelData) {
 	strct := "type " + par.Protagonist
 	strct += par.ChanNameBase
 	strct += "_start struct {\n"
+	strct += "\truntime.Endpoint\n"
 	strct += "\tChannels *Channels\n"
-	strct += "\tUsed bool\n"
 	strct += "}\n\n"
 	t.StructSlice = append(t.StructSlice, strct)
 	t.Structs += strct
@@ -151,8 +154,8 @@ func WriteParEndStruct(t *Translation, par *ParallelData) {
 	strct := "type " + par.Protagonist
 	strct += par.ChanNameBase
 	strct += "_end struct {\n"
+	strct += "\truntime.Endpoint\n"
 	strct += "\tChannels *Channels\n"
-	strct += "\tUsed bool\n"
 	strct += "}\n\n"
 	t.StructSlice = append(t.StructSlice, strct)
 	t.Structs += strct
@@ -174,8 +177,8 @@ func GetMultiValueTypeStruct(t *Translation, mess *MessageData) string {
 		strct += param
 	}
 	strct += " struct {\n"
-	for i, param := range mess.Parameters {
-		strct += "\tparam" + strconv.Itoa(i+1) + " " + param + "\n"
+	for i, param := range mess.ParameterTypes {
+		strct += "\t" + mess.ParameterNames[i] + " " + param + "\n"
 	}
 	strct += "}\n\n"
 	return strct
@@ -191,8 +194,8 @@ func WriteDataStruct(mess *MessageData) string {
 	if len(mess.Parameters) == 0 {
 		strct += "\tParam1  struct{}\n"
 	} else {
-		for i, param := range mess.Parameters {
-			strct += "\tParam" + strconv.Itoa(i+1) + " "
+		for i, param := range mess.ParameterTypes {
+			strct += "\t" + CapitaliseFirstLetter(mess.ParameterNames[i]) + " "
 			strct += param + "\n"
 		}
 	}
@@ -408,20 +411,49 @@ func WriteConstructorChoiceLines(t *Translation, m *MessagesData) {
 	}
 }
 
-func GetImportsForString(s string) string {
+// UsesPackage reports whether s refers to the package named pkg, so that
+// time.Second counts as a use of time but runtime.Send does not.
+func UsesPackage(s string, pkg string) bool {
+	for i := 0; i < len(s); {
+		j := strings.Index(s[i:], pkg+".")
+		if j < 0 {
+			return false
+		}
+		i += j
+		if i == 0 || !IsIdentifierByte(s[i-1]) {
+			return true
+		}
+		i += len(pkg)
+	}
+	return false
+}
+
+func IsIdentifierByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func GetImportsForString(s string, typeImports []*TypeImport) string {
 	noImports := true
 	importString := "import (\n"
-	potentialImports := []string{"errors", "sync", "log", "fmt", "net", "gob", "time"}
+	potentialImports := []string{"errors", "sync", "log", "fmt", "net", "gob", "time", "runtime"}
+	imported := make(map[string]bool)
 	for _, potimp := range potentialImports {
-		if strings.Contains(s, potimp+".") {
+		if UsesPackage(s, potimp) {
+			imported[potimp] = true
 			importString += "\t\""
 			if potimp == "gob" {
 				importString += "encoding/"
+			} else if potimp == "runtime" {
+				importString += "gobble/"
 			}
 			importString += potimp + "\"\n"
 			noImports = false
 		}
 	}
+	if typeImportString := GetTypeImportsForString(s, typeImports, imported); typeImportString != "" {
+		importString += typeImportString
+		noImports = false
+	}
 	importString += ")\n\n"
 	if noImports {
 		return ""
@@ -429,11 +461,10 @@ func GetImportsForString(s string) string {
 	return importString
 }
 
-func SetupNetworkConnection(t *Translation) {
+func SetupNetworkConnection(t *Translation, config *NetworkConfig) {
 	t.HasNetConn = true
 	t.NetConnType = "tcp"
-	t.NetConnAddress = "localhost"
-	t.NetConnPort = ":8080"
+	t.NetConfig = config
 }
 
 func PrintTranslation(t *Translation) {
@@ -475,7 +506,7 @@ func WriteAPIToFile(t *Translation, moduleName string, wg *sync.WaitGroup) {
 	}
 	defer file.Close()
 	output := t.Channels + t.Structs + t.Methods
-	imports := GetImportsForString(output)
+	imports := GetImportsForString(output, t.TypeImports)
 	output = t.Package + imports + output
 	fmt.Fprint(file, output)
 }
@@ -495,7 +526,7 @@ func WriteNetworkToFile(t *Translation, moduleName string, wg *sync.WaitGroup) {
 	}
 	defer file.Close()
 	output := t.Network
-	imports := GetImportsForString(output)
+	imports := GetImportsForString(output, t.TypeImports)
 	output = t.Package + imports + output
 	fmt.Fprint(file, output)
 }
@@ -515,12 +546,89 @@ func WriteFunctionsToFile(t *Translation, moduleName string, wg *sync.WaitGroup)
 	}
 	defer file.Close()
 	output := t.Functions + t.Main
-	imports := GetImportsForString(output)
+	imports := GetImportsForString(output, t.TypeImports)
 	output = t.Package + imports + output
 	fmt.Fprint(file, output)
 }
 
-func WriteCombinedTranslationToFileInstance(pkg string, content string, path string, name string) {
+// GetRuntimeDir returns the directory holding the gobble/runtime package
+// which generated code imports: the directory named by the GOBBLE_RUNTIME
+// environment variable if it is set, otherwise the runtime directory
+// beside the Gobble executable. It returns an error if the directory
+// found holds no go.mod, since the generated code would not build.
+func GetRuntimeDir() (string, error) {
+	dir := os.Getenv("GOBBLE_RUNTIME")
+	where := "GOBBLE_RUNTIME"
+	if dir == "" {
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			return "", errors.New("Cannot find the gobble/runtime package: " + err.Error() + "; set GOBBLE_RUNTIME to the runtime directory of Gobble's source.")
+		}
+		dir = filepath.Join(filepath.Dir(exe), "runtime")
+		where = "the Gobble executable"
+	}
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		dir = abs
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		if where == "GOBBLE_RUNTIME" {
+			return "", errors.New("GOBBLE_RUNTIME is " + dir + ", which does not hold the gobble/runtime package: it has no go.mod.")
+		}
+		return "", errors.New("Cannot find the gobble/runtime package beside " + where + " at " + dir + "; set GOBBLE_RUNTIME to the runtime directory of Gobble's source.")
+	}
+	return dir, nil
+}
+
+func GetModuleFilePath(moduleName string) string {
+	sep := string(os.PathSeparator)
+	return "." + sep + "output" + sep + moduleName + "_Gobble" + sep + "go.mod"
+}
+
+// GetModuleRuntimeDir returns the runtime directory which the go.mod for
+// the code generated for moduleName is to point at, or "" if a go.mod is
+// already there and will be kept. It is called before any code is
+// written, so that Gobble stops without writing code which cannot build.
+func GetModuleRuntimeDir(moduleName string) (string, error) {
+	if _, err := os.Stat(GetModuleFilePath(moduleName)); err == nil {
+		return "", nil
+	}
+	return GetRuntimeDir()
+}
+
+func GetModuleFile(moduleName string, runtimeDir string) string {
+	mod := "module " + moduleName + "_Gobble\n\n"
+	mod += "go 1.18\n\n"
+	mod += "require gobble/runtime v0.0.0\n\n"
+	mod += "replace gobble/runtime => " + runtimeDir + "\n"
+	return mod
+}
+
+// WriteModuleFile writes the go.mod for the code generated for moduleName,
+// which points gobble/runtime at runtimeDir, as found by
+// GetModuleRuntimeDir. An existing go.mod is left alone, so requirements
+// added to it for the packages of imported types are kept.
+func WriteModuleFile(moduleName string, runtimeDir string) {
+	fileName := GetModuleFilePath(moduleName)
+	err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm)
+	if err != nil {
+		log.Fatal("Error creating directory: ", err)
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		return
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		log.Fatal("Cannot create file: ", err)
+	}
+	defer file.Close()
+	fmt.Fprint(file, GetModuleFile(moduleName, runtimeDir))
+}
+
+func WriteCombinedTranslationToFileInstance(pkg string, content string, typeImports []*TypeImport, path string, name string) {
 	fileName := path + name
 	file, err := os.Create(fileName)
 	if err != nil {
@@ -528,7 +636,7 @@ func WriteCombinedTranslationToFileInstance(pkg string, content string, path str
 	}
 	defer file.Close()
 	output := content
-	imports := GetImportsForString(output)
+	imports := GetImportsForString(output, typeImports)
 	output = pkg + imports + output
 	fmt.Fprint(file, output)
 }
@@ -541,10 +649,10 @@ func WriteCombinedTranslationToFile(t *Translation, moduleName string) {
 		log.Fatal("Error creating directory: ", err)
 	}
 	pkg := "package main\n\n"
-	WriteCombinedTranslationToFileInstance(pkg, t.Functions+t.Main, path, "main.go")
-	WriteCombinedTranslationToFileInstance(pkg, t.Structs, path, "structs.go")
-	WriteCombinedTranslationToFileInstance(pkg, t.Channels, path, "channels.go")
-	WriteCombinedTranslationToFileInstance(pkg, t.Methods, path, "methods.go")
+	WriteCombinedTranslationToFileInstance(pkg, t.Functions+t.Main, t.TypeImports, path, "main.go")
+	WriteCombinedTranslationToFileInstance(pkg, t.Structs, t.TypeImports, path, "structs.go")
+	WriteCombinedTranslationToFileInstance(pkg, t.Channels, t.TypeImports, path, "channels.go")
+	WriteCombinedTranslationToFileInstance(pkg, t.Methods, t.TypeImports, path, "methods.go")
 }
 
 func GetCombinedChannels(t *Translation) string {
@@ -618,15 +726,22 @@ func GetCombinedTranslationMain(m []*MessagesData, t *Translation) string {
 	return main
 }
 
-func WriteCombinedTranslation(mds []*MessagesData, fileName string) {
+func WriteCombinedTranslation(mds []*MessagesData, fileName string, runtimeDir string) {
 	t := &Translation{}
 	main := GetCombinedTranslationMain(mds, t)
 	channelDefs := make([]string, 0)
 	channelConstructors := make([]string, 0)
 	structs := make([]string, 0)
+	typeImportPaths := make(map[string]bool)
 	for _, m := range mds {
+		for _, imp := range m.TypeImports {
+			if !typeImportPaths[imp.Path] {
+				typeImportPaths[imp.Path] = true
+				t.TypeImports = append(t.TypeImports, imp)
+			}
+		}
 		var wg sync.WaitGroup
-		SetupNetworkConnection(t)
+		SetupNetworkConnection(t, nil)
 		wg.Add(1)
 		WriteChannels(t, m, &wg)
 		channelDefs = append(channelDefs, t.ChannelDefSlice...)
@@ -651,11 +766,12 @@ func WriteCombinedTranslation(mds []*MessagesData, fileName string) {
 	t.Main = main
 	GenerateCombinedTranslation(t)
 	WriteCombinedTranslationToFile(t, fileName)
+	WriteModuleFile(fileName, runtimeDir)
 }
 
-func WriteTranslation(m *MessagesData, fileName string) {
-	t := &Translation{}
-	SetupNetworkConnection(t)
+func WriteTranslation(m *MessagesData, fileName string, config *NetworkConfig, runtimeDir string) {
+	t := &Translation{TypeImports: m.TypeImports}
+	SetupNetworkConnection(t, config)
 	WritePackage(t, m)
 	// Generate output strings concurrently
 	var wg sync.WaitGroup
@@ -684,4 +800,5 @@ func WriteTranslation(m *MessagesData, fileName string) {
 	wg2.Add(1)
 	WriteFunctionsToFile(t, fileName, &wg2)
 	wg2.Wait()
+	WriteModuleFile(fileName, runtimeDir)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ChdirTestTemp changes to a new temporary directory until the test ends,
+// so that generated code is written there.
+func ChdirTestTemp(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestGetRuntimeDir(t *testing.T) {
+	runtimeDir, err := filepath.Abs("runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOBBLE_RUNTIME", "runtime")
+	dir, err := GetRuntimeDir()
+	if err != nil || dir != runtimeDir {
+		t.Errorf("got %q, %v, want %q", dir, err, runtimeDir)
+	}
+	empty := t.TempDir()
+	t.Setenv("GOBBLE_RUNTIME", empty)
+	_, err = GetRuntimeDir()
+	if err == nil || !strings.Contains(err.Error(), "GOBBLE_RUNTIME is "+empty+", which does not hold the gobble/runtime package") {
+		t.Errorf("got error %v for a directory without the runtime", err)
+	}
+}
+
+func TestProcessFilesWritesModuleFile(t *testing.T) {
+	runtimeDir, err := filepath.Abs("runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := ChdirTestTemp(t)
+	source := "module M;\n\nlocal protocol P at A(role A, role B) {\n\tm(int) to B;\n}\n"
+	name := WriteTestFile(t, "test.scr", source)
+
+	t.Setenv("GOBBLE_RUNTIME", t.TempDir())
+	err = ProcessFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err == nil || !strings.Contains(err.Error(), "does not hold the gobble/runtime package") {
+		t.Fatalf("got error %v generating without the runtime", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "output")); !os.IsNotExist(err) {
+		t.Errorf("code was written although the runtime could not be found")
+	}
+
+	t.Setenv("GOBBLE_RUNTIME", runtimeDir)
+	err = ProcessFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := os.ReadFile(GetModuleFilePath("Protocol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(mod), "replace gobble/runtime => "+runtimeDir+"\n") {
+		t.Errorf("go.mod does not point at the runtime:\n%s", mod)
+	}
+
+	// An existing go.mod is kept, so the runtime need not be found again
+	t.Setenv("GOBBLE_RUNTIME", t.TempDir())
+	err = ProcessFiles([]string{name}, "Protocol", &NetworkConfig{})
+	if err != nil {
+		t.Errorf("unexpected error regenerating beside an existing go.mod: %v", err)
+	}
+}
@@ -24,30 +24,50 @@ import (
 	"sync"
 )
 
-func WriteSendMethodHeader(t *Translation, mess *MessageData, m *MessagesData) {
-	header := "func (self *"
+// GetMessageStructName returns the name of the struct on which the send
+// or receive method for mess is defined.
+func GetMessageStructName(mess *MessageData) string {
 	if IsInitialChoice(mess) {
-		header += GetInitialChoiceName(mess)
-	} else {
-		header += mess.Protagonist + GetStringSliceAsString(mess.Suffix)
+		return GetInitialChoiceName(mess)
 	}
-	header += ") Send"
-	header += "_" + mess.MethodNameBase
+	return mess.Protagonist + GetStringSliceAsString(mess.Suffix)
+}
+
+// GetMessageMethodName returns the name of the send or receive method
+// for mess, with direction "Send" or "Receive".
+func GetMessageMethodName(mess *MessageData, direction string) string {
+	name := direction + "_" + mess.MethodNameBase
 	for _, param := range mess.Parameters {
-		header += "_" + param
+		name += "_" + param
 	}
+	return name
+}
+
+// GetSubsequentStructName returns the name of the struct for the step
+// which follows mess in its conversation, or "" if mess ends it.
+func GetSubsequentStructName(mess *MessageData) string {
+	if len(mess.SubsequentSuffix) > 0 {
+		if mess.SubsequentSuffix[len(mess.SubsequentSuffix)-1] != "_end" {
+			return mess.Protagonist + GetStringSliceAsString(AddUnderscoreSeparatorBetweenIntStrings(mess.SubsequentSuffix))
+		}
+	}
+	return ""
+}
+
+func WriteSendMethodHeader(t *Translation, mess *MessageData, m *MessagesData) {
+	header := "func (self *"
+	header += GetMessageStructName(mess)
+	header += ") " + GetMessageMethodName(mess, "Send")
 	header += "("
-	for i, param := range mess.Parameters {
+	for i, param := range mess.ParameterTypes {
 		if i > 0 {
 			header += ", "
 		}
-		header += "param" + strconv.Itoa(i+1) + " " + param
+		header += mess.ParameterNames[i] + " " + param
 	}
 	header += ") ("
-	if len(mess.SubsequentSuffix) > 0 {
-		if mess.SubsequentSuffix[len(mess.SubsequentSuffix)-1] != "_end" {
-			header += "*" + mess.Protagonist + GetStringSliceAsString(AddUnderscoreSeparatorBetweenIntStrings(mess.SubsequentSuffix)) + ", "
-		}
+	if next := GetSubsequentStructName(mess); next != "" {
+		header += "*" + next + ", "
 	}
 	whereTo := mess.WhereToIfBranchEnds
 	if whereTo != (WhereToIfBranchEnds{}) {
@@ -64,36 +84,35 @@ func WriteSendMethodHeader(t *Translation, mess *MessageData, m *MessagesData) {
 
 func WriteReceiveMethodHeader(t *Translation, mess *MessageData, m *MessagesData) {
 	header := "func (self *"
-	if IsInitialChoice(mess) {
-		header += GetInitialChoiceName(mess)
-	} else {
-		header += mess.Protagonist + GetStringSliceAsString(mess.Suffix)
-	}
-	header += ") Receive"
-	header += "_" + mess.MethodNameBase
-	for _, param := range mess.Parameters {
-		header += "_" + param
-	}
+	header += GetMessageStructName(mess)
+	header += ") " + GetMessageMethodName(mess, "Receive")
 	header += "() ("
-	if len(mess.SubsequentSuffix) > 0 {
-		if mess.SubsequentSuffix[len(mess.SubsequentSuffix)-1] != "_end" {
-			header += "*" + mess.Protagonist + GetStringSliceAsString(mess.SubsequentSuffix) + ", "
-		}
+	header += GetReceiveMethodReturnTypes(mess, m)
+	header += ") {\n"
+	t.Methods += header
+}
+
+// GetReceiveMethodReturnTypes returns the comma separated list of the
+// types returned by the receive method for mess.
+func GetReceiveMethodReturnTypes(mess *MessageData, m *MessagesData) string {
+	retTypes := ""
+	if next := GetSubsequentStructName(mess); next != "" {
+		retTypes += "*" + next + ", "
 	}
 	whereTo := mess.WhereToIfBranchEnds
 	if whereTo != (WhereToIfBranchEnds{}) {
 		retVal := GetWhereToIfBranchEndsStructName(whereTo, m)
-		header += "*" + retVal + ", "
+		retTypes += "*" + retVal + ", "
 	}
 	if mess.ContinueToStruct != "" {
 		name := mess.ContinueToStruct
-		header += "*" + name + ", "
+		retTypes += "*" + name + ", "
 	}
-	for _, param := range mess.Parameters {
-		header += param + ", "
+	for _, param := range mess.ParameterTypes {
+		retTypes += param + ", "
 	}
-	header += "error) {\n"
-	t.Methods += header
+	retTypes += "error"
+	return retTypes
 }
 
 func GetDataStructNameWithIdAndType(id string, typ string, m *MessagesData) string {
@@ -134,11 +153,6 @@ func GetDataStructNameWithIdAndType(id string, typ string, m *MessagesData) stri
 	return name
 }
 
-func WriteSetUsedFunction(t *Translation) {
-	setUsedFunction := "\tdefer func() { self.Used = true }()\n"
-	t.Methods += setUsedFunction
-}
-
 func GetSendValName(mess *MessageData) string {
 	name := ""
 	name += strings.Title(mess.MethodNameBase)
@@ -158,12 +172,12 @@ func GetSendValName(mess *MessageData) string {
 
 func GetSendValContents(mess *MessageData) string {
 	contents := ""
-	for i, _ := range mess.Parameters {
+	for i, name := range mess.ParameterNames {
 		if i > 0 {
 			contents += ", "
 		}
-		contents += "Param" + strconv.Itoa(i+1) + ": "
-		contents += "param" + strconv.Itoa(i+1)
+		contents += CapitaliseFirstLetter(name) + ": "
+		contents += name
 	}
 	return contents
 }
@@ -175,18 +189,16 @@ func WriteSendMultiParamsMethodLine(t *Translation, mess *MessageData) {
 }
 
 func WriteSendMethodSendToChannelLine(t *Translation, mess *MessageData) {
-	line := "\tself.Channels."
-	line += GetChannelName(mess) + " <- "
-	line += "sendVal"
-	line += "\n"
+	line := "\truntime.Send(self.Channels."
+	line += GetChannelName(mess) + ", sendVal)\n"
 	t.Methods += line
 }
 
 func WriteReceiveMethodAssignInputsToVariables(t *Translation, mess *MessageData) {
 	assignments := ""
-	for i, param := range mess.Parameters {
-		assignments += "\tin_" + strconv.Itoa(i+1) + "_" + param
-		assignments += " = in.Param" + strconv.Itoa(i+1) + "\n"
+	for i, name := range mess.ParameterNames {
+		assignments += "\t" + GetReceiveVarName(mess, i)
+		assignments += " = in." + CapitaliseFirstLetter(name) + "\n"
 	}
 	t.Methods += assignments
 }
@@ -197,9 +209,9 @@ func WriteReceiveMethodInputChannel(t *Translation, mess *MessageData) {
 		line += "in := "
 	}
 	if mess.ChoiceChanName == "" {
-		line += "<- self.Channels." + GetChannelName(mess) + "\n"
+		line += "runtime.Recv(self.Channels." + GetChannelName(mess) + ")\n"
 	} else {
-		line += "<- self.Channels." + mess.ChoiceChanName + "\n"
+		line += "runtime.Recv(self.Channels." + mess.ChoiceChanName + ")\n"
 	}
 	t.Methods += line
 }
@@ -232,7 +244,19 @@ func WriteMethodRetValDefinitionLine(t *Translation, mess *MessageData, m *Messa
 }
 
 func WriteMethodStructAlreadyUsedCheck(t *Translation, mess *MessageData, m *MessagesData) {
-	check := "\tif self.Used {\n"
+	check := "\tif err := self.Use(\""
+	if IsInitialChoice(mess) {
+		check += GetInitialChoiceName(mess)
+	} else {
+		check += mess.Protagonist + GetStringSliceAsString(mess.Suffix)
+	}
+	check += "\", \""
+	if mess.Protagonist == mess.FromBase {
+		check += GetMessageMethodName(mess, "Send")
+	} else {
+		check += GetMessageMethodName(mess, "Receive")
+	}
+	check += "()\"); err != nil {\n"
 	check += "\t\treturn "
 	if len(mess.SubsequentSuffix) > 0 {
 		if mess.SubsequentSuffix[len(mess.SubsequentSuffix)-1] != "_end" {
@@ -247,26 +271,11 @@ func WriteMethodStructAlreadyUsedCheck(t *Translation, mess *MessageData, m *Mes
 		check += "retVal, "
 	}
 	if mess.Protagonist == mess.ToBase {
-		for i, param := range mess.Parameters {
-			check += "in_" + strconv.Itoa(i+1) + "_" + param + ", "
+		for i := range mess.Parameters {
+			check += GetReceiveVarName(mess, i) + ", "
 		}
 	}
-	check += "errors.New(\"Dynamic session type checking error: attempted repeat method call to one or more methods of a given session type struct. Struct: "
-	if IsInitialChoice(mess) {
-		check += GetInitialChoiceName(mess)
-	} else {
-		check += mess.Protagonist + GetStringSliceAsString(mess.Suffix)
-	}
-	check += "; method: "
-	if mess.Protagonist == mess.FromBase {
-		check += "Send"
-	} else {
-		check += "Receive"
-	}
-	for _, param := range mess.Parameters {
-		check += "_" + param
-	}
-	check += "()\")\n"
+	check += "err\n"
 	check += "\t}\n"
 	t.Methods += check
 }
@@ -305,8 +314,8 @@ func WriteReceiveMethodReturnLine(t *Translation, mess *MessageData, m *Messages
 	if whereTo != (WhereToIfBranchEnds{}) {
 		line += "retVal, "
 	}
-	for i, param := range mess.Parameters {
-		line += "in_" + strconv.Itoa(i+1) + "_" + param + ", "
+	for i := range mess.Parameters {
+		line += GetReceiveVarName(mess, i) + ", "
 	}
 	line += "nil\n"
 	t.Methods += line
@@ -315,11 +324,11 @@ func WriteReceiveMethodReturnLine(t *Translation, mess *MessageData, m *Messages
 func WriteSendParDoneSignalLine(t *Translation, mess *MessageData) {
 	if len(mess.SubsequentSuffix) > 0 {
 		if mess.SubsequentSuffix[len(mess.SubsequentSuffix)-1] == "_end" {
-			line := "\tself.Channels.done"
+			line := "\truntime.Done(self.Channels.done"
 			for i := 0; i < len(mess.Suffix)-1; i++ {
 				line += mess.Suffix[i]
 			}
-			line += " <- true\n"
+			line += ")\n"
 			t.Methods += line
 		}
 	}
@@ -331,7 +340,6 @@ func WriteSendMethodSendValDefinition(t *Translation, mess *MessageData) {
 
 func WriteSendMethod(t *Translation, mess *MessageData, m *MessagesData) {
 	WriteSendMethodHeader(t, mess, m)
-	WriteSetUsedFunction(t)
 	WriteSendMethodSendValDefinition(t, mess)
 	WriteMethodRetValDefinitionLine(t, mess, m)
 	WriteMethodStructAlreadyUsedCheck(t, mess, m)
@@ -340,17 +348,30 @@ func WriteSendMethod(t *Translation, mess *MessageData, m *MessagesData) {
 	WriteSendMethodReturnLine(t, mess)
 }
 
+// GetReceiveVarName returns the name of the variable in which a receive
+// method holds the ith value it has received: the name of the parameter
+// if it was given one, or in_i_Type otherwise.
+func GetReceiveVarName(mess *MessageData, i int) string {
+	if IsNamedParameter(mess, i) {
+		return mess.ParameterNames[i]
+	}
+	return "in_" + strconv.Itoa(i+1) + "_" + mess.Parameters[i]
+}
+
+func IsNamedParameter(mess *MessageData, i int) bool {
+	return mess.ParameterNames[i] != "param"+strconv.Itoa(i+1)
+}
+
 func WriteReceiveMethodInputVariableDeclarations(t *Translation, mess *MessageData) {
 	line := ""
-	for i, param := range mess.Parameters {
-		line += "\tvar in_" + strconv.Itoa(i+1) + "_" + param + " " + param + "\n"
+	for i := range mess.Parameters {
+		line += "\tvar " + GetReceiveVarName(mess, i) + " " + mess.ParameterTypes[i] + "\n"
 	}
 	t.Methods += line
 }
 
 func WriteReceiveMethod(t *Translation, mess *MessageData, m *MessagesData) {
 	WriteReceiveMethodHeader(t, mess, m)
-	WriteSetUsedFunction(t)
 	WriteReceiveMethodInputVariableDeclarations(t, mess)
 	WriteMethodRetValDefinitionLine(t, mess, m)
 	WriteMethodStructAlreadyUsedCheck(t, mess, m)
@@ -360,15 +381,40 @@ func WriteReceiveMethod(t *Translation, mess *MessageData, m *MessagesData) {
 	WriteReceiveMethodReturnLine(t, mess, m)
 }
 
-func WriteParStartMethodHeader(t *Translation, par *ParallelData) {
-	header := "func (self *"
-	header += par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_") + "_start) StartPar() ("
-	header += "*" + par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_") + "_end"
+// GetParStructNameBase returns the name shared by the _start and _end
+// structs of par.
+func GetParStructNameBase(par *ParallelData) string {
+	return par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_")
+}
+
+// GetParBranchStructNames returns the names of the structs for the first
+// steps of the branches of par.
+func GetParBranchStructNames(par *ParallelData) []string {
+	names := make([]string, 0)
 	for _, param := range par.OptionSuffixes {
 		if param[len(param)-5:len(param)] == "_par1" {
 			param += "_start"
 		}
-		header += ", *" + par.Protagonist + param
+		names = append(names, par.Protagonist+param)
+	}
+	return names
+}
+
+// GetParSubsequentStructName returns the name of the struct for the step
+// which follows par, or "" if par ends its conversation.
+func GetParSubsequentStructName(par *ParallelData) string {
+	if len(par.SubsequentSuffix) > 0 {
+		return par.Protagonist + GetStringSliceAsString(AddUnderscoreSeparatorBetweenIntStrings(par.SubsequentSuffix))
+	}
+	return ""
+}
+
+func WriteParStartMethodHeader(t *Translation, par *ParallelData) {
+	header := "func (self *"
+	header += GetParStructNameBase(par) + "_start) StartPar() ("
+	header += "*" + GetParStructNameBase(par) + "_end"
+	for _, name := range GetParBranchStructNames(par) {
+		header += ", *" + name
 	}
 	header += ", error) {\n"
 	t.Methods += header
@@ -391,9 +437,9 @@ func CutStringAfterLetter(myString string, letter string) string {
 
 func WriteParEndMethodHeader(t *Translation, par *ParallelData) {
 	header := "func (self *"
-	header += par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_") + "_end) EndPar() ("
-	if len(par.SubsequentSuffix) > 0 {
-		header += "*" + par.Protagonist + GetStringSliceAsString(AddUnderscoreSeparatorBetweenIntStrings(par.SubsequentSuffix)) + ", "
+	header += GetParStructNameBase(par) + "_end) EndPar() ("
+	if next := GetParSubsequentStructName(par); next != "" {
+		header += "*" + next + ", "
 	}
 	header += "error) {\n"
 	t.Methods += header
@@ -419,7 +465,7 @@ func WriteParStartMethodParDefinitions(t *Translation, par *ParallelData) {
 }
 
 func WriteParStartMethodAlreadyUsedCheck(t *Translation, par *ParallelData) {
-	check := "\tif self.Used {\n"
+	check := "\tif err := self.Use(\"" + par.Protagonist + GetStringSliceAsString(par.Suffix) + "_start\", \"StartPar()\"); err != nil {\n"
 	check += "\t\treturn "
 	check += par.Protagonist + CutStringAfterLetter(par.OptionSuffixes[0], "_") + "_end"
 	for _, param := range par.OptionSuffixes {
@@ -428,21 +474,17 @@ func WriteParStartMethodAlreadyUsedCheck(t *Translation, par *ParallelData) {
 		}
 		check += ", " + par.Protagonist + param
 	}
-	check += ", errors.New(\"Dynamic session type checking error: attempted repeat method call to one or more methods of a given session type struct. Struct: "
-	check += par.Protagonist + GetStringSliceAsString(par.Suffix) + "_start"
-	check += "; method: StartPar()\")\n\t}\n"
+	check += ", err\n\t}\n"
 	t.Methods += check
 }
 
 func WriteParEndMethodAlreadyUsedCheck(t *Translation, par *ParallelData) {
-	check := "\tif self.Used {\n"
+	check := "\tif err := self.Use(\"" + GetParStructNameBase(par) + "_end\", \"EndPar()\"); err != nil {\n"
 	check += "\t\treturn "
 	if len(par.SubsequentSuffix) > 0 {
 		check += "retVal, "
 	}
-	check += "errors.New(\"Dynamic session type checking error: attempted repeat method call to one or more methods of a given session type struct. Struct: "
-	check += par.Protagonist + GetStringSliceAsString(par.Suffix) + "_start"
-	check += "; method: EndPar()\")\n\t}\n"
+	check += "err\n\t}\n"
 	t.Methods += check
 }
 
@@ -468,26 +510,16 @@ func WriteParEndMethodRetValDefinition(t *Translation, par *ParallelData) {
 	}
 }
 
-func WriteParEndDoneSignalReceivers(t *Translation, par *ParallelData) {
-	for i := 0; i < len(par.OptionSuffixes); i++ {
-		receiver := "\tpar" + GetNextLetter(i) + "_done := false\n"
-		receiver += "\tselect{\n"
-		receiver += "\tcase <- self.Channels.done" + CutStringAfterLetter(par.OptionSuffixes[0], "_") + GetNextLetter(i) + ":\n"
-		receiver += "\t\tpar" + GetNextLetter(i) + "_done = true\n"
-		receiver += "\tdefault:\n"
-		receiver += "\t}\n"
-		t.Methods += receiver
-	}
-}
-
 func WriteParEndDoneCheckers(t *Translation, par *ParallelData) {
+	branches := GetParBranchStructNames(par)
 	for i := 0; i < len(par.OptionSuffixes); i++ {
-		checker := "\tif !par" + GetNextLetter(i) + "_done {\n"
+		checker := "\tif err := runtime.CheckDone(self.Channels.done" + CutStringAfterLetter(par.OptionSuffixes[0], "_") + GetNextLetter(i)
+		checker += ", \"" + GetParStructNameBase(par) + "_end\", \"" + branches[i] + "\"); err != nil {\n"
 		checker += "\t\treturn "
 		if len(par.SubsequentSuffix) > 0 {
 			checker += "retVal, "
 		}
-		checker += "errors.New(\"Dynamic session type checking error: attempted call to Seller" + GetStringSliceAsString(par.Suffix) + "_end.EndPar() prior to completion of parallel process Seller" + GetStringSliceAsString(par.Suffix) + GetNextLetter(i) + "\")\n"
+		checker += "err\n"
 		checker += "\t}\n"
 		t.Methods += checker
 	}
@@ -504,7 +536,6 @@ func WriteParEndReturnLine(t *Translation, par *ParallelData) {
 
 func WriteParStartMethod(t *Translation, par *ParallelData) {
 	WriteParStartMethodHeader(t, par)
-	WriteSetUsedFunction(t)
 	WriteParStartMethodEndDefinition(t, par)
 	WriteParStartMethodParDefinitions(t, par)
 	WriteParStartMethodAlreadyUsedCheck(t, par)
@@ -513,14 +544,122 @@ func WriteParStartMethod(t *Translation, par *ParallelData) {
 
 func WriteParEndMethod(t *Translation, par *ParallelData) {
 	WriteParEndMethodHeader(t, par)
-	WriteSetUsedFunction(t)
 	WriteParEndMethodRetValDefinition(t, par)
 	WriteParEndMethodAlreadyUsedCheck(t, par)
-	WriteParEndDoneSignalReceivers(t, par)
 	WriteParEndDoneCheckers(t, par)
 	WriteParEndReturnLine(t, par)
 }
 
+// GetChoiceStructName returns the name of the struct for the state at
+// which the choice is made.
+func GetChoiceStructName(choiceData *ChoiceData) string {
+	return choiceData.Protagonist + CutStringAfterLetter(choiceData.OptionSuffixes[0], "_")
+}
+
+// IsChosenChoice reports whether the choice is made by another role, so
+// that the protagonist must wait to find out which branch was chosen.
+func IsChosenChoice(choiceData *ChoiceData) bool {
+	return choiceData.Protagonist != choiceData.Chooser
+}
+
+// GetChoiceBranchStructName returns the name of the struct for the
+// branch of a choice made by another role which begins with mess.
+func GetChoiceBranchStructName(choiceData *ChoiceData, mess *MessageData) string {
+	return GetChoiceStructName(choiceData) + "_" + strings.TrimPrefix(GetMessageMethodName(mess, "Receive"), "Receive_")
+}
+
+// GetChoiceBranchMessages returns the first message of each branch of the
+// choice.
+func GetChoiceBranchMessages(choiceData *ChoiceData, m *MessagesData) []*MessageData {
+	messages := make([]*MessageData, 0)
+	for _, id := range choiceData.OptionIds {
+		mess, err := GetMessageDataWithId(m, id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		messages = append(messages, mess)
+	}
+	return messages
+}
+
+func WriteChoiceBranchInterface(t *Translation, choiceData *ChoiceData) {
+	name := GetChoiceStructName(choiceData)
+	def := "type " + name + "_Branch interface {\n"
+	def += "\tSwitch(visitor " + name + "_Visitor) error\n"
+	def += "\tis" + name + "_Branch()\n"
+	def += "}\n\n"
+	t.Methods += def
+}
+
+func WriteChoiceVisitorInterface(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	def := "type " + GetChoiceStructName(choiceData) + "_Visitor interface {\n"
+	for _, mess := range GetChoiceBranchMessages(choiceData, m) {
+		branch := GetChoiceBranchStructName(choiceData, mess)
+		def += "\tCase_" + strings.TrimPrefix(branch, GetChoiceStructName(choiceData)+"_")
+		def += "(branch *" + branch + ") error\n"
+	}
+	def += "}\n\n"
+	t.Methods += def
+}
+
+func WriteChoiceBranchStruct(t *Translation, choiceData *ChoiceData, mess *MessageData, m *MessagesData) {
+	name := GetChoiceStructName(choiceData)
+	branch := GetChoiceBranchStructName(choiceData, mess)
+	receive := GetMessageMethodName(mess, "Receive")
+	def := "type " + branch + " struct {\n"
+	def += "\tstate *" + name + "\n"
+	def += "}\n\n"
+	def += "func (self *" + branch + ") " + receive + "() (" + GetReceiveMethodReturnTypes(mess, m) + ") {\n"
+	def += "\treturn self.state." + receive + "()\n"
+	def += "}\n\n"
+	def += "func (self *" + branch + ") Switch(visitor " + name + "_Visitor) error {\n"
+	def += "\treturn visitor.Case_" + strings.TrimPrefix(branch, name+"_") + "(self)\n"
+	def += "}\n\n"
+	def += "func (self *" + branch + ") is" + name + "_Branch() {}\n\n"
+	t.Methods += def
+}
+
+func WriteChoiceBranchMethod(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	name := GetChoiceStructName(choiceData)
+	method := "func (self *" + name + ") Branch() (" + name + "_Branch, error) {\n"
+	method += "\tif err := self.Use(\"" + name + "\", \"Branch()\"); err != nil {\n"
+	method += "\t\treturn nil, err\n"
+	method += "\t}\n"
+	// The branch receives on a state of its own, which is left unused so
+	// that the receive method can be called on it once
+	method += "\tstate := &" + name + "{Channels: self.Channels}\n"
+	method += "\tselect {\n"
+	for _, mess := range GetChoiceBranchMessages(choiceData, m) {
+		method += "\tcase in := <- self.Channels." + mess.ChanName + ":\n"
+		if mess.ChoiceChanName != "" {
+			method += "\t\tself.Channels." + mess.ChoiceChanName + " <- in\n"
+		} else {
+			method += "\t\tself.Channels." + mess.ChanName + " <- in\n"
+		}
+		method += "\t\treturn &" + GetChoiceBranchStructName(choiceData, mess) + "{state: state}, nil\n"
+	}
+	method += "\t}\n"
+	method += "}\n\n"
+	t.Methods += method
+}
+
+// WriteChoiceBranches writes, for a choice made by another role, an
+// interface implemented by one struct for each branch, each of which
+// offers only the receive method for its branch, and a Branch method
+// which waits for the choice to be made and, like the other methods of
+// a state, may be called only once. The Switch method of a branch
+// calls the method of a visitor for that branch, so that a visitor must
+// handle every branch and adding a branch to the protocol is found by
+// the compiler.
+func WriteChoiceBranches(t *Translation, choiceData *ChoiceData, m *MessagesData) {
+	WriteChoiceBranchInterface(t, choiceData)
+	WriteChoiceVisitorInterface(t, choiceData, m)
+	for _, mess := range GetChoiceBranchMessages(choiceData, m) {
+		WriteChoiceBranchStruct(t, choiceData, mess, m)
+	}
+	WriteChoiceBranchMethod(t, choiceData, m)
+}
+
 func WriteMethods(t *Translation, m *MessagesData, wg *sync.WaitGroup) {
 	defer wg.Done()
 	t.Methods += "//Methods\n\n"
@@ -539,4 +678,9 @@ func WriteMethods(t *Translation, m *MessagesData, wg *sync.WaitGroup) {
 		WriteParEndMethod(t, par)
 		t.Methods += "}\n\n"
 	}
+	for _, choiceData := range m.Choices {
+		if IsChosenChoice(choiceData) {
+			WriteChoiceBranches(t, choiceData, m)
+		}
+	}
 }
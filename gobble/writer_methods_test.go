@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNamedParameters(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "m(dest: string, int) to B;\n\tn(count: int, []string) from B;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, receive := m.Messages[0], m.Messages[1]
+	tr := &Translation{}
+	WriteSendMethod(tr, send, m)
+	for _, want := range []string{
+		") Send_m_string_int(dest string, param2 int) (",
+		"sendVal := M_from_A_to_B_string_int{Dest: dest, Param2: param2}",
+	} {
+		if !strings.Contains(tr.Methods, want) {
+			t.Errorf("send method does not contain %q:\n%s", want, tr.Methods)
+		}
+	}
+	tr = &Translation{}
+	WriteReceiveMethod(tr, receive, m)
+	for _, want := range []string{
+		"\tvar count int\n",
+		"\tvar in_2_SliceOfString []string\n",
+		"\tcount = in.Count\n",
+		"\tin_2_SliceOfString = in.Param2\n",
+	} {
+		if !strings.Contains(tr.Methods, want) {
+			t.Errorf("receive method does not contain %q:\n%s", want, tr.Methods)
+		}
+	}
+	want := "type M_from_A_to_B_string_int struct {\n\tDest string\n\tParam2 int\n}\n\n"
+	if got := WriteDataStruct(send); got != want {
+		t.Errorf("got payload struct\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestProjectNamedParameters(t *testing.T) {
+	tree, err := ProjectTestSource(t, "module M;\n\nglobal protocol P(role A, role B) {\n\tm(dest: string, int) from A to B;\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for role, want := range map[string]string{"A": "m(dest: string, int) to B;", "B": "m(dest: string, int) from A;"} {
+		if local := GetTestLocal(t, tree, role); !strings.Contains(local, want) {
+			t.Errorf("local protocol of %s does not contain %q:\n%s", role, want, local)
+		}
+	}
+}
+
+func TestWriteChoiceBranches(t *testing.T) {
+	m, err := TranslateTestSource(t, "", "choice at B { x(int) from B; } or { y() from B; z() to B; }\n\tchoice at A { p() to B; } or { q() to B; }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Translation{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	WriteMethods(tr, m, &wg)
+	for _, want := range []string{
+		"type A_choice1_Branch interface {\n\tSwitch(visitor A_choice1_Visitor) error\n\tisA_choice1_Branch()\n}\n",
+		"type A_choice1_Visitor interface {\n\tCase_x_int(branch *A_choice1_x_int) error\n\tCase_y(branch *A_choice1_y) error\n}\n",
+		"func (self *A_choice1_x_int) Receive_x_int() (*A_choice2, int, error) {\n\treturn self.state.Receive_x_int()\n}\n",
+		"func (self *A_choice1_y) Switch(visitor A_choice1_Visitor) error {\n\treturn visitor.Case_y(self)\n}\n",
+		"func (self *A_choice1) Branch() (A_choice1_Branch, error) {\n\tif err := self.Use(\"A_choice1\", \"Branch()\"); err != nil {\n\t\treturn nil, err\n\t}\n\tstate := &A_choice1{Channels: self.Channels}\n",
+		"\tcase in := <- self.Channels.yFromBToA_Empty:\n",
+		"\t\treturn &A_choice1_y{state: state}, nil\n",
+	} {
+		if !strings.Contains(tr.Methods, want) {
+			t.Errorf("methods do not contain %q:\n%s", want, tr.Methods)
+		}
+	}
+	// Each branch offers only the receive method of its own branch
+	if strings.Contains(tr.Methods, "func (self *A_choice1_x_int) Receive_y(") {
+		t.Errorf("branch x offers the receive method of branch y")
+	}
+	// A choice the protagonist makes itself is made by calling a send method
+	if strings.Contains(tr.Methods, "A_choice2_Branch") || strings.Contains(tr.Methods, "func (self *A_choice2) Branch(") {
+		t.Errorf("Branch written for a choice made by the protagonist:\n%s", tr.Methods)
+	}
+}
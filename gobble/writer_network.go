@@ -18,6 +18,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"sort"
 	"strings"
 	"sync"
@@ -70,42 +72,6 @@ func WritePackage(t *Translation, m *MessagesData) {
 	t.Package = "package " + packageName + "\n\n"
 }
 
-func WriteTransmitterStruct() string {
-	trans := "type Transmitter struct {\n"
-	trans += "\tEncoder *gob.Encoder\n"
-	trans += "\tDecoder *gob.Decoder\n"
-	trans += "\tConnection net.Conn\n"
-	trans += "}\n\n"
-	return trans
-}
-
-func WriteClientTransmitterConstructor() string {
-	constructor := "func NewTransmitter(conType string, serverAddress string) *Transmitter {\n"
-	constructor += "\tt := new(Transmitter)\n"
-	constructor += "\tvar connToServer net.Conn\n"
-	constructor += "\tvar err error\n"
-	constructor += "\testablishingConnection := true\n"
-	constructor += "\tfor establishingConnection {\n"
-	constructor += "\t\tconnToServer, err = net.Dial(conType, serverAddress)\n"
-	constructor += "\t\tif err != nil {\n"
-	constructor += "\t\t\tfmt.Println(\"Connection error: \" + err.Error())\n"
-	constructor += "\t\t\tfmt.Println(\"Retrying...\")\n"
-	constructor += "\t\t\ttime.Sleep(time.Second * 3)\n"
-	constructor += "\t\t} else {\n"
-	constructor += "\t\t\testablishingConnection = false\n"
-	constructor += "\t\t\tfmt.Println(\"Successfully established \" + conType + \" connection with \" + serverAddress)\n"
-	constructor += "\t\t}\n"
-	constructor += "\t}\n"
-	constructor += "\tEncoder := gob.NewEncoder(connToServer)\n"
-	constructor += "\tDecoder := gob.NewDecoder(connToServer)\n"
-	constructor += "\tt.Connection = connToServer\n"
-	constructor += "\tt.Encoder = Encoder\n"
-	constructor += "\tt.Decoder = Decoder\n"
-	constructor += "\treturn t\n"
-	constructor += "}\n\n"
-	return constructor
-}
-
 type DialogueRecord struct {
 	Partner      string
 	Protagonist  string
@@ -138,7 +104,7 @@ func GetDialoguePartners(m *MessagesData) []string {
 	return partners
 }
 
-func GetDialoguesWithPartner(m *MessagesData, partnerName string) *DialogueRecord {
+func GetDialoguesWithPartner(m *MessagesData, partnerName string, config *NetworkConfig) *DialogueRecord {
 	msgs := make([]*MessageData, 0)
 	toMessages := false
 	fromMessages := false
@@ -152,86 +118,119 @@ func GetDialoguesWithPartner(m *MessagesData, partnerName string) *DialogueRecor
 			msgs = append(msgs, mess)
 		}
 	}
-	names := []string{m.Messages[0].Protagonist, partnerName}
-	// First alphabetically designated as client, second alphabetically
-	// designated as server
-	sort.Strings(names)
-	client := names[0]
-	server := names[1]
+	client := GetDialer(config, m.Messages[0].Protagonist, partnerName)
+	server := partnerName
+	if client == partnerName {
+		server = m.Messages[0].Protagonist
+	}
 	dialogue := DialogueRecord{Partner: partnerName, Messages: msgs, Client: client, Server: server, ToMessages: toMessages, FromMessages: fromMessages, Protagonist: msgs[0].Protagonist}
 	return &dialogue
 }
 
-func GetDialogues(m *MessagesData) []*DialogueRecord {
+func GetDialogues(m *MessagesData, config *NetworkConfig) []*DialogueRecord {
 	dialogues := make([]*DialogueRecord, 0)
 	partners := GetDialoguePartners(m)
 	for _, p := range partners {
-		newDialogue := GetDialoguesWithPartner(m, p)
+		newDialogue := GetDialoguesWithPartner(m, p, config)
 		dialogues = append(dialogues, newDialogue)
 	}
 	return dialogues
 }
 
-func WriteNetworkSentToFunctionHeader(m *MessagesData, dialogue *DialogueRecord) string {
-	header := "func SendTo" + dialogue.Partner
-	header += "(chans *Channels, trans *Transmitter) {\n"
-	return header
+// GetDialogueHash returns the hash of the protagonist's local protocol
+// restricted to the messages it exchanges with the partner of the
+// dialogue, written by WriteDialogueActions. The restriction keeps the
+// order of the messages and the choice, par, rec and continue blocks
+// around them, and is written the same way from either end, so the local
+// protocols of two compatible roles give the same hash, while adding,
+// removing, renaming, reordering or changing the types of any message
+// between them, or moving it into or out of a choice or loop, changes it.
+func GetDialogueHash(m *MessagesData, dialogue *DialogueRecord) string {
+	actions := RestrictNodes(m.Local.Conv.Nodes, dialogue.Protagonist, dialogue.Partner, m.TypeMap)
+	sum := sha256.Sum256([]byte(WriteDialogueActions(actions)))
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteDialogueActions writes a restricted local protocol naming the
+// sender and receiver of each message rather than its direction, so that
+// it does not depend on which of the two roles it was restricted from.
+// The branches of a choice and of a par block are sorted and those of a
+// choice de-duplicated, since the role which makes a choice may have
+// several branches which its partner cannot tell apart.
+func WriteDialogueActions(actions []*Action) string {
+	s := ""
+	for _, a := range actions {
+		if a.Kind == "send" || a.Kind == "receive" {
+			s += a.Mess.From + "->" + a.Mess.To + ":" + a.Mess.Name + "(" + strings.Join(a.Types, ", ") + ");"
+		} else if a.Kind == "choice" || a.Kind == "par" {
+			branches := make([]string, 0)
+			seen := make(map[string]bool)
+			for _, branch := range a.Branches {
+				b := WriteDialogueActions(branch)
+				if a.Kind == "par" || !seen[b] {
+					seen[b] = true
+					branches = append(branches, b)
+				}
+			}
+			sort.Strings(branches)
+			s += a.Kind + "{" + strings.Join(branches, "}{") + "}"
+		} else if a.Kind == "rec" {
+			s += "rec{" + WriteDialogueActions(a.Body) + "}"
+		} else if a.Kind == "continue" {
+			s += "continue;"
+		}
+	}
+	return s
 }
 
-func WriteNetworkSentToFunctionDefer(m *MessagesData, dialogue *DialogueRecord) string {
-	def := "\tdefer func() {\n"
-	def += "\t\ttrans.Connection.Close()\n"
-	def += "\t}()\n"
-	return def
+// WriteNetworkSessionVar writes the runtime.Session the protagonist
+// states and checks in its handshake with each partner.
+func WriteNetworkSessionVar(m *MessagesData, dialogues []*DialogueRecord) string {
+	v := "var networkSession = runtime.Session{\n"
+	v += "\tModule:   \"" + m.Module + "\",\n"
+	v += "\tProtocol: \"" + m.Protocol + "\",\n"
+	v += "\tRole:     \"" + GetProtagonist(m) + "\",\n"
+	v += "\tHashes: map[string]string{\n"
+	for _, dialogue := range dialogues {
+		v += "\t\t\"" + dialogue.Partner + "\": \"" + GetDialogueHash(m, dialogue) + "\",\n"
+	}
+	v += "\t},\n"
+	v += "}\n\n"
+	return v
 }
 
-func WriteNetworkSentToFunctionIdentifySelf(m *MessagesData, dialogue *DialogueRecord) string {
-	slice := []*DialogueRecord{dialogue}
-	isClient := GetIsClient(slice)
-	id := "\t"
-	if !isClient {
-		id += "// "
-	}
-	id += "identifier := Identifier{Id: \""
-	id += m.Messages[0].Protagonist + "\"}"
-	id += " // Comment out line if connecting to " + dialogue.Partner + " as server; uncomment line if connecting to " + dialogue.Partner + " as client."
-	id += "\n"
-	id += "\t"
-	if !isClient {
-		id += "// "
-	}
-	id += "trans.Encoder.Encode(identifier)"
-	id += " // Comment out line if connecting to " + dialogue.Partner + " as server; uncomment line if connecting to " + dialogue.Partner + " as client."
-	id += "\n"
-	return id
+func WriteNetworkSentToFunctionHeader(m *MessagesData, dialogue *DialogueRecord) string {
+	header := "func SendTo" + dialogue.Partner
+	header += "(chans *Channels, trans *runtime.Transmitter) {\n"
+	return header
 }
 
 func WriteNetworkSendToFunctionCase(mess *MessageData, m *MessagesData, dialogue *DialogueRecord) string {
-	self := dialogue.Protagonist
-	other := dialogue.Partner
-	roleNames := []string{self, other}
-	// Order alphabetically
-	sort.Strings(roleNames)
-	gobName := strings.Title(roleNames[0]) + roleNames[1] + "Gob"
 	c := "\t\tcase out := <-chans."
 	c += mess.ChanName + ":\n"
-	c += "\t\t\tg := " + gobName + "{Name: \""
-	c += GetSendValName(mess) + "\", "
-	c += GetSendValName(mess) + ": out}\n"
-	c += "\t\t\terr := trans.Encoder.Encode(g)\n"
-	c += "\t\t\tif err != nil {\n"
-	c += "\t\t\t\tlog.Fatal(err)\n"
-	c += "\t\t\t}\n"
+	c += "\t\t\truntime.Encode(trans, \"" + GetSendValName(mess) + "\", out)\n"
+	return c
+}
+
+func WriteNetworkSendToFunctionClose(dialogue *DialogueRecord, indent string) string {
+	c := indent + "trans.Connection.Close()\n"
+	c += indent + "chans.doneCommunicatingWith" + dialogue.Partner + " <- true\n"
 	return c
 }
 
 func WriteNetworkSendToFunctionDoneCase(dialogue *DialogueRecord) string {
-	c := "\t\tcase <- chans.doneCommunicatingWith" + dialogue.Partner + ":\n"
+	c := "\t\tcase <-chans.doneCommunicatingWith" + dialogue.Partner + ":\n"
+	c += WriteNetworkSendToFunctionClose(dialogue, "\t\t\t")
 	c += "\t\t\treturn\n"
 	return c
 }
 
 func WriteNetworkSentToFunctionLoop(m *MessagesData, dialogue *DialogueRecord) string {
+	if !dialogue.ToMessages {
+		wait := "\t<-chans.doneCommunicatingWith" + dialogue.Partner + "\n"
+		wait += WriteNetworkSendToFunctionClose(dialogue, "\t")
+		return wait
+	}
 	loop := "\tfor {\n"
 	loop += "\t\tselect {\n"
 	for _, mess := range dialogue.Messages {
@@ -240,17 +239,18 @@ func WriteNetworkSentToFunctionLoop(m *MessagesData, dialogue *DialogueRecord) s
 		}
 	}
 	loop += WriteNetworkSendToFunctionDoneCase(dialogue)
-	loop += "\t\tdefault:\n"
-	loop += "\t\t\t// Keep looping\n"
 	loop += "\t\t}\n"
 	loop += "\t}\n"
 	return loop
 }
 
+// WriteNetworkSendToFunction writes the goroutine which owns the
+// connection with a partner: it waits for a message to send to the
+// partner or for the end of the session, when it closes the connection
+// and tells CloseNetworkConnections it has done so. It is written for
+// every partner, including those which are only received from.
 func WriteNetworkSendToFunction(m *MessagesData, dialogue *DialogueRecord) string {
 	fun := WriteNetworkSentToFunctionHeader(m, dialogue)
-	fun += WriteNetworkSentToFunctionDefer(m, dialogue)
-	fun += WriteNetworkSentToFunctionIdentifySelf(m, dialogue)
 	fun += WriteNetworkSentToFunctionLoop(m, dialogue)
 	fun += "}\n\n"
 	return fun
@@ -259,34 +259,21 @@ func WriteNetworkSendToFunction(m *MessagesData, dialogue *DialogueRecord) strin
 func WriteNetworkSendToFunctions(m *MessagesData, dialogues []*DialogueRecord) string {
 	funcs := ""
 	for _, dialogue := range dialogues {
-		if dialogue.ToMessages {
-			funcs += WriteNetworkSendToFunction(m, dialogue)
-		}
+		funcs += WriteNetworkSendToFunction(m, dialogue)
 	}
 	return funcs
 }
 
 func WriteNetworkReceiveFromFunctionHeader(dialogue *DialogueRecord) string {
 	header := "func ReceiveFrom" + dialogue.Partner
-	header += "(chans *Channels, trans *Transmitter) {\n"
+	header += "(chans *Channels, trans *runtime.Transmitter) {\n"
 	return header
 }
 
-func WriteNetworkReceiveFromFunctionGobDec(dialogue *DialogueRecord) string {
-	self := dialogue.Protagonist
-	other := dialogue.Partner
-	roleNames := []string{self, other}
-	// Order alphabetically
-	sort.Strings(roleNames)
-	varType := strings.Title(roleNames[0]) + roleNames[1] + "Gob"
-	dec := "\tvar in " + varType + "\n"
-	return dec
-}
-
 func WriteNetworkReceiveFromFunctionLoopDecode(dialogue *DialogueRecord) string {
-	dec := "\t\terr := trans.Decoder.Decode(&in)\n"
-	dec += "\t\tif err != nil {\n"
-	dec += "\t\t\tlog.Fatal(err)\n"
+	dec := "\t\tname, ok := runtime.DecodeName(trans)\n"
+	dec += "\t\tif !ok {\n"
+	dec += "\t\t\treturn\n"
 	dec += "\t\t}\n"
 	return dec
 }
@@ -298,15 +285,15 @@ func WriteNetworkReceiveFromFunctionLoopMessage(firstInIfThenElseChain bool, mes
 	} else {
 		cond = "\t\t} else if"
 	}
-	msg := cond + " in.Name == \"" + GetSendValName(mess) + "\" {\n"
+	msg := cond + " name == \"" + GetSendValName(mess) + "\" {\n"
 	msg += "\t\t\tchans." + mess.ChanName
-	msg += " <- in." + GetSendValName(mess) + "\n"
+	msg += " <- runtime.Decode[" + GetSendValName(mess) + "](trans)\n"
 	return msg
 }
 
-func WriteNetworkReceiveFromFunctionLoopUnknownGob() string {
+func WriteNetworkReceiveFromFunctionLoopUnknownMessage(dialogue *DialogueRecord) string {
 	unknown := "\t\t} else {\n"
-	unknown += "\t\t\tlog.Fatal(\"ReceiveFromServer() received unknown gob: \", in)\n"
+	unknown += "\t\t\tlog.Fatal(\"ReceiveFrom" + dialogue.Partner + "() received unknown message: \", name)\n"
 	unknown += "\t\t}\n"
 	return unknown
 }
@@ -322,7 +309,7 @@ func WriteNetworkReceiveFromFunctionLoop(dialogue *DialogueRecord) string {
 			firstInIfThenElseChain = false
 		}
 	}
-	loop += WriteNetworkReceiveFromFunctionLoopUnknownGob()
+	loop += WriteNetworkReceiveFromFunctionLoopUnknownMessage(dialogue)
 	loop += "\t}\n"
 	return loop
 }
@@ -330,7 +317,6 @@ func WriteNetworkReceiveFromFunctionLoop(dialogue *DialogueRecord) string {
 func WriteNetworkReceiveFromFunction(m *MessagesData, dialogue *DialogueRecord) string {
 	fun := ""
 	fun += WriteNetworkReceiveFromFunctionHeader(dialogue)
-	fun += WriteNetworkReceiveFromFunctionGobDec(dialogue)
 	fun += WriteNetworkReceiveFromFunctionLoop(dialogue)
 	fun += "}\n\n"
 	return fun
@@ -346,59 +332,33 @@ func WriteNetworkReceiveFromFunctions(m *MessagesData, dialogues []*DialogueReco
 	return funcs
 }
 
-func WriteNetworkIdentifierStruct() string {
-	strct := "type Identifier struct {\n"
-	strct += "\tId string\n"
-	strct += "}\n\n"
-	return strct
-}
-
-func WriteNetworkGobStruct(m *MessagesData, dialogue *DialogueRecord) string {
-	self := dialogue.Protagonist
-	other := dialogue.Partner
-	roleNames := []string{self, other}
-	// Order alphabetically
-	sort.Strings(roleNames)
-	strct := "type " + strings.Title(roleNames[0]) + roleNames[1] + "Gob struct {\n"
-	strct += "\tName string\n"
-	typeNames := make([]string, 0)
-	for _, mess := range dialogue.Messages {
-		nextType := GetSendValName(mess)
-		typeNames = append(typeNames, nextType)
-	}
-	// Order alphabetically
-	sort.Strings(typeNames)
-	for _, t := range typeNames {
-		strct += "\t" + t + " " + t + "\n"
+// WriteNetworkRegisterTypesFunction registers the payload types
+// declared with type declarations with gob, so that values of those
+// types can be sent between roles.
+func WriteNetworkRegisterTypesFunction(m *MessagesData) string {
+	if len(m.CustomTypes) == 0 {
+		return ""
 	}
-	strct += "}\n\n"
-	return strct
-}
-
-func WriteNetworkGobStructs(m *MessagesData, dialogues []*DialogueRecord) string {
-	strcts := ""
-	for _, dialogue := range dialogues {
-		strcts += WriteNetworkGobStruct(m, dialogue)
+	fun := "func init() {\n"
+	for _, typ := range m.CustomTypes {
+		fun += "\tgob.Register(*new(" + typ + "))\n"
 	}
-	return strcts
+	fun += "}\n\n"
+	return fun
 }
 
 func WriteNetworkStartConnectionFunctionHeader(m *MessagesData, dialogue *DialogueRecord) string {
 	header := "func ConnectTo" + dialogue.Partner + "AsClient"
-	header += "(chans *Channels, conType string, serverAddress string) {\n"
+	header += "(chans *Channels, conType string, serverAddress string, codec runtime.Codec) {\n"
 	return header
 }
 
-func WriteNetworkStartConnectionFunctionTransmitterDef() string {
-	def := "\ttrans := NewTransmitter(conType, serverAddress)\n"
-	return def
+func WriteNetworkStartConnectionFunctionTransmitterDef(m *MessagesData, dialogue *DialogueRecord) string {
+	return "\ttrans := runtime.DialSession(conType, serverAddress, codec, networkSession, \"" + dialogue.Partner + "\")\n"
 }
 
 func WriteNetworkStartConnectionFunctionLaunchGoRoutines(m *MessagesData, dialogue *DialogueRecord) string {
-	launchers := ""
-	if dialogue.ToMessages {
-		launchers += "\tgo SendTo" + dialogue.Partner + "(chans, trans)\n"
-	}
+	launchers := "\tgo SendTo" + dialogue.Partner + "(chans, trans)\n"
 	if dialogue.FromMessages {
 		launchers += "\tgo ReceiveFrom" + dialogue.Partner + "(chans, trans)\n"
 	}
@@ -408,7 +368,7 @@ func WriteNetworkStartConnectionFunctionLaunchGoRoutines(m *MessagesData, dialog
 func WriteNetworkStartConnectionFunction(m *MessagesData, dialogue *DialogueRecord) string {
 	fun := ""
 	fun += WriteNetworkStartConnectionFunctionHeader(m, dialogue)
-	fun += WriteNetworkStartConnectionFunctionTransmitterDef()
+	fun += WriteNetworkStartConnectionFunctionTransmitterDef(m, dialogue)
 	fun += WriteNetworkStartConnectionFunctionLaunchGoRoutines(m, dialogue)
 	fun += "}\n\n"
 	return fun
@@ -429,8 +389,8 @@ func WriteNetworkCloseConnectionFunctionHeader(m *MessagesData, dialogue *Dialog
 }
 
 func WriteNetworkCloseConnectionFunctionSend(m *MessagesData, dialogue *DialogueRecord) string {
-	line := "\tchans.doneCommunicatingWith" + dialogue.Partner
-	line += "<- true\n"
+	line := "\tchans.doneCommunicatingWith" + dialogue.Partner + " <- true\n"
+	line += "\t<-chans.doneCommunicatingWith" + dialogue.Partner + "\n"
 	return line
 }
 
@@ -450,46 +410,30 @@ func WriteNetworkCloseConnectionFunctions(m *MessagesData, dialogues []*Dialogue
 }
 
 func WriteNetworkAcceptConnectionsFunction() string {
-	fun := "func AcceptConnections(conType string, port string, chans *Channels) {\n"
-	fun += "\tln, err := net.Listen(conType, port)\n"
-	fun += "\tif err != nil {\n"
-	fun += "\t\tlog.Fatal(err)\n"
-	fun += "\t}\n"
-	fun += "\tfor {\n"
-	fun += "\t\tconn, err := ln.Accept()\n"
-	fun += "\t\tif err != nil {\n"
-	fun += "\t\t\tlog.Fatal(err)\n"
-	fun += "\t\t}\n"
-	fun += "\t\tHandleConnection(conn, chans)\n"
-	fun += "\t}\n"
+	fun := "func AcceptConnections(conType string, endpoint string, codec runtime.Codec, chans *Channels) {\n"
+	fun += "\truntime.AcceptConnections(conType, endpoint, codec, networkSession, func(trans *runtime.Transmitter, role string) {\n"
+	fun += "\t\tHandleConnection(trans, role, chans)\n"
+	fun += "\t})\n"
 	fun += "}\n\n"
 	return fun
 }
 
 func WriteNetworkHandleConnectionsFunction(m *MessagesData, dialogues []*DialogueRecord) string {
-	fun := "func HandleConnection(conn net.Conn, chans *Channels) {\n"
-	fun += "\tdecoder := gob.NewDecoder(conn)\n"
-	fun += "\tencoder := gob.NewEncoder(conn)\n"
-	fun += "\ttrans := &Transmitter{Decoder: decoder, Encoder: encoder, Connection: conn}\n"
-	fun += "\tvar identifier Identifier\n"
-	fun += "\terr := trans.Decoder.Decode(&identifier)\n"
-	fun += "\tif err != nil {\n"
-	fun += "\t\tlog.Fatal(err)\n"
-	fun += "\t}\n"
+	fun := "func HandleConnection(trans *runtime.Transmitter, role string, chans *Channels) {\n"
 	for i, dialogue := range dialogues {
 		if i == 0 {
 			fun += "\tif "
 		} else {
 			fun += "\t} else if "
 		}
-		fun += "identifier.Id == \"" + dialogue.Partner
+		fun += "role == \"" + dialogue.Partner
 		fun += "\" {\n"
 		fun += "\t\tHandle" + dialogue.Partner
 		fun += "ConnectionAsServer(trans, chans)\n"
 	}
 	fun += "\t} else {\n"
 	fun += "\t\tlog.Fatal(\"HandleConnection received "
-	fun += "unknown identifier: \", identifier)\n"
+	fun += "connection from unexpected role: \", role)\n"
 	fun += "\t}\n"
 	fun += "}\n\n"
 	return fun
@@ -497,10 +441,8 @@ func WriteNetworkHandleConnectionsFunction(m *MessagesData, dialogues []*Dialogu
 
 func WriteNetworkHandleConnectionsAsServerFunction(m *MessagesData, dialogue *DialogueRecord) string {
 	fun := "func Handle" + dialogue.Partner
-	fun += "ConnectionAsServer(trans *Transmitter, chans *Channels) {\n"
-	if dialogue.ToMessages {
-		fun += "\tgo SendTo" + dialogue.Partner + "(chans, trans)\n"
-	}
+	fun += "ConnectionAsServer(trans *runtime.Transmitter, chans *Channels) {\n"
+	fun += "\tgo SendTo" + dialogue.Partner + "(chans, trans)\n"
 	if dialogue.FromMessages {
 		fun += "\tgo ReceiveFrom" + dialogue.Partner + "(chans, trans)\n"
 	}
@@ -520,80 +462,56 @@ func WriteNetworkSetupFunctionHeader(m *MessagesData, dialogues []*DialogueRecor
 	header := "func SetupNetworkConnections("
 	header += "chans *Channels, "
 	header += "connType string, "
-	header += "address string,"
-	header += "port string"
+	header += "endpoints runtime.Endpoints, "
+	header += "codec runtime.Codec"
 	header += ") {\n"
 	return header
 }
 
-func GetIsServer(dialogues []*DialogueRecord) bool {
-	isServer := false
+// GetServerDialogues returns the dialogues in which the partner dials
+// the protagonist.
+func GetServerDialogues(dialogues []*DialogueRecord) []*DialogueRecord {
+	server := make([]*DialogueRecord, 0)
 	for _, dialogue := range dialogues {
-		pair := []string{dialogue.Protagonist, dialogue.Partner}
-		// Order alphabetically
-		sort.Strings(pair)
-		if pair[1] == dialogue.Protagonist {
-			isServer = true
+		if dialogue.Server == dialogue.Protagonist {
+			server = append(server, dialogue)
 		}
 	}
-	return isServer
+	return server
 }
 
-func GetIsClient(dialogues []*DialogueRecord) bool {
-	isClient := false
+// GetClientDialogues returns the dialogues in which the protagonist dials
+// the partner.
+func GetClientDialogues(dialogues []*DialogueRecord) []*DialogueRecord {
+	client := make([]*DialogueRecord, 0)
 	for _, dialogue := range dialogues {
-		pair := []string{dialogue.Protagonist, dialogue.Partner}
-		// Order alphabetically
-		sort.Strings(pair)
-		if pair[0] == dialogue.Protagonist {
-			isClient = true
+		if dialogue.Client == dialogue.Protagonist {
+			client = append(client, dialogue)
 		}
 	}
-	return isClient
+	return client
 }
 
-func WriteNetworkLaunchAcceptConnections(m *MessagesData, dialogues []*DialogueRecord, isServer bool) string {
-	line := "\t"
-	if !isServer {
-		line += "//"
-	}
-	line += "go AcceptConnections(connType, port, chans)"
-	if !isServer {
-		line += " // Uncomment to accept connections as Server"
-	} else {
-		line += " // Comment out to stop accepting connections as Server"
-	}
-	line += "\n"
-	return line
+func WriteNetworkLaunchAcceptConnections(m *MessagesData) string {
+	return "\tgo AcceptConnections(connType, endpoints.Listen(\"" + GetProtagonist(m) + "\"), codec, chans)\n"
 }
 
 func WriteNetworkLaunchMakeConnectionAsClient(m *MessagesData, dialogue *DialogueRecord) string {
-	slice := []*DialogueRecord{dialogue}
-	isClient := GetIsClient(slice)
-	line := "\t"
-	if !isClient {
-		line += "// "
-	}
-	line += "ConnectTo" + dialogue.Partner + "AsClient("
+	line := "\tConnectTo" + dialogue.Partner + "AsClient("
 	line += "chans, "
 	line += "connType, "
-	line += "address + port"
-	line += ")"
-	if !isClient {
-		line += " // Uncomment to connect as client"
-	} else {
-		line += " // Comment out to stop connecting as client"
-	}
-	line += "\n"
+	line += "endpoints.Dial(\"" + dialogue.Partner + "\"), "
+	line += "codec"
+	line += ")\n"
 	return line
 }
 
 func WriteNetworkSetupFunction(m *MessagesData, dialogues []*DialogueRecord) string {
-	isServer := GetIsServer(dialogues)
-	_ = GetIsClient(dialogues)
 	fun := WriteNetworkSetupFunctionHeader(m, dialogues)
-	fun += WriteNetworkLaunchAcceptConnections(m, dialogues, isServer)
-	for _, dialogue := range dialogues {
+	if len(GetServerDialogues(dialogues)) > 0 {
+		fun += WriteNetworkLaunchAcceptConnections(m)
+	}
+	for _, dialogue := range GetClientDialogues(dialogues) {
 		fun += WriteNetworkLaunchMakeConnectionAsClient(m, dialogue)
 	}
 	fun += "}\n\n"
@@ -614,18 +532,18 @@ func WriteNetworkCloseConnectionsFunction(m *MessagesData, dialogues []*Dialogue
 func WriteNetwork(t *Translation, m *MessagesData, wg *sync.WaitGroup) string {
 	defer wg.Done()
 	network := "// Network \n\n"
-	network += WriteTransmitterStruct()
-	network += WriteClientTransmitterConstructor()
-	network += WriteNetworkIdentifierStruct()
-	dialogues := GetDialogues(m)
-	network += WriteNetworkGobStructs(m, dialogues)
+	dialogues := GetDialogues(m, t.NetConfig)
+	network += WriteNetworkRegisterTypesFunction(m)
+	network += WriteNetworkSessionVar(m, dialogues)
 	network += WriteNetworkSendToFunctions(m, dialogues)
 	network += WriteNetworkReceiveFromFunctions(m, dialogues)
-	network += WriteNetworkStartConnectionFunctions(m, dialogues)
-	network += WriteNetworkCloseConnectionFunctions(m, dialogues)
-	network += WriteNetworkAcceptConnectionsFunction()
-	network += WriteNetworkHandleConnectionsFunction(m, dialogues)
-	network += WriteNetworkHandleConnectionsAsServerFunctions(m, dialogues)
+	network += WriteNetworkStartConnectionFunctions(m, GetClientDialogues(dialogues))
+	network += WriteNetworkCloseConnectionFunctions(m, GetClientDialogues(dialogues))
+	if server := GetServerDialogues(dialogues); len(server) > 0 {
+		network += WriteNetworkAcceptConnectionsFunction()
+		network += WriteNetworkHandleConnectionsFunction(m, server)
+		network += WriteNetworkHandleConnectionsAsServerFunctions(m, server)
+	}
 	network += WriteNetworkSetupFunction(m, dialogues)
 	network += WriteNetworkCloseConnectionsFunction(m, dialogues)
 	return network
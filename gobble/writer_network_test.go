@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+)
+
+func GetTestDialogueHash(t *testing.T, source string, partner string) string {
+	t.Helper()
+	tree, err := ParseTestSource(source)
+	if err != nil {
+		t.Fatalf("unexpected error parsing source: %v", err)
+	}
+	m, err := TranslateTree(GetLocalProtocols(tree)[0])
+	if err != nil {
+		t.Fatalf("unexpected error translating source: %v", err)
+	}
+	return GetDialogueHash(m, &DialogueRecord{Protagonist: m.Local.Protagonist, Partner: partner})
+}
+
+func GetTestLocalSource(role string, body string) string {
+	return "module M;\n\ntype <go> \"int\" from \"\" as Count;\n\nlocal protocol P at " + role + "(role A, role B, role C) {\n\t" + body + "\n}\n"
+}
+
+// CheckTestDialogueHashesAgree checks that each pair of roles in locals
+// gives the same hash for the dialogue between them, and that there are
+// want such dialogues.
+func CheckTestDialogueHashesAgree(t *testing.T, locals []*Protocol, want int) {
+	t.Helper()
+	hashes := make(map[string]string)
+	for _, local := range locals {
+		m, err := TranslateTree(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, partner := range GetDialoguePartners(m) {
+			role := m.Local.Protagonist
+			hash := GetDialogueHash(m, &DialogueRecord{Protagonist: role, Partner: partner})
+			key := role + " " + partner
+			if partner < role {
+				key = partner + " " + role
+			}
+			if other, ok := hashes[key]; ok && other != hash {
+				t.Errorf("%s and %s give different hashes for their dialogue", role, partner)
+			}
+			hashes[key] = hash
+		}
+	}
+	if len(hashes) != want {
+		t.Errorf("got %d dialogues, want %d", len(hashes), want)
+	}
+}
+
+func TestGetDialogueHashAgreesAcrossRoles(t *testing.T) {
+	tree, err := ParseAndCheckGlobals("travelAgencyGlobal.scr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ProjectAndCheck(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckTestDialogueHashesAgree(t, GetLocalProtocols(tree), 3)
+}
+
+// The hand-written locals of the case study must agree as well as the
+// projections, or the generated programs refuse each other's handshake.
+func TestGetDialogueHashAgreesAcrossCaseStudyLocals(t *testing.T) {
+	trees, err := ParseFiles([]string{"aggregatorLocal.scr", "clientLocal.scr", "brutishAirwaysLocal.scr", "queasyJetLocal.scr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	locals := make([]*Protocol, 0)
+	for _, tree := range trees {
+		locals = append(locals, GetLocalProtocols(tree)...)
+	}
+	CheckTestDialogueHashesAgree(t, locals, 3)
+}
+
+func TestGetDialogueHash(t *testing.T) {
+	base := GetTestDialogueHash(t, GetTestLocalSource("A", "rec L { x(int) to B; y(string) from B; choice at A { z() to B; continue L; } or { w() to B; } }"), "B")
+	tests := []struct {
+		name    string
+		source  string
+		partner string
+		same    bool
+	}{
+		{
+			name:    "partner",
+			source:  GetTestLocalSource("B", "rec L { x(int) from A; y(string) to A; choice at A { z() from A; continue L; } or { w() from A; } }"),
+			partner: "A",
+			same:    true,
+		},
+		{
+			name:   "other roles",
+			source: GetTestLocalSource("A", "m() to C; rec L { x(int) to B; y(string) from B; n() from C; choice at A { z() to B; continue L; } or { w() to B; } }"),
+			same:   true,
+		},
+		{
+			name:   "aliased type",
+			source: GetTestLocalSource("A", "rec L { x(Count) to B; y(string) from B; choice at A { z() to B; continue L; } or { w() to B; } }"),
+			same:   true,
+		},
+		{
+			name:   "branch order",
+			source: GetTestLocalSource("A", "rec L { x(int) to B; y(string) from B; choice at A { w() to B; } or { z() to B; continue L; } }"),
+			same:   true,
+		},
+		{
+			name:   "message order",
+			source: GetTestLocalSource("A", "rec L { y(string) from B; x(int) to B; choice at A { z() to B; continue L; } or { w() to B; } }"),
+		},
+		{
+			name:   "payload type",
+			source: GetTestLocalSource("A", "rec L { x(string) to B; y(string) from B; choice at A { z() to B; continue L; } or { w() to B; } }"),
+		},
+		{
+			name:   "direction",
+			source: GetTestLocalSource("A", "rec L { x(int) from B; y(string) from B; choice at A { z() to B; continue L; } or { w() to B; } }"),
+		},
+		{
+			name:   "message moved out of loop",
+			source: GetTestLocalSource("A", "x(int) to B; rec L { y(string) from B; choice at A { z() to B; continue L; } or { w() to B; } }"),
+		},
+		{
+			name:   "no loop",
+			source: GetTestLocalSource("A", "x(int) to B; y(string) from B; choice at A { z() to B; } or { w() to B; }"),
+		},
+	}
+	for _, test := range tests {
+		partner := test.partner
+		if partner == "" {
+			partner = "B"
+		}
+		hash := GetTestDialogueHash(t, test.source, partner)
+		if (hash == base) != test.same {
+			t.Errorf("%s: got same hash %v, want %v", test.name, hash == base, test.same)
+		}
+	}
+}
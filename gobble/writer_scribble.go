@@ -0,0 +1,177 @@
+// The Scribble writer takes a Local from the parser's tree and writes it
+// back out as the text of a Scribble local protocol. It is used to save
+// the local protocols produced by projecting a global protocol so that
+// they can be reviewed, or passed to Gobble in place of the global.
+
+// input: tree of structs descending from a Protocol root node
+
+// output: .scr files in a ``projections'' directory located within the
+// relevant subdirectory of the ``output'' directory.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func WriteScribbleModule(mod *Module) string {
+	if mod == nil {
+		return ""
+	}
+	return "module " + mod.Name + ";\n\n"
+}
+
+func WriteScribbleType(typ *Type) string {
+	line := "type <" + typ.Schema + "> "
+	line += "\"" + typ.Source + "\" "
+	line += "from \"" + typ.FileName + "\" "
+	line += "as " + typ.Alias + ";\n"
+	return line
+}
+
+func WriteScribbleTypes(types []*Type) string {
+	s := ""
+	for _, typ := range types {
+		s += WriteScribbleType(typ)
+	}
+	if len(types) > 0 {
+		s += "\n"
+	}
+	return s
+}
+
+func WriteScribbleLocalHeader(l *Local) string {
+	header := "local protocol " + l.Name + " at " + l.Protagonist + "("
+	for i, role := range l.Roles {
+		if i > 0 {
+			header += ", "
+		}
+		header += "role " + role.Name
+	}
+	header += ") "
+	return header
+}
+
+func WriteScribbleParameters(mess *Message) string {
+	params := make([]string, 0)
+	for i, typ := range mess.Types {
+		if i < len(mess.Names) && mess.Names[i] != "" {
+			typ = mess.Names[i] + ": " + typ
+		}
+		params = append(params, typ)
+	}
+	return GetCommaSepList(params)
+}
+
+func WriteScribbleMessage(mess *Message, indent string) string {
+	line := indent + mess.Name + "("
+	line += WriteScribbleParameters(mess)
+	line += ")"
+	if mess.From == mess.Protagonist {
+		line += " to " + mess.To
+	} else {
+		line += " from " + mess.From
+	}
+	line += ";\n"
+	return line
+}
+
+func WriteScribbleDoArgs(d *Do) string {
+	s := ""
+	for i, role := range d.Roles {
+		if i > 0 {
+			s += ", "
+		}
+		s += role
+		if i < len(d.Params) && d.Params[i] != "" {
+			s += " as " + d.Params[i]
+		}
+	}
+	return s
+}
+
+func WriteScribbleBranches(convs []*Conversation, separator string, indent string) string {
+	s := ""
+	for i, conv := range convs {
+		if i > 0 {
+			s += " " + separator + " "
+		}
+		s += WriteScribbleConversation(conv, indent)
+	}
+	return s
+}
+
+func WriteScribbleNode(n *Node, indent string) string {
+	s := ""
+	if n.Cat == "message" {
+		s += WriteScribbleMessage(n.Mess, indent)
+	} else if n.Cat == "choice" {
+		s += indent + "choice at " + n.Choice.Chooser + " "
+		s += WriteScribbleBranches(n.Choice.Convs, "or", indent)
+		s += "\n"
+	} else if n.Cat == "par" {
+		s += indent + "par "
+		s += WriteScribbleBranches(n.Par.Convs, "and", indent)
+		s += "\n"
+	} else if n.Cat == "rec" {
+		s += indent + "rec " + n.Rec.Name + " "
+		s += WriteScribbleConversation(n.Rec.Conv, indent)
+		s += "\n"
+	} else if n.Cat == "continue" {
+		s += indent + "continue " + n.Cont.Name + ";\n"
+	} else if n.Cat == "do" {
+		s += indent + "do " + n.Do.Name + "(" + WriteScribbleDoArgs(n.Do) + ");\n"
+	} else {
+		log.Fatal("Unknown Cat encountered in WriteScribbleNode(): " + n.Cat)
+	}
+	return s
+}
+
+func WriteScribbleConversation(c *Conversation, indent string) string {
+	s := "{\n"
+	for _, n := range c.Nodes {
+		s += WriteScribbleNode(n, indent+"\t")
+	}
+	s += indent + "}"
+	return s
+}
+
+func WriteScribbleImports(imports []*Import) string {
+	s := ""
+	for _, imp := range imports {
+		s += "import " + imp.Name + ";\n"
+	}
+	if len(imports) > 0 {
+		s += "\n"
+	}
+	return s
+}
+
+func WriteScribbleLocal(mod *Module, imports []*Import, types []*Type, l *Local) string {
+	s := WriteScribbleModule(mod)
+	s += WriteScribbleImports(imports)
+	s += WriteScribbleTypes(types)
+	s += WriteScribbleLocalHeader(l)
+	s += WriteScribbleConversation(l.Conv, "")
+	s += "\n"
+	return s
+}
+
+func WriteProjectionsToFile(p *Protocol, moduleName string) {
+	sep := string(os.PathSeparator)
+	path := "." + sep + "output" + sep + moduleName + "_Gobble" + sep + "projections" + sep
+	err := os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		log.Fatal("Error creating directory: ", err)
+	}
+	for _, l := range p.Locals {
+		file, err := os.Create(path + l.Name + "_" + l.Protagonist + ".scr")
+		if err != nil {
+			log.Fatal("Cannot create file: ", err)
+		}
+		fmt.Fprint(file, WriteScribbleLocal(p.Mod, p.Imports, p.Types, l))
+		file.Close()
+	}
+}